@@ -1,11 +1,15 @@
 package main
 
 import (
+	"compress/flate"
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"github.com/object-model/goModel/cmd/proxy/server"
+	"github.com/object-model/goModel/rawConn"
 	"io"
 	"log"
+	"math"
 	"os"
 	"time"
 )
@@ -19,16 +23,32 @@ const Desc = "Proxy is object model proxy server which can transmit model messag
 func main() {
 	var webSocket bool
 	var webSocketAddr string
+	var webSocketCompress bool
 	var address string
 	var showVersion bool
 	var showProxyMeta bool
 	var printDataLog bool
 	var saveLogFile bool
+	var maxMsgSize uint
+	var frameHeader bool
+	var bigEndian bool
+	var logFormat string
+	var snapshotTTL time.Duration
+	var fanoutTimeout time.Duration
+	var sessionTTL time.Duration
 	flag.BoolVar(&webSocket, "ws", false, "whether to run websocket service")
 	flag.StringVar(&webSocketAddr, "wsAddr", "0.0.0.0:9090", "proxy websocket address")
+	flag.BoolVar(&webSocketCompress, "wsCompress", false, "whether to negotiate permessage-deflate compression for websocket connection")
 	flag.StringVar(&address, "addr", "0.0.0.0:8080", "proxy tcp address")
 	flag.BoolVar(&printDataLog, "p", false, "whether to print send and received message on console")
 	flag.BoolVar(&saveLogFile, "log", false, "whether to save send and received message to file")
+	flag.StringVar(&logFormat, "logFormat", "raw", "format of send and received message log: raw or json")
+	flag.UintVar(&maxMsgSize, "maxMsgSize", uint(rawConn.DefaultMaxMsgSize), "max size in bytes of message received from tcp connection")
+	flag.BoolVar(&frameHeader, "frameHeader", false, "whether to send tcp message with frame header")
+	flag.BoolVar(&bigEndian, "bigEndian", false, "whether to use big-endian length prefix for tcp message")
+	flag.DurationVar(&snapshotTTL, "snapshotTTL", 0, "max age of cached state sent on subscribe, 0 means no limit")
+	flag.DurationVar(&fanoutTimeout, "fanoutTimeout", server.DefaultFanoutTimeout, "max time to wait for responses of wildcard call")
+	flag.DurationVar(&sessionTTL, "sessionTTL", 0, "how long subscriptions of a disconnected session are kept, 0 means session disabled")
 	flag.BoolVar(&showVersion, "v", false, "show version of proxy and quit")
 	flag.BoolVar(&showProxyMeta, "meta", false, "show proxy meta info")
 
@@ -72,7 +92,31 @@ func main() {
 		logWriters = append(logWriters, file)
 	}
 
-	s := server.New(io.MultiWriter(logWriters...))
+	format, err := server.ParseLogFormat(logFormat)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if maxMsgSize == 0 || maxMsgSize > math.MaxUint32 {
+		log.Fatalln("invalid maxMsgSize:", maxMsgSize)
+	}
+
+	tcpOpts := []rawConn.TcpOption{rawConn.WithMaxMsgSize(uint32(maxMsgSize))}
+	if frameHeader {
+		tcpOpts = append(tcpOpts, rawConn.WithFrameHeader())
+	}
+
+	serverOpts := []server.ServerOption{server.WithTcpOptions(tcpOpts...), server.WithSnapshotTTL(snapshotTTL),
+		server.WithLogFormat(format), server.WithFanoutTimeout(fanoutTimeout),
+		server.WithSessionTTL(sessionTTL), server.WithVersion(Version)}
+	if bigEndian {
+		serverOpts = append(serverOpts, server.WithFrameByteOrder(binary.BigEndian))
+	}
+	if webSocketCompress {
+		serverOpts = append(serverOpts, server.WithWebSocketCompression(flate.DefaultCompression))
+	}
+
+	s := server.New(io.MultiWriter(logWriters...), serverOpts...)
 
 	// 开启webSocket服务
 	if webSocket {
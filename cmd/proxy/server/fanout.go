@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/object-model/goModel/message"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFanoutTimeout 为通配符调用等待所有物模型响应的默认超时时间
+const DefaultFanoutTimeout = 5 * time.Second
+
+// fanoutResult 为通配符调用中单个物模型的调用结果, 是聚合响应中results数组的元素
+type fanoutResult struct {
+	Model    string          `json:"model"`    // 物模型名称
+	Error    string          `json:"error"`    // 调用错误信息, 为空表示调用成功
+	Response message.RawResp `json:"response"` // 物模型的调用响应返回值
+}
+
+// isWildcard 返回模型名称modelName是否包含通配符token "*"
+func isWildcard(modelName string) bool {
+	for _, token := range strings.Split(modelName, "/") {
+		if token == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchWildcard 返回模型名称modelName是否匹配通配符模式pattern, 模式中的每个"*"匹配且只匹配一个token
+func matchWildcard(pattern string, modelName string) bool {
+	patternTokens := strings.Split(pattern, "/")
+	nameTokens := strings.Split(modelName, "/")
+	if len(patternTokens) != len(nameTokens) {
+		return false
+	}
+	for i, token := range patternTokens {
+		if token != "*" && token != nameTokens[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// onFanoutCall 将模型名为通配符模式的调用请求call转发给所有匹配的在线物模型,
+// 每个物模型的调用请求使用新的UUID, 记录在fanouts中, 由 waitFanout 聚合所有响应后回复调用者.
+func (s *Server) onFanoutCall(call callMessage,
+	connections map[string]connection,
+	fanouts map[string]chan<- responseMessage) {
+	source := connections[call.Source]
+
+	// 代理不支持通配符状态设置
+	if call.SetState {
+		errStr := fmt.Sprintf("wildcard model %q NOT supported for set-state", call.Model)
+		source.writeChan <- message.Must(message.EncodeRespMsg(call.UUID, errStr, message.Resp{}))
+		return
+	}
+
+	var targets []string
+	for name := range connections {
+		if matchWildcard(call.Model, name) {
+			targets = append(targets, name)
+		}
+	}
+	if len(targets) == 0 {
+		errStr := fmt.Sprintf("NO model matches %q", call.Model)
+		source.writeChan <- message.Must(message.EncodeRespMsg(call.UUID, errStr, message.Resp{}))
+		return
+	}
+
+	args := make(message.Args, len(call.Args))
+	for name, arg := range call.Args {
+		args[name] = arg
+	}
+
+	// 向每个匹配的物模型转发调用请求
+	results := make(chan responseMessage, len(targets))
+	subCalls := make(map[string]string, len(targets))
+	for _, target := range targets {
+		uid := uuid.NewString()
+		conn := connections[target]
+		conn.writeChan <- message.Must(message.EncodeCallMsgWithHeaders(target+"/"+call.Method, uid, args, call.Headers))
+		conn.inCalls[uid] = struct{}{}
+		fanouts[uid] = results
+		subCalls[uid] = target
+	}
+
+	go s.waitFanout(call, source, subCalls, results)
+}
+
+// waitFanout 等待通配符调用call转发的所有调用请求subCalls(UUID -> 物模型名称)的响应, 直到全部收到响应或者超时,
+// 将所有结果按照物模型名称排序后聚合为一个响应回复调用者source, 超时未响应的物模型的错误信息为"timeout".
+func (s *Server) waitFanout(call callMessage, source connection, subCalls map[string]string, results <-chan responseMessage) {
+	timer := time.NewTimer(s.fanoutTimeout)
+	defer timer.Stop()
+
+	got := make(map[string]fanoutResult, len(subCalls))
+wait:
+	for len(got) < len(subCalls) {
+		select {
+		case resp := <-results:
+			got[resp.UUID] = parseFanoutResult(subCalls[resp.UUID], resp.FullData)
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	ans := make([]fanoutResult, 0, len(subCalls))
+	pending := make(map[string]string)
+	for uid, target := range subCalls {
+		if result, seen := got[uid]; seen {
+			ans = append(ans, result)
+		} else {
+			ans = append(ans, fanoutResult{Model: target, Error: "timeout", Response: message.RawResp{}})
+			pending[uid] = target
+		}
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].Model < ans[j].Model
+	})
+
+	// 清除超时的调用记录, 转发协程已经退出时不再清除
+	if len(pending) > 0 {
+		select {
+		case s.fanoutDone <- pending:
+		case <-s.done:
+		}
+	}
+
+	// 发送聚合响应
+	select {
+	case source.writeChan <- message.Must(message.EncodeRespMsg(call.UUID, "", message.Resp{"results": ans})):
+	case <-source.writerQuit:
+		return
+	}
+}
+
+// onFanoutDone 清除通配符调用中超时未响应的调用请求pending(UUID -> 物模型名称)的记录
+func onFanoutDone(connections map[string]connection, pending map[string]string, fanouts map[string]chan<- responseMessage) {
+	for uid, target := range pending {
+		delete(fanouts, uid)
+		if conn, seen := connections[target]; seen {
+			delete(conn.inCalls, uid)
+		}
+	}
+}
+
+// parseFanoutResult 将物模型target的响应报文data解析为调用结果
+func parseFanoutResult(target string, data []byte) fanoutResult {
+	ans := fanoutResult{Model: target, Response: message.RawResp{}}
+	msg, err := message.Decode(data)
+	if err != nil {
+		ans.Error = err.Error()
+		return ans
+	}
+	resp, err := message.ParseResponsePayload(msg.Payload)
+	if err != nil {
+		ans.Error = err.Error()
+		return ans
+	}
+
+	ans.Error = resp.Error
+	if resp.Response != nil {
+		ans.Response = resp.Response
+	}
+	return ans
+}
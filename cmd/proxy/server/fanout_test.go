@@ -0,0 +1,236 @@
+package server
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// newFanoutServer 创建用于测试通配符调用的代理, 不运行转发协程
+func newFanoutServer(timeout time.Duration) *Server {
+	return &Server{
+		fanoutTimeout: timeout,
+		fanoutDone:    make(chan map[string]string, 1),
+		done:          make(chan struct{}),
+	}
+}
+
+// readCall 读取连接conn收到的调用请求, 返回调用的方法全名和UUID
+func readCall(t *testing.T, conn connection) (string, string) {
+	select {
+	case data := <-conn.writeChan:
+		msg, err := message.Decode(data)
+		require.Nil(t, err)
+		require.Equal(t, "call", msg.Type)
+		call, err := message.ParseCallPayload(msg.Payload)
+		require.Nil(t, err)
+		return call.Name, call.UUID
+	default:
+		require.Fail(t, "NO call")
+		return "", ""
+	}
+}
+
+// readResults 等待连接conn收到UUID为uuid的通配符调用的聚合响应, 返回所有物模型的调用结果
+func readResults(t *testing.T, conn connection, uuid string) []fanoutResult {
+	select {
+	case data := <-conn.writeChan:
+		msg, err := message.Decode(data)
+		require.Nil(t, err)
+		resp, err := message.ParseResponsePayload(msg.Payload)
+		require.Nil(t, err)
+		require.Equal(t, uuid, resp.UUID)
+		require.Equal(t, "", resp.Error)
+		var ans []fanoutResult
+		require.Nil(t, jsoniter.Unmarshal(resp.Response["results"], &ans))
+		return ans
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "NO aggregated response")
+		return nil
+	}
+}
+
+// respondTo 以物模型source的身份回复UUID为uuid的调用请求
+func respondTo(connections map[string]connection, fanouts map[string]chan<- responseMessage,
+	source string, uuid string, errStr string, resp message.Resp) {
+	onResp(connections, responseMessage{
+		Source:   source,
+		UUID:     uuid,
+		FullData: message.Must(message.EncodeRespMsg(uuid, errStr, resp)),
+	}, map[string]string{}, fanouts)
+}
+
+// TestMatchWildcard 测试通配符模式匹配模型名称
+func TestMatchWildcard(t *testing.T) {
+	type TestCase struct {
+		pattern string // 通配符模式
+		name    string // 模型名称
+		want    bool   // 是否匹配
+	}
+
+	testCases := []TestCase{
+		{"A/*/tpqs", "A/car/tpqs", true},
+		{"A/*/*", "A/car/tpqs", true},
+		{"*", "A", true},
+		{"A/*/tpqs", "A/car/#1/tpqs", false},
+		{"A/*", "A", false},
+		{"A/*/tpqs", "B/car/tpqs", false},
+		{"A/car/tpqs", "A/car/tpqs", true},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.want, matchWildcard(test.pattern, test.name), "%s ~ %s", test.pattern, test.name)
+	}
+	assert.True(t, isWildcard("A/*/tpqs"))
+	assert.False(t, isWildcard("A/c*r/tpqs"), "*只能作为完整的token")
+}
+
+// TestFanout_Aggregate 测试通配符调用转发给所有匹配的物模型并按名称聚合响应
+func TestFanout_Aggregate(t *testing.T) {
+	s := newFanoutServer(time.Second)
+	connections := map[string]connection{
+		"C":   newTestConn(nil, nil),
+		"A/2": newTestConn(nil, nil),
+		"A/1": newTestConn(nil, nil),
+		"B/1": newTestConn(nil, nil),
+	}
+	fanouts := make(map[string]chan<- responseMessage)
+
+	s.onCall(callMessage{
+		Source: "C",
+		Model:  "A/*",
+		Method: "echo",
+		UUID:   "u1",
+		Args:   map[string]jsoniter.RawMessage{"x": jsoniter.RawMessage("1")},
+	}, connections, map[string]string{}, fanouts)
+
+	name1, uid1 := readCall(t, connections["A/1"])
+	name2, uid2 := readCall(t, connections["A/2"])
+	assert.Equal(t, "A/1/echo", name1)
+	assert.Equal(t, "A/2/echo", name2)
+	assert.NotEqual(t, uid1, uid2, "每个物模型的调用请求使用新的UUID")
+	assert.Empty(t, connections["B/1"].writeChan, "不匹配的物模型不转发")
+	assert.Len(t, fanouts, 2)
+
+	respondTo(connections, fanouts, "A/2", uid2, "busy", message.Resp{})
+	respondTo(connections, fanouts, "A/1", uid1, "", message.Resp{"x": 1})
+
+	results := readResults(t, connections["C"], "u1")
+	require.Len(t, results, 2)
+	assert.Equal(t, "A/1", results[0].Model, "按物模型名称排序")
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, jsoniter.RawMessage("1"), results[0].Response["x"])
+	assert.Equal(t, "A/2", results[1].Model)
+	assert.Equal(t, "busy", results[1].Error)
+	assert.Empty(t, fanouts, "收到所有响应后清除记录")
+	assert.Empty(t, connections["A/1"].inCalls)
+	assert.Empty(t, s.fanoutDone, "没有超时的调用请求")
+
+	// 没有匹配的物模型
+	s.onCall(callMessage{Source: "C", Model: "D/*", Method: "echo", UUID: "u2"}, connections, map[string]string{}, fanouts)
+	errStr, _ := readAck(t, connections["C"])
+	assert.Equal(t, `NO model matches "D/*"`, errStr)
+}
+
+// TestFanout_Timeout 测试通配符调用超时未响应的物模型的结果为timeout, 并清除其调用记录
+func TestFanout_Timeout(t *testing.T) {
+	s := newFanoutServer(20 * time.Millisecond)
+	connections := map[string]connection{
+		"C":   newTestConn(nil, nil),
+		"A/1": newTestConn(nil, nil),
+		"A/2": newTestConn(nil, nil),
+	}
+	fanouts := make(map[string]chan<- responseMessage)
+
+	s.onCall(callMessage{Source: "C", Model: "A/*", Method: "echo", UUID: "u1"}, connections, map[string]string{}, fanouts)
+	_, uid1 := readCall(t, connections["A/1"])
+	_, uid2 := readCall(t, connections["A/2"])
+	respondTo(connections, fanouts, "A/1", uid1, "", message.Resp{})
+
+	results := readResults(t, connections["C"], "u1")
+	require.Len(t, results, 2)
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, fanoutResult{Model: "A/2", Error: "timeout", Response: message.RawResp{}}, results[1])
+
+	// 转发协程清除超时的调用记录
+	var pending map[string]string
+	select {
+	case pending = <-s.fanoutDone:
+	default:
+		require.Fail(t, "NO pending calls")
+	}
+	assert.Equal(t, map[string]string{uid2: "A/2"}, pending)
+	onFanoutDone(connections, pending, fanouts)
+	assert.Empty(t, fanouts)
+	assert.Empty(t, connections["A/2"].inCalls)
+
+	// 超时后的响应被忽略
+	respondTo(connections, fanouts, "A/2", uid2, "", message.Resp{})
+	assert.Empty(t, connections["C"].writeChan)
+}
+
+// TestFanout_Disconnect 测试通配符调用中物模型下线时以下线错误作为其结果
+func TestFanout_Disconnect(t *testing.T) {
+	s := newFanoutServer(time.Second)
+	quitting := &model{
+		RawConn:    addrConn{},
+		writeChan:  make(chan []byte, 8),
+		writerQuit: make(chan struct{}),
+		added:      make(chan struct{}),
+		MetaInfo:   &meta.Meta{Name: "A/2"},
+	}
+	quitting.setAdded()
+	connections := map[string]connection{
+		"C":   newTestConn(nil, nil),
+		"A/1": newTestConn(nil, nil),
+		"A/2": {
+			model:     quitting,
+			outCalls:  map[string]struct{}{},
+			inCalls:   map[string]struct{}{},
+			pubStates: map[string]struct{}{},
+			pubEvents: map[string]struct{}{},
+		},
+	}
+	fanouts := make(map[string]chan<- responseMessage)
+
+	s.onCall(callMessage{Source: "C", Model: "A/*", Method: "echo", UUID: "u1"}, connections, map[string]string{}, fanouts)
+	_, uid1 := readCall(t, connections["A/1"])
+	readCall(t, connections["A/2"])
+
+	s.onRemoveConn(connections, quitting, map[string]string{}, fanouts,
+		map[string]savedSession{}, map[string]cachedState{})
+	respondTo(connections, fanouts, "A/1", uid1, "", message.Resp{})
+
+	results := readResults(t, connections["C"], "u1")
+	require.Len(t, results, 2)
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, `model "A/2" have quit`, results[1].Error)
+	assert.Empty(t, fanouts)
+	assert.NotContains(t, connections, "A/2")
+}
+
+// TestFanout_HubDone 测试转发协程退出后等待通配符调用的协程不会泄漏
+func TestFanout_HubDone(t *testing.T) {
+	s := newFanoutServer(10 * time.Millisecond)
+	s.fanoutDone = make(chan map[string]string)
+	close(s.done)
+
+	source := newTestConn(nil, nil)
+	finished := make(chan struct{})
+	go func() {
+		s.waitFanout(callMessage{UUID: "u1"}, source, map[string]string{"x": "A/1"}, make(chan responseMessage))
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "转发协程退出后等待通配符调用的协程阻塞")
+	}
+	results := readResults(t, source, "u1")
+	assert.Equal(t, []fanoutResult{{Model: "A/1", Error: "timeout", Response: message.RawResp{}}}, results)
+}
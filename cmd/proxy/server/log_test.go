@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/rawConn"
+	"github.com/object-model/goModel/replay"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"net"
+	"testing"
+)
+
+// addrConn 为只提供远端地址的原始连接, 用于测试日志记录
+type addrConn struct {
+	rawConn.RawConn
+}
+
+func (addrConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+// TestLogData_Replay 测试两种日志格式记录的收发数据都能被 replay.Reader 原样读取
+func TestLogData_Replay(t *testing.T) {
+	packets := [][]byte{
+		message.EncodeQueryMetaMsg(),
+		message.Must(message.EncodeCallMsg("A/echo", "1", message.Args{"x": []int{1, 2}})),
+		[]byte("not json"),
+	}
+
+	for _, format := range []LogFormat{LogRaw, LogJSON} {
+		var buf bytes.Buffer
+		m := &model{
+			RawConn:   addrConn{},
+			log:       log.New(&buf, "", log.LstdFlags|log.Lmicroseconds),
+			logFormat: format,
+		}
+		m.logData("<--", packets[0])
+		m.logData("-->", packets[1])
+		m.logData("<--", packets[2])
+
+		records, err := replay.ReadAll(&buf)
+		require.Nil(t, err, format.String())
+		require.Len(t, records, len(packets), format.String())
+		for i, record := range records {
+			assert.Equal(t, packets[i], record.Data, format.String())
+			assert.Equal(t, "127.0.0.1:50000", record.Addr, format.String())
+		}
+		assert.Equal(t, replay.Received, records[0].Direction, format.String())
+		assert.Equal(t, replay.Sent, records[1].Direction, format.String())
+	}
+}
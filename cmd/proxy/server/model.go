@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	stdjson "encoding/json"
 	"errors"
 	"fmt"
 	jsoniter "github.com/json-iterator/go"
@@ -33,6 +35,9 @@ type callMessage struct {
 	UUID     string                         // 调用UUID
 	Args     map[string]jsoniter.RawMessage // 调用参数
 	FullData []byte                         // 全报文原始数据，是Message类型序列化的结果
+	SetState bool                           // 是否为状态设置请求, 为true时 Method 为状态名
+	Data     jsoniter.RawMessage            // 状态设置请求的状态数据
+	Headers  map[string]string              // 调用请求的附加头部, 重新编码调用请求时原样转发
 }
 
 type responseMessage struct {
@@ -42,9 +47,12 @@ type responseMessage struct {
 }
 
 type subStateOrEventMessage struct {
-	Source string   // 发送者的物模型名称
-	Type   int      // 订阅类型
-	Items  []string // 状态或者事件列表
+	Source   string   // 发送者的物模型名称
+	Type     int      // 订阅类型
+	Items    []string // 状态或者事件列表
+	Remove   []string // 取消订阅的状态或者事件列表, 仅对 message.UpdateSub 类型有效
+	Snapshot bool     // 是否立即推送新订阅状态的最新值
+	UUID     string   // 订阅确认的UUID, 为空表示不需要回复订阅确认
 }
 
 type model struct {
@@ -58,6 +66,7 @@ type model struct {
 	respChan        chan<- responseMessage        // 响应结果通道
 	subStateChan    chan<- subStateOrEventMessage // 更新状态订阅写入通道
 	subEventChan    chan<- subStateOrEventMessage // 更新事件订阅写入通道
+	sessionChan     chan<- sessionMessage         // 会话报文通道
 	writeChan       chan []byte                   // 数据写入通道
 	metaGotChan     chan struct{}                 // 收到元信息消息通道
 	queryOnce       sync.Once                     // 保证只查询一次元信息
@@ -67,9 +76,12 @@ type model struct {
 	MetaInfo        *meta.Meta                    // 元信息
 	MetaRaw         []byte                        // 原始的元信息
 	log             *log.Logger                   // 记录收发数据
+	logFormat       LogFormat                     // 收发数据的日志格式
 	buffer          []msgPack                     // 挂起的报文
 	closeReason     string                        // 连接关闭原因
+	peerCloseReason string                        // 物模型通过关闭报文告知的关闭原因
 	msgHandlers     map[string]msgHandler         // 报文消息处理函数集合
+	metaMessage     []byte                        // 代理的元信息响应报文
 }
 
 func (m *model) quitWriter() {
@@ -121,6 +133,9 @@ func (m *model) reader() {
 		data, err := m.ReadMsg()
 		if err != nil {
 			m.closeReason = err.Error()
+			if m.peerCloseReason != "" {
+				m.closeReason = "peer closed: " + m.peerCloseReason
+			}
 			break
 		}
 
@@ -129,7 +144,7 @@ func (m *model) reader() {
 		}
 
 		// 记录接收数据
-		m.log.Println("<--", m.RemoteAddr().String(), string(data))
+		m.logData("<--", data)
 
 		// 解析JSON报文
 		rawMessage := message.RawMessage{}
@@ -138,6 +153,15 @@ func (m *model) reader() {
 			break
 		}
 
+		// 批量报文拆分后依次处理
+		if rawMessage.Type == "batch" {
+			if err = m.dealBatch(rawMessage.Payload); err != nil {
+				m.closeReason = err.Error()
+				break
+			}
+			continue
+		}
+
 		// 处理包
 		msg := msgPack{
 			Type:     rawMessage.Type,
@@ -151,6 +175,36 @@ func (m *model) reader() {
 	}
 }
 
+// dealBatch 依次处理批量报文中的每个报文, 批量报文不能嵌套
+func (m *model) dealBatch(payload []byte) error {
+	msgs, err := message.ParseBatchPayload(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, data := range msgs {
+		rawMessage := message.RawMessage{}
+		if err = jsoniter.Unmarshal(data, &rawMessage); err != nil {
+			return err
+		}
+
+		if rawMessage.Type == "batch" {
+			return fmt.Errorf("nested batch message")
+		}
+
+		msg := msgPack{
+			Type:     rawMessage.Type,
+			payload:  rawMessage.Payload,
+			fullData: data,
+		}
+		if err = m.dealMsg(msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (m *model) notifyClosed() {
 	fullData := message.Must(message.EncodeEventMsg("proxy/closed", message.Args{
 		"addr":   m.RemoteAddr().String(),
@@ -178,12 +232,24 @@ func (m *model) writer() {
 		// 发送数据
 		case data := <-m.writeChan:
 			// 记录发送数据
-			m.log.Println("-->", m.RemoteAddr().String(), string(data))
+			m.logData("-->", data)
 			_ = m.WriteMsg(data)
 		}
 	}
 }
 
+// logData 按照日志格式记录方向为dir的收发数据data
+func (m *model) logData(dir string, data []byte) {
+	if m.logFormat == LogJSON {
+		var buf bytes.Buffer
+		if stdjson.Indent(&buf, data, "", "  ") == nil {
+			m.log.Printf("%s %s\n%s\n", dir, m.RemoteAddr().String(), buf.String())
+			return
+		}
+	}
+	m.log.Println(dir, m.RemoteAddr().String(), string(data))
+}
+
 func (m *model) dealMsg(msg msgPack) error {
 	select {
 	case <-m.added:
@@ -209,14 +275,18 @@ var trans = map[string]struct{}{
 	"add-subscribe-state":    {},
 	"remove-subscribe-state": {},
 	"clear-subscribe-state":  {},
+	"update-subscribe-state": {},
 	"set-subscribe-event":    {},
 	"add-subscribe-event":    {},
 	"remove-subscribe-event": {},
 	"clear-subscribe-event":  {},
+	"update-subscribe-event": {},
 	"state":                  {},
 	"event":                  {},
 	"call":                   {},
+	"set-state":              {},
 	"response":               {},
+	"session":                {},
 }
 
 func isTransMsg(msg msgPack) bool {
@@ -232,8 +302,8 @@ func (m *model) onMsg(msg msgPack) error {
 }
 
 func (m *model) onSubState(msg msgPack) error {
-	var states []string
-	if err := jsoniter.Unmarshal(msg.payload, &states); err != nil {
+	var sub message.SubPayload
+	if err := jsoniter.Unmarshal(msg.payload, &sub); err != nil {
 		return err
 	}
 
@@ -247,19 +317,24 @@ func (m *model) onSubState(msg msgPack) error {
 		option = message.RemoveSub
 	case "clear-subscribe-state":
 		option = message.ClearSub
+	case "update-subscribe-state":
+		option = message.UpdateSub
 	}
 
 	m.subStateChan <- subStateOrEventMessage{
-		Source: m.MetaInfo.Name,
-		Type:   option,
-		Items:  states,
+		Source:   m.MetaInfo.Name,
+		Type:     option,
+		Items:    sub.Items,
+		Remove:   sub.Remove,
+		Snapshot: sub.Snapshot,
+		UUID:     sub.UUID,
 	}
 	return nil
 }
 
 func (m *model) onSubEvent(msg msgPack) error {
-	var events []string
-	if err := jsoniter.Unmarshal(msg.payload, &events); err != nil {
+	var sub message.SubPayload
+	if err := jsoniter.Unmarshal(msg.payload, &sub); err != nil {
 		return err
 	}
 
@@ -274,12 +349,16 @@ func (m *model) onSubEvent(msg msgPack) error {
 		option = message.RemoveSub
 	case "clear-subscribe-event":
 		option = message.ClearSub
+	case "update-subscribe-event":
+		option = message.UpdateSub
 	}
 
 	m.subEventChan <- subStateOrEventMessage{
 		Source: m.MetaInfo.Name,
 		Type:   option,
-		Items:  events,
+		Items:  sub.Items,
+		Remove: sub.Remove,
+		UUID:   sub.UUID,
 	}
 	return nil
 }
@@ -295,9 +374,9 @@ func (m *model) onState(msg msgPack) error {
 		return errors.New("name NOT exist or empty")
 	}
 
-	// data字段为null或不存在
-	if state.Data == nil {
-		return errors.New("data NOT exist or null")
+	// data字段不存在, 为null时是清除状态报文, 照常转发
+	if state.Data == nil && jsoniter.Get(msg.payload, "data").ValueType() != jsoniter.NilValue {
+		return errors.New("data NOT exist")
 	}
 
 	m.stateBroadcast <- stateOrEventMessage{
@@ -361,6 +440,44 @@ func (m *model) onCall(msg msgPack) error {
 		UUID:     call.UUID,
 		Args:     call.Args,
 		FullData: msg.fullData,
+		Headers:  call.Headers,
+	}
+	return nil
+}
+
+// onSetState 将状态设置请求与调用请求一样转发给目标物模型, 目标物模型以调用响应报文回复设置结果
+func (m *model) onSetState(msg msgPack) error {
+	var setState message.SetStatePayload
+	if err := jsoniter.Unmarshal(msg.payload, &setState); err != nil {
+		return err
+	}
+
+	// uuid字段为空或不存在
+	if strings.TrimSpace(setState.UUID) == "" {
+		return errors.New("uuid NOT exist or empty")
+	}
+
+	// data字段不存在
+	if setState.Data == nil {
+		errStr := "data NOT exist"
+		m.writeChan <- message.Must(message.EncodeRespMsg(setState.UUID, errStr, message.Resp{}))
+		return nil
+	}
+
+	modelName, stateName, err := splitModelName(setState.Name)
+	if err != nil {
+		m.writeChan <- message.Must(message.EncodeRespMsg(setState.UUID, err.Error(), message.Resp{}))
+		return nil
+	}
+
+	m.callChan <- callMessage{
+		Source:   m.MetaInfo.Name,
+		Model:    modelName,
+		Method:   stateName,
+		UUID:     setState.UUID,
+		FullData: msg.fullData,
+		SetState: true,
+		Data:     setState.Data,
 	}
 	return nil
 }
@@ -386,7 +503,7 @@ func (m *model) onResp(msg msgPack) error {
 }
 
 func (m *model) onQueryMeta(msgPack) error {
-	m.writeChan <- proxyMetaMessage
+	m.writeChan <- m.metaMessage
 	return nil
 }
 
@@ -399,6 +516,51 @@ func (m *model) onMetaInfo(msg msgPack) error {
 	return nil
 }
 
+func (m *model) onPing(msg msgPack) error {
+	var ping message.PingPayload
+	if err := jsoniter.Unmarshal(msg.payload, &ping); err != nil {
+		return err
+	}
+
+	m.writeChan <- message.EncodePongMsg(ping.UUID)
+	return nil
+}
+
+func (m *model) onPong(msgPack) error {
+	// NOTE: 代理不主动发送ping报文, 忽略pong报文
+	return nil
+}
+
+// onClose 记录物模型关闭连接前告知的关闭原因, 作为连接关闭事件中的关闭原因
+func (m *model) onClose(msg msgPack) error {
+	closePayload, err := message.ParseClosePayload(msg.payload)
+	if err != nil {
+		return err
+	}
+
+	m.peerCloseReason = closePayload.Reason
+	return nil
+}
+
+func (m *model) onSession(msg msgPack) error {
+	session, err := message.ParseSessionPayload(msg.payload)
+	if err != nil {
+		return err
+	}
+
+	// id字段为空或不存在
+	if strings.TrimSpace(session.ID) == "" {
+		return errors.New("id NOT exist or empty")
+	}
+
+	m.sessionChan <- sessionMessage{
+		Source: m.MetaInfo.Name,
+		ID:     session.ID,
+		UUID:   session.UUID,
+	}
+	return nil
+}
+
 func splitModelName(fullName string) (string, string, error) {
 	index := strings.LastIndex(fullName, "/")
 	if index == -1 {
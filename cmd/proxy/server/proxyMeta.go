@@ -3,6 +3,7 @@ package server
 import (
 	jsoniter "github.com/json-iterator/go"
 	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
 	"strings"
 )
 
@@ -108,6 +109,54 @@ const ProxyMetaString = `
         }
     ],
     "method": [
+        {
+            "name": "GetModelSummary",
+            "description": "获取本代理下当前在线的所有物模型的概要信息",
+            "args": [],
+            "response": [
+                {
+                    "name": "modelList",
+                    "description": "在线的物模型概要信息列表",
+                    "type": "slice",
+                    "element": {
+                        "type": "struct",
+                        "fields": [
+                            {
+                                "name": "modelName",
+                                "description": "物模型名称",
+                                "type": "string"
+                            },
+                            {
+                                "name": "addr",
+                                "description": "地址",
+                                "type": "string"
+                            },
+                            {
+                                "name": "description",
+                                "description": "物模型描述",
+                                "type": "string"
+                            },
+                            {
+                                "name": "stateCount",
+                                "description": "状态数量",
+                                "type": "uint"
+                            },
+                            {
+                                "name": "eventCount",
+                                "description": "事件数量",
+                                "type": "uint"
+                            },
+                            {
+                                "name": "methodCount",
+                                "description": "方法数量",
+                                "type": "uint"
+                            }
+                        ]
+                    }
+                }
+            ]
+        },
+
         {
             "name": "GetAllModel",
             "description": "获取本代理下当前在线的所有物模型信息",
@@ -217,6 +266,26 @@ const ProxyMetaString = `
 
         },
 
+        {
+            "name": "GetMeta",
+            "description": "获取指定名称的物模型的元信息",
+            "args": [
+                {
+                    "name": "modelName",
+                    "description": "物模型名称",
+                    "type": "string"
+                }
+            ],
+
+            "response": [
+                {
+                    "name": "metaInfo",
+                    "description": "模型元信息",
+                    "type": "meta"
+                }
+            ]
+        },
+
         {
             "name": "ModelIsOnline",
             "description": "查询指定名称的物模型是否在线",
@@ -308,9 +377,39 @@ var proxyMetaMessage []byte
 // noneMetaMessage 表示空物模型描述元信息响应报文
 var noneMetaMessage []byte
 
+// proxyMetaInfo 表示代理的物模型元信息
+var proxyMetaInfo *meta.Meta
+
 func init() {
 	metaSendData := message.Must(message.EncodeRawMsg("meta-info",
 		jsoniter.RawMessage(strings.Join(strings.Fields(ProxyMetaString), ""))))
 	proxyMetaMessage = metaSendData
 	noneMetaMessage = []byte(strings.Join(strings.Fields(NoneMetaString), ""))
+
+	parsed, err := meta.Parse([]byte(ProxyMetaString), nil)
+	if err != nil {
+		panic(err)
+	}
+	proxyMetaInfo = parsed
+}
+
+// proxyMetaMessageOf 返回版本号为version的代理元信息响应报文, 版本号不是语义化版本号时返回错误信息
+func proxyMetaMessageOf(version string) ([]byte, error) {
+	var root map[string]interface{}
+	if err := jsoniter.Unmarshal([]byte(ProxyMetaString), &root); err != nil {
+		return nil, err
+	}
+	root["version"] = version
+
+	data, err := jsoniter.Marshal(root)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := meta.Parse(data, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return message.EncodeRawMsg("meta-info", jsoniter.RawMessage(parsed.ToJSON()))
 }
@@ -15,6 +15,15 @@ type modelItem struct {
 	MetaInfo  jsoniter.RawMessage `json:"metaInfo"`
 }
 
+type modelSummary struct {
+	ModelName   string `json:"modelName"`
+	Addr        string `json:"addr"`
+	Description string `json:"description"`
+	StateCount  uint   `json:"stateCount"`
+	EventCount  uint   `json:"eventCount"`
+	MethodCount uint   `json:"methodCount"`
+}
+
 type queryModelRes struct {
 	ModelInfo modelItem `json:"modelInfo"`
 	Got       bool      `json:"got"`
@@ -44,16 +53,20 @@ func (s *Server) dealProxyCall(call callMessage, conn connection) {
 	resp := message.Resp{}
 	errStr := ""
 	switch call.Method {
+	case "GetModelSummary":
+		resp, errStr = s.getModelSummary()
 	case "GetAllModel":
 		resp, errStr = s.getAllModel()
 	case "GetModel":
 		resp, errStr = s.getModel(call.Args)
+	case "GetMeta":
+		resp, errStr = s.getMeta(call.Args)
 	case "ModelIsOnline":
 		resp, errStr = s.modelIsOnline(call.Args)
 	case "GetSubState":
-		resp, errStr = s.getSubList(call.Args, s.querySubState)
+		resp, errStr = s.getSubList(call.Args, s.querySubState, conn.writerQuit)
 	case "GetSubEvent":
-		resp, errStr = s.getSubList(call.Args, s.querySubEvent)
+		resp, errStr = s.getSubList(call.Args, s.querySubEvent, conn.writerQuit)
 	default:
 		errStr = fmt.Sprintf("NO method %q in proxy", call.Method)
 	}
@@ -76,6 +89,16 @@ func (s *Server) getAllModel() (resp message.Resp, err string) {
 	return
 }
 
+func (s *Server) getModelSummary() (resp message.Resp, err string) {
+	resChan := make(chan []modelSummary, 1)
+	s.queryAllSummary <- resChan
+	items := <-resChan
+	resp = message.Resp{
+		"modelList": items,
+	}
+	return
+}
+
 func (s *Server) getModel(Args map[string]jsoniter.RawMessage) (resp message.Resp, err string) {
 	var modelName string
 	data, seen := Args["modelName"]
@@ -100,6 +123,34 @@ func (s *Server) getModel(Args map[string]jsoniter.RawMessage) (resp message.Res
 	}, ""
 }
 
+func (s *Server) getMeta(Args map[string]jsoniter.RawMessage) (message.Resp, string) {
+	var modelName string
+	data, seen := Args["modelName"]
+	if !seen {
+		return message.Resp{}, "missing field \"modelName\" in args"
+	}
+	if err := jsoniter.Unmarshal(data, &modelName); err != nil {
+		return message.Resp{}, err.Error()
+	}
+
+	req := queryModelReq{
+		ModelName: modelName,
+		ResChan:   make(chan queryModelRes, 1),
+	}
+
+	s.queryModel <- req
+	res := <-req.ResChan
+
+	// 物模型不在线则返回错误
+	if !res.Got {
+		return message.Resp{}, fmt.Sprintf("model %q NOT exist", modelName)
+	}
+
+	return message.Resp{
+		"metaInfo": res.ModelInfo.MetaInfo,
+	}, ""
+}
+
 func (s *Server) modelIsOnline(Args map[string]jsoniter.RawMessage) (message.Resp, string) {
 	var modelName string
 	data, seen := Args["modelName"]
@@ -121,7 +172,9 @@ func (s *Server) modelIsOnline(Args map[string]jsoniter.RawMessage) (message.Res
 	}, ""
 }
 
-func (s *Server) getSubList(Args map[string]jsoniter.RawMessage, queryChan chan<- querySubReq) (message.Resp, string) {
+// getSubList 通过queryChan查询参数Args指定的物模型的订阅列表.
+// 调用者的连接关闭(quit关闭)时不再等待查询结果, 立即返回空的订阅列表和错误信息, 避免查询协程一直阻塞.
+func (s *Server) getSubList(Args map[string]jsoniter.RawMessage, queryChan chan<- querySubReq, quit <-chan struct{}) (message.Resp, string) {
 	var modelName string
 	data, seen := Args["modelName"]
 	if !seen {
@@ -136,9 +189,23 @@ func (s *Server) getSubList(Args map[string]jsoniter.RawMessage, queryChan chan<
 		ResChan:   make(chan querySubRes, 1),
 	}
 
-	queryChan <- req
+	closed := message.Resp{
+		"subList": []string{},
+		"got":     false,
+	}
 
-	res := <-req.ResChan
+	select {
+	case queryChan <- req:
+	case <-quit:
+		return closed, "connection closed"
+	}
+
+	var res querySubRes
+	select {
+	case res = <-req.ResChan:
+	case <-quit:
+		return closed, "connection closed"
+	}
 
 	return message.Resp{
 		"subList": res.SubList,
@@ -146,7 +213,8 @@ func (s *Server) getSubList(Args map[string]jsoniter.RawMessage, queryChan chan<
 	}, ""
 }
 
-func (s *Server) pushOnlineOrOfflineEvent(modelName string, addr string, online bool) {
+// onlineOrOfflineEvent 生成名称为modelName、地址为addr的物模型的上线或下线事件
+func onlineOrOfflineEvent(modelName string, addr string, online bool) stateOrEventMessage {
 	EventName := "proxy/offline"
 	if online {
 		EventName = "proxy/online"
@@ -157,14 +225,14 @@ func (s *Server) pushOnlineOrOfflineEvent(modelName string, addr string, online
 		"addr":      addr,
 	}))
 
-	s.eventChan <- stateOrEventMessage{
+	return stateOrEventMessage{
 		Name:     EventName,
 		FullData: fullData,
 	}
 }
 
 func (s *Server) pushMetaCheckErrorEvent(checkErr error, m *model) {
-	fullData := message.Must(message.EncodeEventMsg("metaCheckError", message.Args{
+	fullData := message.Must(message.EncodeEventMsg("proxy/metaCheckError", message.Args{
 		"modelName": m.MetaInfo.Name,
 		"addr":      m.RemoteAddr().String(),
 		"error":     checkErr.Error(),
@@ -188,7 +256,7 @@ func (s *Server) pushMetaCheckErrorEvent(checkErr error, m *model) {
 }
 
 func (s *Server) pushRepeatModelNameEvent(m *model) {
-	fullData := message.Must(message.EncodeEventMsg("repeatModelNameError", message.Args{
+	fullData := message.Must(message.EncodeEventMsg("proxy/repeatModelNameError", message.Args{
 		"modelName": m.MetaInfo.Name,
 		"addr":      m.RemoteAddr().String(),
 	}))
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Router 为代理的调用请求路由接口, 用于决定调用请求最终转发给哪个在线的物模型.
+// 通过自定义 Router 可以实现虚拟物模型、将一个逻辑物模型分片到多个后端物模型或者故障切换等功能.
+type Router interface {
+	// Resolve 根据调用请求的方法全名fullName(模型名/方法名),
+	// 返回调用请求需要转发的目标物模型名称, 若无法路由则返回错误信息.
+	// 返回的错误信息会作为调用响应报文的错误提示信息返回给调用者.
+	// 目标物模型不在线时, 代理同样以错误响应回复调用者.
+	// NOTE: Resolve 在代理的报文转发协程中执行, 不能阻塞.
+	// NOTE: Resolve 返回物模型名称而不是连接对象, 因为所有在线连接只属于报文转发协程,
+	// 不能暴露给路由在其他协程中使用, 由转发协程根据名称查找连接才能保证目标在转发时仍然在线.
+	Resolve(fullName string) (string, error)
+}
+
+// RouterFunc 为调用请求路由函数, 是 Router 的函数形式
+type RouterFunc func(fullName string) (string, error)
+
+func (f RouterFunc) Resolve(fullName string) (string, error) {
+	return f(fullName)
+}
+
+// nameRouter 为默认的调用请求路由, 直接按照方法全名中的模型名进行转发
+type nameRouter struct{}
+
+func (nameRouter) Resolve(fullName string) (string, error) {
+	index := strings.LastIndex(fullName, "/")
+	if index == -1 {
+		return "", fmt.Errorf("%q missing '/'", fullName)
+	}
+	return fullName[:index], nil
+}
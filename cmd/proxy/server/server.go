@@ -1,6 +1,8 @@
 package server
 
 import (
+	"compress/flate"
+	"encoding/binary"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/object-model/goModel/message"
@@ -10,6 +12,8 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -28,55 +32,237 @@ var upgrader = websocket.Upgrader{
 // 获取某个物模型的状态订阅列表方法、获取某个物模型的事件订阅列表方法.
 // 物模型可以通过tcp或websocket接口与代理服务器建立连接.
 type Server struct {
-	addConnChan    chan *model                 // 添加链路通道
-	removeConnChan chan *model                 // 删除链路通道
-	subStateChan   chan subStateOrEventMessage // 订阅状态通道
-	subEventChan   chan subStateOrEventMessage // 订阅事件通道
-	stateChan      chan stateOrEventMessage    // 状态报文通道
-	eventChan      chan stateOrEventMessage    // 事件报文通道
-	callChan       chan callMessage            // 调用报文通道
-	respChan       chan responseMessage        // 响应报文通道
-	queryAllModel  chan chan []modelItem       // 查询在线模型通道
-	queryModel     chan queryModelReq          // 查询指定模型通道
-	queryOnline    chan queryOnlineReq         // 查询模型是否在线通道
-	querySubState  chan querySubReq            // 查询模型的状态订阅关系
-	querySubEvent  chan querySubReq            // 查询模型的事件订阅关系
-	log            *log.Logger                 // 记录收发的数据
+	addConnChan     chan *model                 // 添加链路通道
+	removeConnChan  chan *model                 // 删除链路通道
+	subStateChan    chan subStateOrEventMessage // 订阅状态通道
+	subEventChan    chan subStateOrEventMessage // 订阅事件通道
+	stateChan       chan stateOrEventMessage    // 状态报文通道
+	eventChan       chan stateOrEventMessage    // 事件报文通道
+	callChan        chan callMessage            // 调用报文通道
+	respChan        chan responseMessage        // 响应报文通道
+	queryAllModel   chan chan []modelItem       // 查询在线模型通道
+	queryAllSummary chan chan []modelSummary    // 查询在线模型概要信息通道
+	queryModel      chan queryModelReq          // 查询指定模型通道
+	queryOnline     chan queryOnlineReq         // 查询模型是否在线通道
+	querySubState   chan querySubReq            // 查询模型的状态订阅关系
+	querySubEvent   chan querySubReq            // 查询模型的事件订阅关系
+	log             *log.Logger                 // 记录收发的数据
+	router          Router                      // 调用请求路由
+	tcpOpts         []rawConn.TcpOption         // TCP连接配置选项
+	snapshotTTL     time.Duration               // 订阅时推送的缓存状态的有效期, 为0表示不限制
+	logFormat       LogFormat                   // 收发数据的日志格式
+	fanoutTimeout   time.Duration               // 通配符调用等待所有响应的超时时间
+	fanoutDone      chan map[string]string      // 通配符调用超时未响应的调用请求通道, UUID -> 物模型名称
+	done            chan struct{}               // 转发协程退出信号, 见 run
+	sessionChan     chan sessionMessage         // 会话报文通道
+	sessionTTL      time.Duration               // 断开连接后会话订阅关系的保存时长, 为0表示不开启会话
+	metaMessage     []byte                      // 代理的元信息响应报文
+	wsCompress      bool                        // 是否协商WebSocket的permessage-deflate压缩扩展
+	wsCompressLevel int                         // WebSocket报文的压缩级别, 仅在 wsCompress 为true时有效
+}
+
+// LogFormat 为收发数据的日志格式
+type LogFormat int
+
+const (
+	LogRaw  LogFormat = iota // 每个报文一行, 原样记录报文数据, 默认格式
+	LogJSON                  // 将报文数据缩进格式化为多行JSON后记录在记录头之后, 便于阅读, 不是有效JSON的报文原样记录, 可以由 replay.Reader 读取
+)
+
+func (f LogFormat) String() string {
+	switch f {
+	case LogRaw:
+		return "raw"
+	case LogJSON:
+		return "json"
+	default:
+		return fmt.Sprintf("LogFormat(%d)", int(f))
+	}
+}
+
+// ParseLogFormat 将日志格式名称name(raw或json)解析为日志格式, 名称无效时返回错误信息
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch name {
+	case "raw":
+		return LogRaw, nil
+	case "json":
+		return LogJSON, nil
+	default:
+		return LogRaw, fmt.Errorf("invalid log format %q", name)
+	}
+}
+
+// cachedState 为缓存的状态报文
+type cachedState struct {
+	data []byte    // 状态报文
+	time time.Time // 接收时间
+}
+
+// ServerOption 为代理服务器配置选项
+type ServerOption func(*Server)
+
+// WithRouter 配置代理服务器的调用请求路由为router, 默认按照方法全名中的模型名进行路由.
+func WithRouter(router Router) ServerOption {
+	return func(s *Server) {
+		if router != nil {
+			s.router = router
+		}
+	}
+}
+
+// WithRouterFunc 配置代理服务器的调用请求路由函数为router
+func WithRouterFunc(router func(fullName string) (string, error)) ServerOption {
+	return func(s *Server) {
+		if router != nil {
+			s.router = RouterFunc(router)
+		}
+	}
+}
+
+// WithTcpOptions 配置代理服务器接受的所有TCP连接的配置选项为opts,
+// 例如通过 rawConn.WithMaxMsgSize 限制允许接收的最大报文长度.
+func WithTcpOptions(opts ...rawConn.TcpOption) ServerOption {
+	return func(s *Server) {
+		for _, opt := range opts {
+			if opt != nil {
+				s.tcpOpts = append(s.tcpOpts, opt)
+			}
+		}
+	}
+}
+
+// WithFrameByteOrder 配置代理服务器接受的所有TCP连接收发报文时报文长度的字节序为order, 默认为小端序,
+// 见 rawConn.WithFrameByteOrder. 连接到代理的物模型必须使用相同的字节序.
+func WithFrameByteOrder(order binary.ByteOrder) ServerOption {
+	return func(s *Server) {
+		if order != nil {
+			s.tcpOpts = append(s.tcpOpts, rawConn.WithFrameByteOrder(order))
+		}
+	}
+}
+
+// WithSnapshotTTL 配置代理服务器缓存状态的有效期为ttl, ttl不大于0时该选项无效, 默认不限制.
+// 物模型订阅状态时要求立即推送状态最新值的, 代理只推送在ttl内收到的缓存状态, 避免将设备离线前的过时状态当作当前状态.
+func WithSnapshotTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.snapshotTTL = ttl
+		}
+	}
+}
+
+// WithLogFormat 配置代理服务器收发数据的日志格式为format, 默认为 LogRaw.
+// 每条日志都包含时间、方向(<--为接收, -->为发送)和物模型地址.
+func WithLogFormat(format LogFormat) ServerOption {
+	return func(s *Server) {
+		s.logFormat = format
+	}
+}
+
+// WithFanoutTimeout 配置代理服务器等待通配符调用所有响应的超时时间为timeout, timeout不大于0时该选项无效,
+// 默认为 DefaultFanoutTimeout. 超时未响应的物模型在聚合响应中的错误信息为"timeout".
+func WithFanoutTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		if timeout > 0 {
+			s.fanoutTimeout = timeout
+		}
+	}
+}
+
+// WithSessionTTL 开启代理服务器的会话功能, 物模型断开连接后其会话的订阅关系保存ttl时长, ttl不大于0时该选项无效, 默认不开启.
+// 物模型连接到代理后发送会话报文(见 message.EncodeSessionMsg)绑定会话, 断开连接后在ttl内以相同的会话ID重连,
+// 代理会恢复断开前的状态和事件订阅关系, 不需要重新订阅, 适用于连接不稳定的物模型. 过期的会话会被定期清除.
+// NOTE: 会话ID由物模型自行选择, 代理不验证会话的归属, 知道会话ID的物模型可以在原物模型断开后接管其订阅关系,
+// 因此只应在可信的网络中开启会话, 物模型应使用不可猜测的会话ID, 见 model.Connection.BindSession
+func WithSessionTTL(ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if ttl > 0 {
+			s.sessionTTL = ttl
+		}
+	}
+}
+
+// WithVersion 配置代理服务器在元信息中公开的版本号为version, 物模型查询代理的元信息时可以据此检查兼容性.
+// version必须是语义化版本号(如 0.0.5 或 1.2.0-rc.1), 否则该选项无效, 默认元信息中不包含版本号.
+func WithVersion(version string) ServerOption {
+	return func(s *Server) {
+		if msg, err := proxyMetaMessageOf(version); err == nil {
+			s.metaMessage = msg
+		}
+	}
+}
+
+// WithWebSocketCompression 配置代理服务器接受的WebSocket连接通过标准的 permessage-deflate 扩展协商压缩报文, 压缩级别为level,
+// level的有效范围与 compress/flate 相同, 为 flate.HuffmanOnly 到 flate.BestCompression, 无效时不开启压缩.
+// 只有物模型也支持压缩扩展时才压缩(见 model.WithWebSocketCompression), 否则仍然发送未压缩的报文.
+func WithWebSocketCompression(level int) ServerOption {
+	return func(s *Server) {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return
+		}
+		s.wsCompress = true
+		s.wsCompressLevel = level
+	}
 }
 
 // New 创建一个数据日志写入对象为dataLogWriter的物模型代理服务器.
 // 代理从物模型接收的报文数据和向物模型写入的数据都将写入dataLogWriter.
-// 如果dataLogWriter为nil, 所有收发的数据将丢弃.
-func New(dataLogWriter io.Writer) *Server {
+// 如果dataLogWriter为nil, 所有收发的数据将丢弃. opts为代理服务器的配置选项.
+func New(dataLogWriter io.Writer, opts ...ServerOption) *Server {
 	if dataLogWriter == nil {
 		dataLogWriter = io.Discard
 	}
 	s := &Server{
-		addConnChan:    make(chan *model),
-		removeConnChan: make(chan *model),
-		subStateChan:   make(chan subStateOrEventMessage),
-		subEventChan:   make(chan subStateOrEventMessage),
-		stateChan:      make(chan stateOrEventMessage),
-		eventChan:      make(chan stateOrEventMessage),
-		callChan:       make(chan callMessage),
-		respChan:       make(chan responseMessage),
-		queryAllModel:  make(chan chan []modelItem),
-		queryModel:     make(chan queryModelReq),
-		queryOnline:    make(chan queryOnlineReq),
-		querySubState:  make(chan querySubReq),
-		querySubEvent:  make(chan querySubReq),
-		log:            log.New(dataLogWriter, "", log.LstdFlags|log.Lmicroseconds),
+		addConnChan:     make(chan *model),
+		removeConnChan:  make(chan *model),
+		subStateChan:    make(chan subStateOrEventMessage),
+		subEventChan:    make(chan subStateOrEventMessage),
+		stateChan:       make(chan stateOrEventMessage),
+		eventChan:       make(chan stateOrEventMessage),
+		callChan:        make(chan callMessage),
+		respChan:        make(chan responseMessage),
+		queryAllModel:   make(chan chan []modelItem),
+		queryAllSummary: make(chan chan []modelSummary),
+		queryModel:      make(chan queryModelReq),
+		queryOnline:     make(chan queryOnlineReq),
+		querySubState:   make(chan querySubReq),
+		querySubEvent:   make(chan querySubReq),
+		log:             log.New(dataLogWriter, "", log.LstdFlags|log.Lmicroseconds),
+		router:          nameRouter{},
+		fanoutTimeout:   DefaultFanoutTimeout,
+		fanoutDone:      make(chan map[string]string),
+		done:            make(chan struct{}),
+		sessionChan:     make(chan sessionMessage),
+		metaMessage:     proxyMetaMessage,
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
 	go s.run()
 	return s
 }
 
 type connection struct {
 	*model
-	outCalls  map[string]struct{} // 自己发送的所有调用请求的UUID
-	inCalls   map[string]struct{} // 所有发给自己的调用请求的UUID
-	pubStates map[string]struct{} // 状态发布表, 用于记录哪些状态可以发送到链路上
-	pubEvents map[string]struct{} // 事件发布表, 用于记录哪些事件可以发送到链路上
+	outCalls      map[string]struct{} // 自己发送的所有调用请求的UUID
+	inCalls       map[string]struct{} // 所有发给自己的调用请求的UUID
+	pubStates     map[string]struct{} // 状态发布表, 用于记录哪些状态可以发送到链路上
+	pubEvents     map[string]struct{} // 事件发布表, 用于记录哪些事件可以发送到链路上
+	statePrefixes []string            // 状态发布表中所有前缀订阅的前缀
+	eventPrefixes []string            // 事件发布表中所有前缀订阅的前缀
+	session       string              // 绑定的会话ID, 为空表示没有绑定会话
+}
+
+// wantState 返回连接conn是否订阅了状态state
+func (conn connection) wantState(state string) bool {
+	return wants(conn.pubStates, conn.statePrefixes, state)
+}
+
+// wantEvent 返回连接conn是否订阅了事件event
+func (conn connection) wantEvent(event string) bool {
+	return wants(conn.pubEvents, conn.eventPrefixes, event)
 }
 
 // ListenServeTCP 会监听tcp网络地址addr, 等待物模型与之建立tcp连接.
@@ -103,62 +289,93 @@ func (s *Server) ListenServeTCP(addr string) error {
 			return err
 		}
 
-		go s.addModelConnection(rawConn.NewTcpConn(conn, true))
+		go s.addModelConnection(rawConn.NewTcpConn(conn, true, s.tcpOpts...))
 	}
 }
 
 // ListenServeWebSocket 会监听websocket地址http://addr, 等待物模型与与其建立websocket连接.
 // 连接建立后的处理过程和 ListenServeTCP 相同。
 func (s *Server) ListenServeWebSocket(addr string) error {
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		conn, err := upgrader.Upgrade(writer, request, nil)
-		if err != nil {
-			return
-		}
-		s.addModelConnection(rawConn.NewWebSocketConn(conn, true))
-	})
+	http.HandleFunc("/", s.serveWebSocket)
 	return http.ListenAndServe(addr, nil)
 }
 
+// serveWebSocket 将http请求升级为websocket连接, 并作为物模型连接处理
+func (s *Server) serveWebSocket(writer http.ResponseWriter, request *http.Request) {
+	wsUpgrader := upgrader
+	wsUpgrader.EnableCompression = s.wsCompress
+	conn, err := wsUpgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		return
+	}
+	if s.wsCompress {
+		_ = conn.SetCompressionLevel(s.wsCompressLevel)
+	}
+	s.addModelConnection(rawConn.NewWebSocketConn(conn, true))
+}
+
 func (s *Server) run() {
+	// NOTE: 退出时通知仍在等待转发协程的协程(如 waitFanout)不再等待, 避免协程泄漏
+	defer close(s.done)
+
 	// 所有连接
 	connections := make(map[string]connection)
 	// 等待响应的所有连接，uuid -> 发送调用请求的物模型名称
 	respWaiters := make(map[string]string)
+	// 每个状态最近一次的状态报文, 状态全名 -> 状态报文
+	lastStates := make(map[string]cachedState)
+	// 通配符调用转发的所有调用请求, uuid -> 聚合响应的通道
+	fanouts := make(map[string]chan<- responseMessage)
+	// 断开连接的物模型的会话, 会话ID -> 保存的订阅关系
+	sessions := make(map[string]savedSession)
+	// 定期清除过期的会话, 没有开启会话时不清除
+	var sweep <-chan time.Time
+	if s.sessionTTL > 0 {
+		ticker := time.NewTicker(s.sessionTTL)
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
 	for {
 		select {
 		case state := <-s.stateChan:
+			lastStates[state.Name] = cachedState{
+				data: state.FullData,
+				time: time.Now(),
+			}
 			for _, conn := range connections {
-				if _, want := conn.pubStates[state.Name]; want {
+				if conn.wantState(state.Name) {
 					conn.writeChan <- state.FullData
 				}
 			}
 		case event := <-s.eventChan:
-			for _, conn := range connections {
-				if _, want := conn.pubEvents[event.Name]; want {
-					conn.writeChan <- event.FullData
-				}
-			}
+			broadcastEvent(connections, event)
 		case call := <-s.callChan:
-			s.onCall(call, connections, respWaiters)
+			s.onCall(call, connections, respWaiters, fanouts)
 		case resp := <-s.respChan:
-			onResp(connections, resp, respWaiters)
+			onResp(connections, resp, respWaiters, fanouts)
+		case pending := <-s.fanoutDone:
+			onFanoutDone(connections, pending, fanouts)
 		case subStateReq := <-s.subStateChan:
-			if conn, seen := connections[subStateReq.Source]; seen {
-				conn.pubStates = updatePubTable(subStateReq, conn.pubStates)
-				connections[subStateReq.Source] = conn
-			}
+			s.onSubState(connections, subStateReq, lastStates)
 		case subEventReq := <-s.subEventChan:
 			if conn, seen := connections[subEventReq.Source]; seen {
 				conn.pubEvents = updatePubTable(subEventReq, conn.pubEvents)
+				conn.eventPrefixes = prefixes(conn.pubEvents)
 				connections[subEventReq.Source] = conn
+				ackSub(connections, conn, subEventReq.UUID, false)
 			}
+		case req := <-s.sessionChan:
+			s.onSession(connections, req, sessions)
+		case <-sweep:
+			sweepSessions(sessions)
 		case m := <-s.addConnChan:
 			s.onAddConn(connections, m)
 		case m := <-s.removeConnChan:
-			s.onRemoveConn(connections, m, respWaiters)
+			s.onRemoveConn(connections, m, respWaiters, fanouts, sessions, lastStates)
 		case resChan := <-s.queryAllModel:
 			onQueryAllModel(connections, resChan)
+		case resChan := <-s.queryAllSummary:
+			onQueryAllSummary(connections, resChan)
 		case queryModel := <-s.queryModel:
 			onQueryModel(connections, queryModel)
 		case isOnlineReq := <-s.queryOnline:
@@ -174,24 +391,56 @@ func (s *Server) run() {
 
 func (s *Server) onCall(call callMessage,
 	connections map[string]connection,
-	respWaiters map[string]string) {
+	respWaiters map[string]string,
+	fanouts map[string]chan<- responseMessage) {
 	if call.Model == "proxy" {
+		// 代理没有可写状态
+		if call.SetState {
+			errStr := fmt.Sprintf("NO writable state %q in proxy", call.Method)
+			connections[call.Source].writeChan <- message.Must(message.EncodeRespMsg(call.UUID, errStr, message.Resp{}))
+			return
+		}
+
 		// 调用代理的方法
 		go s.dealProxyCall(call, connections[call.Source])
 		return
 	}
 
-	conn, seen := connections[call.Model]
+	// 通配符调用广播给所有匹配的物模型
+	if isWildcard(call.Model) {
+		s.onFanoutCall(call, connections, fanouts)
+		return
+	}
+
+	// 路由调用请求
+	target, err := s.router.Resolve(call.Model + "/" + call.Method)
+	if err != nil {
+		resp := make(map[string]interface{})
+		connections[call.Source].writeChan <- message.Must(message.EncodeRespMsg(call.UUID, err.Error(), resp))
+		return
+	}
+
+	conn, seen := connections[target]
 	if !seen {
 		// 期望调用的物模型不存在，直接返回错误响应
-		errStr := fmt.Sprintf("model %q NOT exist", call.Model)
+		errStr := fmt.Sprintf("model %q NOT exist", target)
 		resp := make(map[string]interface{})
 		connections[call.Source].writeChan <- message.Must(message.EncodeRespMsg(call.UUID, errStr, resp))
 		return
 	}
 
-	// 转发调用请求
-	conn.writeChan <- call.FullData
+	// 转发调用请求, 路由到其他物模型时需要替换方法全名中的模型名
+	if target == call.Model {
+		conn.writeChan <- call.FullData
+	} else if call.SetState {
+		conn.writeChan <- message.Must(message.EncodeSetStateMsg(target+"/"+call.Method, call.UUID, call.Data))
+	} else {
+		args := make(message.Args, len(call.Args))
+		for name, arg := range call.Args {
+			args[name] = arg
+		}
+		conn.writeChan <- message.Must(message.EncodeCallMsgWithHeaders(target+"/"+call.Method, call.UUID, args, call.Headers))
+	}
 
 	// 记录调用请求
 	respWaiters[call.UUID] = call.Source
@@ -200,13 +449,19 @@ func (s *Server) onCall(call callMessage,
 }
 
 func onResp(connections map[string]connection, resp responseMessage,
-	respWaiters map[string]string) {
+	respWaiters map[string]string, fanouts map[string]chan<- responseMessage) {
 	// 不是在编的物模型连接发送的调用请求不响应
 	if srcConn, seen := connections[resp.Source]; !seen {
 		return
 	} else {
 		delete(srcConn.inCalls, resp.UUID)
 	}
+	// 通配符调用的响应交给聚合协程
+	if results, seen := fanouts[resp.UUID]; seen {
+		results <- resp
+		delete(fanouts, resp.UUID)
+		return
+	}
 	// 响应无调用请求
 	if _, seen := respWaiters[resp.UUID]; !seen {
 		return
@@ -243,7 +498,8 @@ func (s *Server) onAddConn(connections map[string]connection, m *model) {
 	}
 
 	// 推送上线事件
-	go s.pushOnlineOrOfflineEvent(m.MetaInfo.Name, m.RemoteAddr().String(), true)
+	// NOTE: 在转发协程中直接广播, 保证同一物模型的上线事件始终先于其下线事件推送
+	broadcastEvent(connections, onlineOrOfflineEvent(m.MetaInfo.Name, m.RemoteAddr().String(), true))
 
 	// 添加链路, 并通知已添加
 	connections[m.MetaInfo.Name] = conn
@@ -254,7 +510,8 @@ func (s *Server) onAddConn(connections map[string]connection, m *model) {
 }
 
 func (s *Server) onRemoveConn(connections map[string]connection, m *model,
-	respWaiters map[string]string) {
+	respWaiters map[string]string, fanouts map[string]chan<- responseMessage,
+	sessions map[string]savedSession, lastStates map[string]cachedState) {
 	// NOTE: 需要判断模型是否添加,
 	// NOTE: 目的是防止重名的模型在退出时把原先好的物模型给删除了,
 	// NOTE: 导致原先好的物模型发送报文时出错，导致程序崩溃
@@ -263,6 +520,15 @@ func (s *Server) onRemoveConn(connections map[string]connection, m *model,
 		errStr := fmt.Sprintf("model %q have quit", m.MetaInfo.Name)
 		empty := make(map[string]interface{})
 		for uuid := range conn.inCalls {
+			if results, ok := fanouts[uuid]; ok {
+				results <- responseMessage{
+					Source:   m.MetaInfo.Name,
+					UUID:     uuid,
+					FullData: message.Must(message.EncodeRespMsg(uuid, errStr, empty)),
+				}
+				delete(fanouts, uuid)
+				continue
+			}
 			if destConn, ok := connections[respWaiters[uuid]]; ok {
 				destConn.writeChan <- message.Must(message.EncodeRespMsg(uuid, errStr, empty))
 			}
@@ -273,11 +539,22 @@ func (s *Server) onRemoveConn(connections map[string]connection, m *model,
 			delete(respWaiters, uuid)
 		}
 
+		// 保存会话的订阅关系
+		saveSession(conn, sessions, s.sessionTTL)
+
 		// 删除链路
 		delete(connections, m.MetaInfo.Name)
 
+		// 删除下线物模型的状态缓存
+		prefix := m.MetaInfo.Name + "/"
+		for state := range lastStates {
+			if strings.HasPrefix(state, prefix) {
+				delete(lastStates, state)
+			}
+		}
+
 		// 推送下线事件
-		go s.pushOnlineOrOfflineEvent(m.MetaInfo.Name, m.RemoteAddr().String(), false)
+		broadcastEvent(connections, onlineOrOfflineEvent(m.MetaInfo.Name, m.RemoteAddr().String(), false))
 	}
 
 	// NOTE: 在此处quitWriter, 不会导致由于连接writer协程提前退出而导致的死锁
@@ -285,6 +562,15 @@ func (s *Server) onRemoveConn(connections map[string]connection, m *model,
 	m.quitWriter()
 }
 
+// broadcastEvent 向所有订阅了事件event的连接推送事件
+func broadcastEvent(connections map[string]connection, event stateOrEventMessage) {
+	for _, conn := range connections {
+		if conn.wantEvent(event.Name) {
+			conn.writeChan <- event.FullData
+		}
+	}
+}
+
 func onQueryAllModel(connections map[string]connection, resChan chan []modelItem) {
 	items := make([]modelItem, 0, len(connections))
 	for modelName, conn := range connections {
@@ -307,6 +593,21 @@ func onQueryAllModel(connections map[string]connection, resChan chan []modelItem
 	resChan <- items
 }
 
+func onQueryAllSummary(connections map[string]connection, resChan chan []modelSummary) {
+	items := make([]modelSummary, 0, len(connections))
+	for modelName, conn := range connections {
+		items = append(items, modelSummary{
+			ModelName:   modelName,
+			Addr:        conn.model.RemoteAddr().String(),
+			Description: conn.MetaInfo.Description,
+			StateCount:  uint(len(conn.MetaInfo.State)),
+			EventCount:  uint(len(conn.MetaInfo.Event)),
+			MethodCount: uint(len(conn.MetaInfo.Method)),
+		})
+	}
+	resChan <- items
+}
+
 func onQueryModel(connections map[string]connection, queryModel queryModelReq) {
 	info := modelItem{
 		ModelName: "none",
@@ -366,12 +667,15 @@ func (s *Server) addModelConnection(conn rawConn.RawConn) {
 		respChan:       s.respChan,
 		subStateChan:   s.subStateChan,
 		subEventChan:   s.subEventChan,
+		sessionChan:    s.sessionChan,
 		writeChan:      make(chan []byte, 256),
 		writerQuit:     make(chan struct{}),
 		added:          make(chan struct{}),
 		metaGotChan:    make(chan struct{}),
 		MetaInfo:       meta.NewEmptyMeta(),
 		log:            s.log,
+		logFormat:      s.logFormat,
+		metaMessage:    s.metaMessage,
 		buffer:         make([]msgPack, 0, 256),
 	}
 
@@ -380,16 +684,23 @@ func (s *Server) addModelConnection(conn rawConn.RawConn) {
 		"add-subscribe-state":    ans.onSubState,
 		"remove-subscribe-state": ans.onSubState,
 		"clear-subscribe-state":  ans.onSubState,
+		"update-subscribe-state": ans.onSubState,
 		"set-subscribe-event":    ans.onSubEvent,
 		"add-subscribe-event":    ans.onSubEvent,
 		"remove-subscribe-event": ans.onSubEvent,
 		"clear-subscribe-event":  ans.onSubEvent,
+		"update-subscribe-event": ans.onSubEvent,
 		"state":                  ans.onState,
 		"event":                  ans.onEvent,
 		"call":                   ans.onCall,
+		"set-state":              ans.onSetState,
 		"response":               ans.onResp,
 		"query-meta":             ans.onQueryMeta,
 		"meta-info":              ans.onMetaInfo,
+		"ping":                   ans.onPing,
+		"pong":                   ans.onPong,
+		"close":                  ans.onClose,
+		"session":                ans.onSession,
 	}
 
 	go ans.writer()
@@ -417,6 +728,126 @@ func (s *Server) addModelConnection(conn rawConn.RawConn) {
 	s.addConnChan <- ans
 }
 
+// onSubState 按照状态订阅请求req更新物模型的状态订阅关系, 需要时推送新订阅状态的最新值, 并回复订阅确认
+func (s *Server) onSubState(connections map[string]connection, req subStateOrEventMessage, lastStates map[string]cachedState) {
+	conn, seen := connections[req.Source]
+	if !seen {
+		return
+	}
+
+	// NOTE: 更新订阅先取消订阅再添加订阅, 因此必须先取消订阅再计算新增的订阅项,
+	// NOTE: 否则同时取消并重新订阅的状态不会推送最新值
+	if req.Type == message.UpdateSub {
+		for _, sub := range req.Remove {
+			delete(conn.pubStates, sub)
+		}
+	}
+	added := newItems(conn.pubStates, req.Items)
+
+	conn.pubStates = updatePubTable(req, conn.pubStates)
+	conn.statePrefixes = prefixes(conn.pubStates)
+	connections[req.Source] = conn
+	if req.Snapshot {
+		sendSnapshot(conn, added, lastStates, s.snapshotTTL)
+	}
+	ackSub(connections, conn, req.UUID, true)
+}
+
+// newItems 返回items中不在集合set中的所有项
+func newItems(set map[string]struct{}, items []string) []string {
+	ans := make([]string, 0, len(items))
+	for _, item := range items {
+		if _, seen := set[item]; !seen {
+			ans = append(ans, item)
+		}
+	}
+	return ans
+}
+
+// sendSnapshot 向连接conn推送状态列表states中所有已缓存状态的最新值, 前缀订阅推送所有匹配前缀的已缓存状态.
+// ttl大于0时不推送接收时间早于ttl之前的缓存状态.
+func sendSnapshot(conn connection, states []string, lastStates map[string]cachedState, ttl time.Duration) {
+	now := time.Now()
+	sent := make(map[string]struct{})
+	send := func(name string) {
+		if _, done := sent[name]; done {
+			return
+		}
+		cached, seen := lastStates[name]
+		if !seen || (ttl > 0 && now.Sub(cached.time) > ttl) {
+			return
+		}
+		sent[name] = struct{}{}
+		conn.writeChan <- cached.data
+	}
+
+	for _, state := range states {
+		if _, want := conn.pubStates[state]; !want {
+			continue
+		}
+		if prefix, isPrefix := prefixOf(state); isPrefix {
+			for name := range lastStates {
+				if strings.HasPrefix(name, prefix) {
+					send(name)
+				}
+			}
+			continue
+		}
+		send(state)
+	}
+}
+
+// ackSub 若uuid不为空, 则向连接conn回复订阅确认, 确认报文中包含conn当前有效的状态(isState为true)或事件订阅列表,
+// 有效订阅只包含在线物模型和代理本身存在的状态或事件, 以及所有的前缀订阅.
+func ackSub(connections map[string]connection, conn connection, uuid string, isState bool) {
+	if uuid == "" {
+		return
+	}
+
+	pubSet := conn.pubEvents
+	if isState {
+		pubSet = conn.pubStates
+	}
+
+	items := make([]string, 0, len(pubSet))
+	for item := range pubSet {
+		// 前缀订阅匹配的物模型随时上下线, 总是有效
+		if _, isPrefix := prefixOf(item); isPrefix {
+			items = append(items, item)
+			continue
+		}
+
+		modelName, _, err := splitModelName(item)
+		if err != nil {
+			continue
+		}
+		for _, name := range allItems(connections, modelName, isState) {
+			if name == item {
+				items = append(items, item)
+				break
+			}
+		}
+	}
+	sort.Strings(items)
+
+	conn.writeChan <- message.EncodeSubAckMsg(uuid, items)
+}
+
+// allItems 返回名称为modelName的物模型的所有状态(isState为true)或事件全名, 物模型不在线时返回空列表.
+func allItems(connections map[string]connection, modelName string, isState bool) []string {
+	metaInfo := proxyMetaInfo
+	if target, online := connections[modelName]; online {
+		metaInfo = target.MetaInfo
+	} else if modelName != proxyMetaInfo.Name {
+		return nil
+	}
+
+	if isState {
+		return metaInfo.AllStates()
+	}
+	return metaInfo.AllEvents()
+}
+
 func updatePubTable(req subStateOrEventMessage, pubSet map[string]struct{}) map[string]struct{} {
 	switch req.Type {
 	case message.SetSub:
@@ -434,7 +865,53 @@ func updatePubTable(req subStateOrEventMessage, pubSet map[string]struct{}) map[
 		}
 	case message.ClearSub:
 		pubSet = make(map[string]struct{})
+	case message.UpdateSub:
+		for _, sub := range req.Remove {
+			delete(pubSet, sub)
+		}
+		for _, sub := range req.Items {
+			pubSet[sub] = struct{}{}
+		}
 	}
 
 	return pubSet
 }
+
+// prefixOf 若订阅项item为前缀订阅, 返回其匹配的名称前缀和true.
+// 以"/**"结尾的订阅项为前缀订阅, 匹配名称以"/**"之前的部分加"/"开头的所有状态或事件,
+// 例如"A/car/#1/**"匹配物模型"A/car/#1"及名称以"A/car/#1/"开头的所有物模型(如"A/car/#1/tpqs")的状态或事件,
+// 订阅项"**"匹配所有状态或事件.
+func prefixOf(item string) (string, bool) {
+	if item == "**" {
+		return "", true
+	}
+	if strings.HasSuffix(item, "/**") {
+		return strings.TrimSuffix(item, "**"), true
+	}
+	return "", false
+}
+
+// prefixes 返回订阅表pubSet中所有前缀订阅的前缀
+func prefixes(pubSet map[string]struct{}) []string {
+	var ans []string
+	for item := range pubSet {
+		if prefix, isPrefix := prefixOf(item); isPrefix {
+			ans = append(ans, prefix)
+		}
+	}
+	return ans
+}
+
+// wants 返回订阅表pubSet(其中前缀订阅的前缀为prefixList)是否订阅了名称为name的状态或事件.
+// 前缀订阅在转发时匹配, 因此之后上线的物模型的状态或事件也能匹配.
+func wants(pubSet map[string]struct{}, prefixList []string, name string) bool {
+	if _, want := pubSet[name]; want {
+		return true
+	}
+	for _, prefix := range prefixList {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
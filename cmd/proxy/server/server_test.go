@@ -0,0 +1,313 @@
+package server
+
+import (
+	"compress/flate"
+	"github.com/gorilla/websocket"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// drain 读取连接conn收到的所有报文
+func drain(conn connection) []string {
+	var ans []string
+	for {
+		select {
+		case data := <-conn.writeChan:
+			ans = append(ans, string(data))
+		default:
+			return ans
+		}
+	}
+}
+
+// TestSendSnapshot_TTL 测试订阅时只推送未超过ttl的缓存状态
+func TestSendSnapshot_TTL(t *testing.T) {
+	s := New(nil, WithSnapshotTTL(time.Minute))
+	assert.Equal(t, time.Minute, s.snapshotTTL)
+
+	now := time.Now()
+	lastStates := map[string]cachedState{
+		"A/fresh": {data: []byte("fresh"), time: now.Add(-time.Second)},
+		"A/stale": {data: []byte("stale"), time: now.Add(-time.Hour)},
+		"B/fresh": {data: []byte("B fresh"), time: now},
+		"B/stale": {data: []byte("B stale"), time: now.Add(-2 * time.Minute)},
+	}
+
+	states := []string{"A/fresh", "A/stale", "B/**"}
+	conn := newTestConn(states, nil)
+	sendSnapshot(conn, states, lastStates, s.snapshotTTL)
+	assert.ElementsMatch(t, []string{"fresh", "B fresh"}, drain(conn), "不推送超过ttl的状态")
+
+	// ttl为0时推送所有缓存状态
+	sendSnapshot(conn, states, lastStates, 0)
+	assert.ElementsMatch(t, []string{"fresh", "stale", "B fresh", "B stale"}, drain(conn), "不限制ttl")
+}
+
+// TestOnSubState_Update 测试更新订阅先取消订阅再添加订阅, 并推送新订阅状态的最新值
+func TestOnSubState_Update(t *testing.T) {
+	s := &Server{}
+	now := time.Now()
+	lastStates := map[string]cachedState{
+		"A/s1": {data: []byte("s1"), time: now},
+		"A/s2": {data: []byte("s2"), time: now},
+		"A/s3": {data: []byte("s3"), time: now},
+	}
+	connections := map[string]connection{"C": newTestConn([]string{"A/s1", "A/s3"}, nil)}
+
+	// 同时取消并重新订阅的状态视为新增
+	s.onSubState(connections, subStateOrEventMessage{
+		Source:   "C",
+		Type:     message.UpdateSub,
+		Items:    []string{"A/s1", "A/s2"},
+		Remove:   []string{"A/s1", "A/s3"},
+		Snapshot: true,
+	}, lastStates)
+	conn := connections["C"]
+	assert.Equal(t, map[string]struct{}{"A/s1": {}, "A/s2": {}}, conn.pubStates)
+	assert.ElementsMatch(t, []string{"s1", "s2"}, drain(conn), "推送新订阅状态的最新值")
+
+	// 已经订阅的状态不重复推送
+	s.onSubState(connections, subStateOrEventMessage{
+		Source:   "C",
+		Type:     message.UpdateSub,
+		Items:    []string{"A/s2", "A/s3"},
+		Remove:   []string{"A/s1"},
+		Snapshot: true,
+	}, lastStates)
+	conn = connections["C"]
+	assert.Equal(t, map[string]struct{}{"A/s2": {}, "A/s3": {}}, conn.pubStates)
+	assert.Equal(t, []string{"s3"}, drain(conn))
+	assert.True(t, conn.wantState("A/s3"))
+	assert.False(t, conn.wantState("A/s1"), "取消订阅")
+
+	// 不需要推送最新值
+	s.onSubState(connections, subStateOrEventMessage{
+		Source: "C",
+		Type:   message.UpdateSub,
+		Items:  []string{"A/s1"},
+		Remove: []string{"A/s2", "A/s3"},
+	}, lastStates)
+	conn = connections["C"]
+	assert.Equal(t, map[string]struct{}{"A/s1": {}}, conn.pubStates)
+	assert.Empty(t, drain(conn))
+
+	// 不在线的物模型的订阅请求被忽略
+	s.onSubState(connections, subStateOrEventMessage{Source: "D", Type: message.AddSub, Items: []string{"A/s1"}}, lastStates)
+	assert.NotContains(t, connections, "D")
+}
+
+// newOnlineModel 创建名称为name的物模型连接, 用于测试物模型上下线
+func newOnlineModel(name string) *model {
+	return &model{
+		RawConn:    addrConn{},
+		writeChan:  make(chan []byte, 8),
+		writerQuit: make(chan struct{}),
+		added:      make(chan struct{}),
+		MetaInfo:   &meta.Meta{Name: name},
+	}
+}
+
+// TestPrefixOf 测试解析前缀订阅的前缀
+func TestPrefixOf(t *testing.T) {
+	type TestCase struct {
+		item     string // 订阅项
+		prefix   string // 前缀
+		isPrefix bool   // 是否为前缀订阅
+	}
+
+	testCases := []TestCase{
+		{"A/car/#1/**", "A/car/#1/", true},
+		{"A/**", "A/", true},
+		{"**", "", true},
+		{"A/car/#1/tpqs", "", false},
+		{"A/car/#1**", "", false},
+		{"A/car/#1/*", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range testCases {
+		prefix, isPrefix := prefixOf(test.item)
+		assert.Equal(t, test.isPrefix, isPrefix, test.item)
+		assert.Equal(t, test.prefix, prefix, test.item)
+	}
+}
+
+// TestWants 测试订阅表按照全名和前缀匹配状态或事件
+func TestWants(t *testing.T) {
+	conn := newTestConn([]string{"A/car/#1/**", "B/s1"}, []string{"**"})
+	assert.ElementsMatch(t, []string{"A/car/#1/"}, conn.statePrefixes)
+	assert.ElementsMatch(t, []string{""}, conn.eventPrefixes)
+
+	assert.True(t, conn.wantState("A/car/#1/speed"), "匹配物模型本身的状态")
+	assert.True(t, conn.wantState("A/car/#1/tpqs/angle"), "匹配子物模型的状态")
+	assert.True(t, conn.wantState("B/s1"), "全名订阅")
+	assert.False(t, conn.wantState("A/car/#10/speed"), "前缀必须以/分隔")
+	assert.False(t, conn.wantState("A/car/#2/speed"))
+	assert.False(t, conn.wantState("B/s2"))
+	assert.True(t, conn.wantEvent("C/any"), "**匹配所有事件")
+
+	// 取消前缀订阅后不再匹配
+	s := &Server{}
+	connections := map[string]connection{"C": conn}
+	s.onSubState(connections, subStateOrEventMessage{
+		Source: "C",
+		Type:   message.RemoveSub,
+		Items:  []string{"A/car/#1/**"},
+	}, map[string]cachedState{})
+	conn = connections["C"]
+	assert.Empty(t, conn.statePrefixes)
+	assert.False(t, conn.wantState("A/car/#1/speed"))
+	assert.True(t, conn.wantState("B/s1"))
+}
+
+// TestSendSnapshot_Prefix 测试前缀订阅只推送匹配前缀的缓存状态
+func TestSendSnapshot_Prefix(t *testing.T) {
+	now := time.Now()
+	lastStates := map[string]cachedState{
+		"A/car/#1/speed":      {data: []byte("speed"), time: now},
+		"A/car/#1/tpqs/angle": {data: []byte("angle"), time: now},
+		"A/car/#10/speed":     {data: []byte("#10 speed"), time: now},
+		"A/car/#2/speed":      {data: []byte("#2 speed"), time: now},
+	}
+
+	states := []string{"A/car/#1/**", "A/car/#1/speed"}
+	conn := newTestConn(states, nil)
+	sendSnapshot(conn, states, lastStates, 0)
+	assert.ElementsMatch(t, []string{"speed", "angle"}, drain(conn), "前缀与全名同时匹配的状态只推送一次")
+
+	// 没有订阅的订阅项不推送
+	sendSnapshot(conn, []string{"A/car/#2/**"}, lastStates, 0)
+	assert.Empty(t, drain(conn))
+}
+
+// TestPrefix_OnlineOffline 测试前缀订阅在物模型上下线后重新匹配
+func TestPrefix_OnlineOffline(t *testing.T) {
+	s := &Server{}
+	connections := map[string]connection{"C": newTestConn(nil, nil)}
+	lastStates := make(map[string]cachedState)
+
+	// 订阅时匹配的物模型不在线, 前缀订阅仍然有效
+	s.onSubState(connections, subStateOrEventMessage{
+		Source:   "C",
+		Type:     message.SetSub,
+		Items:    []string{"A/car/#1/**"},
+		UUID:     "u1",
+		Snapshot: true,
+	}, lastStates)
+	_, resp := readAck(t, connections["C"])
+	assert.Equal(t, []interface{}{"A/car/#1/**"}, resp["items"], "前缀订阅总是有效")
+	assert.Empty(t, drain(connections["C"]))
+
+	// 匹配的物模型上线后, 其状态被转发
+	tpqs := newOnlineModel("A/car/#1/tpqs")
+	s.onAddConn(connections, tpqs)
+	require.Contains(t, connections, "A/car/#1/tpqs")
+	assert.True(t, connections["C"].wantState("A/car/#1/tpqs/angle"))
+	lastStates["A/car/#1/tpqs/angle"] = cachedState{data: []byte("angle"), time: time.Now()}
+	lastStates["A/car/#2/tpqs/angle"] = cachedState{data: []byte("#2 angle"), time: time.Now()}
+
+	// 重新订阅时推送已上线物模型的最新状态
+	s.onSubState(connections, subStateOrEventMessage{
+		Source:   "C",
+		Type:     message.UpdateSub,
+		Items:    []string{"A/car/#1/**"},
+		Remove:   []string{"A/car/#1/**"},
+		Snapshot: true,
+	}, lastStates)
+	assert.Equal(t, []string{"angle"}, drain(connections["C"]))
+
+	// 匹配的物模型下线后, 不再推送其缓存状态
+	s.onRemoveConn(connections, tpqs, map[string]string{}, map[string]chan<- responseMessage{},
+		map[string]savedSession{}, lastStates)
+	assert.NotContains(t, connections, "A/car/#1/tpqs")
+	assert.NotContains(t, lastStates, "A/car/#1/tpqs/angle")
+	s.onSubState(connections, subStateOrEventMessage{
+		Source:   "C",
+		Type:     message.UpdateSub,
+		Items:    []string{"A/car/#1/**"},
+		Remove:   []string{"A/car/#1/**"},
+		Snapshot: true,
+	}, lastStates)
+	assert.Empty(t, drain(connections["C"]))
+	assert.True(t, connections["C"].wantState("A/car/#1/tpqs/angle"), "前缀订阅在物模型下线后仍然有效")
+}
+
+// TestWithWebSocketCompression 测试代理接受的WebSocket连接协商压缩扩展
+func TestWithWebSocketCompression(t *testing.T) {
+	assert.False(t, New(nil, WithWebSocketCompression(flate.BestCompression+1)).wsCompress, "无效的压缩级别")
+	assert.False(t, New(nil).wsCompress, "默认不压缩")
+
+	s := New(nil, WithWebSocketCompression(flate.BestSpeed))
+	assert.True(t, s.wsCompress)
+	assert.Equal(t, flate.BestSpeed, s.wsCompressLevel)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.serveWebSocket))
+	defer ts.Close()
+	addr := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	dialer := websocket.Dialer{EnableCompression: true}
+	raw, resp, err := dialer.Dial(addr, nil)
+	require.Nil(t, err)
+	assert.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+	// 压缩的元信息查询报文仍然能正确解码
+	_, data, err := raw.ReadMessage()
+	require.Nil(t, err)
+	assert.Equal(t, message.EncodeQueryMetaMsg(), data)
+	require.Nil(t, raw.Close())
+
+	raw, resp, err = websocket.DefaultDialer.Dial(addr, nil)
+	require.Nil(t, err)
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"), "物模型不支持压缩时不压缩")
+	require.Nil(t, raw.Close())
+
+	// 未开启压缩的代理不协商压缩扩展
+	ts2 := httptest.NewServer(http.HandlerFunc(New(nil).serveWebSocket))
+	defer ts2.Close()
+	raw, resp, err = dialer.Dial("ws"+strings.TrimPrefix(ts2.URL, "http"), nil)
+	require.Nil(t, err)
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"))
+	require.Nil(t, raw.Close())
+}
+
+// TestWithVersion 测试代理回复的元信息报文中包含配置的版本号
+func TestWithVersion(t *testing.T) {
+	assert.Equal(t, proxyMetaMessage, New(nil, WithVersion("v1")).metaMessage, "无效的版本号")
+	assert.Equal(t, proxyMetaMessage, New(nil).metaMessage, "默认不包含版本号")
+
+	s := New(nil, WithVersion("1.2.0-rc.1"))
+	ts := httptest.NewServer(http.HandlerFunc(s.serveWebSocket))
+	defer ts.Close()
+	raw, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	require.Nil(t, err)
+	defer raw.Close()
+	require.Nil(t, raw.SetReadDeadline(time.Now().Add(2*time.Second)))
+
+	// 回复代理的元信息查询报文后查询代理的元信息
+	require.Nil(t, raw.WriteMessage(websocket.TextMessage, message.Must(message.EncodeRawMsg("meta-info",
+		[]byte(`{"name": "A/car", "description": "车辆", "state": [], "event": [], "method": []}`)))))
+	require.Nil(t, raw.WriteMessage(websocket.TextMessage, message.EncodeQueryMetaMsg()))
+	for {
+		_, data, err := raw.ReadMessage()
+		require.Nil(t, err)
+		msg, err := message.Decode(data)
+		require.Nil(t, err)
+		if msg.Type != "meta-info" {
+			continue
+		}
+
+		got, err := meta.Parse(msg.Payload, nil)
+		require.Nil(t, err)
+		assert.Equal(t, "1.2.0-rc.1", got.Version)
+		assert.Equal(t, proxyMetaInfo.Name, got.Name)
+		assert.Equal(t, len(proxyMetaInfo.Method), len(got.Method))
+		return
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"github.com/object-model/goModel/message"
+	"sort"
+	"time"
+)
+
+type sessionMessage struct {
+	Source string // 发送会话报文的物模型名称
+	ID     string // 会话ID
+	UUID   string // 会话确认的UUID, 为空表示不需要回复会话确认
+}
+
+// savedSession 为断开连接的物模型的会话保存的订阅关系
+type savedSession struct {
+	pubStates map[string]struct{} // 状态发布表
+	pubEvents map[string]struct{} // 事件发布表
+	expire    time.Time           // 过期时间
+}
+
+// onSession 将物模型conn绑定到会话req.ID, 若该会话在断开后尚未过期, 恢复会话保存的订阅关系.
+// 代理没有开启会话或者会话已被其他在线物模型绑定时回复错误.
+func (s *Server) onSession(connections map[string]connection, req sessionMessage, sessions map[string]savedSession) {
+	conn, seen := connections[req.Source]
+	if !seen {
+		return
+	}
+
+	if s.sessionTTL <= 0 {
+		ackSession(conn, req.UUID, "session NOT enabled", false)
+		return
+	}
+
+	for name, other := range connections {
+		if name != req.Source && other.session == req.ID {
+			ackSession(conn, req.UUID, fmt.Sprintf("session %q in use by %q", req.ID, name), false)
+			return
+		}
+	}
+
+	// 恢复未过期的会话, 与当前的订阅关系合并
+	saved, restored := sessions[req.ID]
+	if restored && time.Now().After(saved.expire) {
+		restored = false
+	}
+	if restored {
+		for item := range saved.pubStates {
+			conn.pubStates[item] = struct{}{}
+		}
+		for item := range saved.pubEvents {
+			conn.pubEvents[item] = struct{}{}
+		}
+		conn.statePrefixes = prefixes(conn.pubStates)
+		conn.eventPrefixes = prefixes(conn.pubEvents)
+	}
+	delete(sessions, req.ID)
+
+	conn.session = req.ID
+	connections[req.Source] = conn
+	ackSession(conn, req.UUID, "", restored)
+}
+
+// saveSession 在绑定了会话的物模型conn断开连接时保存其订阅关系, 保存时长为ttl
+func saveSession(conn connection, sessions map[string]savedSession, ttl time.Duration) {
+	if conn.session == "" || ttl <= 0 {
+		return
+	}
+
+	sessions[conn.session] = savedSession{
+		pubStates: conn.pubStates,
+		pubEvents: conn.pubEvents,
+		expire:    time.Now().Add(ttl),
+	}
+}
+
+// sweepSessions 删除所有已经过期的会话
+func sweepSessions(sessions map[string]savedSession) {
+	now := time.Now()
+	for id, saved := range sessions {
+		if now.After(saved.expire) {
+			delete(sessions, id)
+		}
+	}
+}
+
+// ackSession 向物模型conn回复确认标识为uuid的会话确认, uuid为空时不回复
+func ackSession(conn connection, uuid string, errStr string, restored bool) {
+	if uuid == "" {
+		return
+	}
+
+	conn.writeChan <- message.Must(message.EncodeRespMsg(uuid, errStr, message.Resp{
+		"restored": restored,
+		"states":   sortedItems(conn.pubStates),
+		"events":   sortedItems(conn.pubEvents),
+	}))
+}
+
+// sortedItems 返回订阅集合set中的所有项, 按名称排序
+func sortedItems(set map[string]struct{}) []string {
+	ans := make([]string, 0, len(set))
+	for item := range set {
+		ans = append(ans, item)
+	}
+	sort.Strings(ans)
+	return ans
+}
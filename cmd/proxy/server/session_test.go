@@ -0,0 +1,195 @@
+package server
+
+import (
+	jsoniter "github.com/json-iterator/go"
+	"github.com/object-model/goModel/message"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestConn 创建用于测试的连接, 订阅了状态列表states和事件列表events
+func newTestConn(states []string, events []string) connection {
+	conn := connection{
+		model:     &model{writeChan: make(chan []byte, 8)},
+		outCalls:  make(map[string]struct{}),
+		inCalls:   make(map[string]struct{}),
+		pubStates: make(map[string]struct{}),
+		pubEvents: make(map[string]struct{}),
+	}
+	for _, state := range states {
+		conn.pubStates[state] = struct{}{}
+	}
+	for _, event := range events {
+		conn.pubEvents[event] = struct{}{}
+	}
+	conn.statePrefixes = prefixes(conn.pubStates)
+	conn.eventPrefixes = prefixes(conn.pubEvents)
+	return conn
+}
+
+// readAck 读取连接conn收到的会话确认
+func readAck(t *testing.T, conn connection) (string, message.Resp) {
+	select {
+	case data := <-conn.writeChan:
+		msg, err := message.Decode(data)
+		require.Nil(t, err)
+		payload, err := message.ParseResponsePayload(msg.Payload)
+		require.Nil(t, err)
+		resp, err := payload.Response.ToResp()
+		require.Nil(t, err)
+		return payload.Error, resp
+	default:
+		require.Fail(t, "NO session ack")
+		return "", nil
+	}
+}
+
+// TestSession_Restore 测试以相同的会话ID重连后恢复并合并订阅关系
+func TestSession_Restore(t *testing.T) {
+	s := &Server{sessionTTL: time.Minute}
+	sessions := make(map[string]savedSession)
+
+	// 绑定会话后断开连接, 保存订阅关系
+	old := newTestConn([]string{"A/s1", "B/**"}, []string{"A/e1"})
+	connections := map[string]connection{"phone": old}
+	s.onSession(connections, sessionMessage{Source: "phone", ID: "sid", UUID: "1"}, sessions)
+	errStr, resp := readAck(t, connections["phone"])
+	assert.Equal(t, "", errStr)
+	assert.Equal(t, false, resp["restored"], "新会话")
+	saveSession(connections["phone"], sessions, s.sessionTTL)
+	require.Contains(t, sessions, "sid")
+
+	// 重连后恢复, 与当前的订阅关系合并
+	connections = map[string]connection{"phone": newTestConn([]string{"C/s2"}, nil)}
+	s.onSession(connections, sessionMessage{Source: "phone", ID: "sid", UUID: "2"}, sessions)
+	errStr, resp = readAck(t, connections["phone"])
+	assert.Equal(t, "", errStr)
+	assert.Equal(t, true, resp["restored"], "恢复会话")
+	assert.Equal(t, []interface{}{"A/s1", "B/**", "C/s2"}, resp["states"], "合并状态订阅")
+	assert.Equal(t, []interface{}{"A/e1"}, resp["events"], "恢复事件订阅")
+
+	conn := connections["phone"]
+	assert.Equal(t, "sid", conn.session)
+	assert.True(t, conn.wantState("B/s3"), "恢复前缀订阅")
+	assert.True(t, conn.wantEvent("A/e1"))
+	assert.NotContains(t, sessions, "sid", "恢复后删除保存的会话")
+
+	// 会话已被其他在线物模型绑定
+	connections["tablet"] = newTestConn(nil, nil)
+	s.onSession(connections, sessionMessage{Source: "tablet", ID: "sid", UUID: "3"}, sessions)
+	errStr, _ = readAck(t, connections["tablet"])
+	assert.Equal(t, `session "sid" in use by "phone"`, errStr)
+	assert.Equal(t, "", connections["tablet"].session)
+
+	// 没有开启会话
+	disabled := &Server{}
+	disabled.onSession(connections, sessionMessage{Source: "tablet", ID: "other", UUID: "4"}, sessions)
+	errStr, _ = readAck(t, connections["tablet"])
+	assert.Equal(t, "session NOT enabled", errStr)
+	saveSession(connections["phone"], sessions, disabled.sessionTTL)
+	assert.Empty(t, sessions, "没有开启会话时不保存")
+}
+
+// TestSession_Expire 测试过期的会话不再恢复并被定期清除
+func TestSession_Expire(t *testing.T) {
+	s := &Server{sessionTTL: time.Minute}
+	sessions := map[string]savedSession{
+		"expired": {
+			pubStates: map[string]struct{}{"A/s1": {}},
+			pubEvents: map[string]struct{}{},
+			expire:    time.Now().Add(-time.Second),
+		},
+		"alive": {
+			pubStates: map[string]struct{}{"A/s2": {}},
+			pubEvents: map[string]struct{}{},
+			expire:    time.Now().Add(time.Minute),
+		},
+		"stale": {
+			pubStates: map[string]struct{}{},
+			pubEvents: map[string]struct{}{},
+			expire:    time.Now().Add(-time.Second),
+		},
+	}
+
+	// 过期的会话不恢复
+	connections := map[string]connection{"phone": newTestConn(nil, nil)}
+	s.onSession(connections, sessionMessage{Source: "phone", ID: "expired", UUID: "1"}, sessions)
+	errStr, resp := readAck(t, connections["phone"])
+	assert.Equal(t, "", errStr)
+	assert.Equal(t, false, resp["restored"], "会话已过期")
+	assert.Equal(t, []interface{}{}, resp["states"], "会话已过期")
+	assert.False(t, connections["phone"].wantState("A/s1"), "会话已过期")
+	assert.NotContains(t, sessions, "expired")
+
+	// 定期清除过期的会话
+	sweepSessions(sessions)
+	assert.NotContains(t, sessions, "stale", "清除过期的会话")
+	assert.Contains(t, sessions, "alive", "保留未过期的会话")
+
+	// 断开连接后保存ttl时长
+	saveSession(connections["phone"], sessions, time.Millisecond)
+	require.Contains(t, sessions, "expired")
+	time.Sleep(5 * time.Millisecond)
+	sweepSessions(sessions)
+	assert.NotContains(t, sessions, "expired", "超过ttl后清除")
+}
+
+// TestGetSubList_Close 测试在调用者的连接关闭的同时查询订阅列表不会阻塞
+func TestGetSubList_Close(t *testing.T) {
+	s := &Server{
+		querySubState: make(chan querySubReq),
+		querySubEvent: make(chan querySubReq),
+	}
+	connections := map[string]connection{"A": newTestConn([]string{"B/s1"}, []string{"B/e1"})}
+
+	// 代理只响应前几次查询, 模拟繁忙的代理
+	go func() {
+		for i := 0; i < 5; i++ {
+			select {
+			case req := <-s.querySubState:
+				onQuerySub(connections, req, true)
+			case req := <-s.querySubEvent:
+				onQuerySub(connections, req, false)
+			}
+		}
+	}()
+
+	caller := newTestConn(nil, nil)
+	caller.writerQuit = make(chan struct{})
+	args := map[string]jsoniter.RawMessage{"modelName": jsoniter.RawMessage(`"A"`)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		method := "GetSubState"
+		if i%2 == 1 {
+			method = "GetSubEvent"
+		}
+		go func() {
+			defer wg.Done()
+			s.dealProxyCall(callMessage{Method: method, UUID: "1", Args: args}, caller)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	caller.quitWriter()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "查询订阅列表阻塞")
+	}
+
+	// 连接关闭后立即返回
+	resp, errStr := s.getSubList(args, s.querySubState, caller.writerQuit)
+	assert.Equal(t, "connection closed", errStr)
+	assert.Equal(t, message.Resp{"subList": []string{}, "got": false}, resp)
+}
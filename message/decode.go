@@ -0,0 +1,151 @@
+package message
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+)
+
+// Decode 将一帧完整的报文数据data解码为 RawMessage, 返回解码结果和错误信息.
+// 报文内容保持未解码状态, 可以根据报文类型通过 ParseStatePayload 、 ParseCallPayload 等函数进一步解析,
+// 用于在自定义传输层或者网关中不借助连接处理报文.
+func Decode(data []byte) (RawMessage, error) {
+	msg := RawMessage{}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return RawMessage{}, err
+	}
+	return msg, nil
+}
+
+// ParseStatePayload 将状态报文的报文内容payload解码为 StatePayload, 状态名为空或者缺少状态数据时返回错误信息.
+// 状态数据为JSON null的清除状态报文(见 EncodeClearStateMsg)解码后的状态数据为 null, StatePayload.Cleared 返回true.
+func ParseStatePayload(payload []byte) (StatePayload, error) {
+	state := StatePayload{}
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return StatePayload{}, err
+	}
+
+	if strings.TrimSpace(state.Name) == "" {
+		return StatePayload{}, errors.New("name missing")
+	}
+	if state.Data == nil {
+		// NOTE: 解码时JSON null与字段缺失都得到nil, 需要区分清除状态报文
+		if json.Get(payload, "data").ValueType() != jsoniter.NilValue {
+			return StatePayload{}, errors.New("data missing")
+		}
+		state.Data = jsoniter.RawMessage("null")
+	}
+
+	return state, nil
+}
+
+// ParseEventPayload 将事件报文的报文内容payload解码为 EventPayload, 事件名为空或者缺少事件参数时返回错误信息.
+func ParseEventPayload(payload []byte) (EventPayload, error) {
+	event := EventPayload{}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return EventPayload{}, err
+	}
+
+	if strings.TrimSpace(event.Name) == "" {
+		return EventPayload{}, errors.New("name missing")
+	}
+	if event.Args == nil {
+		return EventPayload{}, errors.New("args missing")
+	}
+
+	return event, nil
+}
+
+// ParseCallPayload 将调用请求报文的报文内容payload解码为 CallPayload, 方法名或UUID为空或者缺少调用参数时返回错误信息.
+func ParseCallPayload(payload []byte) (CallPayload, error) {
+	call := CallPayload{}
+	if err := json.Unmarshal(payload, &call); err != nil {
+		return CallPayload{}, err
+	}
+
+	if strings.TrimSpace(call.Name) == "" {
+		return CallPayload{}, errors.New("name missing")
+	}
+	if strings.TrimSpace(call.UUID) == "" {
+		return CallPayload{}, errors.New("uuid missing")
+	}
+	if call.Args == nil {
+		return CallPayload{}, errors.New("args missing")
+	}
+
+	return call, nil
+}
+
+// ParseSetStatePayload 将状态设置报文的报文内容payload解码为 SetStatePayload, 状态名或UUID为空或者缺少状态数据时返回错误信息.
+func ParseSetStatePayload(payload []byte) (SetStatePayload, error) {
+	setState := SetStatePayload{}
+	if err := json.Unmarshal(payload, &setState); err != nil {
+		return SetStatePayload{}, err
+	}
+
+	if strings.TrimSpace(setState.Name) == "" {
+		return SetStatePayload{}, errors.New("name missing")
+	}
+	if strings.TrimSpace(setState.UUID) == "" {
+		return SetStatePayload{}, errors.New("uuid missing")
+	}
+	if setState.Data == nil {
+		return SetStatePayload{}, errors.New("data missing")
+	}
+
+	return setState, nil
+}
+
+// ParseResponsePayload 将调用响应报文的报文内容payload解码为 ResponsePayload, UUID为空或者缺少响应返回值时返回错误信息.
+// NOTE: 缺少error字段视为调用没有出错
+func ParseResponsePayload(payload []byte) (ResponsePayload, error) {
+	resp := ResponsePayload{}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return ResponsePayload{}, err
+	}
+
+	if strings.TrimSpace(resp.UUID) == "" {
+		return ResponsePayload{}, errors.New("uuid missing")
+	}
+	if resp.Response == nil {
+		return ResponsePayload{}, errors.New("response missing")
+	}
+
+	return resp, nil
+}
+
+// ParseSubPayload 将订阅报文的报文内容payload解码为 SubPayload, 支持订阅列表本身和包含订阅选项的对象两种格式.
+func ParseSubPayload(payload []byte) (SubPayload, error) {
+	sub := SubPayload{}
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return SubPayload{}, err
+	}
+	return sub, nil
+}
+
+// ParsePingPayload 将ping报文或pong报文的报文内容payload解码为 PingPayload.
+func ParsePingPayload(payload []byte) (PingPayload, error) {
+	ping := PingPayload{}
+	if err := json.Unmarshal(payload, &ping); err != nil {
+		return PingPayload{}, err
+	}
+	return ping, nil
+}
+
+// ParseSessionPayload 将会话报文的报文内容payload解码为 SessionPayload.
+func ParseSessionPayload(payload []byte) (SessionPayload, error) {
+	session := SessionPayload{}
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return SessionPayload{}, err
+	}
+	return session, nil
+}
+
+// ParseClosePayload 将关闭报文的报文内容payload解码为 ClosePayload.
+func ParseClosePayload(payload []byte) (ClosePayload, error) {
+	closePayload := ClosePayload{}
+	if err := json.Unmarshal(payload, &closePayload); err != nil {
+		return ClosePayload{}, err
+	}
+	return closePayload, nil
+}
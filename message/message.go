@@ -1,17 +1,83 @@
 package message
 
 import (
+	"bytes"
 	"fmt"
 	jsoniter "github.com/json-iterator/go"
+	"strings"
 )
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
+// DefaultJSONConfig 为默认的JSON编解码配置, 与 jsoniter.ConfigCompatibleWithStandardLibrary 的配置相同
+var DefaultJSONConfig = jsoniter.Config{
+	EscapeHTML:             true,
+	SortMapKeys:            true,
+	ValidateJsonRawMessage: true,
+}
+
+// json 默认与 jsoniter.ConfigCompatibleWithStandardLibrary 的配置相同, 可以通过 SetJSONConfig 替换.
+// time.Duration 与标准库一致编码为纳秒整数, 物模型推送时按照元信息中声明的单位转换, 见 meta.Meta.StateInUnit.
+// 结构体字段的meta标签优先于json标签作为字段名, 见 FrozeJSON.
+var json = FrozeJSON(DefaultJSONConfig)
+
+// jsonUseNumber 与 json 的配置相同, 区别是解码到interface{}时数值被解码为json.Number而非float64
+var jsonUseNumber = frozeJSONUseNumber(DefaultJSONConfig)
+
+// FrozeJSON 根据配置config生成报文编解码使用的API, 与 config.Froze 的区别是结构体字段有meta标签时,
+// 以meta标签而非json标签作为编解码的字段名, 与元信息校验结构体时的字段名保持一致(见 meta.Meta.VerifyState).
+// 例如字段 QsState string `json:"qs_state" meta:"qsState"` 在报文中的字段名为qsState.
+func FrozeJSON(config jsoniter.Config) jsoniter.API {
+	api := config.Froze()
+	api.RegisterExtension(&metaTagExtension{})
+	return api
+}
+
+// frozeJSONUseNumber 根据配置config生成解码到interface{}时将数值解码为json.Number的API
+func frozeJSONUseNumber(config jsoniter.Config) jsoniter.API {
+	config.UseNumber = true
+	return FrozeJSON(config)
+}
+
+// metaTagExtension 将有meta标签的结构体字段的字段名替换为meta标签
+type metaTagExtension struct {
+	jsoniter.DummyExtension
+}
+
+func (*metaTagExtension) UpdateStructDescriptor(desc *jsoniter.StructDescriptor) {
+	for _, binding := range desc.Fields {
+		if name, ok := binding.Field.Tag().Lookup("meta"); ok {
+			binding.ToNames = []string{name}
+			binding.FromNames = []string{name}
+		}
+	}
+}
+
+// SetJSONConfig 将报文编解码使用的JSON配置替换为config, 默认为 DefaultJSONConfig,
+// 用于有特殊需求的用户, 例如关闭HTML转义(EscapeHTML)或者以6位精度编码浮点数(MarshalFloatWith6Digits).
+// 通常应该通过 model.SetJSONConfig 同时替换报文、元信息和物模型的JSON配置, 保证编码、解码和校验的行为一致.
+//
+// 替换配置有以下风险, 需要自行评估:
+//   - 报文的编码结果会改变, 对端可能无法解析, 例如降低浮点数精度后对端收到的数据可能超出元信息的范围;
+//   - 关闭 SortMapKeys 后相同的状态数据可能编码为不同的报文, 导致状态去重(见 model.WithStateDedup)失效;
+//   - 关闭 ValidateJsonRawMessage 后无效的原始JSON数据可能被原样发送给对端.
+//
+// NOTE: 配置是进程全局的, 所有报文共用, 必须在编解码任何报文之前调用, 例如在init或者main函数开始时, 不能与编解码并发调用
+func SetJSONConfig(config jsoniter.Config) {
+	json = FrozeJSON(config)
+	jsonUseNumber = frozeJSONUseNumber(config)
+}
+
+// JSON 返回报文编解码使用的API, 即最近一次 SetJSONConfig 替换后的API.
+// 物模型通过 JSON 与报文共用同一个API实例, 保证两者的编解码行为一致, 见 model.SetJSONConfig.
+func JSON() jsoniter.API {
+	return json
+}
 
 const (
 	SetSub    = iota // 设置订阅
 	AddSub           // 添加订阅
 	RemoveSub        // 删除订阅
 	ClearSub         // 清空订阅
+	UpdateSub        // 同时新增和取消订阅, 报文内容为 SubPayload 对象格式, 见 SubPayload.Remove
 )
 
 // 物模型报文定义
@@ -38,23 +104,58 @@ type RawArgs map[string]jsoniter.RawMessage
 // 未解析的调用返回值
 type RawResp map[string]jsoniter.RawMessage
 
+// UnmarshalUseNumber 将JSON数据data解码到v中, 解码到interface{}时所有数值都被解码为json.Number而非float64,
+// 从而保证超出float64精度的大整数(例如9007199254740993)在解码和重新编码后保持不变.
+func UnmarshalUseNumber(data []byte, v interface{}) error {
+	return jsonUseNumber.Unmarshal(data, v)
+}
+
+// ToArgs 将未解析的参数args解码为 Args, 参数中的数值均解码为json.Number, 保证整数精度不丢失.
+func (args RawArgs) ToArgs() (Args, error) {
+	ans := make(Args, len(args))
+	for name, raw := range args {
+		var value interface{}
+		if err := UnmarshalUseNumber(raw, &value); err != nil {
+			return nil, fmt.Errorf("arg %q: %s", name, err)
+		}
+		ans[name] = value
+	}
+	return ans, nil
+}
+
+// ToResp 将未解析的响应返回值resp解码为 Resp, 返回值中的数值均解码为json.Number, 保证整数精度不丢失.
+func (resp RawResp) ToResp() (Resp, error) {
+	ans := make(Resp, len(resp))
+	for name, raw := range resp {
+		var value interface{}
+		if err := UnmarshalUseNumber(raw, &value); err != nil {
+			return nil, fmt.Errorf("response %q: %s", name, err)
+		}
+		ans[name] = value
+	}
+	return ans, nil
+}
+
 // 状态
 type State struct {
-	Name string      `json:"name"` // 状态全名: 模型名/状态名
-	Data interface{} `json:"data"` // 状态数据
+	Name string      `json:"name"`          // 状态全名: 模型名/状态名
+	Data interface{} `json:"data"`          // 状态数据
+	Seq  uint64      `json:"seq,omitempty"` // 推送序号, 为0表示没有序号, 见 EncodeStateSeqMsg
 }
 
 // 事件
 type Event struct {
-	Name string `json:"name"` // 事件全名: 模型名/事件名
-	Args Args   `json:"args"` // 事件参数
+	Name string `json:"name"`          // 事件全名: 模型名/事件名
+	Args Args   `json:"args"`          // 事件参数
+	Seq  uint64 `json:"seq,omitempty"` // 推送序号, 为0表示没有序号, 见 EncodeEventSeqMsg
 }
 
 // 调用请求
 type Call struct {
-	Name string `json:"name"` // 方法全名: 模型名/方法名
-	UUID string `json:"uuid"` // 调用请求的UUID
-	Args Args   `json:"args"` // 调用请求的参数
+	Name    string            `json:"name"`              // 方法全名: 模型名/方法名
+	UUID    string            `json:"uuid"`              // 调用请求的UUID
+	Args    Args              `json:"args"`              // 调用请求的参数
+	Headers map[string]string `json:"headers,omitempty"` // 调用请求的附加头部, 见 EncodeCallMsgWithHeaders
 }
 
 // 调用结果
@@ -64,23 +165,85 @@ type Response struct {
 	Response Resp   `json:"response"` // 调用的结果
 }
 
+// 探测报文 报文内容定义, ping报文和pong报文的报文内容相同
+type PingPayload struct {
+	UUID string `json:"uuid"` // 探测报文的UUID, pong报文的UUID与对应ping报文的UUID相同
+}
+
+// 元信息错误报文 报文内容定义, 对端拒绝公开元信息时回复的元信息报文的报文内容
+type MetaErrorPayload struct {
+	Error *string `json:"error"` // 错误提示信息
+}
+
+// 状态设置报文 报文内容定义, 请求对端将可写状态设置为data, 对端以调用响应报文回复设置结果
+type SetStatePayload struct {
+	Name string              `json:"name"` // 状态全名: 模型名/状态名
+	UUID string              `json:"uuid"` // 设置请求的UUID, 与响应报文的UUID相同
+	Data jsoniter.RawMessage `json:"data"` // 设置的状态原始数据
+}
+
+// 关闭报文 报文内容定义, 主动关闭连接的一端在关闭前发送, 告知对端关闭原因
+type ClosePayload struct {
+	Reason string `json:"reason"` // 关闭原因
+}
+
+// 会话报文 报文内容定义, 物模型连接到代理后发送, 请求代理恢复同一会话在上次断开前的订阅关系
+type SessionPayload struct {
+	ID   string `json:"id"`             // 会话ID, 由物模型生成, 重连时使用相同的会话ID
+	UUID string `json:"uuid,omitempty"` // 会话确认的UUID, 不为空时代理回复携带恢复结果的响应报文
+}
+
+// 订阅报文 报文内容定义
+// 订阅报文的报文内容可以是订阅列表本身(字符串数组), 也可以是包含订阅列表和订阅选项的对象,
+// 解码时两种格式都支持, 以兼容旧版本的订阅报文.
+type SubPayload struct {
+	Items    []string `json:"items"`              // 订阅列表
+	Snapshot bool     `json:"snapshot,omitempty"` // 是否在订阅后立即推送新订阅状态的最新值或者新订阅事件的历史事件
+	UUID     string   `json:"uuid,omitempty"`     // 订阅确认的UUID, 不为空时对端处理订阅后回复携带有效订阅列表的响应报文
+	Remove   []string `json:"remove,omitempty"`   // 取消订阅列表, 仅对 UpdateSub 类型的订阅报文有效, 对端先取消 Remove 再新增 Items
+}
+
+func (p *SubPayload) UnmarshalJSON(data []byte) error {
+	// 旧版本格式: 订阅列表本身
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		*p = SubPayload{}
+		return json.Unmarshal(data, &p.Items)
+	}
+
+	type subPayload SubPayload
+	var ans subPayload
+	if err := json.Unmarshal(data, &ans); err != nil {
+		return err
+	}
+	*p = SubPayload(ans)
+	return nil
+}
+
 // 状态报文 报文内容定义
 type StatePayload struct {
-	Name string              `json:"name"` // 状态全名: 模型名/状态名
-	Data jsoniter.RawMessage `json:"data"` // 状态原始数据
+	Name string              `json:"name"`          // 状态全名: 模型名/状态名
+	Data jsoniter.RawMessage `json:"data"`          // 状态原始数据, 清除状态报文为null, 见 EncodeClearStateMsg
+	Seq  uint64              `json:"seq,omitempty"` // 推送序号, 为0表示对端没有携带序号
+}
+
+// Cleared 返回状态报文是否为清除状态报文, 即状态数据为JSON null, 见 EncodeClearStateMsg.
+func (s StatePayload) Cleared() bool {
+	return bytes.Equal(bytes.TrimSpace(s.Data), nullData)
 }
 
 // 事件报文 报文内容定义
 type EventPayload struct {
-	Name string  `json:"name"` // 事件全名: 模型名/事件名
-	Args RawArgs `json:"args"` // 事件参数
+	Name string  `json:"name"`          // 事件全名: 模型名/事件名
+	Args RawArgs `json:"args"`          // 事件参数
+	Seq  uint64  `json:"seq,omitempty"` // 推送序号, 为0表示对端没有携带序号
 }
 
 // 调用请求报文 报文内容定义
 type CallPayload struct {
-	Name string  `json:"name"` // 调用的全方法名: 模型名/方法名
-	UUID string  `json:"uuid"` // 调用的UUID
-	Args RawArgs `json:"args"` // 未解析的调用的参数
+	Name    string            `json:"name"`              // 调用的全方法名: 模型名/方法名
+	UUID    string            `json:"uuid"`              // 调用的UUID
+	Args    RawArgs           `json:"args"`              // 未解析的调用的参数
+	Headers map[string]string `json:"headers,omitempty"` // 调用请求的附加头部, 没有附加头部时为nil
 }
 
 // 调用响应报文 报文内容定义
@@ -104,18 +267,9 @@ func EncodeSubStateMsg(Type int, items []string) ([]byte, error) {
 	if items == nil {
 		items = make([]string, 0)
 	}
-	var typeStr string
-	switch Type {
-	case SetSub:
-		typeStr = "set-subscribe-state"
-	case AddSub:
-		typeStr = "add-subscribe-state"
-	case RemoveSub:
-		typeStr = "remove-subscribe-state"
-	case ClearSub:
-		typeStr = "clear-subscribe-state"
-	default:
-		return nil, fmt.Errorf("invalid Type")
+	typeStr, err := subTypeString(Type, "state")
+	if err != nil {
+		return nil, err
 	}
 
 	msg := Message{
@@ -134,18 +288,9 @@ func EncodeSubEventMsg(Type int, items []string) ([]byte, error) {
 	if items == nil {
 		items = make([]string, 0)
 	}
-	var typeStr string
-	switch Type {
-	case SetSub:
-		typeStr = "set-subscribe-event"
-	case AddSub:
-		typeStr = "add-subscribe-event"
-	case RemoveSub:
-		typeStr = "remove-subscribe-event"
-	case ClearSub:
-		typeStr = "clear-subscribe-event"
-	default:
-		return nil, fmt.Errorf("invalid Type")
+	typeStr, err := subTypeString(Type, "event")
+	if err != nil {
+		return nil, err
 	}
 
 	msg := Message{
@@ -158,9 +303,89 @@ func EncodeSubEventMsg(Type int, items []string) ([]byte, error) {
 	return ans, nil
 }
 
+// EncodeSubStatePayloadMsg 编码一个订阅类型为Type,报文内容为payload的状态订阅报文,
+// 返回JSON编码后的全报文数据和错误信息. 与 EncodeSubStateMsg 不同, 报文内容为 SubPayload 对象格式,
+// 可以携带订阅选项, 只有支持该格式的对端才能正确处理.
+func EncodeSubStatePayloadMsg(Type int, payload SubPayload) ([]byte, error) {
+	typeStr, err := subTypeString(Type, "state")
+	if err != nil {
+		return nil, err
+	}
+	return encodeSubPayloadMsg(typeStr, payload)
+}
+
+// EncodeSubEventPayloadMsg 编码一个订阅类型为Type,报文内容为payload的事件订阅报文,
+// 返回JSON编码后的全报文数据和错误信息. 与 EncodeSubEventMsg 不同, 报文内容为 SubPayload 对象格式,
+// 可以携带订阅选项, 只有支持该格式的对端才能正确处理.
+func EncodeSubEventPayloadMsg(Type int, payload SubPayload) ([]byte, error) {
+	typeStr, err := subTypeString(Type, "event")
+	if err != nil {
+		return nil, err
+	}
+	return encodeSubPayloadMsg(typeStr, payload)
+}
+
+func encodeSubPayloadMsg(typeStr string, payload SubPayload) ([]byte, error) {
+	if payload.Items == nil {
+		payload.Items = make([]string, 0)
+	}
+
+	msg := Message{
+		Type:    typeStr,
+		Payload: payload,
+	}
+
+	ans, _ := json.Marshal(msg)
+
+	return ans, nil
+}
+
+// subTypeString 返回订阅类型为Type的kind(state或event)订阅报文的报文类型
+func subTypeString(Type int, kind string) (string, error) {
+	switch Type {
+	case SetSub:
+		return "set-subscribe-" + kind, nil
+	case AddSub:
+		return "add-subscribe-" + kind, nil
+	case RemoveSub:
+		return "remove-subscribe-" + kind, nil
+	case ClearSub:
+		return "clear-subscribe-" + kind, nil
+	case UpdateSub:
+		return "update-subscribe-" + kind, nil
+	default:
+		return "", fmt.Errorf("invalid Type")
+	}
+}
+
+// checkFullName 检查状态、事件或者方法的全名fullName是否为有效的 "模型名/名称" 格式,
+// 即至少包含一个"/", 并且以"/"分割后的每一部分都不为空(只含空白字符也视为空)
+func checkFullName(fullName string) error {
+	if !strings.Contains(fullName, "/") {
+		return fmt.Errorf("fullName %q missing '/'", fullName)
+	}
+	for _, token := range strings.Split(fullName, "/") {
+		if strings.TrimSpace(token) == "" {
+			return fmt.Errorf("fullName %q has empty segment", fullName)
+		}
+	}
+	return nil
+}
+
 // EncodeStateMsg 编码一个状态全名为stateName数据为data的状态报文,
-// 返回JSON编码后的全报文数据和错误信息
+// 返回JSON编码后的全报文数据和错误信息. 全名不是有效的 "模型名/状态名" 格式(如缺少"/"或者含有"a//b"这样的空部分)时返回错误信息,
+// EncodeEventMsg EncodeCallMsg 和 EncodeSetStateMsg 对全名的校验相同.
 func EncodeStateMsg(stateName string, data interface{}) ([]byte, error) {
+	return EncodeStateSeqMsg(stateName, 0, data)
+}
+
+// EncodeStateSeqMsg 编码一个状态全名为stateName,推送序号为seq,数据为data的状态报文,
+// 返回JSON编码后的全报文数据和错误信息. seq为0时不携带序号, 与 EncodeStateMsg 相同.
+// 不识别序号的对端会忽略序号字段, 识别序号的对端可以通过 SeqNewer 丢弃乱序到达的旧状态.
+func EncodeStateSeqMsg(stateName string, seq uint64, data interface{}) ([]byte, error) {
+	if err := checkFullName(stateName); err != nil {
+		return nil, err
+	}
 	if data == nil {
 		return nil, fmt.Errorf("nil data")
 	}
@@ -170,10 +395,10 @@ func EncodeStateMsg(stateName string, data interface{}) ([]byte, error) {
 		Payload: State{
 			Name: stateName,
 			Data: data,
+			Seq:  seq,
 		},
 	}
 
-	json := jsoniter.ConfigCompatibleWithStandardLibrary
 	ans, err := json.Marshal(msg)
 	if err != nil {
 		return nil, fmt.Errorf("encode data failed")
@@ -182,9 +407,39 @@ func EncodeStateMsg(stateName string, data interface{}) ([]byte, error) {
 	return ans, nil
 }
 
+// nullData 为清除状态报文的状态数据
+var nullData = []byte("null")
+
+// EncodeClearStateMsg 编码一个状态全名为stateName, 推送序号为seq的清除状态报文, seq为0时不携带序号.
+// 清除状态报文与状态报文的格式相同, 只是状态数据为JSON null, 例如:
+//
+//	{"type":"state","payload":{"name":"A/car/#1/tpqs/target","data":null}}
+//
+// 表示该状态之前推送的值已经失效(如传感器离线), 与没有推送新值(状态不变)不同.
+// 只有元信息中声明了 "optional": true 的状态可以被清除, 见 meta.Meta.VerifyStateClear.
+func EncodeClearStateMsg(stateName string, seq uint64) []byte {
+	return Must(json.Marshal(Message{
+		Type: "state",
+		Payload: State{
+			Name: stateName,
+			Data: nil,
+			Seq:  seq,
+		},
+	}))
+}
+
 // EncodeEventMsg 编码一个事件全名为eventName参数为args的事件报文,
 // 返回JSON编码后的全报文数据和错误信息
 func EncodeEventMsg(eventName string, args Args) ([]byte, error) {
+	return EncodeEventSeqMsg(eventName, 0, args)
+}
+
+// EncodeEventSeqMsg 编码一个事件全名为eventName,推送序号为seq,参数为args的事件报文,
+// 返回JSON编码后的全报文数据和错误信息. seq为0时不携带序号, 与 EncodeEventMsg 相同.
+func EncodeEventSeqMsg(eventName string, seq uint64, args Args) ([]byte, error) {
+	if err := checkFullName(eventName); err != nil {
+		return nil, err
+	}
 	if args == nil {
 		args = Args{}
 	}
@@ -194,6 +449,7 @@ func EncodeEventMsg(eventName string, args Args) ([]byte, error) {
 		Payload: Event{
 			Name: eventName,
 			Args: args,
+			Seq:  seq,
 		},
 	}
 
@@ -205,9 +461,51 @@ func EncodeEventMsg(eventName string, args Args) ([]byte, error) {
 	return ans, nil
 }
 
+// SeqNewer 返回推送序号a是否比推送序号b新. 序号递增到最大值后回绕, SeqNewer 按照序列号算术比较,
+// 只要两个序号相差不超过 2^63, 回绕后的小序号也被认为比回绕前的大序号新. 序号为0表示没有序号, 不比任何序号新.
+func SeqNewer(a uint64, b uint64) bool {
+	if a == 0 {
+		return false
+	}
+	if b == 0 {
+		return true
+	}
+	return int64(a-b) > 0
+}
+
+// 标准错误事件的参数名, 见 EncodeErrorEventMsg
+const (
+	ErrorEventCode    = "code"    // 错误码
+	ErrorEventMessage = "message" // 错误信息
+)
+
+// EncodeErrorEventMsg 编码一个事件全名为eventName的标准错误事件报文, 参数 ErrorEventCode 为错误码code,
+// 参数 ErrorEventMessage 为错误信息msg, extra为附加参数, 附加参数中与错误码和错误信息同名的参数被忽略.
+// 返回JSON编码后的全报文数据和错误信息. 对应的事件元信息可以通过 meta.ErrorEventMeta 声明.
+func EncodeErrorEventMsg(eventName string, code int, msg string, extra Args) ([]byte, error) {
+	args := make(Args, len(extra)+2)
+	for name, arg := range extra {
+		args[name] = arg
+	}
+	args[ErrorEventCode] = code
+	args[ErrorEventMessage] = msg
+
+	return EncodeEventMsg(eventName, args)
+}
+
 // EncodeCallMsg 编码一个方法全名为methodName,调用唯一标识为uuid,调用参数为args的调用请求报文,
 // 返回JSON编码后的全报文数据和错误信息
 func EncodeCallMsg(methodName string, uuid string, args Args) ([]byte, error) {
+	return EncodeCallMsgWithHeaders(methodName, uuid, args, nil)
+}
+
+// EncodeCallMsgWithHeaders 编码一个携带附加头部headers的调用请求报文, 其余参数与 EncodeCallMsg 相同.
+// 附加头部用于在调用参数之外传递追踪ID、截止时间等与具体方法无关的信息, 不根据元信息校验,
+// 旧版本的对端会忽略附加头部. headers为空时编码结果与 EncodeCallMsg 相同.
+func EncodeCallMsgWithHeaders(methodName string, uuid string, args Args, headers map[string]string) ([]byte, error) {
+	if err := checkFullName(methodName); err != nil {
+		return nil, err
+	}
 	if args == nil {
 		args = Args{}
 	}
@@ -215,9 +513,10 @@ func EncodeCallMsg(methodName string, uuid string, args Args) ([]byte, error) {
 	msg := Message{
 		Type: "call",
 		Payload: Call{
-			Name: methodName,
-			UUID: uuid,
-			Args: args,
+			Name:    methodName,
+			UUID:    uuid,
+			Args:    args,
+			Headers: headers,
 		},
 	}
 
@@ -229,6 +528,33 @@ func EncodeCallMsg(methodName string, uuid string, args Args) ([]byte, error) {
 	return ans, nil
 }
 
+// EncodeSetStateMsg 编码一个状态全名为stateName,请求唯一标识为uuid,状态数据为data的状态设置报文,
+// 返回JSON编码后的全报文数据和错误信息. 对端以调用标识为uuid的调用响应报文回复设置结果.
+func EncodeSetStateMsg(stateName string, uuid string, data interface{}) ([]byte, error) {
+	if err := checkFullName(stateName); err != nil {
+		return nil, err
+	}
+	msg := Message{
+		Type: "set-state",
+		Payload: struct {
+			Name string      `json:"name"`
+			UUID string      `json:"uuid"`
+			Data interface{} `json:"data"`
+		}{
+			Name: stateName,
+			UUID: uuid,
+			Data: data,
+		},
+	}
+
+	ans, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("encode set state failed")
+	}
+
+	return ans, nil
+}
+
 // EncodeRespMsg 编码一个调用标识为uuid,错误提示信息为errStr,响应结果为resp的调用结果报文,
 // 返回JSON编码后的全报文数据和错误信息
 func EncodeRespMsg(uuid string, errStr string, resp Resp) ([]byte, error) {
@@ -253,11 +579,105 @@ func EncodeRespMsg(uuid string, errStr string, resp Resp) ([]byte, error) {
 	return ans, nil
 }
 
+// EncodePingMsg 编码一个探测唯一标识为uuid的ping报文, 返回JSON编码后的全报文数据
+func EncodePingMsg(uuid string) []byte {
+	ans, _ := json.Marshal(Message{
+		Type:    "ping",
+		Payload: PingPayload{UUID: uuid},
+	})
+	return ans
+}
+
+// EncodePongMsg 编码一个探测唯一标识为uuid的pong报文, 返回JSON编码后的全报文数据
+func EncodePongMsg(uuid string) []byte {
+	ans, _ := json.Marshal(Message{
+		Type:    "pong",
+		Payload: PingPayload{UUID: uuid},
+	})
+	return ans
+}
+
+// EncodeSubAckMsg 编码一个订阅确认标识为uuid,有效订阅列表为items的订阅确认报文,
+// 订阅确认报文为调用响应报文, 返回值items为对端处理订阅报文后的有效订阅列表. 返回JSON编码后的全报文数据.
+func EncodeSubAckMsg(uuid string, items []string) []byte {
+	if items == nil {
+		items = make([]string, 0)
+	}
+	return Must(EncodeRespMsg(uuid, "", Resp{
+		"items": items,
+	}))
+}
+
 // EncodeQueryMetaMsg 编码一个查询物模型元信息JSON报文, 返回JSON编码后的全报文数据
 func EncodeQueryMetaMsg() []byte {
 	return []byte(`{"type":"query-meta","payload":null}`)
 }
 
+// EncodeMetaErrorMsg 编码一个错误提示信息为errStr的元信息错误报文, 用于拒绝对端的元信息查询,
+// 返回JSON编码后的全报文数据
+func EncodeMetaErrorMsg(errStr string) []byte {
+	ans, _ := json.Marshal(Message{
+		Type:    "meta-info",
+		Payload: MetaErrorPayload{Error: &errStr},
+	})
+	return ans
+}
+
+// EncodeCloseMsg 编码一个关闭原因为reason的关闭报文, 返回JSON编码后的全报文数据.
+// 关闭报文在关闭连接前发送, 不支持关闭报文的对端会忽略该报文, 只能感知到连接断开.
+func EncodeCloseMsg(reason string) []byte {
+	ans, _ := json.Marshal(Message{
+		Type:    "close",
+		Payload: ClosePayload{Reason: reason},
+	})
+	return ans
+}
+
+// EncodeSessionMsg 编码一个会话ID为id, 会话确认标识为uuid的会话报文, 返回JSON编码后的全报文数据.
+// uuid不为空时, 代理以调用响应报文回复会话确认, 返回值restored为是否恢复了之前的订阅关系,
+// 返回值states和events为恢复后的状态订阅列表和事件订阅列表.
+func EncodeSessionMsg(id string, uuid string) []byte {
+	ans, _ := json.Marshal(Message{
+		Type:    "session",
+		Payload: SessionPayload{ID: id, UUID: uuid},
+	})
+	return ans
+}
+
+// EncodeBatchMsg 将多个完整的报文msgs编码为一个批量报文, 批量报文的报文内容为报文数组, 返回JSON编码后的全报文数据.
+// 对端通过 ParseBatchPayload 拆分批量报文, 依次处理其中的每个报文. 用于合并发送大量的小报文.
+// NOTE: msgs中的报文必须是有效的JSON报文, 且不能是批量报文
+func EncodeBatchMsg(msgs [][]byte) []byte {
+	size := len(`{"type":"batch","payload":[]}`) + len(msgs)
+	for _, msg := range msgs {
+		size += len(msg)
+	}
+
+	ans := make([]byte, 0, size)
+	ans = append(ans, `{"type":"batch","payload":[`...)
+	for i, msg := range msgs {
+		if i > 0 {
+			ans = append(ans, ',')
+		}
+		ans = append(ans, msg...)
+	}
+	return append(ans, `]}`...)
+}
+
+// ParseBatchPayload 将批量报文的报文内容payload拆分为其中的每个完整的报文数据, 返回拆分结果和错误信息.
+func ParseBatchPayload(payload []byte) ([][]byte, error) {
+	var msgs []jsoniter.RawMessage
+	if err := json.Unmarshal(payload, &msgs); err != nil {
+		return nil, err
+	}
+
+	ans := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		ans = append(ans, msg)
+	}
+	return ans, nil
+}
+
 // EncodeRawMsg 编码一个报文类型为Type,报文数据域为payload的JSON报文,
 // 返回JSON编码后的全报文数据和错误信息
 func EncodeRawMsg(Type string, payload jsoniter.RawMessage) ([]byte, error) {
@@ -1,11 +1,14 @@
 package message
 
 import (
+	gojson "encoding/json"
 	"errors"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math"
 	"testing"
+	"time"
 )
 
 func TestMust(t *testing.T) {
@@ -135,6 +138,145 @@ func TestEncodeSubEventMsg(t *testing.T) {
 	}
 }
 
+func TestEncodeSubPayloadMsg(t *testing.T) {
+	type TestCase struct {
+		isState  bool
+		subType  int
+		payload  SubPayload
+		wantData []byte
+		wantErr  error
+		desc     string
+	}
+
+	testCases := []TestCase{
+		{
+			isState:  true,
+			subType:  5,
+			wantData: nil,
+			wantErr:  errors.New("invalid Type"),
+			desc:     "无效的订阅类型",
+		},
+
+		{
+			isState:  true,
+			subType:  SetSub,
+			payload:  SubPayload{Snapshot: true},
+			wantData: []byte(`{"type":"set-subscribe-state","payload":{"items":[],"snapshot":true}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--列表为nil",
+		},
+
+		{
+			isState:  true,
+			subType:  AddSub,
+			payload:  SubPayload{Items: []string{"A/state1"}},
+			wantData: []byte(`{"type":"add-subscribe-state","payload":{"items":["A/state1"]}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--不请求最新值",
+		},
+
+		{
+			isState:  true,
+			subType:  SetSub,
+			payload:  SubPayload{Items: []string{"A/state1"}, UUID: "123"},
+			wantData: []byte(`{"type":"set-subscribe-state","payload":{"items":["A/state1"],"uuid":"123"}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--请求订阅确认",
+		},
+
+		{
+			isState:  false,
+			subType:  SetSub,
+			payload:  SubPayload{Items: []string{"A/event1"}},
+			wantData: []byte(`{"type":"set-subscribe-event","payload":{"items":["A/event1"]}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--事件订阅",
+		},
+
+		{
+			isState:  true,
+			subType:  UpdateSub,
+			payload:  SubPayload{Items: []string{"A/state1"}, Remove: []string{"A/state2"}},
+			wantData: []byte(`{"type":"update-subscribe-state","payload":{"items":["A/state1"],"remove":["A/state2"]}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--同时新增和取消状态订阅",
+		},
+
+		{
+			isState:  false,
+			subType:  UpdateSub,
+			payload:  SubPayload{Remove: []string{"A/event1"}},
+			wantData: []byte(`{"type":"update-subscribe-event","payload":{"items":[],"remove":["A/event1"]}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--只取消事件订阅",
+		},
+	}
+
+	for _, test := range testCases {
+		var gotData []byte
+		var gotErr error
+		if test.isState {
+			gotData, gotErr = EncodeSubStatePayloadMsg(test.subType, test.payload)
+		} else {
+			gotData, gotErr = EncodeSubEventPayloadMsg(test.subType, test.payload)
+		}
+		require.EqualValues(t, test.wantData, gotData, test.desc)
+		require.EqualValues(t, test.wantErr, gotErr, test.desc)
+	}
+}
+
+func TestSubPayload_UnmarshalJSON(t *testing.T) {
+	type TestCase struct {
+		data    []byte
+		want    SubPayload
+		wantErr bool
+		desc    string
+	}
+
+	testCases := []TestCase{
+		{
+			data: []byte(`["A/state1","A/state2"]`),
+			want: SubPayload{Items: []string{"A/state1", "A/state2"}},
+			desc: "旧版本格式",
+		},
+
+		{
+			data: []byte(` null `),
+			want: SubPayload{},
+			desc: "旧版本格式--null",
+		},
+
+		{
+			data: []byte(`{"items":["A/state1"],"snapshot":true}`),
+			want: SubPayload{Items: []string{"A/state1"}, Snapshot: true},
+			desc: "对象格式",
+		},
+
+		{
+			data:    []byte(`{"items":"A/state1"}`),
+			wantErr: true,
+			desc:    "对象格式--订阅列表类型错误",
+		},
+
+		{
+			data:    []byte(`123`),
+			wantErr: true,
+			desc:    "既不是数组也不是对象",
+		},
+	}
+
+	for _, test := range testCases {
+		var got SubPayload
+		err := jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal(test.data, &got)
+		if test.wantErr {
+			require.NotNil(t, err, test.desc)
+			continue
+		}
+		require.Nil(t, err, test.desc)
+		require.Equal(t, test.want, got, test.desc)
+	}
+}
+
 func TestEncodeStateMsg(t *testing.T) {
 	type TestCase struct {
 		name     string
@@ -185,6 +327,28 @@ func TestEncodeStateMsg(t *testing.T) {
 			desc:     "序列化成功--简单数据类型",
 		},
 
+		{
+			name: "model/state",
+			data: map[string]interface{}{
+				"timeout": time.Millisecond * 500,
+				"uptime":  time.Duration(1),
+			},
+			wantData: []byte(`{"type":"state","payload":{"name":"model/state","data":{"timeout":500000000,"uptime":1}}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--时长编码为纳秒整数",
+		},
+
+		{
+			name: "model/state",
+			data: struct {
+				QsState string  `json:"qs_state" meta:"qsState"`
+				QsAngle float64 `json:"qs_angle"`
+			}{QsState: "up", QsAngle: 10},
+			wantData: []byte(`{"type":"state","payload":{"name":"model/state","data":{"qsState":"up","qs_angle":10}}}`),
+			wantErr:  nil,
+			desc:     "序列化成功--meta标签优先于json标签",
+		},
+
 		{
 			name: "model/state",
 			data: []interface{}{
@@ -404,6 +568,34 @@ func TestEncodeCallMsg(t *testing.T) {
 	}
 }
 
+func TestEncodeCallMsgWithHeaders(t *testing.T) {
+	data, err := EncodeCallMsgWithHeaders("model/QS", "1", Args{"a": 1}, map[string]string{
+		"trace-id": "abc",
+		"deadline": "2024-01-01T00:00:00Z",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"call","payload":{"name":"model/QS","uuid":"1","args":{"a":1},`+
+		`"headers":{"deadline":"2024-01-01T00:00:00Z","trace-id":"abc"}}}`, string(data))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	call, err := ParseCallPayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"trace-id": "abc", "deadline": "2024-01-01T00:00:00Z"}, call.Headers)
+
+	// 没有附加头部时与 EncodeCallMsg 相同
+	for _, headers := range []map[string]string{nil, {}} {
+		data, err = EncodeCallMsgWithHeaders("model/QS", "1", Args{"a": 1}, headers)
+		require.Nil(t, err)
+		assert.Equal(t, Must(EncodeCallMsg("model/QS", "1", Args{"a": 1})), data)
+	}
+
+	// 旧版本的调用请求报文没有附加头部
+	call, err = ParseCallPayload([]byte(`{"name":"model/QS","uuid":"1","args":{}}`))
+	require.Nil(t, err)
+	assert.Nil(t, call.Headers)
+}
+
 func TestEncodeRespMsg(t *testing.T) {
 	type TestCase struct {
 		uuid     string
@@ -470,6 +662,22 @@ func TestEncodeRespMsg(t *testing.T) {
 	}
 }
 
+func TestEncodeSubAckMsg(t *testing.T) {
+	require.EqualValues(t, []byte(`{"type":"response","payload":{"uuid":"123","error":"","response":{"items":[]}}}`),
+		EncodeSubAckMsg("123", nil))
+	require.EqualValues(t, []byte(`{"type":"response","payload":{"uuid":"123","error":"","response":{"items":["A/s1","A/s2"]}}}`),
+		EncodeSubAckMsg("123", []string{"A/s1", "A/s2"}))
+}
+
+func TestEncodeMetaErrorMsg(t *testing.T) {
+	require.EqualValues(t, []byte(`{"type":"meta-info","payload":{"error":"disabled"}}`), EncodeMetaErrorMsg("disabled"))
+}
+
+func TestEncodePingPongMsg(t *testing.T) {
+	require.EqualValues(t, []byte(`{"type":"ping","payload":{"uuid":"123"}}`), EncodePingMsg("123"))
+	require.EqualValues(t, []byte(`{"type":"pong","payload":{"uuid":"123"}}`), EncodePongMsg("123"))
+}
+
 func TestEncodeQueryMetaMsg(t *testing.T) {
 	require.EqualValues(t, []byte(`{"type":"query-meta","payload":null}`), EncodeQueryMetaMsg())
 }
@@ -548,3 +756,440 @@ func TestEncodeRawMsg(t *testing.T) {
 		require.EqualValues(t, test.wantErr, gotErr, test.desc)
 	}
 }
+
+// TestRawArgs_ToArgs 测试将未解析的参数解码为 Args 的情况
+func TestRawArgs_ToArgs(t *testing.T) {
+	raw := RawArgs{
+		"count": []byte(`9007199254740993`),
+		"list":  []byte(`[1,2.5,"a"]`),
+		"flag":  []byte(`true`),
+	}
+
+	args, err := raw.ToArgs()
+	require.Nil(t, err)
+	assert.Equal(t, gojson.Number("9007199254740993"), args["count"], "大整数不丢失精度")
+	assert.Equal(t, []interface{}{gojson.Number("1"), gojson.Number("2.5"), "a"}, args["list"], "数组中的数值")
+	assert.Equal(t, true, args["flag"], "非数值类型")
+
+	// 重新编码后保持不变
+	msg, err := EncodeEventMsg("A/event", args)
+	require.Nil(t, err)
+	assert.Contains(t, string(msg), `"count":9007199254740993`, "重新编码后大整数不变")
+
+	_, err = RawArgs{"bad": []byte(`{`)}.ToArgs()
+	assert.NotNil(t, err, "无效的参数")
+}
+
+// TestRawResp_ToResp 测试将未解析的响应返回值解码为 Resp 的情况
+func TestRawResp_ToResp(t *testing.T) {
+	resp, err := RawResp{
+		"id": []byte(`18446744073709551615`),
+	}.ToResp()
+	require.Nil(t, err)
+	assert.Equal(t, Resp{"id": gojson.Number("18446744073709551615")}, resp)
+
+	_, err = RawResp{"bad": []byte(`tru`)}.ToResp()
+	assert.NotNil(t, err, "无效的返回值")
+}
+
+func TestEncodeErrorEventMsg(t *testing.T) {
+	data, err := EncodeErrorEventMsg("model/error", 3, "motor over current", Args{
+		"motor":   2,
+		"code":    100,
+		"message": "ignored",
+	})
+	require.Nil(t, err)
+	assert.Equal(t,
+		[]byte(`{"type":"event","payload":{"name":"model/error","args":{"code":3,"message":"motor over current","motor":2}}}`),
+		data, "附加参数不能覆盖错误码和错误信息")
+
+	data, err = EncodeErrorEventMsg("model/error", 0, "", nil)
+	require.Nil(t, err)
+	assert.Equal(t,
+		[]byte(`{"type":"event","payload":{"name":"model/error","args":{"code":0,"message":""}}}`),
+		data, "没有附加参数")
+
+	_, err = EncodeErrorEventMsg("model/error", 1, "", Args{"ch": make(chan int)})
+	assert.Equal(t, errors.New("encode event args failed"), err, "附加参数编码失败")
+}
+
+func TestDecode(t *testing.T) {
+	msg, err := Decode([]byte(`{"type":"state","payload":{"name":"A/car/speed","data":1}}`))
+	require.Nil(t, err)
+	assert.Equal(t, "state", msg.Type)
+	assert.Equal(t, jsoniter.RawMessage(`{"name":"A/car/speed","data":1}`), msg.Payload)
+
+	state, err := ParseStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, StatePayload{Name: "A/car/speed", Data: []byte(`1`)}, state)
+
+	_, err = Decode([]byte(`{"type":`))
+	assert.NotNil(t, err, "无效的JSON")
+}
+
+func TestParsePayload(t *testing.T) {
+	type TestCase struct {
+		parse   func([]byte) (interface{}, error) // 解析函数
+		payload string                            // 报文内容
+		want    interface{}                       // 期望的解析结果
+		wantErr error                             // 期望的错误信息
+		desc    string                            // 用例描述
+	}
+
+	parseState := func(payload []byte) (interface{}, error) { return ParseStatePayload(payload) }
+	parseEvent := func(payload []byte) (interface{}, error) { return ParseEventPayload(payload) }
+	parseCall := func(payload []byte) (interface{}, error) { return ParseCallPayload(payload) }
+	parseResp := func(payload []byte) (interface{}, error) { return ParseResponsePayload(payload) }
+	parseSub := func(payload []byte) (interface{}, error) { return ParseSubPayload(payload) }
+	parsePing := func(payload []byte) (interface{}, error) { return ParsePingPayload(payload) }
+
+	testCases := []TestCase{
+		{
+			parse:   parseState,
+			payload: `{"data":1}`,
+			want:    StatePayload{},
+			wantErr: errors.New("name missing"),
+			desc:    "状态名缺失",
+		},
+
+		{
+			parse:   parseState,
+			payload: `{"name":"A/car/speed"}`,
+			want:    StatePayload{},
+			wantErr: errors.New("data missing"),
+			desc:    "状态数据缺失",
+		},
+
+		{
+			parse:   parseState,
+			payload: `{"name":"A/car/speed","data":null}`,
+			want:    StatePayload{Name: "A/car/speed", Data: []byte(`null`)},
+			desc:    "清除状态",
+		},
+
+		{
+			parse:   parseEvent,
+			payload: `{"name":"A/car/alarm","args":{"level":2}}`,
+			want:    EventPayload{Name: "A/car/alarm", Args: RawArgs{"level": []byte(`2`)}},
+			desc:    "事件",
+		},
+
+		{
+			parse:   parseEvent,
+			payload: `{"name":"  "}`,
+			want:    EventPayload{},
+			wantErr: errors.New("name missing"),
+			desc:    "事件名为空",
+		},
+
+		{
+			parse:   parseEvent,
+			payload: `{"name":"A/car/alarm"}`,
+			want:    EventPayload{},
+			wantErr: errors.New("args missing"),
+			desc:    "事件参数缺失",
+		},
+
+		{
+			parse:   parseCall,
+			payload: `{"name":"A/car/QS","uuid":"1","args":{}}`,
+			want:    CallPayload{Name: "A/car/QS", UUID: "1", Args: RawArgs{}},
+			desc:    "调用请求",
+		},
+
+		{
+			parse:   parseCall,
+			payload: `{"name":"A/car/QS","args":{}}`,
+			want:    CallPayload{},
+			wantErr: errors.New("uuid missing"),
+			desc:    "调用请求UUID缺失",
+		},
+
+		{
+			parse:   parseCall,
+			payload: `{"uuid":"1","args":{}}`,
+			want:    CallPayload{},
+			wantErr: errors.New("name missing"),
+			desc:    "调用请求方法名缺失",
+		},
+
+		{
+			parse:   parseCall,
+			payload: `{"name":"A/car/QS","uuid":"1"}`,
+			want:    CallPayload{},
+			wantErr: errors.New("args missing"),
+			desc:    "调用请求参数缺失",
+		},
+
+		{
+			parse:   parseResp,
+			payload: `{"uuid":"1","response":{"res":true}}`,
+			want:    ResponsePayload{UUID: "1", Response: RawResp{"res": []byte(`true`)}},
+			desc:    "调用响应缺少error字段",
+		},
+
+		{
+			parse:   parseResp,
+			payload: `{"uuid":"1","error":"timeout"}`,
+			want:    ResponsePayload{},
+			wantErr: errors.New("response missing"),
+			desc:    "调用响应返回值缺失",
+		},
+
+		{
+			parse:   parseResp,
+			payload: `{"response":{}}`,
+			want:    ResponsePayload{},
+			wantErr: errors.New("uuid missing"),
+			desc:    "调用响应UUID缺失",
+		},
+
+		{
+			parse:   parseSub,
+			payload: `["a","b"]`,
+			want:    SubPayload{Items: []string{"a", "b"}},
+			desc:    "旧格式订阅报文",
+		},
+
+		{
+			parse:   parseSub,
+			payload: `{"items":["a"],"snapshot":true,"uuid":"1"}`,
+			want:    SubPayload{Items: []string{"a"}, Snapshot: true, UUID: "1"},
+			desc:    "订阅报文",
+		},
+
+		{
+			parse:   parsePing,
+			payload: `{"uuid":"1"}`,
+			want:    PingPayload{UUID: "1"},
+			desc:    "探测报文",
+		},
+	}
+
+	for _, test := range testCases {
+		got, err := test.parse([]byte(test.payload))
+		assert.Equal(t, test.wantErr, err, test.desc)
+		assert.Equal(t, test.want, got, test.desc)
+	}
+
+	// 报文内容不是有效的JSON
+	for _, parse := range []func([]byte) (interface{}, error){parseState, parseEvent, parseCall, parseResp, parseSub, parsePing} {
+		_, err := parse([]byte(`{`))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestEncodeBatchMsg(t *testing.T) {
+	msgs := [][]byte{
+		[]byte(`{"type":"state","payload":{"name":"A/car/speed","data":1}}`),
+		[]byte(`{"type":"event","payload":{"name":"A/car/alarm","args":{}}}`),
+	}
+
+	batch := EncodeBatchMsg(msgs)
+	assert.Equal(t, `{"type":"batch","payload":[`+string(msgs[0])+`,`+string(msgs[1])+`]}`, string(batch))
+
+	msg, err := Decode(batch)
+	require.Nil(t, err)
+	assert.Equal(t, "batch", msg.Type)
+
+	got, err := ParseBatchPayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, msgs, got)
+
+	assert.Equal(t, `{"type":"batch","payload":[]}`, string(EncodeBatchMsg(nil)))
+	got, err = ParseBatchPayload([]byte(`[]`))
+	require.Nil(t, err)
+	assert.Empty(t, got, "空批量报文")
+
+	_, err = ParseBatchPayload([]byte(`{}`))
+	assert.NotNil(t, err, "报文内容不是数组")
+}
+
+func TestEncodeCloseMsg(t *testing.T) {
+	data := EncodeCloseMsg("maintenance")
+	assert.Equal(t, `{"type":"close","payload":{"reason":"maintenance"}}`, string(data))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	assert.Equal(t, "close", msg.Type)
+
+	payload, err := ParseClosePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, ClosePayload{Reason: "maintenance"}, payload)
+
+	_, err = ParseClosePayload([]byte(`[]`))
+	assert.NotNil(t, err)
+}
+
+func TestEncodeSessionMsg(t *testing.T) {
+	data := EncodeSessionMsg("phone-1", "123")
+	assert.Equal(t, `{"type":"session","payload":{"id":"phone-1","uuid":"123"}}`, string(data))
+	assert.Equal(t, `{"type":"session","payload":{"id":"phone-1"}}`, string(EncodeSessionMsg("phone-1", "")))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	assert.Equal(t, "session", msg.Type)
+
+	payload, err := ParseSessionPayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, SessionPayload{ID: "phone-1", UUID: "123"}, payload)
+
+	_, err = ParseSessionPayload([]byte(`[]`))
+	assert.NotNil(t, err)
+}
+
+func TestEncodeSetStateMsg(t *testing.T) {
+	data, err := EncodeSetStateMsg("A/car/target", "1", 10.5)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"set-state","payload":{"name":"A/car/target","uuid":"1","data":10.5}}`, string(data))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	payload, err := ParseSetStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, SetStatePayload{Name: "A/car/target", UUID: "1", Data: []byte(`10.5`)}, payload)
+
+	_, err = EncodeSetStateMsg("A/car/target", "1", func() {})
+	assert.NotNil(t, err, "无法编码的状态数据")
+
+	testCases := []struct {
+		payload string // 报文内容
+		wantErr string // 期望的错误信息
+	}{
+		{`{"uuid":"1","data":1}`, "name missing"},
+		{`{"name":"A/car/target","data":1}`, "uuid missing"},
+		{`{"name":"A/car/target","uuid":"1"}`, "data missing"},
+	}
+
+	for _, test := range testCases {
+		_, err := ParseSetStatePayload([]byte(test.payload))
+		require.NotNil(t, err, test.payload)
+		assert.Equal(t, test.wantErr, err.Error(), test.payload)
+	}
+}
+
+func TestEncodeInvalidFullName(t *testing.T) {
+	testCases := []struct {
+		fullName string // 全名
+		wantErr  string // 期望的错误信息
+	}{
+		{"noSlash", `fullName "noSlash" missing '/'`},
+		{"a//b", `fullName "a//b" has empty segment`},
+		{"/a", `fullName "/a" has empty segment`},
+		{"a/", `fullName "a/" has empty segment`},
+		{"a/ /b", `fullName "a/ /b" has empty segment`},
+	}
+
+	for _, test := range testCases {
+		_, err := EncodeStateMsg(test.fullName, 1)
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		_, err = EncodeStateSeqMsg(test.fullName, 1, 1)
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		_, err = EncodeEventMsg(test.fullName, Args{})
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		_, err = EncodeEventSeqMsg(test.fullName, 1, Args{})
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		_, err = EncodeCallMsg(test.fullName, "1", Args{})
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		_, err = EncodeSetStateMsg(test.fullName, "1", 1)
+		assert.EqualError(t, err, test.wantErr, test.fullName)
+		assert.Panics(t, func() {
+			Must(EncodeEventMsg(test.fullName, Args{}))
+		}, test.fullName)
+	}
+
+	_, err := EncodeStateMsg("A/car/speed", 1)
+	assert.Nil(t, err)
+}
+
+func TestEncodeSeqMsg(t *testing.T) {
+	data, err := EncodeStateSeqMsg("A/car/speed", 3, 10)
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/speed","data":10,"seq":3}}`, string(data))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	state, err := ParseStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, StatePayload{Name: "A/car/speed", Data: []byte(`10`), Seq: 3}, state)
+
+	data, err = EncodeStateSeqMsg("A/car/speed", 0, 10)
+	require.Nil(t, err)
+	assert.Equal(t, Must(EncodeStateMsg("A/car/speed", 10)), data, "序号为0时不携带序号")
+
+	data, err = EncodeEventSeqMsg("A/car/alarm", 4, Args{"level": 2})
+	require.Nil(t, err)
+	assert.Equal(t, `{"type":"event","payload":{"name":"A/car/alarm","args":{"level":2},"seq":4}}`, string(data))
+
+	msg, err = Decode(data)
+	require.Nil(t, err)
+	event, err := ParseEventPayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, EventPayload{Name: "A/car/alarm", Args: RawArgs{"level": []byte(`2`)}, Seq: 4}, event)
+
+	data, err = EncodeEventSeqMsg("A/car/alarm", 0, nil)
+	require.Nil(t, err)
+	assert.Equal(t, Must(EncodeEventMsg("A/car/alarm", nil)), data, "序号为0时不携带序号")
+}
+
+func TestEncodeClearStateMsg(t *testing.T) {
+	data := EncodeClearStateMsg("A/car/speed", 0)
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/speed","data":null}}`, string(data))
+
+	msg, err := Decode(data)
+	require.Nil(t, err)
+	state, err := ParseStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.True(t, state.Cleared())
+
+	data = EncodeClearStateMsg("A/car/speed", 3)
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/speed","data":null,"seq":3}}`, string(data))
+
+	assert.False(t, StatePayload{Name: "A/car/speed", Data: []byte(`"null"`)}.Cleared(), "字符串null不是清除状态")
+}
+
+func TestSetJSONConfig(t *testing.T) {
+	defer SetJSONConfig(DefaultJSONConfig)
+
+	data := Must(EncodeStateMsg("A/car/tag", "<a&b>"))
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/tag","data":"\u003ca\u0026b\u003e"}}`, string(data))
+
+	config := DefaultJSONConfig
+	config.EscapeHTML = false
+	config.MarshalFloatWith6Digits = true
+	SetJSONConfig(config)
+	assert.True(t, JSON() == json, "返回替换后的API实例")
+
+	data = Must(EncodeStateMsg("A/car/tag", "<a&b>"))
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/tag","data":"<a&b>"}}`, string(data), "关闭HTML转义")
+	data = Must(EncodeStateMsg("A/car/speed", 1.23456789))
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/speed","data":1.234568}}`, string(data), "6位精度")
+	data = Must(EncodeStateMsg("A/car/timeout", 1500*time.Millisecond))
+	assert.Equal(t, `{"type":"state","payload":{"name":"A/car/timeout","data":1500000000}}`, string(data), "时长仍然编码为纳秒整数")
+
+	args, err := RawArgs{"x": []byte("1")}.ToArgs()
+	require.Nil(t, err)
+	assert.Equal(t, Args{"x": gojson.Number("1")}, args, "仍然解码为json.Number")
+}
+
+func TestSeqNewer(t *testing.T) {
+	testCases := []struct {
+		a    uint64 // 序号a
+		b    uint64 // 序号b
+		want bool   // a是否比b新
+		desc string // 用例描述
+	}{
+		{2, 1, true, "递增"},
+		{1, 2, false, "乱序"},
+		{1, 1, false, "相同"},
+		{1, 0, true, "b没有序号"},
+		{0, 1, false, "a没有序号"},
+		{0, 0, false, "都没有序号"},
+		{1, math.MaxUint64, true, "回绕"},
+		{math.MaxUint64, 1, false, "回绕前"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.want, SeqNewer(test.a, test.b), test.desc)
+	}
+}
@@ -0,0 +1,126 @@
+package meta
+
+import (
+	"fmt"
+	"github.com/object-model/goModel/message"
+)
+
+// ParamsBuilder 为根据元信息逐个设置并校验参数的构造器, 由 Meta.NewMethodArgs 、 Meta.NewEventArgs
+// 和 Meta.NewMethodResp 创建. Set 在设置参数时立即校验参数是否存在以及参数值是否符合元信息,
+// 第一个错误会被记录下来, 之后的 Set 不再生效, 并在 Build 时返回. 例如:
+//
+//	args, err := m.NewMethodArgs("QS").
+//		Set("angle", 90.0).
+//		Set("speed", "fast").
+//		Build()
+type ParamsBuilder struct {
+	kind   string                                    // 参数类别, arg或者response, 用于错误信息
+	params map[string]ParamMeta                      // 参数名到参数元信息的映射
+	values map[string]interface{}                    // 已经设置的参数值
+	verify func(values map[string]interface{}) error // 构造时的完整校验
+	err    error                                     // 第一个错误
+}
+
+// NewMethodArgs 创建元信息m中名为method的方法的调用参数构造器, 方法不存在时构造器的 Build 返回错误信息.
+func (m *Meta) NewMethodArgs(method string) *ParamsBuilder {
+	index, seen := m.methodIndex[method]
+	if !seen {
+		return &ParamsBuilder{err: fmt.Errorf("NO method %q", method)}
+	}
+
+	return newParamsBuilder("arg", m.Method[index].Args, func(values map[string]interface{}) error {
+		return m.VerifyMethodArgs(method, values)
+	})
+}
+
+// NewEventArgs 创建元信息m中名为event的事件的参数构造器, 事件不存在时构造器的 Build 返回错误信息.
+func (m *Meta) NewEventArgs(event string) *ParamsBuilder {
+	index, seen := m.eventIndex[event]
+	if !seen {
+		return &ParamsBuilder{err: fmt.Errorf("NO event %q", event)}
+	}
+
+	return newParamsBuilder("arg", m.Event[index].Args, func(values map[string]interface{}) error {
+		return m.VerifyEvent(event, values)
+	})
+}
+
+// NewMethodResp 创建元信息m中名为method的方法的响应构造器, 通过 ParamsBuilder.BuildResp 获取构造的响应,
+// 方法不存在时构造器返回错误信息.
+func (m *Meta) NewMethodResp(method string) *ParamsBuilder {
+	index, seen := m.methodIndex[method]
+	if !seen {
+		return &ParamsBuilder{err: fmt.Errorf("NO method %q", method)}
+	}
+
+	return newParamsBuilder("response", m.Method[index].Response, func(values map[string]interface{}) error {
+		return m.VerifyMethodResp(method, values)
+	})
+}
+
+func newParamsBuilder(kind string, params []ParamMeta, verify func(map[string]interface{}) error) *ParamsBuilder {
+	ans := &ParamsBuilder{
+		kind:   kind,
+		params: make(map[string]ParamMeta, len(params)),
+		values: make(map[string]interface{}, len(params)),
+		verify: verify,
+	}
+
+	for _, param := range params {
+		ans.params[*param.Name] = param
+	}
+
+	return ans
+}
+
+// Set 设置名为name的参数的值为value, 参数不存在或者参数值不符合元信息时记录错误信息, 返回构造器本身以便链式调用.
+func (b *ParamsBuilder) Set(name string, value interface{}) *ParamsBuilder {
+	if b.err != nil {
+		return b
+	}
+
+	param, seen := b.params[name]
+	if !seen {
+		b.err = fmt.Errorf("NO %s %q", b.kind, name)
+		return b
+	}
+
+	if err := verifyData(param, value); err != nil {
+		b.err = fmt.Errorf("%s %q: %s", b.kind, name, err)
+		return b
+	}
+
+	b.values[name] = value
+	return b
+}
+
+// Err 返回构造过程中的第一个错误, 没有错误时返回nil.
+func (b *ParamsBuilder) Err() error {
+	return b.err
+}
+
+// Build 返回构造的参数和错误信息, 在返回前会按照元信息完整校验参数, 例如检查是否缺少参数.
+func (b *ParamsBuilder) Build() (message.Args, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if err := b.verify(b.values); err != nil {
+		return nil, err
+	}
+
+	ans := make(message.Args, len(b.values))
+	for name, value := range b.values {
+		ans[name] = value
+	}
+	return ans, nil
+}
+
+// BuildResp 与 Build 相同, 区别是以 message.Resp 类型返回构造的参数, 用于构造方法响应.
+func (b *ParamsBuilder) BuildResp() (message.Resp, error) {
+	args, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return message.Resp(args), nil
+}
@@ -0,0 +1,394 @@
+package meta
+
+import (
+	"bytes"
+	gojson "encoding/json"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/object-model/goModel/message"
+	"math"
+	"strings"
+	"time"
+)
+
+// durationUnit 返回时长类型参数元信息obj的单位, 没有声明单位时为纳秒
+func durationUnit(obj jsoniter.Any) string {
+	unit := obj.Get("unit")
+	if unit.LastError() != nil {
+		return "ns"
+	}
+	return strings.TrimSpace(unit.ToString())
+}
+
+// durationVal 将JSON值any解析为时长, any可以是以unit为单位的数值, 也可以是Go风格的时长字符串, 例如"1500ms"
+func durationVal(any jsoniter.Any, unit string) (time.Duration, error) {
+	switch any.ValueType() {
+	case jsoniter.NumberValue:
+		value := any.ToFloat64()
+		if any.LastError() != nil {
+			return 0, fmt.Errorf("NOT number")
+		}
+		ns, err := ConvertUnit(value, unit, "ns")
+		if err != nil {
+			return 0, err
+		}
+		// NOTE: 超出int64范围的时长转换后会溢出, 不能静默截断
+		ns = math.Round(ns)
+		if ns >= math.MaxInt64 || ns < math.MinInt64 {
+			return 0, fmt.Errorf("duration overflow")
+		}
+		return time.Duration(ns), nil
+	case jsoniter.StringValue:
+		d, err := time.ParseDuration(strings.TrimSpace(any.ToString()))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", any.ToString())
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("NOT duration")
+	}
+}
+
+// checkDuration 检查时长类型参数元信息obj, 声明了单位时必须是时间单位
+func checkDuration(obj jsoniter.Any) error {
+	unit := obj.Get("unit")
+	if unit.LastError() != nil {
+		return nil
+	}
+
+	// unit字段必须是字符串类型
+	if unit.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("unit is NOT string")
+	}
+
+	// unit必须是时间单位
+	unitStr := strings.TrimSpace(unit.ToString())
+	if dimension, seen := DimensionOf(unitStr); !seen || dimension != "time" {
+		return fmt.Errorf("unit %q is NOT time unit", unitStr)
+	}
+
+	return nil
+}
+
+// checkDurationRange 检查时长类型参数的范围约束, min max default可以是以unit为单位的数值或者时长字符串
+func checkDurationRange(rangeObj jsoniter.Any, unit string) error {
+	minCfg := rangeObj.Get("min")
+	maxCfg := rangeObj.Get("max")
+
+	maxGot := maxCfg.LastError() == nil
+	minGot := minCfg.LastError() == nil
+
+	// duration类型的range必须有min 或 max字段, 不能两个都没有
+	if !maxGot && !minGot {
+		return fmt.Errorf("range: NO min or max for duration range")
+	}
+
+	var min, max time.Duration
+	var err error
+	if minGot {
+		if min, err = durationVal(minCfg, unit); err != nil {
+			return fmt.Errorf("range: min: %s", err)
+		}
+	}
+
+	if maxGot {
+		if max, err = durationVal(maxCfg, unit); err != nil {
+			return fmt.Errorf("range: max: %s", err)
+		}
+	}
+
+	// 在max和min字段都存在的情况下，最小值不能大于最大值
+	if maxGot && minGot && min > max {
+		return fmt.Errorf("range: min is NOT less than max")
+	}
+
+	// 如果有default字段，检查默认值是否合理
+	Default := rangeObj.Get("default")
+	if Default.LastError() == nil {
+		defaultValue, err := durationVal(Default, unit)
+		if err != nil {
+			return fmt.Errorf("range: default: %s", err)
+		}
+
+		// 默认值必须介于[min, max]之间
+		if minGot && defaultValue < min {
+			return fmt.Errorf("range: default: less than min")
+		}
+
+		if maxGot && defaultValue > max {
+			return fmt.Errorf("range: default: greater than max")
+		}
+	}
+
+	return nil
+}
+
+// durationRangeVal 返回时长类型范围约束值any的时长字符串, 用于保存在 RangeInfo 中
+func durationRangeVal(any jsoniter.Any, unit string) interface{} {
+	d, _ := durationVal(any, unit)
+	return d.String()
+}
+
+func verifyDurationData(meta ParamMeta, data interface{}, checkRange bool) error {
+	// 1.类型是否匹配
+	value, isDuration := data.(time.Duration)
+	if !isDuration {
+		return fmt.Errorf("type unmatched")
+	}
+
+	// 2.如果有范围约束，检查是否在范围内
+	if checkRange {
+		return verifyRangeForDuration(meta.Range, value)
+	}
+
+	return nil
+}
+
+func verifyRawDurationData(meta ParamMeta, root jsoniter.Any) error {
+	// 1.必须是数值或者时长字符串
+	unit := "ns"
+	if meta.Unit != nil {
+		unit = *meta.Unit
+	}
+	value, err := durationVal(root, unit)
+	if err != nil {
+		return err
+	}
+
+	// 2.检查范围
+	return verifyRangeForDuration(meta.Range, value)
+}
+
+func verifyRangeForDuration(rangeInfo *RangeInfo, value time.Duration) error {
+	// 没有范围约束，无错误
+	if rangeInfo == nil {
+		return nil
+	}
+
+	if rangeInfo.Min != nil {
+		min, err := rangeDuration(rangeInfo.Min)
+		if err != nil {
+			return fmt.Errorf("range: min: %s", err)
+		}
+		if value < min {
+			return fmt.Errorf("less than min")
+		}
+	}
+	if rangeInfo.Max != nil {
+		max, err := rangeDuration(rangeInfo.Max)
+		if err != nil {
+			return fmt.Errorf("range: max: %s", err)
+		}
+		if value > max {
+			return fmt.Errorf("greater than max")
+		}
+	}
+
+	return nil
+}
+
+// rangeDuration 将 RangeInfo 中保存的时长范围约束值v转换为时长,
+// v可以是解析元信息时保存的时长字符串(见 durationRangeVal), 也可以是直接构造 RangeInfo 时使用的 time.Duration
+func rangeDuration(v interface{}) (time.Duration, error) {
+	switch value := v.(type) {
+	case time.Duration:
+		return value, nil
+	case string:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return d, nil
+	default:
+		return 0, fmt.Errorf("%v is NOT duration", v)
+	}
+}
+
+// needsConversion 判断参数元信息meta中是否包含编码时需要转换的类型, 即单位不是纳秒的时长类型和要求带小数点的浮点数
+func needsConversion(meta ParamMeta) bool {
+	switch meta.Type {
+	case "duration":
+		return meta.Unit != nil && *meta.Unit != "ns"
+	case "float":
+		return meta.Decimal != nil && *meta.Decimal
+	case "array", "slice":
+		return needsConversion(*meta.Element)
+	case "struct":
+		for _, field := range meta.Fields {
+			if needsConversion(field) {
+				return true
+			}
+		}
+	case "union":
+		for _, variant := range meta.Variants {
+			if needsConversion(variant) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dataInUnit 将数据data中时长类型的值转换为以元信息meta中声明的单位为单位的数值, 返回转换后的原始JSON数据.
+// time.Duration 编码为纳秒整数, 因此时长类型位置上的数值都视为纳秒, 时长字符串保持不变.
+// 要求带小数点(decimal)的浮点数位置上的整数字面量(例如90.0默认编码为90)会补充小数点, 保证对端校验通过.
+// meta中不包含需要转换的类型时直接返回data.
+func dataInUnit(meta ParamMeta, data interface{}) (interface{}, error) {
+	if !needsConversion(meta) {
+		return data, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// NOTE: 以json.Number解码, 保证其他数值重新编码后不损失精度
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err = decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	if value, err = convertValue(meta, value); err != nil {
+		return nil, err
+	}
+
+	raw, err = json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return jsoniter.RawMessage(raw), nil
+}
+
+// convertValue 按照元信息meta递归地将解码后的数据value中时长类型位置上的纳秒数值转换为meta中声明的单位,
+// 并为要求带小数点的浮点数位置上的整数字面量补充小数点, 与元信息不匹配的部分保持不变, 由对端校验
+func convertValue(meta ParamMeta, value interface{}) (interface{}, error) {
+	switch meta.Type {
+	case "float":
+		number, ok := value.(gojson.Number)
+		if ok && meta.Decimal != nil && *meta.Decimal && isIntegerToken(number.String()) {
+			return number + ".0", nil
+		}
+		return value, nil
+	case "duration":
+		number, ok := value.(gojson.Number)
+		if !ok || meta.Unit == nil {
+			return value, nil
+		}
+		ns, err := number.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return ConvertUnit(ns, "ns", *meta.Unit)
+	case "array", "slice":
+		elems, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		for i, elem := range elems {
+			scaled, err := convertValue(*meta.Element, elem)
+			if err != nil {
+				return nil, fmt.Errorf("element[%d]: %s", i, err)
+			}
+			elems[i] = scaled
+		}
+	case "struct":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		for _, fieldMeta := range meta.Fields {
+			name := *fieldMeta.Name
+			field, seen := fields[name]
+			if !seen {
+				continue
+			}
+			scaled, err := convertValue(fieldMeta, field)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %s", name, err)
+			}
+			fields[name] = scaled
+		}
+	case "union":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		variantName, _ := fields[*meta.Discriminator].(string)
+		if variant, seen := meta.Variants[variantName]; seen {
+			return convertValue(variant, value)
+		}
+	}
+	return value, nil
+}
+
+// argsInUnit 按照参数元信息metas转换参数args中需要转换的类型, 见 dataInUnit.
+// 不需要转换时直接返回args, 否则返回转换后的副本, 不修改args.
+func argsInUnit(metas []ParamMeta, args map[string]interface{}) (map[string]interface{}, error) {
+	var ans map[string]interface{}
+	for _, argMeta := range metas {
+		name := *argMeta.Name
+		value, seen := args[name]
+		if !seen || !needsConversion(argMeta) {
+			continue
+		}
+
+		scaled, err := dataInUnit(argMeta, value)
+		if err != nil {
+			return nil, fmt.Errorf("arg %q: %s", name, err)
+		}
+		if ans == nil {
+			ans = make(map[string]interface{}, len(args))
+			for key, v := range args {
+				ans[key] = v
+			}
+		}
+		ans[name] = scaled
+	}
+
+	if ans == nil {
+		return args, nil
+	}
+	return ans, nil
+}
+
+// StateInUnit 返回名称为name的状态数据data实际推送时应编码的数据: time.Duration 默认编码为纳秒整数,
+// 元信息中声明了其他单位(unit)的时长类型会被转换为以该单位为单位的数值, 保证对端按照元信息解析的时长与data一致.
+// 声明了decimal的浮点数总是编码为带小数点的数字, 例如90.0编码为90.0而不是90, 保证对端的 VerifyRawState 校验通过.
+// 状态不存在或者不包含需要转换的类型时直接返回data, 数据无法编码时返回错误信息.
+func (m *Meta) StateInUnit(name string, data interface{}) (interface{}, error) {
+	index, seen := m.stateIndex[name]
+	if !seen {
+		return data, nil
+	}
+	return dataInUnit(m.State[index], data)
+}
+
+// EventArgsInUnit 返回名称为name的事件参数args实际推送时应编码的参数, 规则与 StateInUnit 相同, 不修改args
+func (m *Meta) EventArgsInUnit(name string, args message.Args) (message.Args, error) {
+	index, seen := m.eventIndex[name]
+	if !seen {
+		return args, nil
+	}
+	return argsInUnit(m.Event[index].Args, args)
+}
+
+// MethodArgsInUnit 返回调用名称为name的方法时参数args实际应编码的参数, 规则与 StateInUnit 相同, 不修改args
+func (m *Meta) MethodArgsInUnit(name string, args message.Args) (message.Args, error) {
+	index, seen := m.methodIndex[name]
+	if !seen {
+		return args, nil
+	}
+	return argsInUnit(m.Method[index].Args, args)
+}
+
+// MethodRespInUnit 返回名称为name的方法的返回值resp实际响应时应编码的返回值, 规则与 StateInUnit 相同, 不修改resp
+func (m *Meta) MethodRespInUnit(name string, resp message.Resp) (message.Resp, error) {
+	index, seen := m.methodIndex[name]
+	if !seen {
+		return resp, nil
+	}
+	return argsInUnit(m.Method[index].Response, resp)
+}
@@ -0,0 +1,61 @@
+package meta
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// StateInvariantFunc 为状态约束函数, 参数raw为已经通过元信息结构校验的状态数据,
+// 数据满足约束时返回nil, 否则返回错误信息.
+type StateInvariantFunc func(raw jsoniter.Any) error
+
+// stateInvariantsMap 为状态名称到状态约束函数的映射
+type stateInvariantsMap map[string][]StateInvariantFunc
+
+// WithStateInvariant 为元信息m中名为stateName的状态添加约束函数fn, 状态不存在或者fn为nil时返回错误信息.
+// 元信息只能描述单个字段的类型和范围, 约束函数用于描述字段之间的关系, 例如:
+//
+//	_ = m.WithStateInvariant("ctrl", func(raw jsoniter.Any) error {
+//		if raw.Get("mode").ToString() == "manual" && raw.Get("setpoint").ToFloat64() <= 0 {
+//			return fmt.Errorf("setpoint required in manual mode")
+//		}
+//		return nil
+//	})
+//
+// VerifyRawState 在数据通过结构校验后按照添加顺序调用该状态的所有约束函数, 返回第一个错误, 错误信息以状态名称为前缀.
+// 约束函数只属于元信息m, 不会序列化到元信息JSON中, 也不影响 Equal 的比较结果.
+// NOTE: fn会在校验数据的协程中并发调用, 必须是并发安全的.
+func (m *Meta) WithStateInvariant(stateName string, fn StateInvariantFunc) error {
+	if _, seen := m.stateIndex[stateName]; !seen {
+		return fmt.Errorf("NO state %q", stateName)
+	}
+	if fn == nil {
+		return fmt.Errorf("nil invariant")
+	}
+
+	m.invariantLock.Lock()
+	defer m.invariantLock.Unlock()
+	if m.invariants == nil {
+		m.invariants = make(stateInvariantsMap)
+	}
+	m.invariants[stateName] = append(m.invariants[stateName], fn)
+	return nil
+}
+
+// verifyInvariants 依次调用名为name的状态的所有约束函数校验原始数据data
+func (m *Meta) verifyInvariants(name string, data []byte) error {
+	m.invariantLock.RLock()
+	fns := m.invariants[name]
+	m.invariantLock.RUnlock()
+	if len(fns) == 0 {
+		return nil
+	}
+
+	root := json.Get(data)
+	for _, fn := range fns {
+		if err := fn(root); err != nil {
+			return fmt.Errorf("state %q: %s", name, err)
+		}
+	}
+	return nil
+}
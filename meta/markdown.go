@@ -0,0 +1,241 @@
+package meta
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ToMarkdown 将物模型元信息m渲染为Markdown文档, 包括版本号和附加标签(声明了时)、状态表格、每个事件的参数表格以及每个方法的参数和返回值表格.
+// 结构体、数组、切片和联合类型的参数在表格之后以嵌套列表的形式展开其字段或元素.
+// 相同的元信息总是渲染出相同的文档, 可以在持续集成中根据元信息文件重新生成文档并比较差异.
+func (m *Meta) ToMarkdown() []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# %s\n\n", m.Name)
+	fmt.Fprintf(&buf, "%s\n\n", mdText(m.Description))
+	if m.Version != "" {
+		fmt.Fprintf(&buf, "版本: %s\n\n", m.Version)
+	}
+	if len(m.LabelMap) > 0 {
+		keys := make([]string, 0, len(m.LabelMap))
+		for key := range m.LabelMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "- %s: %s\n", mdText(key), mdText(m.LabelMap[key]))
+		}
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString("## 状态\n\n")
+	writeParamsTable(&buf, m.State)
+
+	buf.WriteString("## 事件\n\n")
+	if len(m.Event) == 0 {
+		buf.WriteString("无\n\n")
+	}
+	for _, event := range m.Event {
+		fmt.Fprintf(&buf, "### %s\n\n", event.Name)
+		fmt.Fprintf(&buf, "%s\n\n", mdText(event.Description))
+		buf.WriteString("参数:\n\n")
+		writeParamsTable(&buf, event.Args)
+	}
+
+	buf.WriteString("## 方法\n\n")
+	if len(m.Method) == 0 {
+		buf.WriteString("无\n\n")
+	}
+	for _, method := range m.Method {
+		fmt.Fprintf(&buf, "### %s\n\n", method.Name)
+		fmt.Fprintf(&buf, "%s\n\n", mdText(method.Description))
+		buf.WriteString("参数:\n\n")
+		writeParamsTable(&buf, method.Args)
+		buf.WriteString("返回值:\n\n")
+		writeParamsTable(&buf, method.Response)
+	}
+
+	return bytes.TrimRight(buf.Bytes(), "\n")
+}
+
+// writeParamsTable 将参数列表params渲染为表格, 复合类型的参数在表格之后展开
+func writeParamsTable(buf *bytes.Buffer, params []ParamMeta) {
+	if len(params) == 0 {
+		buf.WriteString("无\n\n")
+		return
+	}
+
+	buf.WriteString("| 名称 | 描述 | 类型 | 单位 | 范围 |\n")
+	buf.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, param := range params {
+		fmt.Fprintf(buf, "| %s | %s | %s | %s | %s |\n",
+			mdCell(paramName(param)),
+			mdCell(paramDescription(param)),
+			mdCell(mdType(param)),
+			mdCell(paramUnit(param)),
+			mdCell(mdRange(param.Range)))
+	}
+	buf.WriteString("\n")
+
+	for _, param := range params {
+		if !isComposite(param) {
+			continue
+		}
+		fmt.Fprintf(buf, "`%s` 的结构:\n\n", paramName(param))
+		writeParamChildren(buf, param, 0)
+		buf.WriteString("\n")
+	}
+}
+
+// writeParamChildren 以缩进层级depth将复合类型参数param的字段、元素或者变体渲染为嵌套列表
+func writeParamChildren(buf *bytes.Buffer, param ParamMeta, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch param.Type {
+	case "struct":
+		for _, field := range param.Fields {
+			fmt.Fprintf(buf, "%s- %s\n", indent, mdItem(field))
+			if isComposite(field) {
+				writeParamChildren(buf, field, depth+1)
+			}
+		}
+	case "array", "slice":
+		element := *param.Element
+		fmt.Fprintf(buf, "%s- 元素: %s\n", indent, mdItem(element))
+		if isComposite(element) {
+			writeParamChildren(buf, element, depth+1)
+		}
+	case "union":
+		keys := make([]string, 0, len(param.Variants))
+		for key := range param.Variants {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Fprintf(buf, "%s- %s = `%s`:\n", indent, paramDiscriminator(param), key)
+			writeParamChildren(buf, param.Variants[key], depth+1)
+		}
+	}
+}
+
+// mdItem 返回参数param在列表中的描述
+func mdItem(param ParamMeta) string {
+	attrs := []string{mdType(param)}
+	if unit := paramUnit(param); unit != "" {
+		attrs = append(attrs, unit)
+	}
+	if r := mdRange(param.Range); r != "" {
+		attrs = append(attrs, r)
+	}
+
+	ans := fmt.Sprintf("(%s)", strings.Join(attrs, ", "))
+	if name := paramName(param); name != "" {
+		ans = fmt.Sprintf("`%s` %s", name, ans)
+	}
+	if description := paramDescription(param); description != "" {
+		ans += ": " + description
+	}
+	return ans
+}
+
+// mdType 返回参数param的类型描述, 数组和切片包含元素类型
+func mdType(param ParamMeta) string {
+	switch param.Type {
+	case "array":
+		return fmt.Sprintf("array[%d]<%s>", *param.Length, mdType(*param.Element))
+	case "slice":
+		return fmt.Sprintf("slice<%s>", mdType(*param.Element))
+	case "union":
+		return fmt.Sprintf("union(%s)", paramDiscriminator(param))
+	}
+	return param.Type
+}
+
+// mdRange 返回范围约束rangeInfo的描述
+func mdRange(rangeInfo *RangeInfo) string {
+	if rangeInfo == nil {
+		return ""
+	}
+
+	var items []string
+	switch {
+	case rangeInfo.Min != nil && rangeInfo.Max != nil:
+		items = append(items, fmt.Sprintf("[%s, %s]", mdValue(rangeInfo.Min), mdValue(rangeInfo.Max)))
+	case rangeInfo.Min != nil:
+		items = append(items, fmt.Sprintf(">= %s", mdValue(rangeInfo.Min)))
+	case rangeInfo.Max != nil:
+		items = append(items, fmt.Sprintf("<= %s", mdValue(rangeInfo.Max)))
+	}
+
+	if len(rangeInfo.Option) > 0 {
+		options := make([]string, 0, len(rangeInfo.Option))
+		for _, option := range rangeInfo.Option {
+			options = append(options, fmt.Sprintf("%s: %s", mdValue(option.Value), mdText(option.Description)))
+		}
+		items = append(items, "可选值: "+strings.Join(options, "; "))
+	}
+
+	if rangeInfo.Default != nil {
+		items = append(items, "默认值: "+mdValue(rangeInfo.Default))
+	}
+
+	return strings.Join(items, ", ")
+}
+
+// mdValue 返回值value的JSON表示
+func mdValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// mdText 将文本s中的连续空白字符替换为一个空格, 避免破坏Markdown格式
+func mdText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// mdCell 转义表格单元格中的竖线
+func mdCell(s string) string {
+	return strings.ReplaceAll(mdText(s), "|", "\\|")
+}
+
+func isComposite(param ParamMeta) bool {
+	switch param.Type {
+	case "struct", "array", "slice", "union":
+		return true
+	}
+	return false
+}
+
+func paramName(param ParamMeta) string {
+	if param.Name == nil {
+		return ""
+	}
+	return *param.Name
+}
+
+func paramDescription(param ParamMeta) string {
+	if param.Description == nil {
+		return ""
+	}
+	return mdText(*param.Description)
+}
+
+func paramUnit(param ParamMeta) string {
+	if param.Unit == nil {
+		return ""
+	}
+	return *param.Unit
+}
+
+func paramDiscriminator(param ParamMeta) string {
+	if param.Discriminator == nil {
+		return ""
+	}
+	return *param.Discriminator
+}
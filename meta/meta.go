@@ -1,11 +1,20 @@
 package meta
 
 import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/object-model/goModel/message"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -20,9 +29,30 @@ var validType = map[string]struct{}{
 	"slice":  {},
 	"struct": {},
 	"meta":   {},
+	"union":  {},
+
+	"duration": {},
+}
+
+// semverPattern 为语义化版本号(https://semver.org)的格式, 如 1.0.0 、 2.1.0-rc.1 和 1.0.0+build.5
+var semverPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(-(0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(\.(0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*)?` +
+	`(\+[0-9a-zA-Z-]+(\.[0-9a-zA-Z-]+)*)?$`)
+
+var json = message.FrozeJSON(message.DefaultJSONConfig)
+
+// SetJSONConfig 将解析元信息、序列化元信息和校验数据使用的JSON配置替换为config,
+// 默认与 jsoniter.ConfigCompatibleWithStandardLibrary 的配置相同, 即 message.DefaultJSONConfig.
+// 已经序列化过的元信息(见 Meta.ToJSON)缓存了序列化结果, 不受替换的影响. 替换配置的风险见 message.SetJSONConfig.
+// NOTE: 配置是进程全局的, 必须在解析任何元信息之前调用, 不能与解析和校验并发调用, 通常应该通过 model.SetJSONConfig 统一替换
+func SetJSONConfig(config jsoniter.Config) {
+	json = message.FrozeJSON(config)
 }
 
-var json = jsoniter.ConfigCompatibleWithStandardLibrary
+// MaxParamDepth 为参数元信息允许的最大嵌套深度, 状态、事件参数、方法参数和返回值的深度为1,
+// 数组和切片的元素、结构体的字段、联合类型的变体的深度为其所属参数的深度加1.
+// 元信息不支持递归类型, Parse 在嵌套深度超过上限时返回错误, 避免恶意或者错误的元信息导致递归过深.
+const MaxParamDepth = 32
 
 // OptionInfo 为选项元信息
 type OptionInfo struct {
@@ -46,10 +76,26 @@ type ParamMeta struct {
 	Element     *ParamMeta  `json:"element,omitempty"`     // 数组或者切片元素的元信息, 仅在 Type 为数组或切片时有效
 	Fields      []ParamMeta `json:"fields,omitempty"`      // 结构体类型参数的字段元信息, 仅在 Type 为结构体时有效
 	Length      *uint       `json:"length,omitempty"`      // 数组长度, 仅在 Type 为 数组时有效
-	Unit        *string     `json:"unit,omitempty"`        // 参数单位
+	Unit        *string     `json:"unit,omitempty"`        // 参数单位, Type 为时长时必须是时间单位, 默认为纳秒
+	Dimension   *string     `json:"dimension,omitempty"`   // 参数单位所属的量纲, 仅在 Type 为 int uint float 时有效
 	Range       *RangeInfo  `json:"range,omitempty"`       // 参数范围, 仅在 Type 为 int uint float string时有效
+	Format      *string     `json:"format,omitempty"`      // 参数的自定义格式, 见 RegisterValidator, 仅在 Type 为叶子类型时有效
+	Decimal     *bool       `json:"decimal,omitempty"`     // 原始数据是否必须是带小数点或者指数的数字, 仅在 Type 为浮点数时有效, 默认为false
+
+	Discriminator *string              `json:"discriminator,omitempty"` // 联合类型的类型标识字段名, 仅在 Type 为联合类型时有效
+	Variants      map[string]ParamMeta `json:"variants,omitempty"`      // 联合类型的类型标识值到结构体元信息的映射, 仅在 Type 为联合类型时有效
+
+	Access   *string `json:"access,omitempty"`   // 状态的访问权限, 取值为 AccessRead AccessWrite AccessReadWrite, 仅对状态有效, 默认为只读
+	Optional *bool   `json:"optional,omitempty"` // 方法参数调用时是否可以省略, 或者状态是否可以被清除, 仅对方法参数和状态有效, 默认为false, 见 Meta.VerifyMethodArgs 和 Meta.VerifyStateClear
 }
 
+// 状态的访问权限
+const (
+	AccessRead      = "r"  // 只读, 状态只能由物模型推送
+	AccessWrite     = "w"  // 只写, 状态只能由对端设置
+	AccessReadWrite = "rw" // 读写, 状态既可以推送也可以由对端设置
+)
+
 // EventMeta 为事件元信息
 type EventMeta struct {
 	Name        string      `json:"name"`        // 事件名称
@@ -63,15 +109,19 @@ type MethodMeta struct {
 	Description string      `json:"description"` // 方法描述
 	Args        []ParamMeta `json:"args"`        // 方法参数
 	Response    []ParamMeta `json:"response"`    // 方法响应
+
+	VerifyResponse *bool `json:"verifyResponse,omitempty"` // 是否校验方法响应, 为nil表示由物模型的全局选项决定
 }
 
 // Meta 为物模型元信息
 type Meta struct {
-	Name        string       `json:"name"`        // 物模型名称
-	Description string       `json:"description"` // 物模型描述
-	State       []ParamMeta  `json:"state"`       // 状态元信息
-	Event       []EventMeta  `json:"event"`       // 事件元信息
-	Method      []MethodMeta `json:"method"`      // 方法元信息
+	Name        string            `json:"name"`              // 物模型名称
+	Description string            `json:"description"`       // 物模型描述
+	Version     string            `json:"version,omitempty"` // 物模型版本号, 为语义化版本号, 没有声明版本号时为空
+	LabelMap    map[string]string `json:"labels,omitempty"`  // 物模型的附加标签, 如厂商、序列号、硬件版本等, 见 Meta.Labels
+	State       []ParamMeta       `json:"state"`             // 状态元信息
+	Event       []EventMeta       `json:"event"`             // 事件元信息
+	Method      []MethodMeta      `json:"method"`            // 方法元信息
 
 	nameTokens    []string       // 物模型名称以/分割后的有效token
 	nameTemplates map[string]int // 模板参数名到nameTokens中的索引
@@ -81,11 +131,30 @@ type Meta struct {
 
 	json       []byte    // 缓存元信息序列化后的JSON串
 	encodeOnce sync.Once // 只序列化一次
+
+	invariantLock sync.RWMutex       // 保护 invariants
+	invariants    stateInvariantsMap // 状态名称 -> 状态约束函数, 见 WithStateInvariant
 }
 
 // TemplateParam 为元信息模板参数
 type TemplateParam map[string]string
 
+// Labels 返回物模型元信息m的附加标签的副本, 标签不参与任何校验, 一般用于资产管理或者服务发现, 例如:
+//
+//	"labels": {"manufacturer": "ACME", "serial": "SN-0001", "hardware": "rev.B"}
+//
+// 元信息没有声明标签时返回nil.
+func (m *Meta) Labels() map[string]string {
+	if len(m.LabelMap) == 0 {
+		return nil
+	}
+	ans := make(map[string]string, len(m.LabelMap))
+	for key, value := range m.LabelMap {
+		ans[key] = value
+	}
+	return ans
+}
+
 // AllStates 返回物模型元信息m中的所有状态全名.
 func (m *Meta) AllStates() []string {
 	res := make([]string, 0, len(m.State))
@@ -122,6 +191,147 @@ func (m *Meta) AllMethods() []string {
 	return res
 }
 
+// Equal 返回元信息m与other的公开结构是否相同, 包括名称、描述以及所有状态、事件、方法的元信息(含范围约束、单位等),
+// 不比较缓存的序列化结果等内部字段. m与other都为nil时返回true.
+func (m *Meta) Equal(other *Meta) bool {
+	if m == nil || other == nil {
+		return m == other
+	}
+
+	if m.Name != other.Name || m.Description != other.Description || m.Version != other.Version {
+		return false
+	}
+
+	if len(m.LabelMap) != 0 || len(other.LabelMap) != 0 {
+		if !reflect.DeepEqual(m.LabelMap, other.LabelMap) {
+			return false
+		}
+	}
+
+	if !paramsEqual(m.State, other.State) {
+		return false
+	}
+
+	if len(m.Event) != len(other.Event) {
+		return false
+	}
+	for i := range m.Event {
+		if m.Event[i].Name != other.Event[i].Name ||
+			m.Event[i].Description != other.Event[i].Description ||
+			!paramsEqual(m.Event[i].Args, other.Event[i].Args) {
+			return false
+		}
+	}
+
+	if len(m.Method) != len(other.Method) {
+		return false
+	}
+	for i := range m.Method {
+		if m.Method[i].Name != other.Method[i].Name ||
+			m.Method[i].Description != other.Method[i].Description ||
+			!paramsEqual(m.Method[i].Args, other.Method[i].Args) ||
+			!paramsEqual(m.Method[i].Response, other.Method[i].Response) ||
+			!reflect.DeepEqual(m.Method[i].VerifyResponse, other.Method[i].VerifyResponse) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// paramsEqual 返回参数元信息列表a与b是否相同, nil与空列表相同
+func paramsEqual(a []ParamMeta, b []ParamMeta) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAndCompare 以模板参数templateParam解析元信息rawData, 返回解析结果是否与元信息want相同(见 Meta.Equal)和解析错误信息.
+// 例如 ParseAndCompare(m.ToJSON(), nil, m) 可以校验元信息m序列化后再解析的结果与m相同.
+func ParseAndCompare(rawData []byte, templateParam TemplateParam, want *Meta) (bool, error) {
+	got, err := Parse(rawData, templateParam)
+	if err != nil {
+		return false, err
+	}
+	return got.Equal(want), nil
+}
+
+// LoadDir 以模板参数param解析目录dir下所有扩展名为.json的元信息文件(不包括子目录), 返回物模型名称到元信息的映射.
+// 任意一个文件读取或者解析失败, 或者两个文件解析后的物模型名称相同时, 返回错误信息, 错误信息以出错的文件路径开头.
+// LoadDir 一般用于在构建时校验项目中的所有元信息文件, 并检查物模型名称是否冲突.
+func LoadDir(dir string, param TemplateParam) (map[string]*Meta, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ans := make(map[string]*Meta)
+	files := make(map[string]string) // 物模型名称 -> 文件路径
+	for _, info := range infos {
+		if info.IsDir() || filepath.Ext(info.Name()) != ".json" {
+			continue
+		}
+
+		file := filepath.Join(dir, info.Name())
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, err := Parse(content, param)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+
+		if prev, seen := files[m.Name]; seen {
+			return nil, fmt.Errorf("%s: model name %q already defined in %s", file, m.Name, prev)
+		}
+		files[m.Name] = file
+		ans[m.Name] = m
+	}
+
+	return ans, nil
+}
+
+// StatesByPrefix 返回物模型元信息m中名称(不含物模型名称)以prefix开头的所有状态全名, 按照声明顺序排列.
+func (m *Meta) StatesByPrefix(prefix string) []string {
+	res := make([]string, 0)
+	for i := range m.State {
+		if strings.HasPrefix(*m.State[i].Name, prefix) {
+			res = append(res, m.Name+"/"+*m.State[i].Name)
+		}
+	}
+	return res
+}
+
+// EventsByPrefix 返回物模型元信息m中名称(不含物模型名称)以prefix开头的所有事件全名, 按照声明顺序排列.
+func (m *Meta) EventsByPrefix(prefix string) []string {
+	res := make([]string, 0)
+	for i := range m.Event {
+		if strings.HasPrefix(m.Event[i].Name, prefix) {
+			res = append(res, m.Name+"/"+m.Event[i].Name)
+		}
+	}
+	return res
+}
+
+// MethodsByPrefix 返回物模型元信息m中名称(不含物模型名称)以prefix开头的所有方法全名, 按照声明顺序排列.
+func (m *Meta) MethodsByPrefix(prefix string) []string {
+	res := make([]string, 0)
+	for i := range m.Method {
+		if strings.HasPrefix(m.Method[i].Name, prefix) {
+			res = append(res, m.Name+"/"+m.Method[i].Name)
+		}
+	}
+	return res
+}
+
 // ToJSON 将物模型元信息m序列化JSON串.
 func (m *Meta) ToJSON() []byte {
 	m.encodeOnce.Do(func() {
@@ -134,6 +344,16 @@ func (m *Meta) ToJSON() []byte {
 	return m.json
 }
 
+// ToJSONIndent 将物模型元信息m序列化为以prefix为行前缀、以indent为缩进的JSON串, 返回序列化结果和错误信息.
+// 序列化内容与 ToJSON 相同, 只是格式不同, 用于导出便于阅读的元信息. ToJSONIndent 不缓存结果, 也不影响 ToJSON 缓存的结果.
+func (m *Meta) ToJSONIndent(prefix string, indent string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := stdjson.Indent(&buf, m.ToJSON(), prefix, indent); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // VerifyState 验证名称为name数据为data的状态是否符合元信息m, 如果符合返回nil, 如果不符合返回错误信息.
 func (m *Meta) VerifyState(name string, data interface{}) error {
 	if index, seen := m.stateIndex[name]; !seen {
@@ -143,6 +363,70 @@ func (m *Meta) VerifyState(name string, data interface{}) error {
 	}
 }
 
+// CanEncodeState 验证名称为name数据为data的状态是否符合元信息m, 并试编码data, 检查data能否编码为JSON,
+// 都通过时返回nil, 否则返回错误信息, 两项检查都不通过时错误信息包含两者的错误原因.
+// VerifyState 只检查元信息中声明的部分, 结构体中未声明的字段为管道或函数等无法编码的类型时也能通过校验,
+// 但是推送时会编码失败, CanEncodeState 可以在推送前一次发现这两类问题. 试编码的结果会被丢弃.
+func (m *Meta) CanEncodeState(name string, data interface{}) error {
+	if _, seen := m.stateIndex[name]; !seen {
+		return fmt.Errorf("NO state %q", name)
+	}
+
+	verifyErr := m.VerifyState(name, data)
+	_, encodeErr := json.Marshal(data)
+	switch {
+	case verifyErr != nil && encodeErr != nil:
+		return fmt.Errorf("%s; encode: %s", verifyErr, encodeErr)
+	case encodeErr != nil:
+		return fmt.Errorf("encode: %s", encodeErr)
+	}
+	return verifyErr
+}
+
+// AccessOf 返回元信息m中名为name的状态的访问权限, 没有声明访问权限的状态为只读 AccessRead,
+// 状态不存在时返回的第二个值为false.
+func (m *Meta) AccessOf(name string) (string, bool) {
+	index, seen := m.stateIndex[name]
+	if !seen {
+		return "", false
+	}
+	if m.State[index].Access == nil {
+		return AccessRead, true
+	}
+	return *m.State[index].Access, true
+}
+
+// VerifyStateWrite 验证元信息m中名为name的状态是否允许对端设置, 允许返回nil, 状态不存在或者状态为只读时返回错误信息.
+// 物模型在处理对端的状态设置请求时, 先通过 VerifyStateWrite 检查访问权限, 再校验设置的状态数据.
+func (m *Meta) VerifyStateWrite(name string) error {
+	access, seen := m.AccessOf(name)
+	if !seen {
+		return fmt.Errorf("NO state %q", name)
+	}
+	if access == AccessRead {
+		return fmt.Errorf("state %q is read-only", name)
+	}
+	return nil
+}
+
+// VerifyStateClear 验证元信息m中名为name的状态是否可以被清除, 可以返回nil, 状态不存在或者没有声明 "optional": true 时返回错误信息.
+// 物模型在推送清除状态报文前通过 VerifyStateClear 检查该状态是否可以被清除.
+func (m *Meta) VerifyStateClear(name string) error {
+	index, seen := m.stateIndex[name]
+	if !seen {
+		return fmt.Errorf("NO state %q", name)
+	}
+	if !isOptional(m.State[index]) {
+		return fmt.Errorf("state %q is NOT optional", name)
+	}
+	return nil
+}
+
+// isOptional 返回参数元信息meta是否声明了 "optional": true
+func isOptional(meta ParamMeta) bool {
+	return meta.Optional != nil && *meta.Optional
+}
+
 // VerifyEvent 验证名为name参数为args的事件是否符合元信息m, 如果符合返回nil, 如果不符合返回错误信息.
 func (m *Meta) VerifyEvent(name string, args message.Args) error {
 	index, seen := m.eventIndex[name]
@@ -177,6 +461,9 @@ func (m *Meta) VerifyEvent(name string, args message.Args) error {
 }
 
 // VerifyMethodArgs 验证名为name参数为args的调用请求是否符合元信息m, 如果符合返回nil, 如果不符合返回错误信息.
+// 声明了 "optional": true 的参数以及有默认值(range.default)的参数可以省略, 省略的参数不做校验, 其余参数缺失时返回错误信息.
+// 省略的默认值参数可以通过 FillMethodArgDefaults 补全.
+// NOTE: 有默认值的参数缺失时不再返回 "arg %q: missing" 错误, 与 VerifyRawMethodArgs 保持一致.
 func (m *Meta) VerifyMethodArgs(name string, args message.Args) error {
 	index, seen := m.methodIndex[name]
 	if !seen {
@@ -189,7 +476,7 @@ func (m *Meta) VerifyMethodArgs(name string, args message.Args) error {
 	}
 
 	// 2.每个参数是否匹配
-	// NOTE: 元信息中每个参数一定要在args中存在，且字段值能匹配
+	// NOTE: 元信息中除了可省略的参数和有默认值的参数, 每个参数一定要在args中存在，且字段值能匹配
 	// NOTE: args中多余的字段不判断, 保持一定的兼容能力
 	for _, argMeta := range m.Method[index].Args {
 		argName := *argMeta.Name
@@ -197,6 +484,9 @@ func (m *Meta) VerifyMethodArgs(name string, args message.Args) error {
 		// a.参数存在性
 		value, seen := args[argName]
 		if !seen {
+			if _, hasDefault := defaultOf(argMeta); isOptional(argMeta) || hasDefault {
+				continue
+			}
 			return fmt.Errorf("arg %q: missing", argName)
 		}
 
@@ -209,6 +499,95 @@ func (m *Meta) VerifyMethodArgs(name string, args message.Args) error {
 	return nil
 }
 
+// FillMethodArgDefaults 将元信息m中名为name的方法的默认值(range.default)填入调用参数args中缺失的参数,
+// 已经存在的参数以及没有默认值的参数保持不变, 方法不存在或者args为nil时返回错误信息.
+// 时长类型参数的默认值填入为 time.Duration. Parse 已经校验过默认值符合范围约束, 因此补全的参数一定能通过 VerifyMethodArgs 的校验,
+// 例如只传入angle调用QS时, 补全后args中包含speed的默认值, 没有默认值又不可省略的参数仍然缺失.
+func (m *Meta) FillMethodArgDefaults(name string, args message.Args) error {
+	index, seen := m.methodIndex[name]
+	if !seen {
+		return fmt.Errorf("NO method %q", name)
+	}
+	if args == nil {
+		return fmt.Errorf("nil method args")
+	}
+
+	for _, argMeta := range m.Method[index].Args {
+		if _, seen := args[*argMeta.Name]; seen {
+			continue
+		}
+		if value, ok := defaultOf(argMeta); ok {
+			args[*argMeta.Name] = value
+		}
+	}
+
+	return nil
+}
+
+// FillRawMethodArgDefaults 与 FillMethodArgDefaults 相同, 将元信息m中名为name的方法的默认值填入原始调用参数args中缺失的参数,
+// 区别是返回补全后的副本, 不修改args, 用于接收端在调用回调前补全对端省略的参数.
+// 默认值按照参数元信息编码, 时长类型的默认值编码为以参数单位为单位的数值, 方法不存在时返回错误信息.
+func (m *Meta) FillRawMethodArgDefaults(name string, args message.RawArgs) (message.RawArgs, error) {
+	index, seen := m.methodIndex[name]
+	if !seen {
+		return nil, fmt.Errorf("NO method %q", name)
+	}
+
+	ans := make(message.RawArgs, len(args))
+	for key, value := range args {
+		ans[key] = value
+	}
+
+	for _, argMeta := range m.Method[index].Args {
+		if _, seen := ans[*argMeta.Name]; seen {
+			continue
+		}
+		value, ok := defaultOf(argMeta)
+		if !ok {
+			continue
+		}
+		value, err := dataInUnit(argMeta, value)
+		if err != nil {
+			return nil, fmt.Errorf("arg %q: %s", *argMeta.Name, err)
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("arg %q: %s", *argMeta.Name, err)
+		}
+		ans[*argMeta.Name] = raw
+	}
+
+	return ans, nil
+}
+
+// defaultOf 返回参数元信息meta中声明的默认值, 没有默认值时返回false
+func defaultOf(meta ParamMeta) (interface{}, bool) {
+	if meta.Range == nil || meta.Range.Default == nil {
+		return nil, false
+	}
+
+	// NOTE: 时长类型的默认值保存为时长字符串, 需要转换成真实数据
+	if meta.Type == "duration" {
+		d, err := rangeDuration(meta.Range.Default)
+		if err != nil {
+			return nil, false
+		}
+		return d, true
+	}
+
+	return meta.Range.Default, true
+}
+
+// VerifyResponseOf 返回元信息m中名为name的方法声明的verifyResponse字段的值,
+// 方法不存在或者没有声明该字段时返回的第二个值为false.
+func (m *Meta) VerifyResponseOf(name string) (bool, bool) {
+	index, seen := m.methodIndex[name]
+	if !seen || m.Method[index].VerifyResponse == nil {
+		return false, false
+	}
+	return *m.Method[index].VerifyResponse, true
+}
+
 // VerifyMethodResp 验证名为name返回值为resp的调用响应是否符合元信息m, 如果符合返回nil, 如果不符合返回错误信息.
 func (m *Meta) VerifyMethodResp(name string, resp message.Resp) error {
 	index, seen := m.methodIndex[name]
@@ -250,6 +629,22 @@ func _verifyData_(meta ParamMeta, data interface{}, checkRange bool) error {
 	if data == nil {
 		return fmt.Errorf("nil")
 	}
+	if err := verifyDataByType(meta, data, checkRange); err != nil {
+		return err
+	}
+	if meta.Format == nil {
+		return nil
+	}
+
+	// 自定义校验函数校验的是数据编码后的JSON, 与对端收到的数据相同
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return verifyFormat(meta, json.Get(raw))
+}
+
+func verifyDataByType(meta ParamMeta, data interface{}, checkRange bool) error {
 	switch meta.Type {
 	case "int":
 		return verifyIntData(meta, data, checkRange)
@@ -271,6 +666,10 @@ func _verifyData_(meta ParamMeta, data interface{}, checkRange bool) error {
 		return verifyStructData(meta, data, checkRange)
 	case "meta":
 		return verifyMetaData(data)
+	case "union":
+		return verifyUnionData(meta, data, checkRange)
+	case "duration":
+		return verifyDurationData(meta, data, checkRange)
 	}
 	return nil
 }
@@ -395,9 +794,14 @@ func verifyArrayData(meta ParamMeta, data interface{}, checkRange bool) error {
 	// 3.数组元素类型也得匹配
 	// NOTE: 必须要先判断数组元素类型是否匹配
 	// NOTE: 另外，在检查数组元素类型时不检查范围，避免因范围不通过而导致的类型错误
-	zeroElem := reflect.New(reflect.TypeOf(data).Elem()).Elem().Interface()
-	if err := _verifyData_(*meta.Element, zeroElem, false); err != nil {
-		return fmt.Errorf("element: %s", err)
+	// NOTE: 联合类型元素的实际类型各不相同, 只能在步骤4中逐个检查
+	// NOTE: 零值元素只用于检查类型, 结构体元素中有范围约束的字段(例如min>0)的零值不符合范围也能通过,
+	// NOTE: 因此空的数组或切片不会校验元素的范围约束, 需要检查元素零值是否符合范围时使用 VerifyRawStateDeep
+	if meta.Element.Type != "union" {
+		zeroElem := reflect.New(reflect.TypeOf(data).Elem()).Elem().Interface()
+		if err := _verifyData_(*meta.Element, zeroElem, false); err != nil {
+			return fmt.Errorf("element: %s", err)
+		}
 	}
 
 	// 4.数组中每个元素是否匹配
@@ -422,9 +826,14 @@ func verifySliceData(meta ParamMeta, data interface{}, checkRange bool) error {
 	// NOTE: 必须要先判断切片元素类型是否匹配！
 	// NOTE: 否则在传入一个空的切片但元素类型不匹配时，会因为进入不了步骤4的判断，而导致校验通过！
 	// NOTE: 另外，在检查切片元素类型时不检查范围，避免因范围不通过而导致的类型错误
-	zeroElem := reflect.New(reflect.TypeOf(data).Elem()).Elem().Interface()
-	if err := _verifyData_(*meta.Element, zeroElem, false); err != nil {
-		return fmt.Errorf("element: %s", err)
+	// NOTE: 联合类型元素的实际类型各不相同, 只能在步骤4中逐个检查
+	// NOTE: 零值元素只用于检查类型, 结构体元素中有范围约束的字段(例如min>0)的零值不符合范围也能通过,
+	// NOTE: 因此空的数组或切片不会校验元素的范围约束, 需要检查元素零值是否符合范围时使用 VerifyRawStateDeep
+	if meta.Element.Type != "union" {
+		zeroElem := reflect.New(reflect.TypeOf(data).Elem()).Elem().Interface()
+		if err := _verifyData_(*meta.Element, zeroElem, false); err != nil {
+			return fmt.Errorf("element: %s", err)
+		}
 	}
 
 	// 3.不能是nil的切片，但可以是长度为0的切片
@@ -460,9 +869,9 @@ func verifyStructData(meta ParamMeta, data interface{}, checkRange bool) error {
 		var fieldType reflect.StructField
 		var found bool = false
 
-		// 查找json标签为fieldName的字段类型
+		// 查找名称为fieldName的字段类型
 		for j := 0; j < Type.NumField(); j++ {
-			if tag, ok := Type.Field(j).Tag.Lookup("json"); ok {
+			if tag, ok := structFieldName(Type.Field(j)); ok {
 				if tag == fieldName {
 					fieldType = Type.Field(j)
 					found = true
@@ -488,6 +897,64 @@ func verifyStructData(meta ParamMeta, data interface{}, checkRange bool) error {
 	return nil
 }
 
+// structFieldName 返回结构体字段field对应的元信息字段名, 优先使用meta标签, 没有meta标签时使用json标签.
+// 例如字段 QsState string `json:"qs_state" meta:"qsState"` 对应元信息中名为qsState的字段.
+// 报文编码时同样以meta标签作为字段名(见 message.FrozeJSON), 保证对端收到的字段名与校验时一致
+func structFieldName(field reflect.StructField) (string, bool) {
+	if tag, ok := field.Tag.Lookup("meta"); ok {
+		return tag, true
+	}
+	return field.Tag.Lookup("json")
+}
+
+func verifyUnionData(meta ParamMeta, data interface{}, checkRange bool) error {
+	// 1.每个变体都是结构体, 因此数据必须是结构体
+	Type := reflect.TypeOf(data)
+	if Type.Kind() != reflect.Struct {
+		return fmt.Errorf("type unmatched")
+	}
+
+	// 2.查找名称为类型标识字段名的字段
+	discriminator := *meta.Discriminator
+	var fieldType reflect.StructField
+	var found bool = false
+	for j := 0; j < Type.NumField(); j++ {
+		if tag, ok := structFieldName(Type.Field(j)); ok {
+			if tag == discriminator {
+				fieldType = Type.Field(j)
+				found = true
+				break
+			}
+		}
+	}
+
+	if found {
+		if fieldType.PkgPath != "" {
+			return fmt.Errorf("discriminator %q: unexported", discriminator)
+		}
+	} else {
+		return fmt.Errorf("discriminator %q: missing", discriminator)
+	}
+
+	// 3.类型标识必须是字符串, 且对应的变体存在
+	variantName, isString := reflect.ValueOf(data).FieldByName(fieldType.Name).Interface().(string)
+	if !isString {
+		return fmt.Errorf("discriminator %q: NOT string", discriminator)
+	}
+
+	variant, seen := meta.Variants[variantName]
+	if !seen {
+		return fmt.Errorf("discriminator %q: unknown variant %q", discriminator, variantName)
+	}
+
+	// 4.数据必须匹配对应的变体
+	if err := _verifyData_(variant, data, checkRange); err != nil {
+		return fmt.Errorf("variant %q: %s", variantName, err)
+	}
+
+	return nil
+}
+
 func verifyMetaData(data interface{}) error {
 	meta, isMeta := data.(Meta)
 	if !isMeta {
@@ -503,12 +970,121 @@ func verifyMetaData(data interface{}) error {
 // VerifyRawState 中的data为尚未解析的JSON原始数据, 而 VerifyState 中的data为真实数据，后续需要序列化.
 // VerifyRawState 一般用于校验从网络上接收的状态报文是否符合元信息,
 // VerifyState 一般用于推送状态前校验待推送的状态是否符合元信息.
+// 数据通过结构校验后, VerifyRawState 还会依次调用通过 WithStateInvariant 为该状态添加的约束函数.
+// 声明了 "optional": true 的状态的原始数据可以为JSON null, 表示清除该状态(见 message.EncodeClearStateMsg),
+// 此时不做结构校验也不调用约束函数, 其他状态的原始数据为null时返回错误.
 func (m *Meta) VerifyRawState(name string, data []byte) error {
-	if index, seen := m.stateIndex[name]; !seen {
+	index, seen := m.stateIndex[name]
+	if !seen {
 		return fmt.Errorf("NO state %q", name)
-	} else {
-		return verifyRawData(m.State[index], data)
 	}
+	if isOptional(m.State[index]) && bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil
+	}
+	if err := verifyRawData(m.State[index], data); err != nil {
+		return err
+	}
+	return m.verifyInvariants(name, data)
+}
+
+// VerifyRawStates 批量校验状态名到状态原始数据的映射items中的所有状态是否符合元信息m, 校验规则与 VerifyRawState 相同,
+// 返回状态名到错误信息的映射, 包含items中的所有状态, 符合元信息的状态对应的错误信息为nil.
+// VerifyRawStates 不会在第一个错误处停止, 一般用于校验批量报文中的多个状态报文.
+func (m *Meta) VerifyRawStates(items map[string][]byte) map[string]error {
+	ans := make(map[string]error, len(items))
+	for name, data := range items {
+		ans[name] = m.VerifyRawState(name, data)
+	}
+	return ans
+}
+
+// VerifyRawStateDeep 与 VerifyRawState 相同, 另外还校验状态元信息中所有数组和切片元素的零值是否符合元素的范围约束和自定义格式.
+// 空的数组或切片没有元素, VerifyRawState 不会校验元素的范围约束,
+// 当元素(或元素中结构体的字段)的零值不符合范围约束时(例如int类型的字段min为1), 对端推送的空切片和非空切片的校验结果可能不一致,
+// VerifyRawStateDeep 可以发现这类元信息, 返回的错误信息指出零值不符合约束的元素.
+// 联合类型和元信息类型的元素没有确定的零值, 不校验其零值.
+func (m *Meta) VerifyRawStateDeep(name string, data []byte) error {
+	if err := m.VerifyRawState(name, data); err != nil {
+		return err
+	}
+	return verifyElemZero(m.State[m.stateIndex[name]])
+}
+
+// verifyElemZero 递归校验参数元信息meta中所有数组和切片元素的零值是否符合元素的元信息
+func verifyElemZero(meta ParamMeta) error {
+	switch meta.Type {
+	case "array", "slice":
+		if zero, ok := zeroValue(*meta.Element); ok {
+			raw, err := json.Marshal(zero)
+			if err != nil {
+				return err
+			}
+			if err := _verifyRawData_(*meta.Element, json.Get(raw)); err != nil {
+				return fmt.Errorf("element zero value: %s", err)
+			}
+		}
+		if err := verifyElemZero(*meta.Element); err != nil {
+			return fmt.Errorf("element: %s", err)
+		}
+	case "struct":
+		for _, field := range meta.Fields {
+			if err := verifyElemZero(field); err != nil {
+				return fmt.Errorf("field %q: %s", *field.Name, err)
+			}
+		}
+	case "union":
+		for _, key := range sortedVariants(meta.Variants) {
+			if err := verifyElemZero(meta.Variants[key]); err != nil {
+				return fmt.Errorf("variant %q: %s", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// zeroValue 返回参数元信息meta对应类型的零值, 联合类型和元信息类型没有确定的零值, 返回false
+func zeroValue(meta ParamMeta) (interface{}, bool) {
+	switch meta.Type {
+	case "int", "uint", "float", "duration":
+		return 0, true
+	case "bool":
+		return false, true
+	case "string":
+		return "", true
+	case "array":
+		elem, ok := zeroValue(*meta.Element)
+		if !ok {
+			return nil, false
+		}
+		ans := make([]interface{}, *meta.Length)
+		for i := range ans {
+			ans[i] = elem
+		}
+		return ans, true
+	case "slice":
+		return []interface{}{}, true
+	case "struct":
+		ans := make(map[string]interface{}, len(meta.Fields))
+		for _, field := range meta.Fields {
+			value, ok := zeroValue(field)
+			if !ok {
+				return nil, false
+			}
+			ans[*field.Name] = value
+		}
+		return ans, true
+	}
+	return nil, false
+}
+
+// sortedVariants 返回联合类型的所有类型标识值, 按照字典序排序
+func sortedVariants(variants map[string]ParamMeta) []string {
+	ans := make([]string, 0, len(variants))
+	for key := range variants {
+		ans = append(ans, key)
+	}
+	sort.Strings(ans)
+	return ans
 }
 
 // VerifyRawEvent 校验名为name事件原始参数为args的事件是否符合元信息m, 如果不符合返回错误原因.
@@ -544,6 +1120,8 @@ func (m *Meta) VerifyRawEvent(name string, args message.RawArgs) error {
 // VerifyRawMethodArgs 校验名为name调用请求原始参数为args的调用请求是否符合元信息m, 如果不符合返回错误原因.
 // VerifyRawMethodArgs 一般用于校验从网络上接收的调用请求报文是否符合元信息,
 // VerifyMethodArgs 一般用于发送调用请求前校验待发送的调用请求是否符合元信息.
+// 与 VerifyMethodArgs 相同, 声明了 "optional": true 的参数以及有默认值(range.default)的参数缺失时不返回错误,
+// 接收端可以通过 FillRawMethodArgDefaults 补全.
 func (m *Meta) VerifyRawMethodArgs(name string, args message.RawArgs) error {
 	// 1.方法存在性
 	index, seen := m.methodIndex[name]
@@ -552,13 +1130,17 @@ func (m *Meta) VerifyRawMethodArgs(name string, args message.RawArgs) error {
 	}
 
 	// 2.每个参数是否匹配
-	// NOTE: 元信息中每个参数一定要在args中存在，且字段值能匹配
+	// NOTE: 元信息中除了可省略的参数和有默认值的参数, 每个参数一定要在args中存在，且字段值能匹配
 	// NOTE: args中多余的字段不判断, 保持一定的兼容能力
 	for _, argMeta := range m.Method[index].Args {
 		// a.参数存在性
 		argName := *argMeta.Name
+		// NOTE: 有默认值的参数可以省略, 接收端通过 FillRawMethodArgDefaults 补全
 		arg, seen := args[argName]
 		if !seen {
+			if _, hasDefault := defaultOf(argMeta); isOptional(argMeta) || hasDefault {
+				continue
+			}
 			return fmt.Errorf("arg %q: missing", argName)
 		}
 
@@ -599,19 +1181,116 @@ func (m *Meta) VerifyRawMethodResp(name string, response message.RawResp) error
 	return nil
 }
 
+// VerifyRawStateFields 只校验名为name的结构体类型状态的原始数据data中名为fields的字段是否符合元信息m,
+// 其他字段不校验, 适用于只关心部分字段的使用者. fields为空时等同于 VerifyRawState.
+// 状态不是结构体类型、字段未在元信息中声明、字段缺失或者字段值不匹配时返回错误信息.
+func (m *Meta) VerifyRawStateFields(name string, data []byte, fields ...string) error {
+	index, seen := m.stateIndex[name]
+	if !seen {
+		return fmt.Errorf("NO state %q", name)
+	}
+
+	stateMeta := m.State[index]
+	if len(fields) == 0 {
+		return verifyRawData(stateMeta, data)
+	}
+
+	if stateMeta.Type != "struct" {
+		return fmt.Errorf("state %q is NOT struct", name)
+	}
+
+	// data必须是有效的JSON数据
+	root, err := parseRawJSON(data)
+	if err != nil {
+		return err
+	}
+	if root.ValueType() != jsoniter.ObjectValue {
+		return fmt.Errorf("NOT struct")
+	}
+
+	for _, fieldName := range fields {
+		var fieldMeta *ParamMeta
+		for i := range stateMeta.Fields {
+			if *stateMeta.Fields[i].Name == fieldName {
+				fieldMeta = &stateMeta.Fields[i]
+				break
+			}
+		}
+		if fieldMeta == nil {
+			return fmt.Errorf("NO field %q", fieldName)
+		}
+
+		field := root.Get(fieldName)
+		if field.LastError() != nil {
+			return fmt.Errorf("field %q: missing", fieldName)
+		}
+
+		if err := _verifyRawData_(*fieldMeta, field); err != nil {
+			return fmt.Errorf("field %q: %s", fieldName, err)
+		}
+	}
+
+	return nil
+}
+
 func verifyRawData(meta ParamMeta, data []byte) error {
 	// data必须是有效的JSON数据
-	var value interface{}
-	if err := json.Unmarshal(data, &value); err != nil {
-		return fmt.Errorf("invalid JSON data")
+	root, err := parseRawJSON(data)
+	if err != nil {
+		return err
 	}
-	it := jsoniter.ParseBytes(json, data)
-	root := it.ReadAny()
 
 	return _verifyRawData_(meta, root)
 }
 
+// parseRawJSON 只遍历一次原始数据data, 在校验data是否为有效JSON数据的同时得到对应的 jsoniter.Any,
+// data不是有效的JSON数据(包括为空或者值之后还有非空白字符)时返回错误.
+func parseRawJSON(data []byte) (jsoniter.Any, error) {
+	root, err := readJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON data")
+	}
+	return root, nil
+}
+
+var (
+	errInvalidJSON  = errors.New("invalid JSON")  // 不是有效的JSON值
+	errTrailingData = errors.New("trailing data") // JSON值之后还有非空白字符
+)
+
+// readJSON 只遍历一次data, 读取data中唯一的JSON值, data不是有效的JSON值时返回 errInvalidJSON,
+// JSON值之后还有非空白字符时返回 errTrailingData.
+// NOTE: 严格模式下 ReadAny 跳过对象和数组时会校验其语法, 因此不需要先用 json.Unmarshal 完整解析一遍
+func readJSON(data []byte) (jsoniter.Any, error) {
+	it := json.BorrowIterator(data)
+	defer json.ReturnIterator(it)
+
+	root := it.ReadAny()
+	if (it.Error != nil && it.Error != io.EOF) || root.ValueType() == jsoniter.InvalidValue {
+		return nil, errInvalidJSON
+	}
+
+	// NOTE: jsoniter 接受顶层带前导零的数字(例如01), 数字数据很短, 直接用标准库再校验一次
+	if root.ValueType() == jsoniter.NumberValue && !stdjson.Valid([]byte(root.ToString())) {
+		return nil, errInvalidJSON
+	}
+
+	// 值之后只能是空白字符
+	if it.WhatIsNext(); it.Error != io.EOF {
+		return nil, errTrailingData
+	}
+
+	return root, nil
+}
+
 func _verifyRawData_(meta ParamMeta, root jsoniter.Any) error {
+	if err := verifyRawDataByType(meta, root); err != nil {
+		return err
+	}
+	return verifyFormat(meta, root)
+}
+
+func verifyRawDataByType(meta ParamMeta, root jsoniter.Any) error {
 	switch meta.Type {
 	case "int":
 		return verifyRawIntData(meta, root)
@@ -631,6 +1310,10 @@ func _verifyRawData_(meta ParamMeta, root jsoniter.Any) error {
 		return verifyRawStructData(meta, root)
 	case "meta":
 		return verifyRawMetaData(root)
+	case "union":
+		return verifyRawUnionData(meta, root)
+	case "duration":
+		return verifyRawDurationData(meta, root)
 	}
 	return nil
 }
@@ -641,9 +1324,10 @@ func verifyRawIntData(meta ParamMeta, root jsoniter.Any) error {
 		return fmt.Errorf("NOT number")
 	}
 
-	// 2.必须能转换成int类型
+	// 2.必须是整数字面量, 并且能转换成int类型
+	// NOTE: ToInt 遇到小数点或者指数时会截断, 例如1e2会被当作1, 必须先检查原始数据
 	value := root.ToInt()
-	if root.LastError() != nil {
+	if !isIntegerToken(root.ToString()) || root.LastError() != nil {
 		return fmt.Errorf("NOT int")
 	}
 
@@ -657,9 +1341,9 @@ func verifyRawUintData(meta ParamMeta, root jsoniter.Any) error {
 		return fmt.Errorf("NOT number")
 	}
 
-	// 2.必须能转换成uint类型
+	// 2.必须是整数字面量, 并且能转换成uint类型
 	value := root.ToUint()
-	if root.LastError() != nil {
+	if !isIntegerToken(root.ToString()) || root.LastError() != nil {
 		return fmt.Errorf("NOT uint")
 	}
 
@@ -679,10 +1363,37 @@ func verifyRawFloatData(meta ParamMeta, root jsoniter.Any) error {
 		return fmt.Errorf("NOT float")
 	}
 
-	// 3.检查范围
+	// 3.要求带小数点或者指数时, 不能是整数字面量
+	if meta.Decimal != nil && *meta.Decimal && isIntegerToken(root.ToString()) {
+		return fmt.Errorf("NOT decimal")
+	}
+
+	// 4.检查范围
 	return verifyRangeForFloat(meta.Range, value)
 }
 
+// isIntegerToken 返回JSON数字的原始文本token是否为整数字面量, 即不包含小数点和指数
+func isIntegerToken(token string) bool {
+	return !strings.ContainsAny(token, ".eE")
+}
+
+// checkDecimal 检查参数元信息obj的decimal字段, decimal字段可选, 若存在必须是bool类型, 并且只能用于浮点数
+func checkDecimal(obj jsoniter.Any, typeStr string) error {
+	decimal := obj.Get("decimal")
+	if decimal.LastError() != nil {
+		return nil
+	}
+
+	if decimal.ValueType() != jsoniter.BoolValue {
+		return fmt.Errorf("decimal is NOT bool")
+	}
+	if typeStr != "float" {
+		return fmt.Errorf("decimal is NOT allowed for %s", typeStr)
+	}
+
+	return nil
+}
+
 func verifyRawBoolData(root jsoniter.Any) error {
 	// 1.必须是bool类型
 	if root.ValueType() != jsoniter.BoolValue {
@@ -731,6 +1442,7 @@ func verifyRawSliceData(meta ParamMeta, root jsoniter.Any) error {
 	}
 
 	// 2.逐个比较每个数值元素
+	// NOTE: 原始数据没有元素类型, 空切片不校验元素的类型和范围约束, 见 VerifyRawStateDeep
 	for i := 0; i < root.Size(); i++ {
 		if err := _verifyRawData_(*meta.Element, root.Get(i)); err != nil {
 			return fmt.Errorf("element[%d]: %s", i, err)
@@ -767,6 +1479,38 @@ func verifyRawStructData(meta ParamMeta, root jsoniter.Any) error {
 	return nil
 }
 
+func verifyRawUnionData(meta ParamMeta, root jsoniter.Any) error {
+	// 1.每个变体都是结构体, 因此必须是object类型
+	if root.ValueType() != jsoniter.ObjectValue {
+		return fmt.Errorf("NOT struct")
+	}
+
+	// 2.类型标识字段必须存在且为字符串
+	discriminator := *meta.Discriminator
+	field := root.Get(discriminator)
+	if field.LastError() != nil {
+		return fmt.Errorf("discriminator %q: missing", discriminator)
+	}
+
+	if field.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("discriminator %q: NOT string", discriminator)
+	}
+
+	// 3.类型标识对应的变体必须存在
+	variantName := field.ToString()
+	variant, seen := meta.Variants[variantName]
+	if !seen {
+		return fmt.Errorf("discriminator %q: unknown variant %q", discriminator, variantName)
+	}
+
+	// 4.数据必须匹配对应的变体
+	if err := _verifyRawData_(variant, root); err != nil {
+		return fmt.Errorf("variant %q: %s", variantName, err)
+	}
+
+	return nil
+}
+
 func verifyRawMetaData(root jsoniter.Any) error {
 	return check(root)
 }
@@ -931,12 +1675,25 @@ func (m *Meta) setTemplate(param TemplateParam) (err error) {
 //
 // 如果解析和设置模板中出错, Parse 返回的元信息为通过调用函数 NewEmptyMeta() 返回的空元信息, '
 // Parse 保证不会返回值为nil的元信息.
+//
+// 元信息根节点可以通过ranges字段声明可复用的范围预设, 参数通过rangeRef字段引用预设代替range字段,
+// 解析时先展开所有引用再检查元信息, 引用不存在的预设时返回错误.
+//
+// 参数元信息的嵌套深度不能超过 MaxParamDepth.
 func Parse(rawData []byte, templateParam TemplateParam) (*Meta, error) {
-	// 1. 解析JSON数据
-	var value interface{}
-	if err := json.Unmarshal(rawData, &value); err != nil {
+	// 1. 解析JSON数据, 根对象之后不能有其他数据
+	if _, err := readJSON(rawData); err == errTrailingData {
+		return NewEmptyMeta(), fmt.Errorf("parse JSON failed: trailing data")
+	} else if err != nil {
 		return NewEmptyMeta(), fmt.Errorf("parse JSON failed")
 	}
+
+	// 展开范围预设引用
+	rawData, err := resolveRangeRefs(rawData)
+	if err != nil {
+		return NewEmptyMeta(), err
+	}
+
 	it := jsoniter.ParseBytes(json, rawData)
 	root := it.ReadAny()
 
@@ -948,6 +1705,7 @@ func Parse(rawData []byte, templateParam TemplateParam) (*Meta, error) {
 	// 3. 解析
 	ans := Meta{
 		Description: strings.TrimSpace(root.Get("description").ToString()),
+		Version:     strings.TrimSpace(root.Get("version").ToString()),
 		State:       make([]ParamMeta, 0, root.Get("state").Size()),
 		Event:       make([]EventMeta, 0, root.Get("event").Size()),
 		Method:      make([]MethodMeta, 0, root.Get("method").Size()),
@@ -956,6 +1714,14 @@ func Parse(rawData []byte, templateParam TemplateParam) (*Meta, error) {
 		methodIndex: make(map[string]int),
 	}
 
+	// 解析附加标签
+	if labels := root.Get("labels"); labels.LastError() == nil && labels.Size() > 0 {
+		ans.LabelMap = make(map[string]string, labels.Size())
+		for _, key := range labels.Keys() {
+			ans.LabelMap[strings.TrimSpace(key)] = labels.Get(key).ToString()
+		}
+	}
+
 	// 4.解析模板参数
 	ans.parseTemplate(root.Get("name").ToString())
 
@@ -973,6 +1739,15 @@ func Parse(rawData []byte, templateParam TemplateParam) (*Meta, error) {
 	// 7.解析状态元信息
 	for i := 0; i < root.Get("state").Size(); i++ {
 		stateMeta := createParamMeta(root.Get("state").Get(i))
+		access := root.Get("state").Get(i).Get("access")
+		if access.LastError() == nil {
+			accessVal := strings.TrimSpace(access.ToString())
+			stateMeta.Access = &accessVal
+		}
+		if optional := root.Get("state").Get(i).Get("optional"); optional.LastError() == nil {
+			optionalVal := optional.ToBool()
+			stateMeta.Optional = &optionalVal
+		}
 		ans.stateIndex[*stateMeta.Name] = i
 		ans.State = append(ans.State, stateMeta)
 	}
@@ -1010,6 +1785,16 @@ func check(root jsoniter.Any) error {
 		return fmt.Errorf("root: name: %s", err)
 	}
 
+	// 检查可选的version字段
+	if err := checkVersion(root); err != nil {
+		return fmt.Errorf("root: version: %s", err)
+	}
+
+	// 检查可选的labels字段
+	if err := checkLabels(root); err != nil {
+		return fmt.Errorf("root: labels: %s", err)
+	}
+
 	// 必须包含state字段
 	state := root.Get("state")
 	if state.LastError() != nil {
@@ -1067,15 +1852,65 @@ func check(root jsoniter.Any) error {
 		}
 	}
 
+	// 检查状态、事件和方法之间的名称是否重复
+	if err := checkCrossNames(state, event, method); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkCrossNames 检查状态state、事件event和方法method之间是否有重复的名称,
+// 代理按照 模型名/名称 转发报文, 不同类别使用相同的名称会产生歧义
+func checkCrossNames(state jsoniter.Any, event jsoniter.Any, method jsoniter.Any) error {
+	categories := []struct {
+		kind  string
+		items jsoniter.Any
+	}{
+		{"state", state},
+		{"event", event},
+		{"method", method},
+	}
+
+	usedBy := make(map[string]string)
+	for _, category := range categories {
+		for i := 0; i < category.items.Size(); i++ {
+			name := strings.TrimSpace(category.items.Get(i).Get("name").ToString())
+			if kind, seen := usedBy[name]; seen {
+				return fmt.Errorf("name %q: used by both %s and %s", name, kind, category.kind)
+			}
+			usedBy[name] = category.kind
+		}
+	}
+
 	return nil
 }
 
 func checkState(state jsoniter.Any, visited map[string]struct{}) error {
 
-	if err := checkParamInfo(state, false); err != nil {
+	if err := checkParamInfo(state, false, 1); err != nil {
 		return err
 	}
 
+	// access字段可选, 若存在必须是有效的访问权限
+	access := state.Get("access")
+	if access.LastError() == nil {
+		if access.ValueType() != jsoniter.StringValue {
+			return fmt.Errorf("access is NOT string")
+		}
+		switch strings.TrimSpace(access.ToString()) {
+		case AccessRead, AccessWrite, AccessReadWrite:
+		default:
+			return fmt.Errorf("invalid access: %q", access.ToString())
+		}
+	}
+
+	// optional字段可选, 若存在必须是bool类型
+	optional := state.Get("optional")
+	if optional.LastError() == nil && optional.ValueType() != jsoniter.BoolValue {
+		return fmt.Errorf("optional is NOT bool")
+	}
+
 	// 确保状态名不重复
 	stateName := state.Get("name").ToString()
 	if _, seen := visited[stateName]; seen {
@@ -1112,7 +1947,7 @@ func checkEvent(event jsoniter.Any, visited map[string]struct{}) error {
 	argsName := make(map[string]struct{})
 	for i := 0; i < args.Size(); i++ {
 		// 检查参数本身
-		if err := checkParamInfo(args.Get(i), false); err != nil {
+		if err := checkParamInfo(args.Get(i), false, 1); err != nil {
 			return fmt.Errorf("args[%d]: %s", i, err)
 		}
 
@@ -1162,10 +1997,16 @@ func checkMethod(method jsoniter.Any, visited map[string]struct{}) error {
 	argsName := make(map[string]struct{})
 	for i := 0; i < args.Size(); i++ {
 		// 检查参数本身
-		if err := checkParamInfo(args.Get(i), false); err != nil {
+		if err := checkParamInfo(args.Get(i), false, 1); err != nil {
 			return fmt.Errorf("args[%d]: %s", i, err)
 		}
 
+		// optional字段可选, 若存在必须是bool类型
+		optional := args.Get(i).Get("optional")
+		if optional.LastError() == nil && optional.ValueType() != jsoniter.BoolValue {
+			return fmt.Errorf("args[%d]: optional is NOT bool", i)
+		}
+
 		// 确保方法参数名称不重复
 		argName := strings.TrimSpace(args.Get(i).Get("name").ToString())
 		if _, seen := argsName[argName]; seen {
@@ -1190,7 +2031,7 @@ func checkMethod(method jsoniter.Any, visited map[string]struct{}) error {
 	respNameSet := make(map[string]struct{})
 	for i := 0; i < response.Size(); i++ {
 		// 检查返回本身
-		if err := checkParamInfo(response.Get(i), false); err != nil {
+		if err := checkParamInfo(response.Get(i), false, 1); err != nil {
 			return fmt.Errorf("response[%d]: %s", i, err)
 		}
 
@@ -1203,6 +2044,12 @@ func checkMethod(method jsoniter.Any, visited map[string]struct{}) error {
 		}
 	}
 
+	// verifyResponse字段可选, 若存在必须是bool类型
+	verifyResponse := method.Get("verifyResponse")
+	if verifyResponse.LastError() == nil && verifyResponse.ValueType() != jsoniter.BoolValue {
+		return fmt.Errorf("verifyResponse is NOT bool")
+	}
+
 	// 确保事件名不能重复
 	methodName := strings.TrimSpace(method.Get("name").ToString())
 	if _, seen := visited[methodName]; seen {
@@ -1250,7 +2097,15 @@ func checkNameDesc(obj jsoniter.Any) error {
 	return nil
 }
 
-func checkParamInfo(obj jsoniter.Any, isElement bool) error {
+// checkParamInfo 检查参数元信息obj, isElement表示obj是否为数组或切片的元素(或联合类型的变体), depth为obj的嵌套深度, 状态和参数的深度为1
+func checkParamInfo(obj jsoniter.Any, isElement bool, depth int) error {
+	// 嵌套深度不能超过上限
+	// NOTE: JSON数据中的元信息是一棵树, 不能引用其他节点, 因此不会出现自引用的类型,
+	// NOTE: 但是嵌套过深的元信息会导致检查和校验数据时递归过深, 必须限制嵌套深度
+	if depth > MaxParamDepth {
+		return fmt.Errorf("nesting depth exceeds %d: recursive type NOT supported", MaxParamDepth)
+	}
+
 	// 元信息必须是对象
 	if obj.ValueType() != jsoniter.ObjectValue {
 		return fmt.Errorf("NOT object")
@@ -1285,6 +2140,16 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 		return fmt.Errorf("invalid type: %q", typeStr)
 	}
 
+	// format字段可选, 若存在只能用于叶子类型
+	if err := checkFormat(obj, typeStr); err != nil {
+		return err
+	}
+
+	// decimal字段可选, 若存在必须是bool类型, 并且只能用于浮点数
+	if err := checkDecimal(obj, typeStr); err != nil {
+		return err
+	}
+
 	// 根据type字段值进一步检查
 	switch typeStr {
 	case "array":
@@ -1317,7 +2182,7 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 		}
 
 		// 检查element
-		if err := checkParamInfo(element, true); err != nil {
+		if err := checkParamInfo(element, true, depth+1); err != nil {
 			return fmt.Errorf("element: %s", err)
 		}
 	case "struct":
@@ -1335,7 +2200,7 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 		fieldSet := make(map[string]struct{})
 		for i := 0; i < fields.Size(); i++ {
 			// 检查字段本身
-			if err := checkParamInfo(fields.Get(i), false); err != nil {
+			if err := checkParamInfo(fields.Get(i), false, depth+1); err != nil {
 				return fmt.Errorf("fields[%d]: %s", i, err)
 			}
 
@@ -1356,9 +2221,13 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 		}
 
 		// 检查element
-		if err := checkParamInfo(element, true); err != nil {
+		if err := checkParamInfo(element, true, depth+1); err != nil {
 			return fmt.Errorf("element: %s", err)
 		}
+	case "union":
+		if err := checkUnion(obj, depth); err != nil {
+			return err
+		}
 	case "int", "uint", "float":
 		unit := obj.Get("unit")
 
@@ -1372,12 +2241,21 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 				return fmt.Errorf("unit is empty")
 			}
 		}
+
+		// 如果声明了量纲, 单位必须属于该量纲
+		if err := checkDimension(obj); err != nil {
+			return err
+		}
+	case "duration":
+		if err := checkDuration(obj); err != nil {
+			return err
+		}
 	}
 
 	// 如果存在range字段，则对range字段值检查
 	rangeObj := obj.Get("range")
 	if rangeObj.LastError() == nil {
-		if err := checkRange(rangeObj, typeStr); err != nil {
+		if err := checkRange(rangeObj, typeStr, durationUnit(obj)); err != nil {
 			return err
 		}
 	}
@@ -1385,7 +2263,71 @@ func checkParamInfo(obj jsoniter.Any, isElement bool) error {
 	return nil
 }
 
-func checkRange(rangeObj jsoniter.Any, typeStr string) error {
+func checkUnion(obj jsoniter.Any, depth int) error {
+	// 联合类型必须有discriminator字段
+	discriminator := obj.Get("discriminator")
+	if discriminator.LastError() != nil {
+		return fmt.Errorf("discriminator NOT exist")
+	}
+
+	// discriminator字段必须是字符串类型
+	if discriminator.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("discriminator is NOT string")
+	}
+
+	// discriminator字段不能为空字符串
+	if strings.TrimSpace(discriminator.ToString()) == "" {
+		return fmt.Errorf("discriminator is empty")
+	}
+
+	// 联合类型必须有variants字段
+	variants := obj.Get("variants")
+	if variants.LastError() != nil {
+		return fmt.Errorf("variants NOT exist")
+	}
+
+	// variants字段必须是对象类型
+	if variants.ValueType() != jsoniter.ObjectValue {
+		return fmt.Errorf("variants is NOT object")
+	}
+
+	// variants必须包含1个以上变体
+	if variants.Size() < 1 {
+		return fmt.Errorf("variants: size less than 1")
+	}
+
+	// 逐个检查每个变体
+	valueSet := make(map[string]struct{})
+	for _, key := range variants.Keys() {
+		// 类型标识值不能为空
+		value := strings.TrimSpace(key)
+		if value == "" {
+			return fmt.Errorf("variants: empty discriminator value")
+		}
+
+		// 类型标识值不能重复
+		if _, seen := valueSet[value]; seen {
+			return fmt.Errorf("variants: repeat discriminator value: %q", value)
+		} else {
+			valueSet[value] = struct{}{}
+		}
+
+		// 检查变体本身
+		variant := variants.Get(key)
+		if err := checkParamInfo(variant, true, depth+1); err != nil {
+			return fmt.Errorf("variants[%q]: %s", key, err)
+		}
+
+		// 变体必须是结构体类型
+		if strings.TrimSpace(variant.Get("type").ToString()) != "struct" {
+			return fmt.Errorf("variants[%q]: NOT struct", key)
+		}
+	}
+
+	return nil
+}
+
+func checkRange(rangeObj jsoniter.Any, typeStr string, unit string) error {
 	if rangeObj.ValueType() != jsoniter.ObjectValue {
 		return fmt.Errorf("range: NOT object")
 	}
@@ -1399,6 +2341,8 @@ func checkRange(rangeObj jsoniter.Any, typeStr string) error {
 		return checkIntRange(rangeObj)
 	case "uint":
 		return checkUintRange(rangeObj)
+	case "duration":
+		return checkDurationRange(rangeObj, unit)
 	default:
 		return fmt.Errorf("range: %q NOT support range", typeStr)
 	}
@@ -1866,6 +2810,54 @@ func checkUintRange(rangeObj jsoniter.Any) error {
 	return nil
 }
 
+// checkVersion 检查元信息根节点root中可选的version字段, 存在时必须是语义化版本号格式的字符串
+func checkVersion(root jsoniter.Any) error {
+	version := root.Get("version")
+	if version.LastError() != nil {
+		return nil
+	}
+
+	if version.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("NOT string")
+	}
+
+	if !semverPattern.MatchString(strings.TrimSpace(version.ToString())) {
+		return fmt.Errorf("%q is NOT semantic version", version.ToString())
+	}
+
+	return nil
+}
+
+// checkLabels 检查元信息根节点root中可选的labels字段, 存在时必须是值为字符串的对象, 并且键不能为空,
+// 去除前后空格后相同的键视为重复
+func checkLabels(root jsoniter.Any) error {
+	labels := root.Get("labels")
+	if labels.LastError() != nil {
+		return nil
+	}
+
+	if labels.ValueType() != jsoniter.ObjectValue {
+		return fmt.Errorf("NOT object")
+	}
+
+	visited := make(map[string]struct{})
+	for _, key := range labels.Keys() {
+		trimmed := strings.TrimSpace(key)
+		if trimmed == "" {
+			return fmt.Errorf("key is empty")
+		}
+		if _, seen := visited[trimmed]; seen {
+			return fmt.Errorf("key %q: duplicated", trimmed)
+		}
+		visited[trimmed] = struct{}{}
+		if labels.Get(key).ValueType() != jsoniter.StringValue {
+			return fmt.Errorf("key %q: value is NOT string", trimmed)
+		}
+	}
+
+	return nil
+}
+
 func checkModelName(name string) error {
 	// 1.先以/分割
 	tokens := strings.Split(name, "/")
@@ -1976,8 +2968,53 @@ func createParamMeta(param jsoniter.Any) ParamMeta {
 		ans.Unit = &unitVal
 	}
 
+	dimension := param.Get("dimension")
+	if dimension.LastError() == nil {
+		dimensionVal := strings.TrimSpace(dimension.ToString())
+		ans.Dimension = &dimensionVal
+	}
+
+	format := param.Get("format")
+	if format.LastError() == nil {
+		formatVal := strings.TrimSpace(format.ToString())
+		ans.Format = &formatVal
+	}
+
+	decimal := param.Get("decimal")
+	if decimal.LastError() == nil {
+		decimalVal := decimal.ToBool()
+		ans.Decimal = &decimalVal
+	}
+
+	discriminator := param.Get("discriminator")
+	if discriminator.LastError() == nil {
+		discriminatorVal := strings.TrimSpace(discriminator.ToString())
+		ans.Discriminator = &discriminatorVal
+	}
+
+	variants := param.Get("variants")
+	if variants.LastError() == nil {
+		ans.Variants = make(map[string]ParamMeta, variants.Size())
+		for _, key := range variants.Keys() {
+			ans.Variants[strings.TrimSpace(key)] = createParamMeta(variants.Get(key))
+		}
+	}
+
 	rangeObj := param.Get("range")
-	if rangeObj.LastError() == nil {
+	if rangeObj.LastError() == nil && ans.Type == "duration" {
+		// 时长类型的范围约束统一保存为时长字符串
+		unit := durationUnit(param)
+		ans.Range = &RangeInfo{}
+		if minCfg := rangeObj.Get("min"); minCfg.LastError() == nil {
+			ans.Range.Min = durationRangeVal(minCfg, unit)
+		}
+		if maxCfg := rangeObj.Get("max"); maxCfg.LastError() == nil {
+			ans.Range.Max = durationRangeVal(maxCfg, unit)
+		}
+		if defaultCfg := rangeObj.Get("default"); defaultCfg.LastError() == nil {
+			ans.Range.Default = durationRangeVal(defaultCfg, unit)
+		}
+	} else if rangeObj.LastError() == nil {
 		ans.Range = &RangeInfo{}
 		minCfg := rangeObj.Get("min")
 		if minCfg.LastError() == nil {
@@ -2028,13 +3065,23 @@ func createMethodMeta(method jsoniter.Any) MethodMeta {
 	}
 
 	for i := 0; i < method.Get("args").Size(); i++ {
-		ans.Args = append(ans.Args, createParamMeta(method.Get("args").Get(i)))
+		argMeta := createParamMeta(method.Get("args").Get(i))
+		if optional := method.Get("args").Get(i).Get("optional"); optional.LastError() == nil {
+			optionalVal := optional.ToBool()
+			argMeta.Optional = &optionalVal
+		}
+		ans.Args = append(ans.Args, argMeta)
 	}
 
 	for i := 0; i < method.Get("response").Size(); i++ {
 		ans.Response = append(ans.Response, createParamMeta(method.Get("response").Get(i)))
 	}
 
+	if verifyResponse := method.Get("verifyResponse"); verifyResponse.LastError() == nil {
+		verify := verifyResponse.ToBool()
+		ans.VerifyResponse = &verify
+	}
+
 	return ans
 }
 
@@ -2053,6 +3100,60 @@ func getVal(Type string, any jsoniter.Any) interface{} {
 	}
 }
 
+// ErrorEventMeta 返回名称为name, 描述为description的标准错误事件的元信息, 与 message.EncodeErrorEventMsg 编码的事件报文匹配.
+// 事件参数包括int类型的错误码 message.ErrorEventCode 和string类型的错误信息 message.ErrorEventMessage,
+// 附加参数不在元信息中声明. 返回的元信息序列化后可以直接作为元信息JSON中event数组的一项.
+func ErrorEventMeta(name string, description string) EventMeta {
+	codeName, codeDesc := message.ErrorEventCode, "错误码"
+	msgName, msgDesc := message.ErrorEventMessage, "错误信息"
+	return EventMeta{
+		Name:        name,
+		Description: description,
+		Args: []ParamMeta{
+			{Name: &codeName, Description: &codeDesc, Type: "int"},
+			{Name: &msgName, Description: &msgDesc, Type: "string"},
+		},
+	}
+}
+
+// TemplateFromEnv 从环境变量中读取元信息rawData中的名称模板参数, 返回读取的模板参数和错误信息.
+// 模板参数name对应的环境变量名为 prefix+strings.ToUpper(name), 例如prefix为"MODEL_"时, 模板参数id对应环境变量MODEL_ID.
+// 模型名称不符合规范或者任意一个模板参数对应的环境变量未设置时返回错误信息.
+func TemplateFromEnv(rawData []byte, prefix string) (TemplateParam, error) {
+	var root struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(rawData, &root); err != nil {
+		return nil, fmt.Errorf("parse JSON failed")
+	}
+
+	// NOTE: parseTemplate 假设名称已经通过检查, 不检查时类似"a/{"的名称会导致panic
+	if err := checkModelName(root.Name); err != nil {
+		return nil, fmt.Errorf("name: %s", err)
+	}
+
+	m := Meta{}
+	m.parseTemplate(root.Name)
+
+	names := make([]string, 0, len(m.nameTemplates))
+	for name := range m.nameTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ans := make(TemplateParam)
+	for _, name := range names {
+		env := prefix + strings.ToUpper(name)
+		val, seen := os.LookupEnv(env)
+		if !seen {
+			return nil, fmt.Errorf("template %q: environment variable %q NOT set", name, env)
+		}
+		ans[name] = val
+	}
+
+	return ans, nil
+}
+
 func trimTemplate(param TemplateParam) TemplateParam {
 	ans := make(map[string]string)
 	for name, val := range param {
@@ -1,12 +1,21 @@
 package meta
 
 import (
+	stdjson "encoding/json"
 	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/object-model/goModel/message"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 const metaJson = `
@@ -55,14 +64,44 @@ func TestParseError(t *testing.T) {
 
 		{
 			`{}{`,
-			"parse JSON failed",
-			"解析JSON串错误3",
+			"parse JSON failed: trailing data",
+			"根对象之后有不完整的对象",
 		},
 
 		{
 			`{}abc`,
-			"parse JSON failed",
-			"解析JSON串错误4",
+			"parse JSON failed: trailing data",
+			"根对象之后有无效的字符",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "state": [], "event": [], "method": []}[]`,
+			"parse JSON failed: trailing data",
+			"根对象之后有数组",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "state": [], "event": [], "method": []} {"name": "test"}`,
+			"parse JSON failed: trailing data",
+			"根对象之后有对象",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "state": [], "event": [], "method": []}` + "\n123",
+			"parse JSON failed: trailing data",
+			"根对象之后有数字",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "state": [], "event": [], "method": []}}`,
+			"parse JSON failed: trailing data",
+			"根对象之后有多余的括号",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "state": [], "event": [], "method": []},`,
+			"parse JSON failed: trailing data",
+			"根对象之后有逗号",
 		},
 
 		{
@@ -929,6 +968,12 @@ func TestParseError(t *testing.T) {
 			"方法名称重复",
 		},
 
+		{
+			`{"name": "test", "description": "测试物模型", "state": [], "event": [], "method": [{"name": "QS", "description": "起竖", "args": [], "response": [], "verifyResponse": 1}]}`,
+			"method[0]: verifyResponse is NOT bool",
+			"方法元信息的verifyResponse字段不是bool类型",
+		},
+
 		{
 			`{"name": " / ", "description": "测试物模型", "state": [], "event": [], "method": []}`,
 			"root: name: empty model name after normalize",
@@ -958,6 +1003,24 @@ func TestParseError(t *testing.T) {
 			"root: name: repeat template: \"a\"",
 			"重复的模板名称",
 		},
+
+		{
+			`{"name": "test", "description": "测试物模型", "state": [{"name": "x", "description": "状态", "type": "bool"}], "event": [], "method": [{"name": " x ", "description": "方法", "args": [], "response": []}]}`,
+			"name \"x\": used by both state and method",
+			"状态和方法名称重复",
+		},
+
+		{
+			`{"name": "test", "description": "测试物模型", "state": [{"name": "x", "description": "状态", "type": "bool"}], "event": [{"name": "x", "description": "事件", "args": []}], "method": []}`,
+			"name \"x\": used by both state and event",
+			"状态和事件名称重复",
+		},
+
+		{
+			`{"name": "test", "description": "测试物模型", "state": [], "event": [{"name": "x", "description": "事件", "args": []}], "method": [{"name": "x", "description": "方法", "args": [], "response": []}]}`,
+			"name \"x\": used by both event and method",
+			"事件和方法名称重复",
+		},
 	}
 
 	for _, test := range testCases {
@@ -2164,13 +2227,6 @@ func TestMeta_VerifyMethodArgsError(t *testing.T) {
 			desc:   "nil类型的方法参数",
 		},
 
-		{
-			name:   "QS",
-			args:   message.Args{},
-			errStr: "arg \"angle\": missing",
-			desc:   "方法参数中缺失字段",
-		},
-
 		{
 			name: "QS",
 			args: message.Args{
@@ -2321,6 +2377,20 @@ func TestMeta_VerifyMethodArgsOK(t *testing.T) {
 			},
 			desc: "正常方法参数8",
 		},
+
+		{
+			name: "QS",
+			args: message.Args{},
+			desc: "方法参数缺失--有默认值的参数可以省略",
+		},
+
+		{
+			name: "QS",
+			args: message.Args{
+				"speed": "slow",
+			},
+			desc: "方法参数缺失--有默认值的参数可以省略",
+		},
 	}
 
 	for _, test := range testCases {
@@ -2883,15 +2953,17 @@ func TestMeta_VerifyRawMethodArgs(t *testing.T) {
 		{
 			name: "QS",
 			args: message.RawArgs{},
-			err:  errors.New("arg \"angle\": missing"),
-			desc: "方法参数缺失",
+			err:  nil,
+			desc: "方法参数缺失--有默认值的参数可以省略",
 		},
 
 		{
 			name: "QS",
-			args: message.RawArgs{},
-			err:  errors.New("arg \"angle\": missing"),
-			desc: "方法参数缺失",
+			args: message.RawArgs{
+				"speed": jsoniter.RawMessage(`"slow"`),
+			},
+			err:  nil,
+			desc: "方法参数缺失--有默认值的参数可以省略",
 		},
 
 		{
@@ -3072,3 +3144,2056 @@ func TestMeta_VerifyMethodResp(t *testing.T) {
 		assert.EqualValues(t, test.err, err, test.desc)
 	}
 }
+
+const unionMetaJson = `
+{
+	"name": "queue",
+	"description": "指令队列",
+	"state": [
+		{
+			"name": "cmds",
+			"description": "指令列表",
+			"type": "slice",
+			"element": {
+				"type": "union",
+				"discriminator": "type",
+				"variants": {
+					"move": {
+						"type": "struct",
+						"fields": [
+							{"name": "type", "description": "指令类型", "type": "string"},
+							{"name": "angle", "description": "角度", "type": "float", "range": {"min": 0, "max": 90}}
+						]
+					},
+					"wait": {
+						"type": "struct",
+						"fields": [
+							{"name": "type", "description": "指令类型", "type": "string"},
+							{"name": "ms", "description": "等待时间", "type": "uint"}
+						]
+					}
+				}
+			}
+		}
+	],
+	"event": [],
+	"method": []
+}
+`
+
+func TestParseUnionError(t *testing.T) {
+	wrap := func(param string) string {
+		return `{"name": "queue", "description": "指令队列", "event": [], "method": [], "state": [` + param + `]}`
+	}
+
+	testCases := []struct {
+		data    string
+		wantErr string
+		desc    string
+	}{
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "variants": {}}`),
+			"state[0]: discriminator NOT exist",
+			"discriminator字段不存在",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": 1}`),
+			"state[0]: discriminator is NOT string",
+			"discriminator字段不是字符串",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "  "}`),
+			"state[0]: discriminator is empty",
+			"discriminator字段为空",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type"}`),
+			"state[0]: variants NOT exist",
+			"variants字段不存在",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type", "variants": []}`),
+			"state[0]: variants is NOT object",
+			"variants字段不是对象",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type", "variants": {}}`),
+			"state[0]: variants: size less than 1",
+			"variants为空",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type",
+				"variants": {" ": {"type": "struct", "fields": []}}}`),
+			"state[0]: variants: empty discriminator value",
+			"类型标识值为空",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type",
+				"variants": {"a": {"type": "struct", "fields": []}, " a ": {"type": "struct", "fields": []}}}`),
+			"state[0]: variants: repeat discriminator value: \"a\"",
+			"类型标识值重复",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type",
+				"variants": {"a": {"type": "struct"}}}`),
+			"state[0]: variants[\"a\"]: fields NOT exist",
+			"变体元信息错误",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type",
+				"variants": {"a": {"type": "int"}}}`),
+			"state[0]: variants[\"a\"]: NOT struct",
+			"变体不是结构体",
+		},
+
+		{
+			wrap(`{"name": "cmd", "description": "指令", "type": "union", "discriminator": "type",
+				"variants": {"a": {"type": "struct", "fields": []}}, "range": {}}`),
+			"state[0]: range: \"union\" NOT support range",
+			"联合类型不支持范围",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		assert.NotNil(t, err, test.desc)
+		if err != nil {
+			assert.Equal(t, test.wantErr, err.Error(), test.desc)
+		}
+	}
+}
+
+type moveCmd struct {
+	Type  string  `json:"type"`
+	Angle float64 `json:"angle"`
+}
+
+type waitCmd struct {
+	Type string `json:"type"`
+	Ms   uint   `json:"ms"`
+}
+
+type badCmd struct {
+	Type int `json:"type"`
+}
+
+func TestMeta_VerifyUnion(t *testing.T) {
+	m, err := Parse([]byte(unionMetaJson), nil)
+	assert.Nil(t, err)
+
+	// 序列化后的元信息可以重新解析
+	_, err = Parse(m.ToJSON(), nil)
+	assert.Nil(t, err, "序列化后重新解析")
+
+	testCases := []struct {
+		data    interface{}
+		wantErr error
+		desc    string
+	}{
+		{
+			data:    []interface{}{moveCmd{Type: "move", Angle: 10}, waitCmd{Type: "wait", Ms: 100}},
+			wantErr: nil,
+			desc:    "不同类型的元素",
+		},
+
+		{
+			data:    []moveCmd{},
+			wantErr: nil,
+			desc:    "空切片",
+		},
+
+		{
+			data:    []interface{}{moveCmd{Type: "move", Angle: 100}},
+			wantErr: errors.New("element[0]: variant \"move\": field \"angle\": greater than max"),
+			desc:    "变体不匹配",
+		},
+
+		{
+			data:    []interface{}{waitCmd{Type: "move"}},
+			wantErr: errors.New("element[0]: variant \"move\": field \"angle\": missing"),
+			desc:    "类型标识与结构体不一致",
+		},
+
+		{
+			data:    []interface{}{moveCmd{Type: "stop"}},
+			wantErr: errors.New("element[0]: discriminator \"type\": unknown variant \"stop\""),
+			desc:    "未知的变体",
+		},
+
+		{
+			data:    []interface{}{badCmd{Type: 1}},
+			wantErr: errors.New("element[0]: discriminator \"type\": NOT string"),
+			desc:    "类型标识不是字符串",
+		},
+
+		{
+			data:    []interface{}{struct{}{}},
+			wantErr: errors.New("element[0]: discriminator \"type\": missing"),
+			desc:    "类型标识缺失",
+		},
+
+		{
+			data:    []int{1},
+			wantErr: errors.New("element[0]: type unmatched"),
+			desc:    "元素不是结构体",
+		},
+
+		{
+			data:    []interface{}{nil},
+			wantErr: errors.New("element[0]: nil"),
+			desc:    "元素为nil",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.wantErr, m.VerifyState("cmds", test.data), test.desc)
+	}
+
+	rawCases := []struct {
+		data    string
+		wantErr error
+		desc    string
+	}{
+		{
+			data:    `[{"type":"move","angle":10},{"type":"wait","ms":100}]`,
+			wantErr: nil,
+			desc:    "不同类型的元素",
+		},
+
+		{
+			data:    `[{"type":"wait","ms":-1}]`,
+			wantErr: errors.New("element[0]: variant \"wait\": field \"ms\": NOT uint"),
+			desc:    "变体不匹配",
+		},
+
+		{
+			data:    `[{"type":"stop"}]`,
+			wantErr: errors.New("element[0]: discriminator \"type\": unknown variant \"stop\""),
+			desc:    "未知的变体",
+		},
+
+		{
+			data:    `[{"type":1}]`,
+			wantErr: errors.New("element[0]: discriminator \"type\": NOT string"),
+			desc:    "类型标识不是字符串",
+		},
+
+		{
+			data:    `[{"angle":1}]`,
+			wantErr: errors.New("element[0]: discriminator \"type\": missing"),
+			desc:    "类型标识缺失",
+		},
+
+		{
+			data:    `[1]`,
+			wantErr: errors.New("element[0]: NOT struct"),
+			desc:    "元素不是对象",
+		},
+	}
+
+	for _, test := range rawCases {
+		assert.Equal(t, test.wantErr, m.VerifyRawState("cmds", []byte(test.data)), test.desc)
+	}
+}
+
+func TestParseDimension(t *testing.T) {
+	wrap := func(param string) string {
+		return `{"name": "car", "description": "车辆", "event": [], "method": [], "state": [` + param + `]}`
+	}
+
+	testCases := []struct {
+		data    string
+		wantErr error
+		desc    string
+	}{
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "°", "dimension": 1}`),
+			errors.New("state[0]: dimension is NOT string"),
+			"dimension字段不是字符串",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "°", "dimension": " "}`),
+			errors.New("state[0]: dimension is empty"),
+			"dimension字段为空",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "dimension": "angle"}`),
+			errors.New("state[0]: unit NOT exist for dimension \"angle\""),
+			"声明了量纲但没有单位",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "℃", "dimension": "angle"}`),
+			errors.New("state[0]: unit \"℃\" NOT belong to dimension \"angle\""),
+			"单位不属于量纲",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "xyz", "dimension": "angle"}`),
+			errors.New("state[0]: unit \"xyz\" NOT belong to dimension \"angle\""),
+			"未注册的单位",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "°", "dimension": "angle"}`),
+			nil,
+			"单位属于量纲",
+		},
+
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "unit": "°"}`),
+			nil,
+			"未声明量纲时不检查单位",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+}
+
+func TestMeta_UnitOf(t *testing.T) {
+	data, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(data, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	assert.Nil(t, err)
+
+	_, seen := m.UnitOf("unknown")
+	assert.False(t, seen, "状态不存在")
+
+	_, seen = m.UnitOf("gear")
+	assert.False(t, seen, "状态没有单位")
+
+	m, err = Parse([]byte(`{"name": "car", "description": "车辆", "event": [], "method": [], "state": [
+		{"name": "angle", "description": "角度", "type": "float", "unit": "°", "dimension": "angle"}]}`), nil)
+	assert.Nil(t, err)
+
+	unit, seen := m.UnitOf("angle")
+	assert.True(t, seen, "状态有单位")
+	assert.Equal(t, "°", unit, "状态有单位")
+	assert.Equal(t, "angle", *m.State[0].Dimension, "状态的量纲")
+}
+
+func TestMeta_ByPrefix(t *testing.T) {
+	data, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(data, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"A/car/#1/tpqs/tpqsInfo"}, m.StatesByPrefix("tpqs"), "状态前缀")
+	assert.Equal(t, m.AllStates(), m.StatesByPrefix(""), "空前缀返回所有状态")
+	assert.Equal(t, []string{}, m.StatesByPrefix("A/car"), "前缀只匹配名称")
+
+	assert.Equal(t, []string{
+		"A/car/#1/tpqs/qsMotorOverCur",
+		"A/car/#1/tpqs/qsAction",
+	}, m.EventsByPrefix("qs"), "事件前缀, 按照声明顺序")
+	assert.Equal(t, []string{}, m.EventsByPrefix("QS"), "区分大小写")
+
+	assert.Equal(t, []string{"A/car/#1/tpqs/QS"}, m.MethodsByPrefix("Q"), "方法前缀")
+	assert.Equal(t, []string{}, m.MethodsByPrefix("qs"), "区分大小写")
+}
+
+func TestConvertUnit(t *testing.T) {
+	testCases := []struct {
+		value   float64
+		from    string
+		to      string
+		want    float64
+		wantErr error
+		desc    string
+	}{
+		{1, "grad", "rad", 0, errors.New("unknown unit \"grad\""), "未注册的单位1"},
+		{1, "rad", "grad", 0, errors.New("unknown unit \"grad\""), "未注册的单位2"},
+		{1, "rad", "℃", 0, errors.New("unit \"rad\" and \"℃\": dimension unmatched"), "量纲不同"},
+		{180, "°", "rad", math.Pi, nil, "角度转弧度"},
+		{100, "℃", "℉", 212, nil, "摄氏度转华氏度"},
+		{0, "℃", "K", 273.15, nil, "摄氏度转开尔文"},
+		{1500, "ms", "s", 1.5, nil, "毫秒转秒"},
+		{60, "rpm", "rad/s", 2 * math.Pi, nil, "转每分转弧度每秒"},
+	}
+
+	for _, test := range testCases {
+		got, err := ConvertUnit(test.value, test.from, test.to)
+		assert.Equal(t, test.wantErr, err, test.desc)
+		assert.InDelta(t, test.want, got, 1e-9, test.desc)
+	}
+}
+
+func TestRegisterUnit(t *testing.T) {
+	assert.Equal(t, errors.New("unit is empty"), RegisterUnit(" ", "angle", 1, 0), "单位为空")
+	assert.Equal(t, errors.New("dimension is empty"), RegisterUnit("grad", "", 1, 0), "量纲为空")
+	assert.Equal(t, errors.New("invalid scale"), RegisterUnit("grad", "angle", 0, 0), "比例为0")
+	assert.Equal(t, errors.New("invalid offset"), RegisterUnit("grad", "angle", 1, math.NaN()), "偏移无效")
+	assert.Equal(t, errors.New("unit \"rad\" already registered"), RegisterUnit("rad", "angle", 1, 0), "单位已注册")
+
+	assert.Nil(t, RegisterUnit("grad", "angle", math.Pi/200, 0), "注册成功")
+	dimension, seen := DimensionOf("grad")
+	assert.True(t, seen, "注册成功")
+	assert.Equal(t, "angle", dimension, "注册成功")
+
+	got, err := ConvertUnit(100, "grad", "°")
+	assert.Nil(t, err, "使用注册的单位换算")
+	assert.InDelta(t, 90, got, 1e-9, "使用注册的单位换算")
+}
+
+func TestRegisterValidator(t *testing.T) {
+	assert.Equal(t, errors.New("format is empty"), RegisterValidator(" ", func(ParamMeta, jsoniter.Any) error {
+		return nil
+	}), "格式为空")
+	assert.Equal(t, errors.New("nil validator"), RegisterValidator("uuid", nil), "校验函数为空")
+
+	require.Nil(t, RegisterValidator("uuid", func(_ ParamMeta, raw jsoniter.Any) error {
+		_, err := uuid.Parse(raw.ToString())
+		return err
+	}), "注册成功")
+	assert.Equal(t, errors.New("format \"uuid\" already registered"), RegisterValidator("uuid", func(ParamMeta, jsoniter.Any) error {
+		return nil
+	}), "格式已注册")
+
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "event": [], "method": [], "state": [
+		{"name": "id", "description": "编号", "type": "string", "format": "uuid"},
+		{"name": "tag", "description": "标签", "type": "string", "format": "unknown"}
+	]}`), nil)
+	require.Nil(t, err)
+	require.NotNil(t, m.State[0].Format)
+	assert.Equal(t, "uuid", *m.State[0].Format)
+
+	id := uuid.NewString()
+	assert.Nil(t, m.VerifyState("id", id), "有效的UUID")
+	assert.Nil(t, m.VerifyRawState("id", []byte(`"`+id+`"`)), "有效的UUID")
+	assert.Equal(t, errors.New("invalid uuid: invalid UUID length: 3"), m.VerifyState("id", "abc"), "无效的UUID")
+	assert.Equal(t, errors.New("invalid uuid: invalid UUID length: 3"), m.VerifyRawState("id", []byte(`"abc"`)), "无效的UUID")
+	assert.Equal(t, errors.New("NOT string"), m.VerifyRawState("id", []byte(`1`)), "先进行内置校验")
+	assert.Nil(t, m.VerifyState("tag", "abc"), "未注册的格式不做自定义校验")
+
+	wrap := func(param string) string {
+		return `{"name": "car", "description": "车辆", "event": [], "method": [], "state": [` + param + `]}`
+	}
+	testCases := []struct {
+		data    string
+		wantErr error
+		desc    string
+	}{
+		{
+			wrap(`{"name": "id", "description": "编号", "type": "string", "format": 1}`),
+			errors.New("state[0]: format is NOT string"),
+			"format字段不是字符串",
+		},
+		{
+			wrap(`{"name": "id", "description": "编号", "type": "string", "format": " "}`),
+			errors.New("state[0]: format is empty"),
+			"format字段为空",
+		},
+		{
+			wrap(`{"name": "ids", "description": "编号", "type": "slice", "format": "uuid", "element": {"type": "string"}}`),
+			errors.New("state[0]: format is NOT allowed for slice"),
+			"format字段只能用于叶子类型",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	wrap := func(param string) string {
+		return `{"name": "car", "description": "车辆", "event": [], "method": [], "state": [` + param + `]}`
+	}
+
+	testCases := []struct {
+		data    string
+		wantErr error
+		desc    string
+	}{
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "unit": 1}`),
+			errors.New("state[0]: unit is NOT string"),
+			"unit字段不是字符串",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "unit": "m"}`),
+			errors.New("state[0]: unit \"m\" is NOT time unit"),
+			"unit不是时间单位",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "range": {}}`),
+			errors.New("state[0]: range: NO min or max for duration range"),
+			"range没有min和max",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "range": {"min": "1x"}}`),
+			errors.New("state[0]: range: min: invalid duration \"1x\""),
+			"min不是有效的时长字符串",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "range": {"max": true}}`),
+			errors.New("state[0]: range: max: NOT duration"),
+			"max不是数值或字符串",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "unit": "ms", "range": {"min": "2s", "max": 1500}}`),
+			errors.New("state[0]: range: min is NOT less than max"),
+			"min大于max",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "range": {"min": "1s", "default": "500ms"}}`),
+			errors.New("state[0]: range: default: less than min"),
+			"default小于min",
+		},
+
+		{
+			wrap(`{"name": "timeout", "description": "超时", "type": "duration", "unit": "ms", "range": {"min": "500ms", "max": 2000, "default": "1.5s"}}`),
+			nil,
+			"有效的时长范围",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+}
+
+func TestMeta_VerifyDuration(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "event": [], "method": [], "state": [
+		{"name": "timeout", "description": "超时", "type": "duration", "unit": "ms", "range": {"min": "500ms", "max": 2000}},
+		{"name": "uptime", "description": "运行时长", "type": "duration"}]}`), nil)
+	require.Nil(t, err)
+
+	assert.Equal(t, &RangeInfo{Min: "500ms", Max: "2s"}, m.State[0].Range, "范围约束保存为时长字符串")
+
+	testCases := []struct {
+		name    string
+		data    interface{}
+		raw     string
+		wantErr error
+		desc    string
+	}{
+		{"timeout", time.Millisecond * 500, `"500ms"`, nil, "时长字符串"},
+		{"timeout", time.Second * 2, `2000`, nil, "以unit为单位的数值"},
+		{"timeout", time.Millisecond * 499, `499`, errors.New("less than min"), "小于最小值"},
+		{"timeout", time.Millisecond * 2001, `"2.001s"`, errors.New("greater than max"), "大于最大值"},
+		{"uptime", time.Duration(1500), `1500`, nil, "没有单位时为纳秒"},
+		{"uptime", time.Nanosecond, `"1ns"`, nil, "纳秒时长字符串"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.wantErr, m.VerifyState(test.name, test.data), test.desc)
+		assert.Equal(t, test.wantErr, m.VerifyRawState(test.name, []byte(test.raw)), test.desc)
+	}
+
+	assert.Equal(t, errors.New("type unmatched"), m.VerifyState("uptime", int64(1)), "必须是time.Duration")
+	assert.Equal(t, errors.New("NOT duration"), m.VerifyRawState("uptime", []byte(`true`)), "不是数值或字符串")
+	assert.Equal(t, errors.New("invalid duration \"1x\""), m.VerifyRawState("uptime", []byte(`"1x"`)), "无效的时长字符串")
+
+	// 直接构造的范围约束
+	m.State[1].Range = &RangeInfo{Min: time.Second, Max: "2s"}
+	assert.Nil(t, m.VerifyState("uptime", time.Second), "最小值为time.Duration")
+	assert.Equal(t, errors.New("less than min"), m.VerifyRawState("uptime", []byte(`"999ms"`)), "最小值为time.Duration")
+	m.State[1].Range = &RangeInfo{Min: 5}
+	assert.NotPanics(t, func() {
+		assert.Equal(t, errors.New("range: min: 5 is NOT duration"), m.VerifyState("uptime", time.Second), "最小值不是时长")
+	})
+	m.State[1].Range = &RangeInfo{Max: "2x"}
+	assert.NotPanics(t, func() {
+		assert.Equal(t, errors.New("range: max: invalid duration \"2x\""), m.VerifyRawState("uptime", []byte(`1`)), "最大值不是有效的时长字符串")
+	})
+}
+
+// TestMeta_StateInUnit 测试时长类型按照元信息中声明的单位编码
+func TestMeta_StateInUnit(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "state": [
+		{"name": "timeout", "description": "超时", "type": "duration", "unit": "ms"},
+		{"name": "uptime", "description": "运行时长", "type": "duration"},
+		{"name": "task", "description": "任务", "type": "struct", "fields": [
+			{"name": "id", "description": "编号", "type": "uint"},
+			{"name": "steps", "description": "步骤耗时", "type": "slice", "element": {"type": "duration", "unit": "s"}}]}],
+		"event": [{"name": "done", "description": "完成", "args": [
+			{"name": "cost", "description": "耗时", "type": "duration", "unit": "ms"},
+			{"name": "code", "description": "结果", "type": "int"}]}],
+		"method": [{"name": "wait", "description": "等待", "args": [], "response": [
+			{"name": "cost", "description": "耗时", "type": "duration", "unit": "s"}]}]}`), nil)
+	require.Nil(t, err)
+
+	type Task struct {
+		ID    uint            `json:"id"`
+		Steps []time.Duration `json:"steps"`
+	}
+
+	encode := func(data interface{}, err error) string {
+		require.Nil(t, err)
+		return string(message.Must(message.EncodeStateMsg("car/x", data)))
+	}
+
+	assert.Equal(t, `{"type":"state","payload":{"name":"car/x","data":1500}}`,
+		encode(m.StateInUnit("timeout", 1500*time.Millisecond)), "转换为毫秒")
+	assert.Equal(t, `{"type":"state","payload":{"name":"car/x","data":"1.5s"}}`,
+		encode(m.StateInUnit("timeout", "1.5s")), "时长字符串保持不变")
+	assert.Equal(t, `{"type":"state","payload":{"name":"car/x","data":1500}}`,
+		encode(m.StateInUnit("uptime", time.Duration(1500))), "纳秒不转换")
+	assert.Equal(t, `{"type":"state","payload":{"name":"car/x","data":{"id":1,"steps":[0.5,2]}}}`,
+		encode(m.StateInUnit("task", Task{ID: 1, Steps: []time.Duration{500 * time.Millisecond, 2 * time.Second}})), "结构体中的切片")
+	assert.Nil(t, m.VerifyRawState("timeout", []byte(`1500`)))
+
+	data, err := m.StateInUnit("task", Task{ID: 1, Steps: []time.Duration{time.Second}})
+	require.Nil(t, err)
+	raw, err := json.Marshal(data)
+	require.Nil(t, err)
+	assert.Nil(t, m.VerifyRawState("task", raw), "对端按照元信息校验通过")
+
+	_, err = m.StateInUnit("timeout", func() {})
+	assert.NotNil(t, err, "无法编码")
+
+	args := message.Args{"cost": 20 * time.Millisecond, "code": 1}
+	converted, err := m.EventArgsInUnit("done", args)
+	require.Nil(t, err)
+	assert.Equal(t, `{"code":1,"cost":20}`, string(message.Must(json.Marshal(converted))), "事件参数")
+	assert.Equal(t, 20*time.Millisecond, args["cost"], "不修改原参数")
+
+	resp, err := m.MethodRespInUnit("wait", message.Resp{"cost": 1500 * time.Millisecond})
+	require.Nil(t, err)
+	assert.Equal(t, `{"cost":1.5}`, string(message.Must(json.Marshal(resp))), "方法返回值")
+}
+
+func TestMeta_Equal(t *testing.T) {
+	data, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(data, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	// 序列化后再解析的结果相同
+	same, err := ParseAndCompare(m.ToJSON(), nil, m)
+	assert.Nil(t, err)
+	assert.True(t, same, "序列化后再解析")
+	assert.True(t, m.Equal(m), "与自身相同")
+
+	other, err := Parse(data, TemplateParam{
+		"group": "A",
+		"id":    "#2",
+	})
+	require.Nil(t, err)
+	assert.False(t, m.Equal(other), "名称不同")
+
+	same, err = ParseAndCompare([]byte(`{}`), nil, m)
+	assert.NotNil(t, err, "解析失败")
+	assert.False(t, same, "解析失败")
+
+	// 修改任意公开结构后不相同
+	modify := []struct {
+		change func(m *Meta)
+		desc   string
+	}{
+		{func(m *Meta) { m.Description = "desc" }, "描述不同"},
+		{func(m *Meta) { m.Version = "1.0.0" }, "版本号不同"},
+		{func(m *Meta) { m.LabelMap = map[string]string{"serial": "SN-0001"} }, "标签不同"},
+		{func(m *Meta) { m.State = m.State[1:] }, "状态数量不同"},
+		{func(m *Meta) { unit := "mm"; m.State[0].Unit = &unit }, "状态单位不同"},
+		{func(m *Meta) { m.Event[0].Description = "desc" }, "事件描述不同"},
+		{func(m *Meta) { m.Event = nil }, "事件数量不同"},
+		{func(m *Meta) { m.Method[0].Args[0].Range.Max = 90.0 }, "方法参数范围不同"},
+		{func(m *Meta) { m.Method[0].Response = m.Method[0].Response[1:] }, "方法返回值不同"},
+	}
+
+	for _, test := range modify {
+		changed, err := Parse(m.ToJSON(), nil)
+		require.Nil(t, err)
+		require.True(t, m.Equal(changed), test.desc)
+		test.change(changed)
+		assert.False(t, m.Equal(changed), test.desc)
+		assert.False(t, changed.Equal(m), test.desc)
+	}
+
+	var nilMeta *Meta
+	assert.True(t, nilMeta.Equal(nil), "都为nil")
+	assert.False(t, nilMeta.Equal(m), "一个为nil")
+	assert.False(t, m.Equal(nil), "一个为nil")
+}
+
+func TestParseVersion(t *testing.T) {
+	const tmpl = `{"name": "car", "description": "小车", %s"state": [], "event": [], "method": []}`
+
+	m, err := Parse([]byte(fmt.Sprintf(tmpl, "")), nil)
+	require.Nil(t, err)
+	assert.Equal(t, "", m.Version, "没有版本号")
+	assert.NotContains(t, string(m.ToJSON()), "version", "没有版本号时不序列化")
+
+	for _, version := range []string{"1.0.0", "0.0.5", "2.1.0-rc.1", "1.0.0+build.5", "1.0.0-alpha.beta+exp.sha.5114f85"} {
+		m, err := Parse([]byte(fmt.Sprintf(tmpl, `"version": " `+version+` ", `)), nil)
+		require.Nil(t, err, version)
+		assert.Equal(t, version, m.Version, version)
+
+		// 序列化后再解析的结果相同
+		same, err := ParseAndCompare(m.ToJSON(), nil, m)
+		assert.Nil(t, err, version)
+		assert.True(t, same, version)
+	}
+
+	testCases := []struct {
+		version string // version字段
+		wantErr string // 期望的错误信息
+	}{
+		{`1`, "root: version: NOT string"},
+		{`""`, `root: version: "" is NOT semantic version`},
+		{`"1.0"`, `root: version: "1.0" is NOT semantic version`},
+		{`"v1.0.0"`, `root: version: "v1.0.0" is NOT semantic version`},
+		{`"01.0.0"`, `root: version: "01.0.0" is NOT semantic version`},
+		{`"1.0.0-"`, `root: version: "1.0.0-" is NOT semantic version`},
+		{`"1.0.0-01"`, `root: version: "1.0.0-01" is NOT semantic version`},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(fmt.Sprintf(tmpl, `"version": `+test.version+`, `)), nil)
+		assert.EqualError(t, err, test.wantErr, test.version)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	const tmpl = `{"name": "car", "description": "小车", %s"state": [], "event": [], "method": []}`
+
+	m, err := Parse([]byte(fmt.Sprintf(tmpl, "")), nil)
+	require.Nil(t, err)
+	assert.Nil(t, m.Labels(), "没有标签")
+	assert.NotContains(t, string(m.ToJSON()), "labels", "没有标签时不序列化")
+
+	m, err = Parse([]byte(fmt.Sprintf(tmpl, `"labels": {" manufacturer ": "ACME", "serial": "SN-0001", "hardware": ""}, `)), nil)
+	require.Nil(t, err)
+	want := map[string]string{"manufacturer": "ACME", "serial": "SN-0001", "hardware": ""}
+	assert.Equal(t, want, m.Labels())
+	assert.Contains(t, string(m.ToJSON()), `"labels":{"hardware":"","manufacturer":"ACME","serial":"SN-0001"}`)
+
+	// 修改返回的标签不影响元信息
+	m.Labels()["serial"] = "SN-0002"
+	assert.Equal(t, want, m.Labels())
+
+	// 序列化后再解析的结果相同
+	same, err := ParseAndCompare(m.ToJSON(), nil, m)
+	assert.Nil(t, err)
+	assert.True(t, same)
+
+	testCases := []struct {
+		labels  string // labels字段
+		wantErr string // 期望的错误信息
+	}{
+		{`[]`, "root: labels: NOT object"},
+		{`{"": "ACME"}`, "root: labels: key is empty"},
+		{`{" ": "ACME"}`, "root: labels: key is empty"},
+		{`{"serial": 1}`, `root: labels: key "serial": value is NOT string`},
+		{`{"serial": "1", " serial": "2"}`, `root: labels: key "serial": duplicated`},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(fmt.Sprintf(tmpl, `"labels": `+test.labels+`, `)), nil)
+		assert.EqualError(t, err, test.wantErr, test.labels)
+	}
+}
+
+func TestErrorEventMeta(t *testing.T) {
+	event, err := jsoniter.Marshal(ErrorEventMeta("error", "错误事件"))
+	require.Nil(t, err)
+
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "state": [], "method": [], "event": [`+
+		string(event)+`]}`), nil)
+	require.Nil(t, err, "声明的事件元信息有效")
+
+	assert.Nil(t, m.VerifyEvent("error", message.Args{
+		message.ErrorEventCode:    3,
+		message.ErrorEventMessage: "motor over current",
+		"motor":                   2,
+	}), "与标准错误事件匹配")
+
+	assert.Equal(t, errors.New(`arg "message": missing`), m.VerifyEvent("error", message.Args{
+		message.ErrorEventCode: 3,
+	}), "缺少错误信息")
+}
+
+func TestMeta_VerifyResponseOf(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "test", "description": "测试物模型", "state": [], "event": [], "method": [
+		{"name": "a", "description": "方法a", "args": [], "response": []},
+		{"name": "b", "description": "方法b", "args": [], "response": [], "verifyResponse": false},
+		{"name": "c", "description": "方法c", "args": [], "response": [], "verifyResponse": true}
+	]}`), nil)
+	require.Nil(t, err)
+
+	type TestCase struct {
+		method     string // 方法名
+		wantVerify bool   // 期望的verifyResponse字段值
+		wantSeen   bool   // 期望是否声明了verifyResponse字段
+	}
+
+	testCases := []TestCase{
+		{"a", false, false},
+		{"b", false, true},
+		{"c", true, true},
+		{"d", false, false},
+	}
+
+	for _, test := range testCases {
+		verify, seen := m.VerifyResponseOf(test.method)
+		assert.Equal(t, test.wantVerify, verify, test.method)
+		assert.Equal(t, test.wantSeen, seen, test.method)
+	}
+
+	equal, err := ParseAndCompare(m.ToJSON(), nil, m)
+	require.Nil(t, err)
+	assert.True(t, equal, "序列化后包含verifyResponse字段")
+}
+
+func TestMeta_VerifyRawStates(t *testing.T) {
+	json, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(json, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	got := m.VerifyRawStates(map[string][]byte{
+		"gear":     []byte(`1`),
+		"QSCount":  []byte(`-1`),
+		"unknown":  []byte(`{}`),
+		"tpqsInfo": []byte(`{"qsState": "unknown"}`),
+	})
+	assert.Equal(t, map[string]error{
+		"gear":     nil,
+		"QSCount":  errors.New("NOT uint"),
+		"unknown":  errors.New(`NO state "unknown"`),
+		"tpqsInfo": errors.New(`field "qsState": "unknown" NOT in option`),
+	}, got, "返回所有状态的校验结果, 不在第一个错误处停止")
+
+	assert.Equal(t, map[string]error{}, m.VerifyRawStates(nil), "没有状态")
+}
+
+func TestParse_MaxParamDepth(t *testing.T) {
+	// nested 返回嵌套深度为depth的切片类型状态元信息
+	nested := func(depth int) []byte {
+		param := `{"type": "int"}`
+		for i := 1; i < depth; i++ {
+			param = `{"type": "slice", "element": ` + param + `}`
+		}
+		param = strings.Replace(param, `{"type"`, `{"name": "deep", "description": "嵌套", "type"`, 1)
+		return []byte(`{"name": "A/car", "description": "车辆", "state": [` + param + `], "event": [], "method": []}`)
+	}
+
+	_, err := Parse(nested(MaxParamDepth), nil)
+	assert.Nil(t, err, "嵌套深度等于上限")
+
+	_, err = Parse(nested(MaxParamDepth+1), nil)
+	require.NotNil(t, err, "嵌套深度超过上限")
+	assert.True(t, strings.HasPrefix(err.Error(), "state[0]: element: "), err.Error())
+	assert.True(t, strings.HasSuffix(err.Error(), "nesting depth exceeds 32: recursive type NOT supported"), err.Error())
+
+	// 构造的深层嵌套元信息不会导致栈溢出
+	_, err = Parse(nested(5000), nil)
+	assert.NotNil(t, err, "嵌套过深")
+
+	// 结构体字段的嵌套深度
+	param := `{"name": "f", "description": "字段", "type": "int"}`
+	for i := 1; i <= MaxParamDepth; i++ {
+		param = `{"name": "f", "description": "字段", "type": "struct", "fields": [` + param + `]}`
+	}
+	_, err = Parse([]byte(`{"name": "A/car", "description": "车辆", "state": [], "event": [],
+		"method": [{"name": "m", "description": "方法", "args": [], "response": [`+param+`]}]}`), nil)
+	require.NotNil(t, err, "结构体嵌套深度超过上限")
+	assert.True(t, strings.HasSuffix(err.Error(), "recursive type NOT supported"), err.Error())
+}
+
+func TestMeta_WalkParams(t *testing.T) {
+	json, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(json, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	var paths []string
+	m.WalkParams(func(path string, pm ParamMeta) bool {
+		paths = append(paths, path+" "+pm.Type)
+		return true
+	})
+	assert.Equal(t, []string{
+		"state.tpqsInfo.qsState string",
+		"state.tpqsInfo.hpSwitch bool",
+		"state.tpqsInfo.qsAngle float",
+		"state.tpqsInfo.errors[].code uint",
+		"state.tpqsInfo.errors[].msg string",
+		"state.powerInfo[].isOn bool",
+		"state.powerInfo[].outCur float",
+		"state.gear uint",
+		"state.QSCount uint",
+		"event.qsAction.motors[].rov int",
+		"event.qsAction.motors[].cur int",
+		"event.qsAction.motors[].temp int",
+		"event.qsAction.qsAngle float",
+		"method.QS.args.angle float",
+		"method.QS.args.speed string",
+		"method.QS.response.res bool",
+		"method.QS.response.msg string",
+		"method.QS.response.time uint",
+		"method.QS.response.code int",
+	}, paths, "按声明顺序遍历所有叶子参数")
+
+	// 返回false时停止遍历
+	paths = nil
+	m.WalkParams(func(path string, pm ParamMeta) bool {
+		paths = append(paths, path)
+		return path != "state.tpqsInfo.errors[].code"
+	})
+	assert.Equal(t, []string{
+		"state.tpqsInfo.qsState",
+		"state.tpqsInfo.hpSwitch",
+		"state.tpqsInfo.qsAngle",
+		"state.tpqsInfo.errors[].code",
+	}, paths, "返回false时停止遍历")
+
+	// 联合类型的变体
+	m, err = Parse([]byte(unionMetaJson), nil)
+	require.Nil(t, err)
+	paths = nil
+	m.WalkParams(func(path string, pm ParamMeta) bool {
+		paths = append(paths, path)
+		return true
+	})
+	assert.Equal(t, []string{
+		"state.cmds[][type=move].type",
+		"state.cmds[][type=move].angle",
+		"state.cmds[][type=wait].type",
+		"state.cmds[][type=wait].ms",
+	}, paths, "按类型标识值的字典序遍历变体")
+}
+
+func TestLoadDir(t *testing.T) {
+	content, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+	param := TemplateParam{"group": "A", "id": "#1"}
+
+	dir := t.TempDir()
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "tpqs.json"), content, 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "motor.json"),
+		[]byte(`{"name": "{group}/car/{id}/motor", "description": "电机", "state": [], "event": [], "method": []}`), 0644))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte(`not meta`), 0644))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, "sub.json"), 0755))
+
+	metas, err := LoadDir(dir, param)
+	require.Nil(t, err)
+	require.Len(t, metas, 2, "只解析.json文件")
+	assert.Equal(t, "A/car/#1/tpqs", metas["A/car/#1/tpqs"].Name)
+	assert.Equal(t, "A/car/#1/motor", metas["A/car/#1/motor"].Name)
+
+	// 物模型名称冲突
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "tpqs2.json"), content, 0644))
+	_, err = LoadDir(dir, param)
+	assert.Equal(t, fmt.Errorf("%s: model name %q already defined in %s",
+		filepath.Join(dir, "tpqs2.json"), "A/car/#1/tpqs", filepath.Join(dir, "tpqs.json")), err)
+	require.Nil(t, os.Remove(filepath.Join(dir, "tpqs2.json")))
+
+	// 文件解析失败
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{`), 0644))
+	_, err = LoadDir(dir, param)
+	assert.Equal(t, fmt.Errorf("%s: parse JSON failed", filepath.Join(dir, "bad.json")), err)
+
+	_, err = LoadDir(filepath.Join(dir, "none"), param)
+	assert.NotNil(t, err, "目录不存在")
+}
+
+func TestMeta_VerifyRawStateDeep(t *testing.T) {
+	m, err := Parse([]byte(`{
+		"name": "A/car",
+		"description": "车辆",
+		"state": [
+			{
+				"name": "wheels",
+				"description": "车轮",
+				"type": "slice",
+				"element": {
+					"type": "struct",
+					"fields": [
+						{"name": "id", "description": "编号", "type": "int", "range": {"min": 1, "max": 4}},
+						{"name": "ok", "description": "是否正常", "type": "bool"}
+					]
+				}
+			},
+			{
+				"name": "levels",
+				"description": "档位",
+				"type": "slice",
+				"element": {"type": "uint", "range": {"max": 4}}
+			},
+			{
+				"name": "lights",
+				"description": "车灯",
+				"type": "struct",
+				"fields": [
+					{
+						"name": "modes",
+						"description": "模式",
+						"type": "array",
+						"length": 2,
+						"element": {"type": "string", "range": {"option": [{"value": "on", "description": "开"}]}}
+					}
+				]
+			}
+		],
+		"event": [],
+		"method": []
+	}`), nil)
+	require.Nil(t, err)
+
+	// 空切片能通过普通校验
+	assert.Nil(t, m.VerifyRawState("wheels", []byte(`[]`)))
+	assert.Equal(t, errors.New(`element[0]: field "id": less than min`),
+		m.VerifyRawState("wheels", []byte(`[{"id": 0, "ok": false}]`)))
+
+	assert.Equal(t, errors.New(`element zero value: field "id": less than min`),
+		m.VerifyRawStateDeep("wheels", []byte(`[]`)), "元素零值不符合范围")
+	assert.Equal(t, errors.New(`element[0]: field "id": less than min`),
+		m.VerifyRawStateDeep("wheels", []byte(`[{"id": 0, "ok": false}]`)), "先进行普通校验")
+	assert.Nil(t, m.VerifyRawStateDeep("levels", []byte(`[]`)), "元素零值符合范围")
+	assert.Equal(t, errors.New(`field "modes": element zero value: "" NOT in option`),
+		m.VerifyRawStateDeep("lights", []byte(`{"modes": ["on", "on"]}`)), "嵌套的数组元素")
+	assert.Equal(t, errors.New(`NO state "unknown"`), m.VerifyRawStateDeep("unknown", []byte(`[]`)))
+}
+
+func TestMeta_VerifyRawStateFields(t *testing.T) {
+	json, _ := ioutil.ReadFile("./tpqs.json")
+	m, err := Parse(json, TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	type TestCase struct {
+		name    string   // 状态名
+		data    string   // 状态数据
+		fields  []string // 校验的字段
+		wantErr error    // 期望的错误信息
+		desc    string   // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			name:   "tpqsInfo",
+			data:   `{"qsState": "downing", "hpSwitch": true, "qsAngle": "bad"}`,
+			fields: []string{"qsState", "hpSwitch"},
+			desc:   "其他字段无效或者缺失不影响校验",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `{"qsState": "downing", "hpSwitch": true, "qsAngle": "bad"}`,
+			wantErr: errors.New(`field "qsAngle": NOT number`),
+			desc:    "不指定字段时校验全部字段",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `{"qsState": "unknown", "hpSwitch": true}`,
+			fields:  []string{"hpSwitch", "qsState"},
+			wantErr: errors.New(`field "qsState": "unknown" NOT in option`),
+			desc:    "字段值不匹配",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `{"qsState": "downing"}`,
+			fields:  []string{"hpSwitch"},
+			wantErr: errors.New(`field "hpSwitch": missing`),
+			desc:    "字段缺失",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `{"qsState": "downing"}`,
+			fields:  []string{"notExist"},
+			wantErr: errors.New(`NO field "notExist"`),
+			desc:    "字段未声明",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `[1, 2]`,
+			fields:  []string{"qsState"},
+			wantErr: errors.New("NOT struct"),
+			desc:    "数据不是结构体",
+		},
+
+		{
+			name:    "tpqsInfo",
+			data:    `{"qsState": `,
+			fields:  []string{"qsState"},
+			wantErr: errors.New("invalid JSON data"),
+			desc:    "无效的JSON数据",
+		},
+
+		{
+			name:    "gear",
+			data:    `0`,
+			fields:  []string{"value"},
+			wantErr: errors.New(`state "gear" is NOT struct`),
+			desc:    "非结构体状态指定了字段",
+		},
+
+		{
+			name:    "notExist",
+			data:    `{}`,
+			fields:  []string{"value"},
+			wantErr: errors.New(`NO state "notExist"`),
+			desc:    "状态不存在",
+		},
+	}
+
+	for _, test := range testCases {
+		err := m.VerifyRawStateFields(test.name, []byte(test.data), test.fields...)
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+}
+
+func TestParseRangeRef(t *testing.T) {
+	m, err := Parse([]byte(`{
+		"name": "test",
+		"description": "测试",
+		"ranges": {
+			"percent": {"min": 0, "max": 100},
+			"mode": {"option": [{"value": "auto", "description": "自动"}, {"value": "manual", "description": "手动"}]}
+		},
+		"state": [
+			{"name": "load", "description": "负载率", "type": "float", "unit": "%", "rangeRef": "percent"},
+			{"name": "info", "description": "信息", "type": "struct", "fields": [
+				{"name": "mode", "description": "模式", "type": "string", "rangeRef": "mode"}
+			]}
+		],
+		"event": [],
+		"method": [
+			{"name": "set", "description": "设置", "args": [
+				{"name": "load", "description": "负载率", "type": "float", "rangeRef": "percent"}
+			], "response": []}
+		]
+	}`), nil)
+	require.Nil(t, err)
+
+	want := &RangeInfo{Min: float64(0), Max: float64(100)}
+	assert.Equal(t, want, m.State[0].Range)
+	assert.Equal(t, want, m.Method[0].Args[0].Range)
+	require.NotNil(t, m.State[1].Fields[0].Range)
+	assert.Len(t, m.State[1].Fields[0].Range.Option, 2)
+	assert.NotContains(t, string(m.ToJSON()), "rangeRef", "序列化结果为展开后的范围")
+
+	// 所有验证路径都使用展开后的范围
+	assert.Nil(t, m.VerifyState("load", 50.0))
+	assert.NotNil(t, m.VerifyState("load", 101.0))
+	assert.NotNil(t, m.VerifyRawState("load", []byte(`-1`)))
+	assert.NotNil(t, m.VerifyState("info", map[string]interface{}{"mode": "none"}))
+	assert.NotNil(t, m.VerifyMethodArgs("set", message.Args{"load": 200.0}))
+
+	testCases := []struct {
+		data    string
+		wantErr string
+		desc    string
+	}{
+		{
+			`{"name": "test", "description": "测试", "state": [
+				{"name": "load", "description": "负载率", "type": "float", "rangeRef": "percent"}
+			], "event": [], "method": []}`,
+			`state[0]: rangeRef "percent" NOT exist`,
+			"引用不存在的预设",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "ranges": [], "state": [], "event": [], "method": []}`,
+			"root: ranges is NOT object",
+			"ranges不是对象",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "ranges": {"percent": {"min": 0, "max": 100}}, "state": [], "event": [
+				{"name": "alarm", "description": "告警", "args": [
+					{"name": "load", "description": "负载率", "type": "float", "rangeRef": 1}
+				]}
+			], "method": []}`,
+			"event[0]: args[0]: rangeRef is NOT string",
+			"rangeRef不是字符串",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "ranges": {"percent": {"min": 0, "max": 100}}, "state": [
+				{"name": "load", "description": "负载率", "type": "float", "rangeRef": "percent", "range": {"max": 1}}
+			], "event": [], "method": []}`,
+			"state[0]: range and rangeRef both exist",
+			"同时声明range和rangeRef",
+		},
+
+		{
+			`{"name": "test", "description": "测试", "ranges": {"percent": {"min": 0, "max": 100}}, "state": [
+				{"name": "name", "description": "名称", "type": "string", "rangeRef": "percent"}
+			], "event": [], "method": []}`,
+			"state[0]: range: NO option for string range",
+			"预设与参数类型不匹配",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		require.NotNil(t, err, test.desc)
+		assert.Equal(t, test.wantErr, err.Error(), test.desc)
+	}
+}
+
+func TestTemplateFromEnv(t *testing.T) {
+	data, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+
+	require.Nil(t, os.Setenv("TPQS_GROUP", "A"))
+	defer os.Unsetenv("TPQS_GROUP")
+
+	_, err = TemplateFromEnv(data, "TPQS_")
+	assert.Equal(t, errors.New(`template "id": environment variable "TPQS_ID" NOT set`), err, "缺少环境变量")
+
+	require.Nil(t, os.Setenv("TPQS_ID", "#1"))
+	defer os.Unsetenv("TPQS_ID")
+
+	tmpl, err := TemplateFromEnv(data, "TPQS_")
+	require.Nil(t, err)
+	assert.Equal(t, TemplateParam{"group": "A", "id": "#1"}, tmpl)
+
+	tmpl, err = TemplateFromEnv([]byte(`{"name": "test"}`), "TPQS_")
+	require.Nil(t, err)
+	assert.Empty(t, tmpl, "没有模板参数")
+
+	_, err = TemplateFromEnv([]byte(`{`), "TPQS_")
+	assert.NotNil(t, err, "无效的JSON")
+
+	for _, name := range []string{"a/{", "a/}", "{", "", "a/{id"} {
+		assert.NotPanics(t, func() {
+			_, err = TemplateFromEnv([]byte(`{"name": "`+name+`"}`), "TPQS_")
+		}, name)
+		assert.NotNil(t, err, "无效的模型名称: "+name)
+	}
+}
+
+func TestParamsBuilder(t *testing.T) {
+	data, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+	m, err := Parse(data, TemplateParam{"group": "A", "id": "#1"})
+	require.Nil(t, err)
+
+	args, err := m.NewMethodArgs("QS").
+		Set("angle", 90.0).
+		Set("speed", "fast").
+		Build()
+	require.Nil(t, err)
+	assert.Equal(t, message.Args{"angle": 90.0, "speed": "fast"}, args)
+
+	resp, err := m.NewMethodResp("QS").
+		Set("res", true).
+		Set("msg", "ok").
+		Set("time", uint(10)).
+		Set("code", 0).
+		BuildResp()
+	require.Nil(t, err)
+	assert.Equal(t, message.Resp{"res": true, "msg": "ok", "time": uint(10), "code": 0}, resp)
+
+	args, err = m.NewEventArgs("qsMotorOverCur").Build()
+	require.Nil(t, err)
+	assert.Equal(t, message.Args{}, args, "没有参数的事件")
+
+	testCases := []struct {
+		builder *ParamsBuilder // 构造器
+		wantErr error          // 期望的错误信息
+		desc    string         // 用例描述
+	}{
+		{
+			builder: m.NewMethodArgs("unknown").Set("angle", 90.0),
+			wantErr: errors.New(`NO method "unknown"`),
+			desc:    "方法不存在",
+		},
+
+		{
+			builder: m.NewEventArgs("unknown"),
+			wantErr: errors.New(`NO event "unknown"`),
+			desc:    "事件不存在",
+		},
+
+		{
+			builder: m.NewMethodArgs("QS").Set("angel", 90.0).Set("speed", "fast"),
+			wantErr: errors.New(`NO arg "angel"`),
+			desc:    "参数名错误",
+		},
+
+		{
+			builder: m.NewMethodArgs("QS").Set("angle", "90"),
+			wantErr: errors.New(`arg "angle": type unmatched`),
+			desc:    "参数类型错误",
+		},
+
+		{
+			builder: m.NewMethodArgs("QS").Set("angle", 100.0),
+			wantErr: errors.New(`arg "angle": greater than max`),
+			desc:    "参数超出范围",
+		},
+
+		{
+			builder: m.NewMethodArgs("QS").Set("angle", 90.0),
+			wantErr: nil,
+			desc:    "缺少参数--有默认值的参数可以省略",
+		},
+
+		{
+			builder: m.NewEventArgs("qsAction"),
+			wantErr: errors.New(`arg "motors": missing`),
+			desc:    "缺少参数",
+		},
+
+		{
+			builder: m.NewMethodResp("QS").Set("res", true).Set("code", "0"),
+			wantErr: errors.New(`response "code": type unmatched`),
+			desc:    "返回值类型错误",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := test.builder.Build()
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+
+	builder := m.NewMethodArgs("QS").Set("angel", 90.0)
+	assert.Equal(t, errors.New(`NO arg "angel"`), builder.Err())
+	_, err = builder.BuildResp()
+	assert.Equal(t, errors.New(`NO arg "angel"`), err)
+}
+
+func TestMeta_ToJSONIndent(t *testing.T) {
+	data, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+	m, err := Parse(data, TemplateParam{"group": "A", "id": "#1"})
+	require.Nil(t, err)
+
+	compact := append([]byte(nil), m.ToJSON()...)
+
+	indented, err := m.ToJSONIndent("", "  ")
+	require.Nil(t, err)
+	assert.Contains(t, string(indented), "\n  \"name\": \"A/car/#1/tpqs\"")
+	assert.JSONEq(t, string(compact), string(indented), "内容与ToJSON相同")
+	assert.Equal(t, compact, m.ToJSON(), "不影响ToJSON缓存的结果")
+
+	equal, err := ParseAndCompare(indented, nil, m)
+	require.Nil(t, err)
+	assert.True(t, equal, "解析结果与原元信息相同")
+
+	indented, err = m.ToJSONIndent("//", "\t")
+	require.Nil(t, err)
+	assert.Contains(t, string(indented), "\n//\t\"name\"")
+}
+
+func TestMeta_ToMarkdown(t *testing.T) {
+	data, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+	m, err := Parse(data, TemplateParam{"group": "A", "id": "#1"})
+	require.Nil(t, err)
+
+	doc := string(m.ToMarkdown())
+	assert.True(t, strings.HasPrefix(doc, "# A/car/#1/tpqs\n\n发射车调平起竖服务\n\n## 状态\n"))
+	assert.Contains(t, doc, "| gear | 车辆档位状态 | uint |  | 可选值: 0: 驻车; 1: 行驶; 2: 空档; 3: 倒档 |\n")
+	assert.Contains(t, doc, "| powerInfo | 8路配电通道信息 | array[8]<struct> |  |  |\n")
+	assert.Contains(t, doc, "`powerInfo` 的结构:\n\n- 元素: (struct)\n  - `isOn` (bool): 配电是否接通\n")
+	assert.Contains(t, doc, "### qsMotorOverCur\n\n起竖电机过流告警事件\n\n参数:\n\n无\n")
+	assert.Contains(t, doc, "| angle | 期望的起竖角度 | float | ° | [0, 91], 默认值: 90 |\n")
+	assert.Contains(t, doc, "返回值:\n\n| 名称 | 描述 | 类型 | 单位 | 范围 |\n")
+
+	// 联合类型的变体按照类型标识值排序, 保证输出稳定
+	m, err = Parse([]byte(unionMetaJson), nil)
+	require.Nil(t, err)
+	doc = string(m.ToMarkdown())
+	for i := 0; i < 10; i++ {
+		again, err := Parse([]byte(unionMetaJson), nil)
+		require.Nil(t, err)
+		assert.Equal(t, doc, string(again.ToMarkdown()), "输出稳定")
+	}
+	assert.Regexp(t, "(?s)- type = `move`:\n.*- type = `wait`:\n", doc)
+}
+
+func TestMeta_CanEncodeState(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "test", "description": "测试", "state": [
+		{"name": "speed", "description": "速度", "type": "float", "range": {"max": 10}},
+		{"name": "info", "description": "信息", "type": "struct", "fields": [
+			{"name": "speed", "description": "速度", "type": "float", "range": {"max": 10}}
+		]}
+	], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+
+	type Info struct {
+		Speed  float64     `json:"speed"`
+		Notify chan string `json:"notify"`
+	}
+
+	assert.Nil(t, m.CanEncodeState("speed", 1.5))
+	assert.Nil(t, m.CanEncodeState("info", struct {
+		Speed float64 `json:"speed"`
+	}{1.5}))
+	assert.Equal(t, errors.New(`NO state "unknown"`), m.CanEncodeState("unknown", 1.5))
+	assert.Equal(t, errors.New("greater than max"), m.CanEncodeState("speed", 11.0), "不符合元信息")
+
+	err = m.CanEncodeState("info", Info{Speed: 1.5, Notify: make(chan string)})
+	require.NotNil(t, err, "未声明的字段无法编码")
+	assert.Nil(t, m.VerifyState("info", Info{Speed: 1.5, Notify: make(chan string)}), "VerifyState 不检查未声明的字段")
+	assert.True(t, strings.HasPrefix(err.Error(), "encode: "), err.Error())
+
+	err = m.CanEncodeState("info", Info{Speed: 11, Notify: make(chan string)})
+	require.NotNil(t, err, "两项检查都不通过")
+	assert.True(t, strings.HasPrefix(err.Error(), `field "speed": greater than max; encode: `), err.Error())
+}
+
+func TestMeta_AccessOf(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "test", "description": "测试", "state": [
+		{"name": "speed", "description": "速度", "type": "float"},
+		{"name": "target", "description": "目标速度", "type": "float", "access": " rw "},
+		{"name": "cmd", "description": "指令", "type": "string", "access": "w"},
+		{"name": "temp", "description": "温度", "type": "float", "access": "r"}
+	], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+
+	testCases := []struct {
+		name       string // 状态名
+		wantAccess string // 期望的访问权限
+		wantSeen   bool   // 期望状态是否存在
+		wantErr    error  // 期望的设置权限校验结果
+	}{
+		{"speed", AccessRead, true, errors.New(`state "speed" is read-only`)},
+		{"target", AccessReadWrite, true, nil},
+		{"cmd", AccessWrite, true, nil},
+		{"temp", AccessRead, true, errors.New(`state "temp" is read-only`)},
+		{"unknown", "", false, errors.New(`NO state "unknown"`)},
+	}
+
+	for _, test := range testCases {
+		access, seen := m.AccessOf(test.name)
+		assert.Equal(t, test.wantAccess, access, test.name)
+		assert.Equal(t, test.wantSeen, seen, test.name)
+		assert.Equal(t, test.wantErr, m.VerifyStateWrite(test.name), test.name)
+	}
+
+	// 序列化后保留访问权限
+	equal, err := ParseAndCompare(m.ToJSON(), nil, m)
+	require.Nil(t, err)
+	assert.True(t, equal)
+	assert.Contains(t, string(m.ToJSON()), `"access":"rw"`)
+
+	errCases := []struct {
+		access  string // 访问权限
+		wantErr string // 期望的错误信息
+	}{
+		{`1`, "state[0]: access is NOT string"},
+		{`"x"`, `state[0]: invalid access: "x"`},
+		{`""`, `state[0]: invalid access: ""`},
+	}
+
+	for _, test := range errCases {
+		_, err := Parse([]byte(`{"name": "test", "description": "测试", "state": [
+			{"name": "speed", "description": "速度", "type": "float", "access": `+test.access+`}
+		], "event": [], "method": []}`), nil)
+		require.NotNil(t, err, test.access)
+		assert.Equal(t, test.wantErr, err.Error(), test.access)
+	}
+}
+
+func TestMeta_VerifyStructMetaTag(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "test", "description": "测试", "state": [
+		{"name": "info", "description": "信息", "type": "struct", "fields": [
+			{"name": "qsState", "description": "起竖状态", "type": "string"},
+			{"name": "qsAngle", "description": "起竖角度", "type": "float", "range": {"min": 0, "max": 90}}
+		]}
+	], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+
+	// 默认按照json标签匹配
+	type jsonInfo struct {
+		QsState string  `json:"qsState"`
+		QsAngle float64 `json:"qsAngle"`
+	}
+	assert.Nil(t, m.VerifyState("info", jsonInfo{QsState: "up", QsAngle: 10}))
+
+	// meta标签优先于json标签
+	type metaInfo struct {
+		QsState string  `json:"qs_state" meta:"qsState"`
+		QsAngle float64 `json:"qs_angle" meta:"qsAngle"`
+	}
+	assert.Nil(t, m.VerifyState("info", metaInfo{QsState: "up", QsAngle: 10}))
+	assert.Equal(t, errors.New(`field "qsAngle": greater than max`),
+		m.VerifyState("info", metaInfo{QsState: "up", QsAngle: 100}))
+
+	// 报文中同样以meta标签作为字段名, 对端按照元信息校验通过
+	msg, err := message.Decode(message.Must(message.EncodeStateMsg("car/info", metaInfo{QsState: "up", QsAngle: 10})))
+	require.Nil(t, err)
+	payload, err := message.ParseStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.JSONEq(t, `{"qsState":"up","qsAngle":10}`, string(payload.Data), "以meta标签编码")
+	assert.Nil(t, m.VerifyRawState("info", payload.Data), "以meta标签编码")
+
+	type snakeInfo struct {
+		QsState string  `json:"qs_state"`
+		QsAngle float64 `json:"qs_angle"`
+	}
+	assert.Equal(t, errors.New(`field "qsState": missing`), m.VerifyState("info", snakeInfo{}))
+
+	type shadowInfo struct {
+		QsState string  `json:"qsState" meta:"state"`
+		QsAngle float64 `json:"qsAngle"`
+	}
+	assert.Equal(t, errors.New(`field "qsState": missing`), m.VerifyState("info", shadowInfo{}), "meta标签覆盖json标签")
+}
+
+func TestMeta_WithStateInvariant(t *testing.T) {
+	m, err := Parse([]byte(`{
+		"name": "A/car",
+		"description": "车辆",
+		"state": [
+			{
+				"name": "ctrl",
+				"description": "控制",
+				"type": "struct",
+				"fields": [
+					{"name": "mode", "description": "模式", "type": "string", "range": {"option": [
+						{"value": "auto", "description": "自动"},
+						{"value": "manual", "description": "手动"}
+					]}},
+					{"name": "setpoint", "description": "设定值", "type": "int", "range": {"max": 100}}
+				]
+			},
+			{"name": "speed", "description": "速度", "type": "int", "range": {"max": 100}}
+		],
+		"event": [],
+		"method": []
+	}`), nil)
+	require.Nil(t, err)
+
+	assert.Equal(t, errors.New(`NO state "unknown"`), m.WithStateInvariant("unknown", func(jsoniter.Any) error {
+		return nil
+	}), "状态不存在")
+	assert.Equal(t, errors.New("nil invariant"), m.WithStateInvariant("ctrl", nil), "约束函数为空")
+
+	called := 0
+	require.Nil(t, m.WithStateInvariant("ctrl", func(raw jsoniter.Any) error {
+		called++
+		if raw.Get("mode").ToString() == "manual" && raw.Get("setpoint").ToInt() <= 0 {
+			return errors.New("setpoint required in manual mode")
+		}
+		return nil
+	}))
+	require.Nil(t, m.WithStateInvariant("ctrl", func(raw jsoniter.Any) error {
+		if raw.Get("setpoint").ToInt() > 50 {
+			return errors.New("setpoint too high")
+		}
+		return nil
+	}))
+
+	assert.Nil(t, m.VerifyRawState("ctrl", []byte(`{"mode": "auto", "setpoint": 0}`)))
+	assert.Nil(t, m.VerifyRawState("ctrl", []byte(`{"mode": "manual", "setpoint": 10}`)))
+	assert.Equal(t, errors.New(`state "ctrl": setpoint required in manual mode`),
+		m.VerifyRawState("ctrl", []byte(`{"mode": "manual", "setpoint": 0}`)), "不满足约束")
+	assert.Equal(t, errors.New(`state "ctrl": setpoint too high`),
+		m.VerifyRawState("ctrl", []byte(`{"mode": "manual", "setpoint": 60}`)), "按添加顺序调用约束函数")
+	assert.Equal(t, 4, called)
+
+	assert.Equal(t, errors.New(`field "setpoint": greater than max`),
+		m.VerifyRawState("ctrl", []byte(`{"mode": "manual", "setpoint": 200}`)), "先进行结构校验")
+	assert.Equal(t, 4, called, "结构校验失败时不调用约束函数")
+
+	assert.Equal(t, map[string]error{
+		"ctrl":  errors.New(`state "ctrl": setpoint required in manual mode`),
+		"speed": nil,
+	}, m.VerifyRawStates(map[string][]byte{
+		"ctrl":  []byte(`{"mode": "manual", "setpoint": 0}`),
+		"speed": []byte(`1`),
+	}), "批量校验")
+}
+
+func TestParseRawJSON(t *testing.T) {
+	testCases := []string{
+		``, ` `, `1`, ` 1 `, `-1.5e3`, `-`, `01`, `1.`, `1x`, `"abc"`, `"a\nb"`, `"a\x"`, "\"a\tb\"",
+		`null`, `nul`, `true`, `tru`, `false`, `{}`, `{`, `{}[]`, `{} `, `{"a": 1}`, `{"a": }`,
+		`{"a" 1}`, `{"a": 1,}`, `{a: 1}`, `[]`, `[1, 2, 3]`, `[1, 2,]`, `[1 2]`, `[[], {}]`, `[{"a": [1, "bA"]}]`,
+		`[{"a": [1, "b\u00"]}]`, `{"a": 1} x`,
+	}
+
+	for _, data := range testCases {
+		root, err := parseRawJSON([]byte(data))
+		if stdjson.Valid([]byte(data)) {
+			assert.Nil(t, err, data)
+			assert.NotEqual(t, jsoniter.InvalidValue, root.ValueType(), data)
+		} else {
+			assert.EqualError(t, err, "invalid JSON data", data)
+		}
+	}
+}
+
+func BenchmarkVerifyRawState(b *testing.B) {
+	m, err := Parse([]byte(`{
+		"name": "A/car",
+		"description": "车辆",
+		"state": [
+			{
+				"name": "points",
+				"description": "轨迹点",
+				"type": "slice",
+				"element": {
+					"type": "struct",
+					"fields": [
+						{"name": "x", "description": "横坐标", "type": "float", "range": {"min": -1000, "max": 1000}},
+						{"name": "y", "description": "纵坐标", "type": "float", "range": {"min": -1000, "max": 1000}},
+						{"name": "tag", "description": "标签", "type": "string"}
+					]
+				}
+			}
+		],
+		"event": [],
+		"method": []
+	}`), nil)
+	require.Nil(b, err)
+
+	points := make([]map[string]interface{}, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		points = append(points, map[string]interface{}{"x": float64(i%1000) / 3, "y": -float64(i%1000) / 7, "tag": fmt.Sprintf("p%d", i)})
+	}
+	data, err := stdjson.Marshal(points)
+	require.Nil(b, err)
+
+	// 两次遍历: 先用 json.Unmarshal 校验语法, 再重新解析得到 jsoniter.Any
+	b.Run("TwoPass", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var value interface{}
+			if err := json.Unmarshal(data, &value); err != nil {
+				b.Fatal(err)
+			}
+			root := jsoniter.ParseBytes(json, data).ReadAny()
+			if err := _verifyRawData_(m.State[0], root); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SinglePass", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := m.VerifyRawState("points", data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestVerifyRawData_TokenTypes(t *testing.T) {
+	tokens := []string{`1`, `-1`, `0`, `-0`, `1.0`, `1.5`, `1e2`, `1E-2`, `99999999999999999999`,
+		`"1"`, `"1s"`, `true`, `false`, `null`, `[]`, `{}`}
+
+	// 每种类型接受的原始数据, 其余的原始数据都必须校验失败
+	accepted := map[string][]string{
+		"int":      {`1`, `-1`, `0`, `-0`},
+		"uint":     {`1`, `0`},
+		"float":    {`1`, `-1`, `0`, `-0`, `1.0`, `1.5`, `1e2`, `1E-2`, `99999999999999999999`},
+		"bool":     {`true`, `false`},
+		"string":   {`"1"`, `"1s"`},
+		"duration": {`1`, `-1`, `0`, `-0`, `1.0`, `1.5`, `1e2`, `1E-2`, `"1s"`},
+	}
+
+	for typ, want := range accepted {
+		wantSet := make(map[string]struct{}, len(want))
+		for _, token := range want {
+			wantSet[token] = struct{}{}
+		}
+		for _, token := range tokens {
+			err := verifyRawData(ParamMeta{Type: typ}, []byte(token))
+			if _, ok := wantSet[token]; ok {
+				assert.Nil(t, err, "%s 接受 %s", typ, token)
+			} else {
+				assert.NotNil(t, err, "%s 拒绝 %s", typ, token)
+			}
+		}
+	}
+
+	assert.Equal(t, errors.New("NOT int"), verifyRawData(ParamMeta{Type: "int"}, []byte(`1e2`)), "指数不会被截断")
+	assert.Equal(t, errors.New("NOT uint"), verifyRawData(ParamMeta{Type: "uint"}, []byte(`1E-2`)), "指数不会被截断")
+	assert.Equal(t, errors.New("duration overflow"), verifyRawData(ParamMeta{Type: "duration"}, []byte(`99999999999999999999`)))
+}
+
+func TestMeta_Decimal(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "event": [], "method": [], "state": [
+		{"name": "angle", "description": "角度", "type": "float", "decimal": true, "range": {"max": 100}},
+		{"name": "speed", "description": "速度", "type": "float", "decimal": false}
+	]}`), nil)
+	require.Nil(t, err)
+	require.NotNil(t, m.State[0].Decimal)
+	assert.True(t, *m.State[0].Decimal)
+
+	for _, data := range []string{`90.0`, `90.5`, `9e1`, `-1.0`} {
+		assert.Nil(t, m.VerifyRawState("angle", []byte(data)), data)
+	}
+	assert.Equal(t, errors.New("NOT decimal"), m.VerifyRawState("angle", []byte(`90`)), "整数字面量")
+	assert.Equal(t, errors.New("NOT number"), m.VerifyRawState("angle", []byte(`"90.0"`)), "字符串")
+	assert.Equal(t, errors.New("greater than max"), m.VerifyRawState("angle", []byte(`200.0`)), "先检查小数再检查范围")
+	assert.Nil(t, m.VerifyRawState("speed", []byte(`90`)), "decimal为false时接受整数字面量")
+	assert.Nil(t, m.VerifyState("angle", 90.0), "真实数据不检查原始文本")
+
+	// 推送时整数字面量补充小数点, 保证对端校验通过
+	for _, data := range []interface{}{90.0, 90, float32(90), 90.5, -1.0} {
+		value, err := m.StateInUnit("angle", data)
+		require.Nil(t, err)
+		raw, err := json.Marshal(value)
+		require.Nil(t, err)
+		assert.Nil(t, m.VerifyRawState("angle", raw), "%v -> %s", data, raw)
+	}
+	value, err := m.StateInUnit("angle", 90.0)
+	require.Nil(t, err)
+	assert.Equal(t, jsoniter.RawMessage(`90.0`), value)
+	value, err = m.StateInUnit("angle", 90.5)
+	require.Nil(t, err)
+	assert.Equal(t, jsoniter.RawMessage(`90.5`), value, "带小数点的数字保持不变")
+	value, err = m.StateInUnit("speed", 90.0)
+	require.Nil(t, err)
+	assert.Equal(t, 90.0, value, "decimal为false时不转换")
+
+	wrap := func(param string) string {
+		return `{"name": "car", "description": "车辆", "event": [], "method": [], "state": [` + param + `]}`
+	}
+	testCases := []struct {
+		data    string
+		wantErr error
+		desc    string
+	}{
+		{
+			wrap(`{"name": "angle", "description": "角度", "type": "float", "decimal": 1}`),
+			errors.New("state[0]: decimal is NOT bool"),
+			"decimal不是bool类型",
+		},
+		{
+			wrap(`{"name": "count", "description": "数量", "type": "int", "decimal": true}`),
+			errors.New("state[0]: decimal is NOT allowed for int"),
+			"decimal只能用于浮点数",
+		},
+	}
+	for _, test := range testCases {
+		_, err := Parse([]byte(test.data), nil)
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+}
+
+func TestMeta_FillMethodArgDefaults(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "state": [], "event": [], "method": [
+		{"name": "QS", "description": "起竖", "args": [
+			{"name": "angle", "description": "角度", "type": "float", "range": {"max": 91}},
+			{"name": "speed", "description": "速度", "type": "string", "range": {"option": [
+				{"value": "slow", "description": "慢速"},
+				{"value": "fast", "description": "快速"}
+			], "default": "fast"}},
+			{"name": "delay", "description": "延时", "type": "duration", "unit": "ms", "range": {"max": 5000, "default": 1500}},
+			{"name": "note", "description": "备注", "type": "string", "optional": true}
+		], "response": []}
+	]}`), nil)
+	require.Nil(t, err)
+	require.NotNil(t, m.Method[0].Args[3].Optional)
+	assert.True(t, *m.Method[0].Args[3].Optional)
+	assert.Nil(t, m.Method[0].Args[0].Optional)
+
+	// 缺失有默认值的参数
+	args := message.Args{"angle": 90.0}
+	assert.Nil(t, m.VerifyMethodArgs("QS", args), "有默认值的参数可以省略")
+	require.Nil(t, m.FillMethodArgDefaults("QS", args))
+	assert.Equal(t, message.Args{"angle": 90.0, "speed": "fast", "delay": 1500 * time.Millisecond}, args, "可选参数没有默认值")
+	assert.Nil(t, m.VerifyMethodArgs("QS", args), "补全后通过校验")
+
+	// 已经存在的参数保持不变
+	args = message.Args{"angle": 90.0, "speed": "slow"}
+	require.Nil(t, m.FillMethodArgDefaults("QS", args))
+	assert.Equal(t, "slow", args["speed"])
+
+	// 缺失没有默认值的必需参数
+	args = message.Args{"speed": "slow"}
+	require.Nil(t, m.FillMethodArgDefaults("QS", args))
+	assert.Equal(t, errors.New(`arg "angle": missing`), m.VerifyMethodArgs("QS", args), "补全后仍然缺失")
+	assert.Equal(t, errors.New(`arg "angle": missing`), m.VerifyRawMethodArgs("QS", message.RawArgs{
+		"speed": []byte(`"slow"`), "delay": []byte(`1`),
+	}))
+
+	// 可选参数可以省略, 存在时仍然校验
+	assert.Nil(t, m.VerifyRawMethodArgs("QS", message.RawArgs{
+		"angle": []byte(`90`), "speed": []byte(`"slow"`), "delay": []byte(`1`),
+	}), "省略可选参数")
+	assert.Equal(t, errors.New(`arg "note": NOT string`), m.VerifyRawMethodArgs("QS", message.RawArgs{
+		"angle": []byte(`90`), "speed": []byte(`"slow"`), "delay": []byte(`1`), "note": []byte(`1`),
+	}), "可选参数存在时校验")
+	assert.Equal(t, errors.New(`arg "note": type unmatched`), m.VerifyMethodArgs("QS", message.Args{
+		"angle": 90.0, "speed": "slow", "delay": time.Second, "note": 1,
+	}), "可选参数存在时校验")
+
+	assert.Equal(t, errors.New(`NO method "unknown"`), m.FillMethodArgDefaults("unknown", message.Args{}))
+	assert.Equal(t, errors.New("nil method args"), m.FillMethodArgDefaults("QS", nil))
+
+	// 原始参数中有默认值的参数可以省略, 补全为按照单位编码的默认值
+	raw := message.RawArgs{"angle": []byte(`90`)}
+	assert.Nil(t, m.VerifyRawMethodArgs("QS", raw), "有默认值的参数可以省略")
+	filled, err := m.FillRawMethodArgDefaults("QS", raw)
+	require.Nil(t, err)
+	assert.Equal(t, message.RawArgs{
+		"angle": []byte(`90`), "speed": []byte(`"fast"`), "delay": []byte(`1500`),
+	}, filled, "补全默认值")
+	assert.Equal(t, message.RawArgs{"angle": []byte(`90`)}, raw, "不修改原参数")
+	assert.Nil(t, m.VerifyRawMethodArgs("QS", filled), "补全后通过校验")
+
+	filled, err = m.FillRawMethodArgDefaults("QS", nil)
+	require.Nil(t, err)
+	assert.Equal(t, message.RawArgs{"speed": []byte(`"fast"`), "delay": []byte(`1500`)}, filled, "参数为空")
+	_, err = m.FillRawMethodArgDefaults("unknown", raw)
+	assert.Equal(t, errors.New(`NO method "unknown"`), err)
+
+	_, err = Parse([]byte(`{"name": "car", "description": "车辆", "state": [], "event": [], "method": [
+		{"name": "QS", "description": "起竖", "args": [
+			{"name": "note", "description": "备注", "type": "string", "optional": "yes"}
+		], "response": []}
+	]}`), nil)
+	assert.EqualError(t, err, "method[0]: args[0]: optional is NOT bool")
+}
+
+func TestMeta_OptionalState(t *testing.T) {
+	m, err := Parse([]byte(`{"name": "car", "description": "车辆", "state": [
+		{"name": "speed", "description": "速度", "type": "float", "range": {"max": 100}, "optional": true},
+		{"name": "gear", "description": "档位", "type": "uint", "range": {"max": 5}}
+	], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+	require.NotNil(t, m.State[0].Optional)
+	assert.True(t, *m.State[0].Optional)
+	assert.Nil(t, m.State[1].Optional)
+
+	// 可选状态可以清除
+	assert.Nil(t, m.VerifyStateClear("speed"))
+	assert.Nil(t, m.VerifyRawState("speed", []byte(` null `)))
+	assert.Nil(t, m.VerifyRawState("speed", []byte(`10`)))
+	assert.Equal(t, errors.New("NOT number"), m.VerifyRawState("speed", []byte(`"null"`)))
+
+	// 其他状态不能清除
+	assert.Equal(t, errors.New(`state "gear" is NOT optional`), m.VerifyStateClear("gear"))
+	assert.Equal(t, errors.New(`NO state "none"`), m.VerifyStateClear("none"))
+	assert.NotNil(t, m.VerifyRawState("gear", []byte(`null`)))
+
+	// 清除状态时不调用约束函数
+	require.Nil(t, m.WithStateInvariant("speed", func(raw jsoniter.Any) error {
+		return errors.New("always fail")
+	}))
+	assert.Nil(t, m.VerifyRawState("speed", []byte(`null`)))
+
+	// 元信息序列化后保留optional字段
+	m2, err := Parse(m.ToJSON(), nil)
+	require.Nil(t, err)
+	assert.Nil(t, m2.VerifyStateClear("speed"))
+
+	_, err = Parse([]byte(`{"name": "car", "description": "车辆", "state": [
+		{"name": "speed", "description": "速度", "type": "float", "optional": 1}
+	], "event": [], "method": []}`), nil)
+	assert.Equal(t, errors.New(`state[0]: optional is NOT bool`), err)
+}
+
+const sampleMetaJson = `{"name": "sample/dev", "description": "样例设备", "state": [
+	{"name": "temp", "description": "温度", "type": "float", "range": {"min": -40, "max": -10}, "decimal": true},
+	{"name": "level", "description": "等级", "type": "int", "range": {"option": [
+		{"value": 1, "description": "低"},
+		{"value": 3, "description": "高"}
+	], "default": 3}},
+	{"name": "count", "description": "计数", "type": "uint", "range": {"min": 10}},
+	{"name": "offset", "description": "偏移", "type": "int", "range": {"max": -5}},
+	{"name": "period", "description": "周期", "type": "duration", "unit": "ms", "range": {"min": 100, "max": 500}},
+	{"name": "timeout", "description": "超时", "type": "duration", "unit": "s", "range": {"max": "-1s"}},
+	{"name": "matrix", "description": "矩阵", "type": "array", "length": 2, "element": {
+		"type": "array", "length": 3, "element": {"type": "uint", "range": {"max": 9}}
+	}},
+	{"name": "sub", "description": "子模型", "type": "meta"},
+	{"name": "info", "description": "信息", "type": "struct", "fields": [
+		{"name": "on", "description": "开关", "type": "bool"},
+		{"name": "mode", "description": "模式", "type": "string", "range": {"option": [
+			{"value": "auto", "description": "自动"},
+			{"value": "manual", "description": "手动"}
+		]}},
+		{"name": "tags", "description": "标签", "type": "slice", "element": {"type": "string"}}
+	]}
+], "event": [
+	{"name": "alarm", "description": "告警", "args": [
+		{"name": "code", "description": "告警码", "type": "int", "range": {"min": 100, "max": 200}}
+	]}
+], "method": [
+	{"name": "set", "description": "设置", "args": [
+		{"name": "speed", "description": "速度", "type": "float", "range": {"max": 5}},
+		{"name": "note", "description": "备注", "type": "string", "optional": true}
+	], "response": [
+		{"name": "ok", "description": "是否成功", "type": "bool"}
+	]}
+]}`
+
+// verifySamples 校验样例生成器sampler为元信息m中所有状态、事件和方法生成的样例数据, 以及编码为报文后的原始数据
+func verifySamples(t *testing.T, m *Meta, sampler *Sampler) {
+	decode := func(data []byte) []byte {
+		msg, err := message.Decode(data)
+		require.Nil(t, err)
+		return msg.Payload
+	}
+
+	for _, state := range m.State {
+		data, err := sampler.State(*state.Name)
+		require.Nil(t, err, *state.Name)
+		assert.Nil(t, m.VerifyState(*state.Name, data), *state.Name)
+		data, err = m.StateInUnit(*state.Name, data)
+		require.Nil(t, err, *state.Name)
+		payload, err := message.ParseStatePayload(decode(message.Must(message.EncodeStateMsg(m.Name+"/"+*state.Name, data))))
+		require.Nil(t, err, *state.Name)
+		assert.Nil(t, m.VerifyRawState(*state.Name, payload.Data), "%s: %s", *state.Name, payload.Data)
+	}
+
+	for _, event := range m.Event {
+		args, err := sampler.EventArgs(event.Name)
+		require.Nil(t, err, event.Name)
+		assert.Nil(t, m.VerifyEvent(event.Name, args), event.Name)
+		args, err = m.EventArgsInUnit(event.Name, args)
+		require.Nil(t, err, event.Name)
+		payload, err := message.ParseEventPayload(decode(message.Must(message.EncodeEventMsg(m.Name+"/"+event.Name, args))))
+		require.Nil(t, err, event.Name)
+		assert.Nil(t, m.VerifyRawEvent(event.Name, payload.Args), event.Name)
+	}
+
+	for _, method := range m.Method {
+		args, err := sampler.MethodArgs(method.Name)
+		require.Nil(t, err, method.Name)
+		assert.Nil(t, m.VerifyMethodArgs(method.Name, args), method.Name)
+		args, err = m.MethodArgsInUnit(method.Name, args)
+		require.Nil(t, err, method.Name)
+		call, err := message.ParseCallPayload(decode(message.Must(message.EncodeCallMsg(m.Name+"/"+method.Name, "1", args))))
+		require.Nil(t, err, method.Name)
+		assert.Nil(t, m.VerifyRawMethodArgs(method.Name, call.Args), method.Name)
+
+		resp, err := sampler.MethodResp(method.Name)
+		require.Nil(t, err, method.Name)
+		assert.Nil(t, m.VerifyMethodResp(method.Name, resp), method.Name)
+		resp, err = m.MethodRespInUnit(method.Name, resp)
+		require.Nil(t, err, method.Name)
+		respPayload, err := message.ParseResponsePayload(decode(message.Must(message.EncodeRespMsg("1", "", resp))))
+		require.Nil(t, err, method.Name)
+		assert.Nil(t, m.VerifyRawMethodResp(method.Name, respPayload.Response), method.Name)
+	}
+}
+
+// TestSetJSONConfig 测试替换JSON配置
+func TestSetJSONConfig(t *testing.T) {
+	defer SetJSONConfig(message.DefaultJSONConfig)
+
+	const buff = `{"name": "test", "description": "a<b", "state": [], "event": [], "method": []}`
+	m, err := Parse([]byte(buff), nil)
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"test","description":"a\u003cb","state":[],"event":[],"method":[]}`, string(m.ToJSON()))
+
+	config := message.DefaultJSONConfig
+	config.EscapeHTML = false
+	SetJSONConfig(config)
+	assert.Equal(t, `{"name":"test","description":"a\u003cb","state":[],"event":[],"method":[]}`, string(m.ToJSON()),
+		"已经序列化过的元信息不受影响")
+
+	m, err = Parse([]byte(buff), nil)
+	require.Nil(t, err)
+	assert.Equal(t, `{"name":"test","description":"a<b","state":[],"event":[],"method":[]}`, string(m.ToJSON()))
+}
+
+func TestSampler(t *testing.T) {
+	tpqs, err := ioutil.ReadFile("./tpqs.json")
+	require.Nil(t, err)
+
+	for _, data := range []string{sampleMetaJson, unionMetaJson, string(tpqs)} {
+		m, err := Parse([]byte(data), TemplateParam{"group": "A", "id": "#1"})
+		require.Nil(t, err)
+
+		verifySamples(t, m, &Sampler{meta: m})
+		for seed := int64(0); seed < 20; seed++ {
+			verifySamples(t, m, NewSampler(m, seed))
+		}
+	}
+
+	m, err := Parse([]byte(sampleMetaJson), nil)
+	require.Nil(t, err)
+
+	// 确定样例数据
+	temp, err := m.SampleState("temp")
+	require.Nil(t, err)
+	assert.Equal(t, -39.5, temp, "最小值不带小数点")
+	level, err := m.SampleState("level")
+	require.Nil(t, err)
+	assert.Equal(t, 3, level, "默认值")
+	offset, err := m.SampleState("offset")
+	require.Nil(t, err)
+	assert.Equal(t, -5, offset, "不超过最大值")
+	timeout, err := m.SampleState("timeout")
+	require.Nil(t, err)
+	assert.Equal(t, -time.Second, timeout)
+	info, err := m.SampleState("info")
+	require.Nil(t, err)
+	assert.Equal(t, `{"on":false,"mode":"auto","tags":["sample"]}`, string(message.Must(json.Marshal(info))), "第一个可选项")
+	args, err := m.SampleMethodArgs("set")
+	require.Nil(t, err)
+	assert.Equal(t, message.Args{"speed": 0.0, "note": "sample"}, args)
+
+	// 相同种子生成相同的样例数据
+	encode := func(seed int64) string {
+		sampler := NewSampler(m, seed)
+		var ans []byte
+		for _, state := range m.State {
+			data, err := sampler.State(*state.Name)
+			require.Nil(t, err)
+			ans = append(ans, message.Must(json.Marshal(data))...)
+		}
+		return string(ans)
+	}
+	assert.Equal(t, encode(1), encode(1))
+	assert.NotEqual(t, encode(1), encode(2))
+
+	// 不存在的状态、事件和方法
+	_, err = m.SampleState("none")
+	assert.Equal(t, errors.New(`NO state "none"`), err)
+	_, err = m.SampleEventArgs("none")
+	assert.Equal(t, errors.New(`NO event "none"`), err)
+	_, err = m.SampleMethodArgs("none")
+	assert.Equal(t, errors.New(`NO method "none"`), err)
+	_, err = m.SampleMethodResp("none")
+	assert.Equal(t, errors.New(`NO method "none"`), err)
+
+	// 以 time.Duration 为边界直接构造的时长范围
+	sampler := &Sampler{meta: m}
+	d, err := sampler.durationValue(ParamMeta{Type: "duration", Range: &RangeInfo{Min: 2 * time.Second, Max: 3 * time.Second}})
+	require.Nil(t, err)
+	assert.Equal(t, 2*time.Second, d)
+	d, err = sampler.durationValue(ParamMeta{Type: "duration", Range: &RangeInfo{Max: "-1s"}})
+	require.Nil(t, err)
+	assert.Equal(t, -time.Second, d)
+	_, err = sampler.durationValue(ParamMeta{Type: "duration", Range: &RangeInfo{Min: "abc"}})
+	assert.Equal(t, errors.New(`range: min: invalid duration "abc"`), err)
+	_, err = sampler.sample(ParamMeta{Type: "duration", Range: &RangeInfo{Max: 1}})
+	assert.Equal(t, errors.New("range: max: 1 is NOT duration"), err, "无效的边界返回错误而不是panic")
+}
@@ -0,0 +1,105 @@
+package meta
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// resolveRangeRefs 将元信息rawData中所有参数的rangeRef字段替换为根节点ranges字段中同名的范围预设,
+// 返回展开后的元信息. 元信息中没有ranges和rangeRef字段时原样返回rawData.
+//
+// 例如:
+//
+//	{
+//	  "ranges": {"percent": {"min": 0, "max": 100}},
+//	  "state": [{"name": "load", "description": "负载率", "type": "float", "rangeRef": "percent"}],
+//	  ...
+//	}
+//
+// 展开后状态load的范围为 {"min": 0, "max": 100}, 展开发生在元信息检查之前, 因此范围预设与直接声明的范围检查规则相同.
+func resolveRangeRefs(rawData []byte) ([]byte, error) {
+	if !bytes.Contains(rawData, []byte(`"ranges"`)) && !bytes.Contains(rawData, []byte(`"rangeRef"`)) {
+		return rawData, nil
+	}
+
+	// NOTE: 保留数值的原始文本, 避免展开后数值精度发生变化
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(rawData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("parse JSON failed")
+	}
+
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return rawData, nil
+	}
+
+	presets := make(map[string]interface{})
+	if ranges, seen := root["ranges"]; seen {
+		if presets, ok = ranges.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("root: ranges is NOT object")
+		}
+	}
+	delete(root, "ranges")
+
+	keys := make([]string, 0, len(root))
+	for key := range root {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := expandRangeRef(root[key], key, presets); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(root)
+}
+
+// expandRangeRef 递归展开节点node中的rangeRef字段, path为节点路径, 用于错误信息
+func expandRangeRef(node interface{}, path string, presets map[string]interface{}) error {
+	switch value := node.(type) {
+	case []interface{}:
+		for i := range value {
+			if err := expandRangeRef(value[i], fmt.Sprintf("%s[%d]", path, i), presets); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if ref, seen := value["rangeRef"]; seen {
+			name, ok := ref.(string)
+			if !ok {
+				return fmt.Errorf("%s: rangeRef is NOT string", path)
+			}
+
+			if _, seen := value["range"]; seen {
+				return fmt.Errorf("%s: range and rangeRef both exist", path)
+			}
+
+			preset, seen := presets[name]
+			if !seen {
+				return fmt.Errorf("%s: rangeRef %q NOT exist", path, name)
+			}
+
+			value["range"] = preset
+			delete(value, "rangeRef")
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if err := expandRangeRef(value[key], path+": "+key, presets); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
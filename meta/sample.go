@@ -0,0 +1,482 @@
+package meta
+
+import (
+	"fmt"
+	"github.com/object-model/goModel/message"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"time"
+)
+
+const (
+	sampleMetaJSON = `{"name": "sample", "description": "样例物模型", "state": [], "event": [], "method": []}` // 元信息类型参数的样例数据
+	sampleMaxLen   = 3                                                                                    // 随机生成的切片的最大长度
+)
+
+var (
+	metaType = reflect.TypeOf((*Meta)(nil)).Elem()        // 元信息类型参数的样例数据类型
+	anyType  = reflect.TypeOf((*interface{})(nil)).Elem() // 联合类型参数的样例数据类型
+)
+
+// Sampler 为样例数据生成器, 根据元信息生成符合元信息的随机样例数据, 用于集成测试和演示, 例如与 modeltest.FakePeer 配合模拟设备.
+// 生成的样例数据满足参数的类型、范围、可选项和数组长度约束, 能够通过对应的 Verify* 校验,
+// 通过 message 包编码为报文后(例如 model.Model.PushState)也能通过对应的 VerifyRaw* 校验, 但不保证满足 WithStateInvariant 添加的约束.
+// 声明了自定义格式的参数依次尝试随机数据、默认值和可选项, 都不满足格式时返回错误信息.
+// 结构体和联合类型的样例数据为动态创建的结构体, 字段的json标签为元信息中的字段名.
+// 相同种子创建的 Sampler 按相同的顺序调用时生成相同的样例数据, 便于复现.
+// NOTE: Sampler 不是并发安全的, 需要在多个协程中生成样例数据时应各自创建 Sampler
+type Sampler struct {
+	meta *Meta      // 元信息
+	rand *rand.Rand // 随机数生成器, 为nil时生成确定的样例数据
+}
+
+// NewSampler 创建以seed为随机数种子, 根据元信息m生成随机样例数据的 Sampler.
+// 随机样例数据在范围约束内随机选取, 有可选项时从可选项中随机选取, 不考虑默认值, 切片的长度在0到3之间随机选取.
+func NewSampler(m *Meta, seed int64) *Sampler {
+	return &Sampler{
+		meta: m,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// SampleState 返回元信息m中名为name的状态的确定样例数据, 状态不存在或者无法生成样例数据时返回错误信息.
+// 确定样例数据优先选取默认值, 其次是第一个可选项, 再次是最小值, 都没有时为不超过最大值的零值, 切片只有一个元素.
+// 需要随机样例数据时使用 NewSampler.
+func (m *Meta) SampleState(name string) (interface{}, error) {
+	return (&Sampler{meta: m}).State(name)
+}
+
+// SampleEventArgs 返回元信息m中名为name的事件参数的确定样例数据, 规则与 SampleState 相同.
+func (m *Meta) SampleEventArgs(name string) (message.Args, error) {
+	return (&Sampler{meta: m}).EventArgs(name)
+}
+
+// SampleMethodArgs 返回元信息m中名为name的方法调用参数的确定样例数据, 规则与 SampleState 相同, 包含可省略的参数.
+func (m *Meta) SampleMethodArgs(name string) (message.Args, error) {
+	return (&Sampler{meta: m}).MethodArgs(name)
+}
+
+// SampleMethodResp 返回元信息m中名为name的方法返回值的确定样例数据, 规则与 SampleState 相同.
+func (m *Meta) SampleMethodResp(name string) (message.Resp, error) {
+	return (&Sampler{meta: m}).MethodResp(name)
+}
+
+// State 返回名为name的状态的样例数据, 状态不存在或者无法生成样例数据时返回错误信息.
+func (s *Sampler) State(name string) (interface{}, error) {
+	index, seen := s.meta.stateIndex[name]
+	if !seen {
+		return nil, fmt.Errorf("NO state %q", name)
+	}
+
+	value, err := s.sample(s.meta.State[index])
+	if err != nil {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+// EventArgs 返回名为name的事件参数的样例数据, 事件不存在或者无法生成样例数据时返回错误信息.
+func (s *Sampler) EventArgs(name string) (message.Args, error) {
+	index, seen := s.meta.eventIndex[name]
+	if !seen {
+		return nil, fmt.Errorf("NO event %q", name)
+	}
+	return s.sampleArgs("arg", s.meta.Event[index].Args)
+}
+
+// MethodArgs 返回名为name的方法调用参数的样例数据, 包含可省略的参数, 方法不存在或者无法生成样例数据时返回错误信息.
+func (s *Sampler) MethodArgs(name string) (message.Args, error) {
+	index, seen := s.meta.methodIndex[name]
+	if !seen {
+		return nil, fmt.Errorf("NO method %q", name)
+	}
+	return s.sampleArgs("arg", s.meta.Method[index].Args)
+}
+
+// MethodResp 返回名为name的方法返回值的样例数据, 方法不存在或者无法生成样例数据时返回错误信息.
+func (s *Sampler) MethodResp(name string) (message.Resp, error) {
+	index, seen := s.meta.methodIndex[name]
+	if !seen {
+		return nil, fmt.Errorf("NO method %q", name)
+	}
+
+	args, err := s.sampleArgs("response", s.meta.Method[index].Response)
+	if err != nil {
+		return nil, err
+	}
+	return message.Resp(args), nil
+}
+
+// sampleArgs 依次生成参数列表params中每个参数的样例数据, kind为错误信息中参数的类别
+func (s *Sampler) sampleArgs(kind string, params []ParamMeta) (message.Args, error) {
+	ans := make(message.Args, len(params))
+	for _, param := range params {
+		value, err := s.sample(param)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %s", kind, *param.Name, err)
+		}
+		ans[*param.Name] = value.Interface()
+	}
+	return ans, nil
+}
+
+// sampleType 返回参数元信息meta对应的样例数据类型, 联合类型的数据类型各不相同, 为interface{}
+func sampleType(meta ParamMeta) reflect.Type {
+	switch meta.Type {
+	case "int":
+		return reflect.TypeOf(0)
+	case "uint":
+		return reflect.TypeOf(uint(0))
+	case "float":
+		return reflect.TypeOf(float64(0))
+	case "bool":
+		return reflect.TypeOf(false)
+	case "string":
+		return reflect.TypeOf("")
+	case "duration":
+		return reflect.TypeOf(time.Duration(0))
+	case "meta":
+		return metaType
+	case "array":
+		return reflect.ArrayOf(int(*meta.Length), sampleType(*meta.Element))
+	case "slice":
+		return reflect.SliceOf(sampleType(*meta.Element))
+	case "struct":
+		return structType(meta.Fields, "")
+	default:
+		return anyType
+	}
+}
+
+// structType 返回字段元信息为fields的结构体样例数据类型, discriminator不为空时第一个字段为类型标识字段,
+// fields中与类型标识字段同名的字段被忽略
+func structType(fields []ParamMeta, discriminator string) reflect.Type {
+	ans := make([]reflect.StructField, 0, len(fields)+1)
+	if discriminator != "" {
+		ans = append(ans, reflect.StructField{
+			Name: "D",
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q`, discriminator)),
+		})
+	}
+	for i, field := range fields {
+		if discriminator != "" && *field.Name == discriminator {
+			continue
+		}
+		ans = append(ans, reflect.StructField{
+			Name: fmt.Sprintf("F%d", i),
+			Type: sampleType(field),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q`, *field.Name)),
+		})
+	}
+	return reflect.StructOf(ans)
+}
+
+// sample 生成参数元信息meta的样例数据, 数据类型为 sampleType(meta)
+func (s *Sampler) sample(meta ParamMeta) (reflect.Value, error) {
+	switch meta.Type {
+	case "array", "slice":
+		n := 1
+		if meta.Type == "array" {
+			n = int(*meta.Length)
+		} else if s.rand != nil {
+			n = s.rand.Intn(sampleMaxLen + 1)
+		}
+
+		var ans reflect.Value
+		if meta.Type == "array" {
+			ans = reflect.New(sampleType(meta)).Elem()
+		} else {
+			ans = reflect.MakeSlice(sampleType(meta), n, n)
+		}
+		for i := 0; i < n; i++ {
+			elem, err := s.sample(*meta.Element)
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("element[%d]: %s", i, err)
+			}
+			ans.Index(i).Set(elem)
+		}
+		return ans, nil
+	case "struct":
+		return s.sampleStruct(meta.Fields, "", "")
+	case "union":
+		return s.sampleUnion(meta)
+	case "meta":
+		m, err := Parse([]byte(sampleMetaJSON), nil)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(m).Elem(), nil
+	default:
+		return s.sampleLeaf(meta)
+	}
+}
+
+// sampleStruct 生成字段元信息为fields的结构体样例数据, discriminator不为空时类型标识字段的值为variant
+func (s *Sampler) sampleStruct(fields []ParamMeta, discriminator string, variant string) (reflect.Value, error) {
+	ans := reflect.New(structType(fields, discriminator)).Elem()
+	j := 0
+	if discriminator != "" {
+		ans.Field(0).SetString(variant)
+		j++
+	}
+	for _, field := range fields {
+		if discriminator != "" && *field.Name == discriminator {
+			continue
+		}
+		value, err := s.sample(field)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("field %q: %s", *field.Name, err)
+		}
+		ans.Field(j).Set(value)
+		j++
+	}
+	return ans, nil
+}
+
+// sampleUnion 生成联合类型参数元信息meta的样例数据, 确定样例数据选取类型标识值字典序最小的变体, 随机样例数据随机选取变体
+func (s *Sampler) sampleUnion(meta ParamMeta) (reflect.Value, error) {
+	variants := make([]string, 0, len(meta.Variants))
+	for variant := range meta.Variants {
+		variants = append(variants, variant)
+	}
+	sort.Strings(variants)
+
+	variant := variants[0]
+	if s.rand != nil {
+		variant = variants[s.rand.Intn(len(variants))]
+	}
+
+	value, err := s.sampleStruct(meta.Variants[variant].Fields, *meta.Discriminator, variant)
+	if err != nil {
+		return reflect.Value{}, fmt.Errorf("variant %q: %s", variant, err)
+	}
+
+	ans := reflect.New(anyType).Elem()
+	ans.Set(value)
+	return ans, nil
+}
+
+// sampleLeaf 生成叶子类型参数元信息meta的样例数据, 声明了自定义格式时依次尝试生成的数据、默认值和可选项,
+// 选取第一个通过自定义校验的数据
+func (s *Sampler) sampleLeaf(meta ParamMeta) (reflect.Value, error) {
+	value, err := s.leafValue(meta)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if meta.Format == nil {
+		return reflect.ValueOf(value), nil
+	}
+
+	candidates := []interface{}{value}
+	if value, ok := defaultOf(meta); ok {
+		candidates = append(candidates, value)
+	}
+	if meta.Range != nil {
+		for _, option := range meta.Range.Option {
+			candidates = append(candidates, option.Value)
+		}
+	}
+	for _, candidate := range candidates {
+		if verifyData(meta, candidate) == nil {
+			return reflect.ValueOf(candidate), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("NO sample matches format %q", *meta.Format)
+}
+
+// leafValue 根据范围约束生成叶子类型参数元信息meta的数据, 不考虑自定义格式, 范围约束无效时返回错误信息
+func (s *Sampler) leafValue(meta ParamMeta) (interface{}, error) {
+	// 确定样例数据优先选取默认值
+	if s.rand == nil {
+		if value, ok := defaultOf(meta); ok {
+			return value, nil
+		}
+	}
+
+	// 有可选项时从可选项中选取
+	if meta.Range != nil && len(meta.Range.Option) > 0 {
+		i := 0
+		if s.rand != nil {
+			i = s.rand.Intn(len(meta.Range.Option))
+		}
+		return meta.Range.Option[i].Value, nil
+	}
+
+	switch meta.Type {
+	case "int":
+		lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+		loGot, hiGot := false, false
+		if meta.Range != nil && meta.Range.Min != nil {
+			lo, loGot = int64(meta.Range.Min.(int)), true
+		}
+		if meta.Range != nil && meta.Range.Max != nil {
+			hi, hiGot = int64(meta.Range.Max.(int)), true
+		}
+		return int(s.intIn(lo, hi, loGot, hiGot)), nil
+	case "uint":
+		lo, hi := uint64(0), uint64(math.MaxUint64)
+		hiGot := false
+		if meta.Range != nil && meta.Range.Min != nil {
+			lo = uint64(meta.Range.Min.(uint))
+		}
+		if meta.Range != nil && meta.Range.Max != nil {
+			hi, hiGot = uint64(meta.Range.Max.(uint)), true
+		}
+		if !hiGot && hi-lo > 100 {
+			hi = lo + 100
+		}
+		if s.rand == nil {
+			return uint(lo), nil
+		}
+		return uint(lo + s.randUint64(hi-lo)), nil
+	case "float":
+		return s.floatValue(meta), nil
+	case "bool":
+		return s.rand != nil && s.rand.Intn(2) == 1, nil
+	case "string":
+		if s.rand == nil {
+			return "sample", nil
+		}
+		letters := make([]byte, 1+s.rand.Intn(8))
+		for i := range letters {
+			letters[i] = byte('a' + s.rand.Intn(26))
+		}
+		return string(letters), nil
+	case "duration":
+		return s.durationValue(meta)
+	}
+	return nil, nil
+}
+
+// intIn 返回[lo, hi]范围内的整数, 只有一侧有界时另一侧取距离边界100的值, 两侧都无界时范围为[0, 100].
+// 确定样例数据为最小值, 没有最小值时为不超过最大值的0.
+func (s *Sampler) intIn(lo int64, hi int64, loGot bool, hiGot bool) int64 {
+	switch {
+	case !loGot && !hiGot:
+		lo, hi = 0, 100
+	case !loGot:
+		lo = hi - 100
+		if hi < math.MinInt64+100 {
+			lo = math.MinInt64
+		}
+		if s.rand == nil {
+			if hi >= 0 {
+				return 0
+			}
+			return hi
+		}
+	case !hiGot:
+		hi = lo + 100
+		if lo > math.MaxInt64-100 {
+			hi = math.MaxInt64
+		}
+	}
+
+	if s.rand == nil {
+		return lo
+	}
+	return lo + int64(s.randUint64(uint64(hi-lo)))
+}
+
+// randUint64 返回[0, n]范围内的随机数
+func (s *Sampler) randUint64(n uint64) uint64 {
+	if n == math.MaxUint64 {
+		return s.rand.Uint64()
+	}
+	return s.rand.Uint64() % (n + 1)
+}
+
+// floatValue 生成浮点数参数元信息meta的数据, 范围规则与 intIn 相同.
+// 要求带小数点的参数(见 ParamMeta.Decimal)的数据不能是整数, 否则编码后无法通过校验.
+func (s *Sampler) floatValue(meta ParamMeta) float64 {
+	lo, hi := 0.0, 100.0
+	loGot, hiGot := false, false
+	if meta.Range != nil && meta.Range.Min != nil {
+		lo, loGot = meta.Range.Min.(float64), true
+	}
+	if meta.Range != nil && meta.Range.Max != nil {
+		hi, hiGot = meta.Range.Max.(float64), true
+	}
+	switch {
+	case loGot && !hiGot:
+		hi = lo + 100
+	case !loGot && hiGot:
+		lo = hi - 100
+	}
+
+	value := lo
+	if s.rand != nil {
+		value = lo + s.rand.Float64()*(hi-lo)
+	} else if !loGot && hiGot {
+		value = math.Min(0, hi)
+	}
+
+	if meta.Decimal != nil && *meta.Decimal && value == math.Trunc(value) {
+		if value+0.5 <= hi {
+			value += 0.5
+		} else if value-0.5 >= lo {
+			value -= 0.5
+		}
+	}
+	return value
+}
+
+// durationValue 生成时长参数元信息meta的数据, 范围规则与 intIn 相同, 只有一侧有界时另一侧取距离边界100个单位的值.
+// 范围的边界可以是时长字符串或者 time.Duration, 见 rangeDuration, 边界无效时返回错误信息.
+func (s *Sampler) durationValue(meta ParamMeta) (time.Duration, error) {
+	unit := "ns"
+	if meta.Unit != nil {
+		unit = *meta.Unit
+	}
+	span, err := ConvertUnit(100, unit, "ns")
+	if err != nil || span >= math.MaxInt64 {
+		span = float64(time.Second)
+	}
+
+	var lo, hi int64
+	loGot, hiGot := false, false
+	if meta.Range != nil && meta.Range.Min != nil {
+		d, err := rangeDuration(meta.Range.Min)
+		if err != nil {
+			return 0, fmt.Errorf("range: min: %s", err)
+		}
+		lo, loGot = int64(d), true
+	}
+	if meta.Range != nil && meta.Range.Max != nil {
+		d, err := rangeDuration(meta.Range.Max)
+		if err != nil {
+			return 0, fmt.Errorf("range: max: %s", err)
+		}
+		hi, hiGot = int64(d), true
+	}
+
+	// NOTE: 时长类型的范围约束至少有最小值或最大值, 这里只需要补全另一侧
+	switch {
+	case loGot && !hiGot:
+		hi = lo + int64(span)
+		if lo > math.MaxInt64-int64(span) {
+			hi = math.MaxInt64
+		}
+	case !loGot && hiGot:
+		lo = hi - int64(span)
+		if hi < math.MinInt64+int64(span) {
+			lo = math.MinInt64
+		}
+		if s.rand == nil {
+			if hi >= 0 {
+				return 0, nil
+			}
+			return time.Duration(hi), nil
+		}
+	case !loGot && !hiGot:
+		lo, hi = 0, int64(span)
+	}
+
+	if s.rand == nil {
+		return time.Duration(lo), nil
+	}
+	return time.Duration(lo + int64(s.randUint64(uint64(hi-lo)))), nil
+}
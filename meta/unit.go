@@ -0,0 +1,169 @@
+package meta
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"math"
+	"strings"
+	"sync"
+)
+
+// unitInfo 为单位信息, 单位的数值value换算为所属量纲基本单位的数值为 value*scale + offset
+type unitInfo struct {
+	dimension string  // 所属量纲
+	scale     float64 // 换算到基本单位的比例
+	offset    float64 // 换算到基本单位的偏移
+}
+
+var (
+	unitLock sync.RWMutex
+	units    = map[string]unitInfo{
+		// 角度, 基本单位为弧度
+		"rad": {"angle", 1, 0},
+		"°":   {"angle", math.Pi / 180, 0},
+		"度":   {"angle", math.Pi / 180, 0},
+		"deg": {"angle", math.Pi / 180, 0},
+
+		// 温度, 基本单位为开尔文
+		"K":  {"temperature", 1, 0},
+		"℃":  {"temperature", 1, 273.15},
+		"°C": {"temperature", 1, 273.15},
+		"℉":  {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+		"°F": {"temperature", 5.0 / 9.0, 459.67 * 5.0 / 9.0},
+
+		// 长度, 基本单位为米
+		"m":  {"length", 1, 0},
+		"km": {"length", 1000, 0},
+		"cm": {"length", 0.01, 0},
+		"mm": {"length", 0.001, 0},
+
+		// 时间, 基本单位为秒
+		"s":   {"time", 1, 0},
+		"ms":  {"time", 1e-3, 0},
+		"us":  {"time", 1e-6, 0},
+		"ns":  {"time", 1e-9, 0},
+		"min": {"time", 60, 0},
+		"h":   {"time", 3600, 0},
+
+		// 电流, 基本单位为安培
+		"A":  {"current", 1, 0},
+		"mA": {"current", 1e-3, 0},
+
+		// 电压, 基本单位为伏特
+		"V":  {"voltage", 1, 0},
+		"mV": {"voltage", 1e-3, 0},
+		"kV": {"voltage", 1e3, 0},
+
+		// 角速度, 基本单位为弧度每秒
+		"rad/s": {"angularVelocity", 1, 0},
+		"°/s":   {"angularVelocity", math.Pi / 180, 0},
+		"rpm":   {"angularVelocity", 2 * math.Pi / 60, 0},
+	}
+)
+
+// RegisterUnit 注册所属量纲为dimension的单位unit, 单位的数值value换算为所属量纲基本单位的数值为 value*scale + offset.
+// 单位已经注册或者参数无效时返回错误信息. 注册后, 元信息中声明量纲为dimension的参数可以使用单位unit,
+// 并且可以通过 ConvertUnit 在同一量纲的单位之间换算.
+// NOTE: 应该在解析元信息前注册单位.
+func RegisterUnit(unit string, dimension string, scale float64, offset float64) error {
+	unit = strings.TrimSpace(unit)
+	dimension = strings.TrimSpace(dimension)
+	if unit == "" {
+		return fmt.Errorf("unit is empty")
+	}
+	if dimension == "" {
+		return fmt.Errorf("dimension is empty")
+	}
+	if scale == 0 || math.IsNaN(scale) || math.IsInf(scale, 0) {
+		return fmt.Errorf("invalid scale")
+	}
+	if math.IsNaN(offset) || math.IsInf(offset, 0) {
+		return fmt.Errorf("invalid offset")
+	}
+
+	unitLock.Lock()
+	defer unitLock.Unlock()
+	if _, seen := units[unit]; seen {
+		return fmt.Errorf("unit %q already registered", unit)
+	}
+	units[unit] = unitInfo{
+		dimension: dimension,
+		scale:     scale,
+		offset:    offset,
+	}
+	return nil
+}
+
+// DimensionOf 返回单位unit所属的量纲, 单位未注册时返回false.
+func DimensionOf(unit string) (string, bool) {
+	unitLock.RLock()
+	defer unitLock.RUnlock()
+	info, seen := units[unit]
+	return info.dimension, seen
+}
+
+// ConvertUnit 将单位为from的数值value换算为单位为to的数值, 返回换算结果和错误信息.
+// 单位未注册或者两个单位不属于同一量纲时返回错误信息.
+func ConvertUnit(value float64, from string, to string) (float64, error) {
+	unitLock.RLock()
+	defer unitLock.RUnlock()
+
+	fromInfo, seen := units[from]
+	if !seen {
+		return 0, fmt.Errorf("unknown unit %q", from)
+	}
+
+	toInfo, seen := units[to]
+	if !seen {
+		return 0, fmt.Errorf("unknown unit %q", to)
+	}
+
+	if fromInfo.dimension != toInfo.dimension {
+		return 0, fmt.Errorf("unit %q and %q: dimension unmatched", from, to)
+	}
+
+	base := value*fromInfo.scale + fromInfo.offset
+	return (base - toInfo.offset) / toInfo.scale, nil
+}
+
+// UnitOf 返回元信息m中名为stateName的状态的单位, 状态不存在或者没有单位时返回false.
+func (m *Meta) UnitOf(stateName string) (string, bool) {
+	index, seen := m.stateIndex[stateName]
+	if !seen || m.State[index].Unit == nil {
+		return "", false
+	}
+	return *m.State[index].Unit, true
+}
+
+// checkDimension 检查参数元信息obj的dimension字段, 声明了量纲时单位必须是该量纲下已注册的单位
+func checkDimension(obj jsoniter.Any) error {
+	dimension := obj.Get("dimension")
+	if dimension.LastError() != nil {
+		return nil
+	}
+
+	// dimension字段必须是字符串类型
+	if dimension.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("dimension is NOT string")
+	}
+
+	// dimension不能是空字符串
+	dimensionStr := strings.TrimSpace(dimension.ToString())
+	if dimensionStr == "" {
+		return fmt.Errorf("dimension is empty")
+	}
+
+	// 声明了量纲就必须有单位
+	unit := obj.Get("unit")
+	if unit.LastError() != nil {
+		return fmt.Errorf("unit NOT exist for dimension %q", dimensionStr)
+	}
+
+	// 单位必须属于该量纲
+	unitStr := strings.TrimSpace(unit.ToString())
+	if got, seen := DimensionOf(unitStr); !seen || got != dimensionStr {
+		return fmt.Errorf("unit %q NOT belong to dimension %q", unitStr, dimensionStr)
+	}
+
+	return nil
+}
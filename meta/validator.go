@@ -0,0 +1,96 @@
+package meta
+
+import (
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"strings"
+	"sync"
+)
+
+// ValidatorFunc 为自定义校验函数, 参数meta为被校验参数的元信息, 参数raw为参数数据,
+// 数据符合自定义格式时返回nil, 否则返回错误信息.
+type ValidatorFunc func(meta ParamMeta, raw jsoniter.Any) error
+
+var (
+	validatorLock sync.RWMutex
+	validators    = map[string]ValidatorFunc{}
+)
+
+// formatTypes 为可以声明format字段的参数类型, 自定义校验只针对叶子类型
+var formatTypes = map[string]struct{}{
+	"bool":     {},
+	"int":      {},
+	"uint":     {},
+	"float":    {},
+	"string":   {},
+	"duration": {},
+}
+
+// RegisterValidator 注册名为format的自定义校验函数fn, 格式已经注册或者参数无效时返回错误信息.
+// 元信息中声明了 "format": format 的参数在通过内置的类型和范围校验后, 还需要通过fn的校验, 例如:
+//
+//	_ = meta.RegisterValidator("uuid", func(_ meta.ParamMeta, raw jsoniter.Any) error {
+//		_, err := uuid.Parse(raw.ToString())
+//		return err
+//	})
+//
+// 之后 {"name": "id", "type": "string", "format": "uuid"} 只接受有效的UUID字符串.
+// 注册表是进程全局的, 所有元信息共用, RegisterValidator 可以在多个协程中并发调用.
+// 没有注册的格式不做自定义校验, 因此对端声明了本端未知的格式也能正常解析元信息.
+// NOTE: fn会在校验数据的协程中并发调用, 必须是并发安全的.
+func RegisterValidator(format string, fn ValidatorFunc) error {
+	format = strings.TrimSpace(format)
+	if format == "" {
+		return fmt.Errorf("format is empty")
+	}
+	if fn == nil {
+		return fmt.Errorf("nil validator")
+	}
+
+	validatorLock.Lock()
+	defer validatorLock.Unlock()
+	if _, seen := validators[format]; seen {
+		return fmt.Errorf("format %q already registered", format)
+	}
+	validators[format] = fn
+	return nil
+}
+
+// checkFormat 检查参数元信息obj的format字段, format字段可选, 若存在必须是非空字符串, 并且只能用于叶子类型
+func checkFormat(obj jsoniter.Any, typeStr string) error {
+	format := obj.Get("format")
+	if format.LastError() != nil {
+		return nil
+	}
+
+	if format.ValueType() != jsoniter.StringValue {
+		return fmt.Errorf("format is NOT string")
+	}
+	if strings.TrimSpace(format.ToString()) == "" {
+		return fmt.Errorf("format is empty")
+	}
+	if _, seen := formatTypes[typeStr]; !seen {
+		return fmt.Errorf("format is NOT allowed for %s", typeStr)
+	}
+
+	return nil
+}
+
+// verifyFormat 以参数元信息meta中声明的格式对应的自定义校验函数校验数据raw, 没有声明格式或者格式未注册时返回nil
+func verifyFormat(meta ParamMeta, raw jsoniter.Any) error {
+	if meta.Format == nil {
+		return nil
+	}
+
+	validatorLock.RLock()
+	fn, seen := validators[*meta.Format]
+	validatorLock.RUnlock()
+	if !seen {
+		return nil
+	}
+
+	if err := fn(meta, raw); err != nil {
+		return fmt.Errorf("invalid %s: %s", *meta.Format, err)
+	}
+	return nil
+}
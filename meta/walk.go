@@ -0,0 +1,64 @@
+package meta
+
+// WalkParams 按照状态、事件、方法的声明顺序遍历物模型元信息m中的所有叶子参数(非结构体、数组、切片和联合类型的参数),
+// 对每个叶子参数调用fn, 参数path为叶子参数的完整路径, 参数pm为叶子参数的元信息, fn返回false时停止遍历.
+// 路径以参数所属的类别开头, 结构体字段以"."连接, 数组和切片的元素以"[]"表示, 联合类型的变体以"[类型标识字段名=类型标识值]"表示, 例如:
+//
+//	state.tpqsInfo.errors[].code
+//	event.qsAction.motors[].rov
+//	method.QS.args.angle
+//	method.QS.response.res
+//	state.cmds[][type=move].angle
+//
+// 联合类型的变体按照类型标识值的字典序遍历.
+func (m *Meta) WalkParams(fn func(path string, pm ParamMeta) bool) {
+	for _, state := range m.State {
+		if !walkParam("state."+*state.Name, state, fn) {
+			return
+		}
+	}
+
+	for _, event := range m.Event {
+		for _, arg := range event.Args {
+			if !walkParam("event."+event.Name+"."+*arg.Name, arg, fn) {
+				return
+			}
+		}
+	}
+
+	for _, method := range m.Method {
+		for _, arg := range method.Args {
+			if !walkParam("method."+method.Name+".args."+*arg.Name, arg, fn) {
+				return
+			}
+		}
+		for _, resp := range method.Response {
+			if !walkParam("method."+method.Name+".response."+*resp.Name, resp, fn) {
+				return
+			}
+		}
+	}
+}
+
+// walkParam 递归遍历路径为path的参数param中的所有叶子参数, fn返回false时停止遍历并返回false
+func walkParam(path string, param ParamMeta, fn func(path string, pm ParamMeta) bool) bool {
+	switch param.Type {
+	case "struct":
+		for _, field := range param.Fields {
+			if !walkParam(path+"."+*field.Name, field, fn) {
+				return false
+			}
+		}
+		return true
+	case "array", "slice":
+		return walkParam(path+"[]", *param.Element, fn)
+	case "union":
+		for _, key := range sortedVariants(param.Variants) {
+			if !walkParam(path+"["+*param.Discriminator+"="+key+"]", param.Variants[key], fn) {
+				return false
+			}
+		}
+		return true
+	}
+	return fn(path, param)
+}
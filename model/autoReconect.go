@@ -66,7 +66,7 @@ func WithOnReConnect(onReConnect OnReConnect) AutoConnectorOption {
 }
 
 // WithConnOption 配置自动重连对象所包含连接的连接设置, 如状态回调和事件回调.
-// AutoConnector 会覆盖 WithClosedHandler 和 WithClosedFunc 所配置的连接关闭处理逻辑.
+// AutoConnector 会覆盖 WithClosedHandler、WithClosedFunc 和 WithCloseReasonFunc 所配置的连接关闭处理逻辑.
 func WithConnOption(connOption ...ConnOption) AutoConnectorOption {
 	return func(a *AutoConnector) {
 		a.connOptions = connOption
@@ -123,6 +123,24 @@ func (a *AutoConnector) SubState(states []string) error {
 	return a.Connection.SubState(states)
 }
 
+// SubStateAck 通过建立的连接订阅状态并等待对端的订阅确认, 返回对端的有效状态订阅列表和错误信息.
+// 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) SubStateAck(states []string) ([]string, error) {
+	a.mutex.Lock()
+	a.subStates = map[string]struct{}{}
+	for _, state := range states {
+		a.subStates[state] = struct{}{}
+	}
+	conn := a.Connection
+	a.mutex.Unlock()
+
+	// NOTE: 等待订阅确认时不持有锁, 避免阻塞连接的恢复
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	return conn.SubStateAck(states)
+}
+
 // AddSubState 通过建立的连接添加状态订阅, 若连接未建立或未恢复, 返回错误信息.
 func (a *AutoConnector) AddSubState(states []string) error {
 	a.mutex.Lock()
@@ -136,6 +154,49 @@ func (a *AutoConnector) AddSubState(states []string) error {
 	return a.Connection.AddSubState(states)
 }
 
+// SubStateWithSnapshot 通过建立的连接订阅状态并请求立即推送状态的最新值, 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) SubStateWithSnapshot(states []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.subStates = map[string]struct{}{}
+	for _, state := range states {
+		a.subStates[state] = struct{}{}
+	}
+	if a.Connection == nil {
+		return errors.New("nil connection")
+	}
+	return a.Connection.SubStateWithSnapshot(states)
+}
+
+// AddSubStateWithSnapshot 通过建立的连接添加状态订阅并请求立即推送状态的最新值, 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) AddSubStateWithSnapshot(states []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, state := range states {
+		a.subStates[state] = struct{}{}
+	}
+	if a.Connection == nil {
+		return errors.New("nil connection")
+	}
+	return a.Connection.AddSubStateWithSnapshot(states)
+}
+
+// UpdateSubState 通过建立的连接同时新增和取消状态订阅, 见 Connection.UpdateSubState, 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) UpdateSubState(add []string, remove []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, state := range remove {
+		delete(a.subStates, state)
+	}
+	for _, state := range add {
+		a.subStates[state] = struct{}{}
+	}
+	if a.Connection == nil {
+		return errors.New("nil connection")
+	}
+	return a.Connection.UpdateSubState(add, remove)
+}
+
 // CancelSubState 通过建立的连接取消状态订阅, 若连接未建立或未恢复, 返回错误信息.
 func (a *AutoConnector) CancelSubState(states []string) error {
 	a.mutex.Lock()
@@ -174,6 +235,24 @@ func (a *AutoConnector) SubEvent(events []string) error {
 	return a.Connection.SubEvent(events)
 }
 
+// SubEventAck 通过建立的连接订阅事件并等待对端的订阅确认, 返回对端的有效事件订阅列表和错误信息.
+// 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) SubEventAck(events []string) ([]string, error) {
+	a.mutex.Lock()
+	a.subEvents = map[string]struct{}{}
+	for _, event := range events {
+		a.subEvents[event] = struct{}{}
+	}
+	conn := a.Connection
+	a.mutex.Unlock()
+
+	// NOTE: 等待订阅确认时不持有锁, 避免阻塞连接的恢复
+	if conn == nil {
+		return nil, errors.New("nil connection")
+	}
+	return conn.SubEventAck(events)
+}
+
 // AddSubEvent 通过建立的连接添加事件订阅, 若连接未建立或未恢复, 返回错误信息.
 func (a *AutoConnector) AddSubEvent(events []string) error {
 	a.mutex.Lock()
@@ -187,6 +266,22 @@ func (a *AutoConnector) AddSubEvent(events []string) error {
 	return a.Connection.AddSubEvent(events)
 }
 
+// UpdateSubEvent 通过建立的连接同时新增和取消事件订阅, 见 Connection.UpdateSubEvent, 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) UpdateSubEvent(add []string, remove []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, event := range remove {
+		delete(a.subEvents, event)
+	}
+	for _, event := range add {
+		a.subEvents[event] = struct{}{}
+	}
+	if a.Connection == nil {
+		return errors.New("nil connection")
+	}
+	return a.Connection.UpdateSubEvent(add, remove)
+}
+
 // CancelSubEvent 通过建立的连接取消事件订阅, 若连接未建立或未恢复, 返回错误信息.
 func (a *AutoConnector) CancelSubEvent(events []string) error {
 	a.mutex.Lock()
@@ -261,6 +356,27 @@ func (a *AutoConnector) CallFor(fullName string, args message.Args, timeout time
 	return a.Connection.CallFor(fullName, args, timeout)
 }
 
+// Ping 通过建立的连接发送ping报文并等待对端的pong报文, 返回往返时间和错误信息.
+// 若连接未建立或未恢复, 返回错误信息.
+func (a *AutoConnector) Ping(timeout time.Duration) (time.Duration, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.Connection == nil {
+		return 0, errors.New("nil connection")
+	}
+	return a.Connection.Ping(timeout)
+}
+
+// PeerMeta 非阻塞地返回通过建立的连接已经获取的对端元信息, 若连接未建立或未恢复, 或者尚未获取对端元信息, 返回空元信息和false.
+func (a *AutoConnector) PeerMeta() (*meta.Meta, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.Connection == nil {
+		return meta.NewEmptyMeta(), false
+	}
+	return a.Connection.PeerMeta()
+}
+
 // GetPeerMeta 通过建立的连接获取对端元信息, 若连接未建立或未恢复, 返回空元信息和错误信息.
 func (a *AutoConnector) GetPeerMeta() (*meta.Meta, error) {
 	a.mutex.RLock()
@@ -325,8 +441,13 @@ func (a *AutoConnector) setConn(connection *Connection) {
 	a.Connection = connection
 	if connection != nil {
 		// 连接建立成功要恢复之前状态和事件订阅
-		_ = a.Connection.SubEvent(set2slice(a.subEvents))
-		_ = a.Connection.SubState(set2slice(a.subStates))
+		// NOTE: 没有订阅时不发送空的设置订阅报文, 避免覆盖通过 WithAutoSubState 等配置的自动订阅
+		if len(a.subEvents) > 0 {
+			_ = a.Connection.SubEvent(set2slice(a.subEvents))
+		}
+		if len(a.subStates) > 0 {
+			_ = a.Connection.SubState(set2slice(a.subStates))
+		}
 	}
 	a.mutex.Unlock()
 }
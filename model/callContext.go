@@ -0,0 +1,81 @@
+package model
+
+import (
+	"context"
+	"github.com/object-model/goModel/message"
+)
+
+// CallContext 为调用请求的上下文, 包括收到调用请求的连接、调用请求的UUID、调用方附加的头部和回调的取消信号,
+// 见 CallRequestContextHandler 和 Connection.InvokeWithHeaders.
+type CallContext struct {
+	conn    *Connection       // 收到调用请求的连接
+	uuid    string            // 调用请求的UUID
+	headers map[string]string // 调用方附加的头部
+	ctx     context.Context   // 回调的取消信号, 为nil表示不会被取消, 见 WithCallHandlerTimeout
+}
+
+// Conn 返回收到调用请求的连接
+func (c *CallContext) Conn() *Connection {
+	return c.conn
+}
+
+// UUID 返回调用请求的UUID
+func (c *CallContext) UUID() string {
+	return c.uuid
+}
+
+// Headers 返回调用方通过 Connection.InvokeWithHeaders 附加的头部的副本, 没有附加头部时返回nil.
+// NOTE: 头部不根据元信息校验, 其内容和格式由调用双方约定, 使用前需要自行检查
+func (c *CallContext) Headers() map[string]string {
+	if len(c.headers) == 0 {
+		return nil
+	}
+	ans := make(map[string]string, len(c.headers))
+	for key, value := range c.headers {
+		ans[key] = value
+	}
+	return ans
+}
+
+// Header 返回调用方附加的名称为key的头部, 不存在时返回空字符串
+func (c *CallContext) Header(key string) string {
+	return c.headers[key]
+}
+
+// Context 返回调用请求回调的 context.Context, 配置了 WithCallHandlerTimeout 时回调超时后被取消,
+// 其 Err 返回 context.DeadlineExceeded, 没有配置时返回不会被取消的 context.Background.
+// 耗时的回调应将其传递给耗时操作或者检查 Done, 超时后及时返回以释放资源.
+func (c *CallContext) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Done 返回回调超时后被关闭的管道, 等同于 c.Context().Done(), 没有配置 WithCallHandlerTimeout 时返回nil.
+func (c *CallContext) Done() <-chan struct{} {
+	return c.Context().Done()
+}
+
+// CallRequestContextHandler 为携带调用上下文的调用请求处理接口, 参数ctx为调用请求的上下文,
+// 处理调用请求时可以通过ctx获取收到调用请求的连接和调用方附加的头部(例如追踪ID).
+type CallRequestContextHandler interface {
+	OnCallReqContext(ctx *CallContext, name string, args message.RawArgs) message.Resp
+}
+
+// CallRequestContextFunc 为携带调用上下文的调用请求回调函数, 参数ctx为调用请求的上下文,
+// 参数name为调用的方法名, 参数args为调用参数, 函数返回值为调用请求的返回值.
+type CallRequestContextFunc func(ctx *CallContext, name string, args message.RawArgs) message.Resp
+
+func (c CallRequestContextFunc) OnCallReqContext(ctx *CallContext, name string, args message.RawArgs) message.Resp {
+	return c(ctx, name, args)
+}
+
+// callConnAdapter 将 CallRequestConnHandler 适配为 CallRequestContextHandler
+type callConnAdapter struct {
+	CallRequestConnHandler
+}
+
+func (c callConnAdapter) OnCallReqContext(ctx *CallContext, name string, args message.RawArgs) message.Resp {
+	return c.OnCallReqConn(ctx.Conn(), name, args)
+}
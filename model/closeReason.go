@@ -0,0 +1,104 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/object-model/goModel/rawConn"
+	"io"
+	"net"
+)
+
+// CloseKind 为连接关闭原因的类别
+type CloseKind int
+
+const (
+	CloseKindUnknown          CloseKind = iota // 未归类的关闭原因, 如底层连接的其他读取错误
+	CloseKindEOF                               // 对端关闭了连接
+	CloseKindActive                            // 本端调用 Connection.Close 或 Connection.CloseWithReason 主动关闭
+	CloseKindPeer                              // 对端发送关闭报文后关闭了连接, 见 Connection.CloseWithReason
+	CloseKindDecodeError                       // 收到无法解析的报文
+	CloseKindWriteError                        // 写入底层连接失败
+	CloseKindTimeout                           // 读取底层连接超时
+	CloseKindHeartbeatTimeout                  // 心跳超时, 见 rawConn.ErrHeartbeatTimeout
+	CloseKindSubLimit                          // 对端的订阅数量超出上限, 见 WithSubLimit
+)
+
+func (k CloseKind) String() string {
+	switch k {
+	case CloseKindUnknown:
+		return "unknown"
+	case CloseKindEOF:
+		return "EOF"
+	case CloseKindActive:
+		return "active close"
+	case CloseKindPeer:
+		return "peer closed"
+	case CloseKindDecodeError:
+		return "decode error"
+	case CloseKindWriteError:
+		return "write error"
+	case CloseKindTimeout:
+		return "timeout"
+	case CloseKindHeartbeatTimeout:
+		return "heartbeat timeout"
+	case CloseKindSubLimit:
+		return "sub limit"
+	default:
+		return fmt.Sprintf("CloseKind(%d)", int(k))
+	}
+}
+
+// CloseReason 为结构化的连接关闭原因, Kind 为关闭原因类别, 可以用来区分正常关闭和协议错误,
+// Detail 为关闭原因的详细描述, 与 ClosedHandler 收到的关闭原因相同.
+type CloseReason struct {
+	Kind   CloseKind
+	Detail string
+}
+
+func (r CloseReason) String() string {
+	return r.Kind.String() + ": " + r.Detail
+}
+
+// Expected 返回连接是否为正常关闭, 即本端主动关闭、对端关闭或者对端通过关闭报文告知后关闭.
+func (r CloseReason) Expected() bool {
+	return r.Kind == CloseKindEOF || r.Kind == CloseKindActive || r.Kind == CloseKindPeer
+}
+
+// CloseReasonHandler 为携带结构化关闭原因的连接关闭处理接口, 是 ClosedHandler 的扩展.
+// 通过 WithClosedHandler 配置的回调对象若同时实现了 CloseReasonHandler, 连接关闭时调用 OnClose 代替 OnClosed.
+type CloseReasonHandler interface {
+	ClosedHandler
+	OnClose(reason CloseReason)
+}
+
+// CloseReasonFunc 为携带结构化关闭原因的连接关闭回调函数, 实现了 CloseReasonHandler,
+// 作为 ClosedHandler 使用时 OnClosed 将字符串关闭原因转换为类别为 CloseKindUnknown 的 CloseReason.
+type CloseReasonFunc func(reason CloseReason)
+
+func (c CloseReasonFunc) OnClose(reason CloseReason) {
+	c(reason)
+}
+
+func (c CloseReasonFunc) OnClosed(reason string) {
+	c(CloseReason{Kind: CloseKindUnknown, Detail: reason})
+}
+
+// readErrKind 返回读取底层连接的错误err对应的关闭原因类别
+func readErrKind(err error) CloseKind {
+	if errors.Is(err, rawConn.ErrHeartbeatTimeout) {
+		return CloseKindHeartbeatTimeout
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return CloseKindTimeout
+	}
+
+	var closeErr *websocket.CloseError
+	if errors.Is(err, io.EOF) || errors.As(err, &closeErr) {
+		return CloseKindEOF
+	}
+
+	return CloseKindUnknown
+}
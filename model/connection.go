@@ -1,6 +1,8 @@
 package model
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
@@ -8,16 +10,30 @@ import (
 	"github.com/object-model/goModel/message"
 	"github.com/object-model/goModel/meta"
 	"github.com/object-model/goModel/rawConn"
+	"net"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	json = jsoniter.ConfigCompatibleWithStandardLibrary
+	json = message.JSON()
 )
 
-// StateHandler 状态报文处理接口
+// SetJSONConfig 将物模型、元信息和报文编解码使用的JSON配置统一替换为config, 等同于同时调用
+// message.SetJSONConfig 和 meta.SetJSONConfig, 保证编码、解码和校验的行为一致, 默认为 message.DefaultJSONConfig.
+// 用于有特殊需求的用户, 例如关闭HTML转义或者以6位精度编码浮点数, 替换配置的风险见 message.SetJSONConfig.
+// NOTE: 配置是进程全局的, 必须在创建物模型和建立连接之前调用, 例如在init或者main函数开始时, 不能与编解码并发调用
+func SetJSONConfig(config jsoniter.Config) {
+	message.SetJSONConfig(config)
+	meta.SetJSONConfig(config)
+	json = message.JSON()
+}
+
+// StateHandler 状态报文处理接口. 对端清除状态(见 Model.ClearState)时, OnState 收到的状态数据data为nil,
+// 其他状态报文的状态数据都是非空的JSON原始数据.
 type StateHandler interface {
 	OnState(modelName string, stateName string, data []byte)
 }
@@ -27,7 +43,21 @@ type EventHandler interface {
 	OnEvent(modelName string, eventName string, args message.RawArgs)
 }
 
-// ClosedHandler 连接关闭处理接口
+// StateSeqHandler 为携带推送序号的状态报文处理接口, 是 StateHandler 的扩展.
+// 通过 WithStateHandler 配置的回调对象若同时实现了 StateSeqHandler, 连接收到状态报文时调用 OnStateSeq 代替 OnState,
+// 参数seq为对端的推送序号, 对端没有开启推送序号(见 WithSequence)时为0.
+type StateSeqHandler interface {
+	StateHandler
+	OnStateSeq(modelName string, stateName string, data []byte, seq uint64)
+}
+
+// EventSeqHandler 为携带推送序号的事件报文处理接口, 是 EventHandler 的扩展, 用法与 StateSeqHandler 相同.
+type EventSeqHandler interface {
+	EventHandler
+	OnEventSeq(modelName string, eventName string, args message.RawArgs, seq uint64)
+}
+
+// ClosedHandler 连接关闭处理接口, 需要结构化关闭原因时可以实现扩展接口 CloseReasonHandler
 type ClosedHandler interface {
 	OnClosed(reason string)
 }
@@ -48,6 +78,48 @@ func (e EventFunc) OnEvent(modelName string, eventName string, args message.RawA
 	e(modelName, eventName, args)
 }
 
+// StateSeqFunc 为携带推送序号的状态回调函数, 实现了 StateSeqHandler, 可以通过 WithStateHandler 配置.
+type StateSeqFunc func(modelName string, stateName string, data []byte, seq uint64)
+
+func (s StateSeqFunc) OnState(modelName string, stateName string, data []byte) {
+	s(modelName, stateName, data, 0)
+}
+
+func (s StateSeqFunc) OnStateSeq(modelName string, stateName string, data []byte, seq uint64) {
+	s(modelName, stateName, data, seq)
+}
+
+// EventSeqFunc 为携带推送序号的事件回调函数, 实现了 EventSeqHandler, 可以通过 WithEventHandler 配置.
+type EventSeqFunc func(modelName string, eventName string, args message.RawArgs, seq uint64)
+
+func (e EventSeqFunc) OnEvent(modelName string, eventName string, args message.RawArgs) {
+	e(modelName, eventName, args, 0)
+}
+
+func (e EventSeqFunc) OnEventSeq(modelName string, eventName string, args message.RawArgs, seq uint64) {
+	e(modelName, eventName, args, seq)
+}
+
+// UnknownMessageFunc 为未知类型报文回调函数, 参数msgType为报文类型, 参数payload为报文的原始payload数据.
+type UnknownMessageFunc func(msgType string, payload []byte)
+
+// StateMessage 为通过 Connection.StatesChannel 接收的状态报文
+type StateMessage struct {
+	ModelName string // 物模型名称
+	StateName string // 状态名
+	Data      []byte // 状态数据
+	Seq       uint64 // 推送序号, 对端没有开启推送序号时为0
+	Cleared   bool   // 是否为清除状态报文, 为true时 Data 为nil, 见 Model.ClearState
+}
+
+// EventMessage 为通过 Connection.EventsChannel 接收的事件报文
+type EventMessage struct {
+	ModelName string          // 物模型名称
+	EventName string          // 事件名
+	Args      message.RawArgs // 事件参数
+	Seq       uint64          // 推送序号, 对端没有开启推送序号时为0
+}
+
 // RespFunc 为响应回调函数, 参数resp为响应原始数据, 参数err为响应错误信息
 type RespFunc func(resp message.RawResp, err error)
 
@@ -60,12 +132,17 @@ func (c ClosedFunc) OnClosed(reason string) {
 
 // Connection 为物模型连接,可以通过连接订阅状态和事件、注册状态和事件回调、远程调用方法、查询对端元信息.
 type Connection struct {
+	// NOTE: 以原子操作访问的64位字段必须放在最前面, 保证在32位平台(386、ARM32、MIPS32)上64位对齐
+	droppedCalls    uint64 // 连接关闭时仍未收到响应的请求数量, 原子操作
+	unknownCount    uint64 // 收到的未知类型报文数量, 原子操作
 	m               *Model
-	writeLock       sync.Mutex                // 写入锁, 保护 raw
+	writeLock       sync.Mutex                // 写入锁, 保护 raw pending pendingBytes 和 flushTimer
 	raw             rawConn.RawConn           // 原始连接
 	msgHandlers     map[string]func([]byte)   // 报文处理函数
 	statesLock      sync.RWMutex              // 保护 pubStates
 	pubStates       map[string]struct{}       // 发布状态列表
+	lastStates      map[string][]byte         // 上一次发送的状态报文, 用于状态去重, 由 statesLock 和 lastStatesLock 保护
+	lastStatesLock  sync.Mutex                // 保护 lastStates
 	eventsLock      sync.RWMutex              // 保护 pubEvents
 	pubEvents       map[string]struct{}       // 发布事件列表
 	statesCloseOnce sync.Once                 // 确保 statesChan 只关闭一次
@@ -78,15 +155,76 @@ type Connection struct {
 	eventHandler    EventHandler              // 事件处理回调
 	closedOnce      sync.Once                 // 确保 closedHandler 只调用一次
 	closedHandler   ClosedHandler             // 连接关闭处理函数
+	doneOnce        sync.Once                 // 确保 done 只关闭一次
+	done            chan struct{}             // 连接关闭信号, 见 Connection.Done
+	closeErr        error                     // 连接关闭原因, done 关闭后才能读取
+	closeReason     CloseReason               // 结构化的连接关闭原因, done 关闭后才能读取
 	onMetaOnce      sync.Once                 // 确保只响应元信息报文一次
 	metaGotCh       chan struct{}             // 对端元信息已获取信号
 	peerMeta        *meta.Meta                // 对端的元信息
 	peerMetaErr     error                     // 查询对端元信息的错误
 	waitersLock     sync.Mutex                // 保护 respWaiters
 	respWaiters     map[string]*RespWaiter    // 所有未收到响应的调用等待器
+	callWaitTimeout time.Duration             // Call 的等待超时时间, 为0表示一直等待, 见 WithCallTimeout 和 WithDefaultCallTimeout
+	subAckTimeout   time.Duration             // 等待订阅确认的超时时间, 见 WithSubAckTimeout
+	flushTimeout    time.Duration             // 关闭连接时等待缓存的报文发送完成的最长时间, 见 closeFlushTimeout
 	uidCreator      func() string             // uuid生成器
+	validateSubs    bool                      // 订阅前是否根据对端元信息校验订阅列表
+	autoSubStates   []string                  // 连接建立后自动订阅的状态, 见 WithAutoSubState
+	autoSubEvents   []string                  // 连接建立后自动订阅的事件, 见 WithAutoSubEvent
+	unknownHandler  UnknownMessageFunc        // 未知类型报文回调
+	outChansLock    sync.Mutex                // 保护 stateOutChans eventOutChans statesDone eventsDone
+	stateOutChans   []chan StateMessage       // 通过 StatesChannel 注册的状态管道
+	eventOutChans   []chan EventMessage       // 通过 EventsChannel 注册的事件管道
+	statesDone      bool                      // dealState 是否已经退出
+	eventsDone      bool                      // dealEvent 是否已经退出
+	coalesceDelay   time.Duration             // 合并发送状态和事件报文的最大延时, 为0表示不合并
+	coalesceBytes   int                       // 合并发送的字节数阈值
+	pending         [][]byte                  // 等待合并发送的报文
+	pendingBytes    int                       // 等待合并发送的报文总字节数
+	flushTimer      *time.Timer               // 合并发送定时器
+	sendQueue       *sendQueue                // 优先级发送队列, 为nil表示直接写入底层连接, 见 WithPrioritySend
+	peerCloseReason string                    // 对端通过关闭报文告知的关闭原因, 只在 dealReceive 中访问
+	tap             TapFunc                   // 抓包回调
+	ctxLock         sync.RWMutex              // 保护 ctx
+	ctx             connContext               // 使用者附加到连接的数据, 见 SetContext
+}
+
+// connContext 为使用者附加到连接上的数据, 见 Connection.SetContext
+type connContext map[interface{}]interface{}
+
+// subLimitReason 为订阅数量超出上限时关闭连接的原因
+const subLimitReason = "too many subscriptions"
+
+// closeFlushTimeout 为关闭连接时等待缓存的报文发送完成的最长时间, 见 Connection.flushFor
+const closeFlushTimeout = time.Second
+
+// DefaultSubAckTimeout 为 Connection.SubStateAck 和 Connection.SubEventAck 等待订阅确认的默认超时时间, 见 WithSubAckTimeout
+const DefaultSubAckTimeout = 10 * time.Second
+
+// Direction 为报文方向, 以本端为参照
+type Direction int
+
+const (
+	Inbound  Direction = iota // 从对端接收的报文
+	Outbound                  // 向对端发送的报文
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Inbound:
+		return "inbound"
+	case Outbound:
+		return "outbound"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
 }
 
+// TapFunc 为抓包回调函数, 参数dir为报文方向, 参数data为收发的完整报文原始数据.
+// NOTE: data在回调返回后可能被复用, 需要保存时必须复制
+type TapFunc func(dir Direction, data []byte)
+
 // ConnOption 为创建连接选项
 type ConnOption func(*Connection)
 
@@ -144,6 +282,15 @@ func WithClosedFunc(onClose ClosedFunc) ConnOption {
 	}
 }
 
+// WithCloseReasonFunc 配置携带结构化关闭原因的连接关闭回调函数
+func WithCloseReasonFunc(onClose CloseReasonFunc) ConnOption {
+	return func(connection *Connection) {
+		if onClose != nil {
+			connection.closedHandler = onClose
+		}
+	}
+}
+
 // WithStateBuffSize 配置连接的状态管道的大小
 func WithStateBuffSize(size int) ConnOption {
 	return func(connection *Connection) {
@@ -162,24 +309,153 @@ func WithEventBuffSize(size int) ConnOption {
 	}
 }
 
+// WithValidatedSubscriptions 开启连接的订阅校验选项.
+// 开启后, 订阅或添加订阅状态和事件前, 会先通过 Connection.GetPeerMeta 获取对端元信息,
+// 若订阅列表中存在对端元信息中不存在的状态或事件, 则不发送订阅报文并返回错误信息, 用于及早发现订阅名称错误.
+// NOTE: 通过代理订阅其他物模型的状态和事件时, 对端元信息为代理本身的元信息, 不能开启该选项.
+func WithValidatedSubscriptions() ConnOption {
+	return func(connection *Connection) {
+		connection.validateSubs = true
+	}
+}
+
+// WithAutoSubState 配置连接建立后自动订阅的状态列表states, 省去建立连接后手动调用 Connection.AddSubState.
+// 自动订阅以添加订阅的方式发送, 不会覆盖其他订阅, 多次配置时列表合并.
+// 未开启 WithValidatedSubscriptions 时, 自动订阅报文在连接开始接收报文之前发送(先事件后状态),
+// 因此任何状态和事件回调都在订阅报文发送之后才会被调用, Dial 和 Attach 返回时订阅报文不一定已经发送.
+// 开启 WithValidatedSubscriptions 时, 自动订阅在收到对端元信息后才发送, 对端元信息中不存在的订阅列表不会发送,
+// 此时回调可能先于订阅被调用(例如对端主动推送的报文).
+// 与 AutoConnector 一起使用时(通过 WithConnOption 配置), 每次重连成功后都会重新发送自动订阅.
+func WithAutoSubState(states []string) ConnOption {
+	return func(connection *Connection) {
+		connection.autoSubStates = append(connection.autoSubStates, states...)
+	}
+}
+
+// WithAutoSubEvent 配置连接建立后自动订阅的事件列表events, 规则与 WithAutoSubState 相同.
+func WithAutoSubEvent(events []string) ConnOption {
+	return func(connection *Connection) {
+		connection.autoSubEvents = append(connection.autoSubEvents, events...)
+	}
+}
+
+// WithCallTimeout 配置连接的 Connection.Call 等待超时时间为timeout, timeout不大于0时该选项无效,
+// 未配置时使用物模型通过 WithDefaultCallTimeout 配置的默认值. 显式调用 CallFor 时总是使用其参数timeout.
+func WithCallTimeout(timeout time.Duration) ConnOption {
+	return func(connection *Connection) {
+		if timeout > 0 {
+			connection.callWaitTimeout = timeout
+		}
+	}
+}
+
+// WithSubAckTimeout 配置连接的 Connection.SubStateAck 和 Connection.SubEventAck 等待订阅确认的超时时间为timeout,
+// timeout不大于0时该选项无效, 未配置时为 DefaultSubAckTimeout.
+func WithSubAckTimeout(timeout time.Duration) ConnOption {
+	return func(connection *Connection) {
+		if timeout > 0 {
+			connection.subAckTimeout = timeout
+		}
+	}
+}
+
+// WithUnknownMessageHandler 配置连接的未知类型报文回调函数.
+// 收到报文类型不能识别的报文时, 会调用onUnknown, 可以用于诊断或者试验新的报文类型.
+// 默认忽略未知类型的报文, 只通过 Connection.UnknownMsgCount 统计数量.
+func WithUnknownMessageHandler(onUnknown UnknownMessageFunc) ConnOption {
+	return func(connection *Connection) {
+		if onUnknown != nil {
+			connection.unknownHandler = onUnknown
+		}
+	}
+}
+
+// WithTap 配置连接的抓包回调, 连接每收到一个报文(合并发送的批量报文视为一个报文)或者发送一个报文都会以报文的原始数据调用tap,
+// 用于在不替换底层连接的情况下抓取单个连接的报文, 例如将报文转发到调试界面.
+// NOTE: tap在收发报文的协程中同步调用, 发送报文时还持有连接的写锁, 耗时的tap会阻塞连接的收发, 需要时应在tap中异步处理
+func WithTap(tap TapFunc) ConnOption {
+	return func(conn *Connection) {
+		if tap != nil {
+			conn.tap = tap
+		}
+	}
+}
+
+// WithWriteCoalesce 开启连接的合并发送选项, 适用于频繁推送状态和事件的场景.
+// 开启后, 推送的状态报文和事件报文先缓存起来, 缓存时间达到maxDelay或者缓存的字节数达到maxBytes时,
+// 合并为一个批量报文(见 message.EncodeBatchMsg)一次性发送, 从而减少写入次数.
+// 调用请求、调用响应等其他报文不缓存, 发送前会先发送缓存的报文, 保证报文顺序不变.
+// maxDelay不大于0时该选项无效, maxBytes不大于0时只根据maxDelay发送.
+// NOTE: 对端需要能够识别批量报文, 本版本的物模型和代理都支持批量报文
+func WithWriteCoalesce(maxDelay time.Duration, maxBytes int) ConnOption {
+	return func(connection *Connection) {
+		if maxDelay > 0 {
+			connection.coalesceDelay = maxDelay
+			connection.coalesceBytes = maxBytes
+		}
+	}
+}
+
+// WithPrioritySend 开启连接的优先级发送选项, 适用于大量推送状态和事件的同时需要及时调用方法的场景.
+// 开启后, 连接的所有报文由单独的发送协程按两级优先级写入底层连接: 调用请求、调用响应、订阅等控制报文为高优先级,
+// 推送的状态报文和事件报文为低优先级, 发送协程总是先发送等待中的高优先级报文, 因此控制报文不会排在积压的状态和事件之后.
+// 同一优先级内的报文顺序保持不变, 但不同优先级之间的报文顺序不再保证, 例如先推送的事件可能在之后发送的调用响应之后到达.
+// 发送高优先级报文的调用(如 Connection.Call)仍然等待报文写入后返回; 推送状态和事件时报文加入队列后立即返回,
+// 写入失败时关闭连接. 与 WithWriteCoalesce 同时开启时, 合并后的批量报文作为一个低优先级报文发送.
+// Flush 和连接关闭时会等待队列中的报文全部发送.
+// 低优先级队列的容量默认为 DefaultSendQueueLimit, 已满时默认合并同名状态的报文, 见 WithSendQueueLimit.
+// NOTE: Connection.PushEventTo 与调用响应一样通过高优先级队列发送, 不受低优先级队列容量的限制
+func WithPrioritySend() ConnOption {
+	return func(connection *Connection) {
+		if connection.sendQueue == nil {
+			connection.sendQueue = newSendQueue()
+		}
+	}
+}
+
+// WithSendQueueLimit 配置连接低优先级发送队列(即推送的状态和事件报文)的容量为limit, 队列已满时按照policy处理,
+// 同时开启 WithPrioritySend. limit不大于0或者policy无效时只开启 WithPrioritySend, 使用默认配置.
+// 对端读取慢于推送时, 队列中积压的报文数量不超过limit, 避免内存无限增长:
+//   - SendQueueCoalesce: 以新的状态报文替换队列中同名状态的旧报文, 对端只会错过过时的中间值;
+//     事件报文或者队列中没有同名状态时丢弃最早的报文, 为默认策略;
+//   - SendQueueDropOldest: 丢弃队列中最早的报文;
+//   - SendQueueBlock: 推送阻塞直到发送协程写出报文, 不丢弃任何报文, 但慢速的对端会拖慢 Model.PushState 等推送调用.
+//
+// 被丢弃和被替换的报文数量见 Connection.DroppedPushes. 状态报文被丢弃后, 下一次推送同名状态时不受 WithStateDedup 去重的影响.
+func WithSendQueueLimit(limit int, policy SendQueuePolicy) ConnOption {
+	return func(connection *Connection) {
+		WithPrioritySend()(connection)
+		if limit <= 0 || policy < SendQueueCoalesce || policy > SendQueueBlock {
+			return
+		}
+		connection.sendQueue.limit = limit
+		connection.sendQueue.policy = policy
+	}
+}
+
 func newConn(m *Model, raw rawConn.RawConn, opts ...ConnOption) *Connection {
 	ans := &Connection{
-		m:             m,
-		raw:           raw,
-		pubStates:     make(map[string]struct{}),
-		pubEvents:     make(map[string]struct{}),
-		statesChan:    make(chan message.StatePayload, 256),
-		eventsChan:    make(chan message.EventPayload, 256),
-		statesQuited:  make(chan struct{}),
-		eventsQuited:  make(chan struct{}),
-		stateHandler:  StateFunc(func(string, string, []byte) {}),
-		eventHandler:  EventFunc(func(string, string, message.RawArgs) {}),
-		closedHandler: ClosedFunc(func(string) {}),
-		metaGotCh:     make(chan struct{}),
-		peerMeta:      meta.NewEmptyMeta(),
-		peerMetaErr:   fmt.Errorf("have NOT got peer meta yet"),
-		respWaiters:   make(map[string]*RespWaiter),
-		uidCreator:    uuid.NewString,
+		m:               m,
+		raw:             raw,
+		pubStates:       make(map[string]struct{}),
+		lastStates:      make(map[string][]byte),
+		pubEvents:       make(map[string]struct{}),
+		statesChan:      make(chan message.StatePayload, m.stateBuffSize),
+		eventsChan:      make(chan message.EventPayload, m.eventBuffSize),
+		statesQuited:    make(chan struct{}),
+		eventsQuited:    make(chan struct{}),
+		stateHandler:    StateFunc(func(string, string, []byte) {}),
+		eventHandler:    EventFunc(func(string, string, message.RawArgs) {}),
+		closedHandler:   ClosedFunc(func(string) {}),
+		done:            make(chan struct{}),
+		metaGotCh:       make(chan struct{}),
+		peerMeta:        meta.NewEmptyMeta(),
+		peerMetaErr:     fmt.Errorf("have NOT got peer meta yet"),
+		respWaiters:     make(map[string]*RespWaiter),
+		uidCreator:      uuid.NewString,
+		callWaitTimeout: m.callWaitTimeout,
+		subAckTimeout:   DefaultSubAckTimeout,
+		flushTimeout:    closeFlushTimeout,
 	}
 
 	ans.msgHandlers = map[string]func([]byte){
@@ -187,22 +463,32 @@ func newConn(m *Model, raw rawConn.RawConn, opts ...ConnOption) *Connection {
 		"add-subscribe-state":    ans.onAddSubState,
 		"remove-subscribe-state": ans.onRemoveSubState,
 		"clear-subscribe-state":  ans.onClearSubState,
+		"update-subscribe-state": ans.onUpdateSubState,
 		"set-subscribe-event":    ans.onSetSubEvent,
 		"add-subscribe-event":    ans.onAddSubEvent,
 		"remove-subscribe-event": ans.onRemoveSubEvent,
 		"clear-subscribe-event":  ans.onClearSubEvent,
+		"update-subscribe-event": ans.onUpdateSubEvent,
 		"state":                  ans.onState,
 		"event":                  ans.onEvent,
 		"call":                   ans.onCall,
 		"response":               ans.onResp,
 		"query-meta":             ans.onQueryMeta,
 		"meta-info":              ans.onMetaInfo,
+		"ping":                   ans.onPing,
+		"pong":                   ans.onPong,
+		"batch":                  ans.onBatch,
+		"close":                  ans.onClose,
+		"set-state":              ans.onSetState,
 	}
 
 	for _, option := range opts {
 		option(ans)
 	}
 
+	if ans.sendQueue != nil {
+		go ans.runSendQueue()
+	}
 	go ans.dealState()
 	go ans.dealEvent()
 
@@ -211,16 +497,215 @@ func newConn(m *Model, raw rawConn.RawConn, opts ...ConnOption) *Connection {
 
 // SubState 通过连接conn发送状态订阅报文,订阅状态列表states中的所有状态,并返回错误信息.
 func (conn *Connection) SubState(states []string) error {
+	if err := conn.validateSub(states, true); err != nil {
+		return err
+	}
 	msg := message.Must(message.EncodeSubStateMsg(message.SetSub, states))
 	return conn.sendMsg(msg)
 }
 
+// SubStateWithSnapshot 与 SubState 相同, 订阅状态列表states中的所有状态,
+// 区别是对端在处理订阅报文后会立即推送新订阅的状态的最新值(若对端推送过该状态), 而不必等待状态的下一次推送.
+// 对端需要支持 message.SubPayload 格式的订阅报文.
+func (conn *Connection) SubStateWithSnapshot(states []string) error {
+	if err := conn.validateSub(states, true); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubStatePayloadMsg(message.SetSub, message.SubPayload{
+		Items:    states,
+		Snapshot: true,
+	}))
+	return conn.sendMsg(msg)
+}
+
+// AddSubStateWithSnapshot 与 AddSubState 相同, 新增对状态列表states中的所有状态的订阅,
+// 区别是对端在处理订阅报文后会立即推送新订阅的状态的最新值(若对端推送过该状态).
+// 对端需要支持 message.SubPayload 格式的订阅报文.
+func (conn *Connection) AddSubStateWithSnapshot(states []string) error {
+	if err := conn.validateSub(states, true); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubStatePayloadMsg(message.AddSub, message.SubPayload{
+		Items:    states,
+		Snapshot: true,
+	}))
+	return conn.sendMsg(msg)
+}
+
+// SubStateAck 与 SubState 相同, 订阅状态列表states中的所有状态, 区别是 SubStateAck 会等待对端的订阅确认,
+// 返回对端处理订阅报文后的有效状态订阅列表(只包含对端存在的状态, 按名称排序)和错误信息.
+// 通过比较states与返回的列表, 可以确认订阅的状态是否真实存在.
+// SubStateAck 会一直等待, 直到收到订阅确认、等待超时(见 WithSubAckTimeout)或者连接关闭. 对端需要支持 message.SubPayload 格式的订阅报文.
+func (conn *Connection) SubStateAck(states []string) ([]string, error) {
+	if err := conn.validateSub(states, true); err != nil {
+		return nil, err
+	}
+	return conn.subAck(states, message.EncodeSubStatePayloadMsg)
+}
+
+// SubEventAck 与 SubEvent 相同, 订阅事件列表events中的所有事件, 区别是 SubEventAck 会等待对端的订阅确认,
+// 返回对端处理订阅报文后的有效事件订阅列表(只包含对端存在的事件, 按名称排序)和错误信息.
+// SubEventAck 会一直等待, 直到收到订阅确认、等待超时(见 WithSubAckTimeout)或者连接关闭. 对端需要支持 message.SubPayload 格式的订阅报文.
+func (conn *Connection) SubEventAck(events []string) ([]string, error) {
+	if err := conn.validateSub(events, false); err != nil {
+		return nil, err
+	}
+	return conn.subAck(events, message.EncodeSubEventPayloadMsg)
+}
+
+// autoSubscribe 发送通过 WithAutoSubState 和 WithAutoSubEvent 配置的自动订阅报文, 在连接开始接收报文之前调用
+func (conn *Connection) autoSubscribe() {
+	if len(conn.autoSubStates) == 0 && len(conn.autoSubEvents) == 0 {
+		return
+	}
+
+	subscribe := func() {
+		if len(conn.autoSubEvents) > 0 {
+			_ = conn.AddSubEvent(conn.autoSubEvents)
+		}
+		if len(conn.autoSubStates) > 0 {
+			_ = conn.AddSubState(conn.autoSubStates)
+		}
+	}
+
+	// NOTE: 开启订阅校验时需要等待对端元信息, 而元信息报文由 dealReceive 处理, 不能在此阻塞
+	if conn.validateSubs {
+		go subscribe()
+		return
+	}
+	subscribe()
+}
+
+// validateSub 在开启订阅校验时, 校验订阅列表items中的状态(isState为true)或事件是否都存在于对端元信息中
+func (conn *Connection) validateSub(items []string, isState bool) error {
+	if !conn.validateSubs {
+		return nil
+	}
+
+	peerMeta, err := conn.GetPeerMeta()
+	if err != nil {
+		return err
+	}
+
+	kind, all := "event", peerMeta.AllEvents()
+	if isState {
+		kind, all = "state", peerMeta.AllStates()
+	}
+
+	set := make(map[string]struct{}, len(all))
+	for _, name := range all {
+		set[name] = struct{}{}
+	}
+
+	for _, item := range items {
+		if _, seen := set[item]; !seen {
+			return fmt.Errorf("NO such %s %q on peer", kind, item)
+		}
+	}
+
+	return nil
+}
+
+func (conn *Connection) subAck(items []string, encode func(int, message.SubPayload) ([]byte, error)) ([]string, error) {
+	uid := conn.uidCreator()
+	msg := message.Must(encode(message.SetSub, message.SubPayload{
+		Items: items,
+		UUID:  uid,
+	}))
+	waiter, err := conn.addRespWaiter(uid)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.sendMsg(msg); err != nil {
+		conn.removeRespWaiter(uid)
+		return nil, err
+	}
+
+	// NOTE: 超时后移除等待器, 之后到达的订阅确认被忽略
+	resp, err := waiter.WaitFor(conn.subAckTimeout)
+	if err != nil {
+		conn.removeRespWaiter(uid)
+		return nil, err
+	}
+
+	var ans []string
+	if err = json.Unmarshal(resp["items"], &ans); err != nil || ans == nil {
+		return nil, errors.New("invalid subscription ack")
+	}
+	return ans, nil
+}
+
+// SessionAck 为代理对会话报文的确认, 见 Connection.BindSession
+type SessionAck struct {
+	Restored bool     `json:"restored"` // 是否恢复了会话断开前保存的订阅关系
+	States   []string `json:"states"`   // 绑定会话后的状态订阅列表, 按名称排序
+	Events   []string `json:"events"`   // 绑定会话后的事件订阅列表, 按名称排序
+}
+
+// BindSession 通过连接conn向代理发送会话报文(见 message.EncodeSessionMsg), 将连接绑定到会话id并等待代理的会话确认,
+// 返回会话确认和错误信息. 会话在断开后的有效期内未过期时, 代理恢复会话保存的订阅关系, 与连接当前的订阅关系合并.
+// 代理没有开启会话或者会话已被其他在线的物模型绑定时返回代理的错误信息, 等待确认的超时时间与订阅确认相同, 见 WithSubAckTimeout.
+// NOTE: 会话ID由物模型自行选择, 任何知道会话ID的物模型都可以在其断开后接管该会话的订阅关系,
+// 因此会话ID应该是不可猜测的随机值(例如 uuid.NewString 生成的UUID), 并且只在可信的网络中使用
+func (conn *Connection) BindSession(id string) (SessionAck, error) {
+	if strings.TrimSpace(id) == "" {
+		return SessionAck{}, errors.New("session id is empty")
+	}
+
+	uid := conn.uidCreator()
+	waiter, err := conn.addRespWaiter(uid)
+	if err != nil {
+		return SessionAck{}, err
+	}
+	if err := conn.sendMsg(message.EncodeSessionMsg(id, uid)); err != nil {
+		conn.removeRespWaiter(uid)
+		return SessionAck{}, err
+	}
+
+	resp, err := waiter.WaitFor(conn.subAckTimeout)
+	if err != nil {
+		conn.removeRespWaiter(uid)
+		return SessionAck{}, err
+	}
+
+	raw, _ := json.Marshal(resp)
+	ans := SessionAck{}
+	if err = json.Unmarshal(raw, &ans); err != nil || ans.States == nil || ans.Events == nil {
+		return SessionAck{}, errors.New("invalid session ack")
+	}
+	return ans, nil
+}
+
 // AddSubState 通过连接conn发送添加状态订阅报文,新增对状态列表states中的所有状态的订阅,并返回错误信息.
 func (conn *Connection) AddSubState(states []string) error {
+	if err := conn.validateSub(states, true); err != nil {
+		return err
+	}
 	msg := message.Must(message.EncodeSubStateMsg(message.AddSub, states))
 	return conn.sendMsg(msg)
 }
 
+// ReplaceSubState 通过连接conn发送一个设置订阅报文, 将状态订阅列表整体替换为states, 并返回错误信息.
+// 与先调用 CancelSubState 再调用 SubState 不同, 对端一次完成替换, 两个列表中都有的状态不会中断推送.
+// ReplaceSubState 与 SubState 发送的报文相同.
+func (conn *Connection) ReplaceSubState(states []string) error {
+	return conn.SubState(states)
+}
+
+// UpdateSubState 通过连接conn发送一个更新订阅报文, 同时新增对状态列表add中所有状态的订阅, 取消对状态列表remove中所有状态的订阅,
+// 并返回错误信息. 对端先取消再新增, 在一次处理中完成, 因此调整订阅时不会出现漏收或者多收状态的间隙,
+// 同时出现在add和remove中的状态在更新后仍然被订阅. 对端需要支持 message.UpdateSub 类型的订阅报文.
+func (conn *Connection) UpdateSubState(add []string, remove []string) error {
+	if err := conn.validateSub(add, true); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubStatePayloadMsg(message.UpdateSub, message.SubPayload{
+		Items:  add,
+		Remove: remove,
+	}))
+	return conn.sendMsg(msg)
+}
+
 // CancelSubState 通过连接conn发送取消状态订阅报文,取消对状态列表states中所有状态的订阅,并返回错误信息.
 func (conn *Connection) CancelSubState(states []string) error {
 	msg := message.Must(message.EncodeSubStateMsg(message.RemoveSub, states))
@@ -235,16 +720,63 @@ func (conn *Connection) CancelAllSubState() error {
 
 // SubEvent 通过连接conn发送事件订阅报文,订阅事件列表events中所有事件,并返回错误信息.
 func (conn *Connection) SubEvent(events []string) error {
+	if err := conn.validateSub(events, false); err != nil {
+		return err
+	}
 	msg := message.Must(message.EncodeSubEventMsg(message.SetSub, events))
 	return conn.sendMsg(msg)
 }
 
 // AddSubEvent 通过连接conn发送添加事件订阅报文,新增对事件列表events中所有事件的订阅,并返回错误信息.
 func (conn *Connection) AddSubEvent(events []string) error {
+	if err := conn.validateSub(events, false); err != nil {
+		return err
+	}
 	msg := message.Must(message.EncodeSubEventMsg(message.AddSub, events))
 	return conn.sendMsg(msg)
 }
 
+// SubEventWithHistory 与 SubEvent 相同, 订阅事件列表events中的所有事件,
+// 区别是对端在处理订阅报文后会立即按推送顺序推送新订阅的事件最近的历史事件(若对端配置了 WithEventHistory), 用于补齐订阅之前错过的事件.
+// 订阅与推送并发时, 同一个事件可能既作为历史事件又作为新事件收到, 可以通过推送序号(见 WithSequence)区分.
+// 对端需要支持 message.SubPayload 格式的订阅报文.
+func (conn *Connection) SubEventWithHistory(events []string) error {
+	if err := conn.validateSub(events, false); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubEventPayloadMsg(message.SetSub, message.SubPayload{
+		Items:    events,
+		Snapshot: true,
+	}))
+	return conn.sendMsg(msg)
+}
+
+// AddSubEventWithHistory 与 AddSubEvent 相同, 新增对事件列表events中所有事件的订阅,
+// 区别是对端在处理订阅报文后会立即推送新订阅的事件最近的历史事件, 见 SubEventWithHistory.
+func (conn *Connection) AddSubEventWithHistory(events []string) error {
+	if err := conn.validateSub(events, false); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubEventPayloadMsg(message.AddSub, message.SubPayload{
+		Items:    events,
+		Snapshot: true,
+	}))
+	return conn.sendMsg(msg)
+}
+
+// UpdateSubEvent 通过连接conn发送一个更新订阅报文, 同时新增对事件列表add中所有事件的订阅, 取消对事件列表remove中所有事件的订阅,
+// 并返回错误信息. 对端在一次处理中完成, 见 UpdateSubState.
+func (conn *Connection) UpdateSubEvent(add []string, remove []string) error {
+	if err := conn.validateSub(add, false); err != nil {
+		return err
+	}
+	msg := message.Must(message.EncodeSubEventPayloadMsg(message.UpdateSub, message.SubPayload{
+		Items:  add,
+		Remove: remove,
+	}))
+	return conn.sendMsg(msg)
+}
+
 // CancelSubEvent 通过连接conn发送取消事件订阅报文,取消对事件列表events中所有事件的订阅,并返回错误信息.
 func (conn *Connection) CancelSubEvent(events []string) error {
 	msg := message.Must(message.EncodeSubEventMsg(message.RemoveSub, events))
@@ -260,12 +792,22 @@ func (conn *Connection) CancelAllSubEvent() error {
 // Invoke 通过连接conn发送调用请求报文,以异步的方式远程调用名为fullName的方法,调用参数为args,
 // 返回用于等待该次调用的响应的等待对象和错误信息. 出错时该函数返回的等待对象为nil.
 func (conn *Connection) Invoke(fullName string, args message.Args) (*RespWaiter, error) {
+	return conn.InvokeWithHeaders(fullName, args, nil)
+}
+
+// InvokeWithHeaders 与 Invoke 相同, 以异步的方式远程调用名为fullName的方法, 同时在调用请求报文中附加头部headers,
+// 用于在调用参数之外传递追踪ID、截止时间等信息, 对端在 CallRequestContextHandler 中通过 CallContext.Headers 读取.
+// 头部不根据元信息校验, 不支持头部的旧版本对端会忽略头部. headers为空时与 Invoke 相同.
+func (conn *Connection) InvokeWithHeaders(fullName string, args message.Args, headers map[string]string) (*RespWaiter, error) {
 	uid := conn.uidCreator()
-	msg, err := message.EncodeCallMsg(fullName, uid, args)
+	msg, err := message.EncodeCallMsgWithHeaders(fullName, uid, args, headers)
+	if err != nil {
+		return nil, err
+	}
+	waiter, err := conn.addRespWaiter(uid)
 	if err != nil {
 		return nil, err
 	}
-	waiter := conn.addRespWaiter(uid)
 	if err = conn.sendMsg(msg); err != nil {
 		conn.removeRespWaiter(uid)
 		return nil, err
@@ -295,24 +837,32 @@ func (conn *Connection) InvokeByCallback(fullName string, args message.Args, onR
 // 若该函数返回的错误信息不为nil, 则表示调用请求发送失败, 回调onResp不会被触发.
 // InvokeFor 与 InvokeByCallback 的区别是, InvokeFor 在后台等待响应报文时,有超时时间为timeout的限制,
 // 若在timeout时间内未收到对应的响应报文,则会调用onResp,调用返回值为空,错误信息为超时.
+// 超时后该请求不再出现在 PendingCalls 中, 之后到达的响应被忽略. onResp为nil时同样在超时后移除该请求.
 func (conn *Connection) InvokeFor(fullName string, args message.Args, onResp RespFunc, timeout time.Duration) error {
 	waiter, err := conn.Invoke(fullName, args)
 	if err != nil {
 		return err
 	}
 
-	if onResp != nil {
-		go func() {
-			onResp(waiter.WaitFor(timeout))
-		}()
-	}
+	go func() {
+		resp, err := conn.waitRespFor(waiter, timeout)
+		if onResp != nil {
+			onResp(resp, err)
+		}
+	}()
 
 	return nil
 }
 
 // Call 通过连接conn发送调用请求报文,以同步的方式远程调用名为fullName的方法,调用参数为args,等待调用响应报文的返回.
 // Call 在成功发送调用请求报文后会一直等待,直到收到调用响应报文或者连接关闭再返回.
+// 通过 WithCallTimeout 或者 WithDefaultCallTimeout 配置了等待超时时间时, Call 与以该超时时间调用 CallFor 相同,
+// 超时后该请求同样不再出现在 PendingCalls 中.
 func (conn *Connection) Call(fullName string, args message.Args) (message.RawResp, error) {
+	if conn.callWaitTimeout > 0 {
+		return conn.CallFor(fullName, args, conn.callWaitTimeout)
+	}
+
 	waiter, err := conn.Invoke(fullName, args)
 	if err != nil {
 		return message.RawResp{}, err
@@ -323,12 +873,62 @@ func (conn *Connection) Call(fullName string, args message.Args) (message.RawRes
 
 // CallFor 通过连接conn发送调用请求报文,以同步的方式远程调用名为fullName的方法,调用参数为args,等待调用响应报文的返回.
 // CallFor 和 Call 类似, 都会阻塞式地等待调用响应报文, 只不过 CallFor 有等待超时时间为timeout的限制.
+// 超时后该请求不再出现在 PendingCalls 中, 之后到达的响应被忽略.
+// CallFor 总是使用参数timeout, 不受 WithCallTimeout 和 WithDefaultCallTimeout 的影响.
 func (conn *Connection) CallFor(fullName string, args message.Args, timeout time.Duration) (message.RawResp, error) {
 	waiter, err := conn.Invoke(fullName, args)
 	if err != nil {
 		return message.RawResp{}, err
 	}
-	return waiter.WaitFor(timeout)
+	return conn.waitRespFor(waiter, timeout)
+}
+
+// SetState 通过连接conn发送状态设置报文, 请求对端将全名为fullName的可写状态设置为data, 等待对端的设置结果.
+// SetState 在成功发送状态设置报文后会一直等待, 直到收到对端的响应报文或者连接关闭再返回,
+// 对端拒绝设置(例如状态为只读、数据不符合元信息或者设置回调返回错误)时返回对端的错误信息.
+func (conn *Connection) SetState(fullName string, data interface{}) error {
+	uid := conn.uidCreator()
+	msg, err := message.EncodeSetStateMsg(fullName, uid, data)
+	if err != nil {
+		return err
+	}
+
+	waiter, err := conn.addRespWaiter(uid)
+	if err != nil {
+		return err
+	}
+	if err = conn.sendMsg(msg); err != nil {
+		conn.removeRespWaiter(uid)
+		return err
+	}
+
+	_, err = waiter.Wait()
+	return err
+}
+
+// PushEventTo 通过连接conn向对端推送名称为name, 参数为args的本端物模型事件, 无论对端是否订阅了该事件,
+// 用于只通知某一个对端的场景, 例如通知调用方其请求的操作已经完成.
+// 参数verify表示是否根据本端物模型的元信息校验事件参数, 校验规则与 Model.PushEvent 相同, 若校验不通过或者发送失败返回错误信息.
+// NOTE: PushEventTo 不检查对端的事件订阅列表, 也不会向其他连接推送该事件.
+// PushEventTo 等待报文写入后返回, 开启 WithPrioritySend 时作为高优先级报文发送, 不受 WithSendQueueLimit 的影响
+func (conn *Connection) PushEventTo(name string, args message.Args, verify bool) error {
+	if verify {
+		if err := conn.m.meta.VerifyEvent(name, args); err != nil {
+			return err
+		}
+	}
+
+	args, err := conn.m.meta.EventArgsInUnit(name, args)
+	if err != nil {
+		return err
+	}
+
+	msg, err := message.EncodeEventSeqMsg(conn.m.meta.Name+"/"+name, conn.m.nextSeq(), args)
+	if err != nil {
+		return err
+	}
+
+	return conn.sendMsg(msg)
 }
 
 // GetPeerMeta 阻塞式地获取对端的元信息,若先前已经收到对端的元信息报文,则直接返回不再发送查询元信息报文.
@@ -347,22 +947,189 @@ func (conn *Connection) GetPeerMeta() (*meta.Meta, error) {
 	}
 }
 
-// Close 关闭连接.
-func (conn *Connection) Close() error {
-	return conn.close("active close")
-}
+// Ping 通过连接conn发送ping报文, 等待对端回复对应的pong报文, 返回ping报文往返的时间和错误信息.
+// 若在timeout时间内未收到对应的pong报文, 则返回超时错误. 连接关闭时也会立即返回错误.
+func (conn *Connection) Ping(timeout time.Duration) (time.Duration, error) {
+	uid := conn.uidCreator()
+	waiter, err := conn.addRespWaiter(uid)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	if err := conn.sendMsg(message.EncodePingMsg(uid)); err != nil {
+		conn.removeRespWaiter(uid)
+		return 0, err
+	}
 
-func (conn *Connection) dealReceive() {
-	reason := ""
-	defer func() {
-		_ = conn.close(reason)
+	if _, err := waiter.WaitFor(timeout); err != nil {
+		conn.removeRespWaiter(uid)
+		return 0, err
+	}
 
-		conn.statesCloseOnce.Do(func() {
-			close(conn.statesChan)
-		})
-		conn.eventsCloseOnce.Do(func() {
-			close(conn.eventsChan)
-		})
+	return time.Since(start), nil
+}
+
+// PeerMeta 非阻塞地返回已经获取的对端元信息, 若尚未收到对端的元信息报文或者对端元信息无效, 返回空元信息和false.
+// PeerMeta 不会发送元信息查询报文, 可以用于查询对端元信息是否已经就绪.
+func (conn *Connection) PeerMeta() (*meta.Meta, bool) {
+	select {
+	case <-conn.metaGotCh:
+		if conn.peerMetaErr != nil {
+			return meta.NewEmptyMeta(), false
+		}
+		return conn.peerMeta, true
+	default:
+		return meta.NewEmptyMeta(), false
+	}
+}
+
+// PeerName 非阻塞地返回对端的物模型名称, 若尚未收到对端的元信息报文或者对端元信息无效, 返回空字符串.
+// PeerName 与 PeerMeta 相同, 不会发送元信息查询报文, 可以在获取元信息的过程中并发调用, 一般用于标记日志.
+func (conn *Connection) PeerName() string {
+	if peerMeta, ok := conn.PeerMeta(); ok {
+		return peerMeta.Name
+	}
+	return ""
+}
+
+// RemoteAddr 返回连接conn的对端网络地址.
+func (conn *Connection) RemoteAddr() net.Addr {
+	return conn.raw.RemoteAddr()
+}
+
+// SetContext 将数据value以键key附加到连接conn上, 键已存在时覆盖原来的数据, key必须是可比较的类型.
+// 一般用于在连接上保存应用数据, 例如认证后的用户身份, 之后在 CallRequestConnHandler 等回调中通过 Context 读取.
+// 附加的数据在连接关闭后清空, 连接关闭后调用 SetContext 无效. SetContext 和 Context 可以在多个协程中并发调用.
+func (conn *Connection) SetContext(key, value interface{}) {
+	conn.ctxLock.Lock()
+	defer conn.ctxLock.Unlock()
+
+	select {
+	case <-conn.done:
+		return
+	default:
+	}
+
+	if conn.ctx == nil {
+		conn.ctx = make(connContext)
+	}
+	conn.ctx[key] = value
+}
+
+// Context 返回通过 SetContext 以键key附加到连接conn上的数据, 数据不存在或者连接已经关闭时第二个返回值为false.
+func (conn *Connection) Context(key interface{}) (interface{}, bool) {
+	conn.ctxLock.RLock()
+	defer conn.ctxLock.RUnlock()
+	value, seen := conn.ctx[key]
+	return value, seen
+}
+
+// PendingCalls 返回连接conn上所有已经发送但尚未收到响应的请求的uuid, 按uuid排序,
+// 包括调用请求、状态设置请求和ping报文, 可以与收到响应的处理并发调用. 连接关闭后返回空列表.
+func (conn *Connection) PendingCalls() []string {
+	conn.waitersLock.Lock()
+	defer conn.waitersLock.Unlock()
+
+	ans := make([]string, 0, len(conn.respWaiters))
+	for uuid := range conn.respWaiters {
+		ans = append(ans, uuid)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// DroppedCalls 返回连接conn关闭时仍未收到响应(见 PendingCalls)而被丢弃的请求数量, 连接未关闭时返回0.
+// 关闭回调调用前该数量已经确定, 因此可以在关闭回调中记录, 例如"dropped 7 pending calls on disconnect".
+func (conn *Connection) DroppedCalls() uint64 {
+	return atomic.LoadUint64(&conn.droppedCalls)
+}
+
+// DroppedPushes 返回开启了 WithPrioritySend 的连接conn因低优先级发送队列已满而丢弃或者被替换的状态和事件报文数量,
+// 见 WithSendQueueLimit, 没有开启优先级发送时返回0.
+func (conn *Connection) DroppedPushes() uint64 {
+	if conn.sendQueue == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&conn.sendQueue.drops)
+}
+
+// UnknownMsgCount 返回连接conn收到的未知类型报文的数量.
+func (conn *Connection) UnknownMsgCount() uint64 {
+	return atomic.LoadUint64(&conn.unknownCount)
+}
+
+// GetSubStates 返回对端通过连接conn订阅的所有状态的全名列表, 列表按名称排序.
+// GetSubStates 只读取当前订阅关系的快照, 不会阻塞, 在连接关闭过程中或关闭后调用也能立即返回.
+func (conn *Connection) GetSubStates() []string {
+	conn.statesLock.RLock()
+	defer conn.statesLock.RUnlock()
+	ans := set2slice(conn.pubStates)
+	sort.Strings(ans)
+	return ans
+}
+
+// GetSubEvents 返回对端通过连接conn订阅的所有事件的全名列表, 列表按名称排序.
+// GetSubEvents 只读取当前订阅关系的快照, 不会阻塞, 在连接关闭过程中或关闭后调用也能立即返回.
+func (conn *Connection) GetSubEvents() []string {
+	conn.eventsLock.RLock()
+	defer conn.eventsLock.RUnlock()
+	ans := set2slice(conn.pubEvents)
+	sort.Strings(ans)
+	return ans
+}
+
+// Close 关闭连接.
+func (conn *Connection) Close() error {
+	return conn.close(CloseKindActive, "active close")
+}
+
+// Done 返回一个在连接关闭后关闭的管道, 用于在select中等待连接关闭, 连接关闭前后都可以调用.
+// 管道关闭时底层连接已经关闭, 关闭原因可以通过 Err 获取.
+func (conn *Connection) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Err 在连接关闭前返回nil, 连接关闭后返回包含关闭原因的错误信息, 关闭原因与 ClosedHandler 收到的相同.
+func (conn *Connection) Err() error {
+	select {
+	case <-conn.done:
+		return conn.closeErr
+	default:
+		return nil
+	}
+}
+
+// CloseReason 在连接关闭前返回false, 连接关闭后返回结构化的关闭原因和true,
+// 关闭原因与 CloseReasonHandler 收到的相同.
+func (conn *Connection) CloseReason() (CloseReason, bool) {
+	select {
+	case <-conn.done:
+		return conn.closeReason, true
+	default:
+		return CloseReason{}, false
+	}
+}
+
+// CloseWithReason 先向对端发送携带关闭原因reason的关闭报文, 再关闭连接.
+// 本端的 ClosedHandler 收到的关闭原因为 "active close: reason",
+// 支持关闭报文的对端的 ClosedHandler 收到的关闭原因为 "peer closed: reason",
+// 不支持关闭报文的对端忽略该报文, 与调用 Close 关闭连接的效果相同.
+func (conn *Connection) CloseWithReason(reason string) error {
+	_ = conn.sendMsg(message.EncodeCloseMsg(reason))
+	return conn.close(CloseKindActive, "active close: "+reason)
+}
+
+func (conn *Connection) dealReceive() {
+	kind, reason := CloseKindUnknown, ""
+	defer func() {
+		_ = conn.close(kind, reason)
+
+		conn.statesCloseOnce.Do(func() {
+			close(conn.statesChan)
+		})
+		conn.eventsCloseOnce.Do(func() {
+			close(conn.eventsChan)
+		})
 		<-conn.statesQuited
 		<-conn.eventsQuited
 	}()
@@ -370,90 +1137,275 @@ func (conn *Connection) dealReceive() {
 	for {
 		data, err := conn.raw.ReadMsg()
 		if err != nil {
-			reason = err.Error()
+			kind, reason = readErrKind(err), err.Error()
+			if conn.peerCloseReason != "" {
+				kind, reason = CloseKindPeer, "peer closed: "+conn.peerCloseReason
+			}
 			break
 		}
+		conn.tapMsg(Inbound, data)
 
-		msg := message.RawMessage{}
-		err = json.Unmarshal(data, &msg)
+		msg, err := message.Decode(data)
 		if err != nil {
-			reason = fmt.Sprintf("decode json: %s", err.Error())
+			kind, reason = CloseKindDecodeError, fmt.Sprintf("decode json: %s", err.Error())
 			break
 		}
 
-		if handler, seen := conn.msgHandlers[msg.Type]; seen {
-			handler(msg.Payload)
+		conn.dispatch(msg)
+	}
+}
+
+// dispatch 根据报文类型处理报文msg
+func (conn *Connection) dispatch(msg message.RawMessage) {
+	if handler, seen := conn.msgHandlers[msg.Type]; seen {
+		handler(msg.Payload)
+	} else {
+		conn.onUnknown(msg.Type, msg.Payload)
+	}
+}
+
+// onBatch 依次处理批量报文中的每个报文, 忽略无效的报文和嵌套的批量报文
+func (conn *Connection) onBatch(payload []byte) {
+	msgs, err := message.ParseBatchPayload(payload)
+	if err != nil {
+		return
+	}
+
+	for _, data := range msgs {
+		msg, err := message.Decode(data)
+		if err != nil || msg.Type == "batch" {
+			continue
 		}
+		conn.dispatch(msg)
+	}
+}
+
+// onClose 记录对端通过关闭报文告知的关闭原因, 对端随后关闭连接时作为本端的关闭原因
+func (conn *Connection) onClose(payload []byte) {
+	closePayload, err := message.ParseClosePayload(payload)
+	if err != nil {
+		return
+	}
+
+	conn.peerCloseReason = closePayload.Reason
+}
 
+// onUnknown 处理未知类型的报文
+func (conn *Connection) onUnknown(msgType string, payload []byte) {
+	atomic.AddUint64(&conn.unknownCount, 1)
+	if conn.unknownHandler != nil {
+		conn.safeCall(func() {
+			conn.unknownHandler(msgType, payload)
+		})
 	}
 }
 
-func (conn *Connection) close(reason string) error {
+// close 以类别为kind的关闭原因reason关闭连接, 只有第一次调用的关闭原因有效
+func (conn *Connection) close(kind CloseKind, reason string) error {
 	// NOTE: 关闭前需要唤醒所有等待者, 避免不必要的等待
 	conn.notifyRespWaiterOnClose(reason)
 	conn.notifyMetaWaiterOnClose(reason)
 
 	// 调用关闭回调
 	conn.closedOnce.Do(func() {
-		conn.closedHandler.OnClosed(reason)
+		conn.closeErr = fmt.Errorf("connection closed for: %s", reason)
+		conn.closeReason = CloseReason{Kind: kind, Detail: reason}
+		if handler, ok := conn.closedHandler.(CloseReasonHandler); ok {
+			handler.OnClose(conn.closeReason)
+		} else {
+			conn.closedHandler.OnClosed(reason)
+		}
 	})
 
+	// 发送缓存的报文
+	// NOTE: 对端不再读取时写入会一直阻塞, 因此最多等待 flushTimeout, 关闭底层连接后阻塞的写入会返回
+	_ = conn.flushFor(conn.flushTimeout)
+
 	err := conn.raw.Close()
 
+	// 结束发送队列, 之后发送的报文都返回连接关闭的原因
+	if conn.sendQueue != nil {
+		conn.sendQueue.fail(conn.closeErr)
+	}
+
+	conn.doneOnce.Do(func() {
+		close(conn.done)
+	})
+
+	// 清空附加数据
+	conn.ctxLock.Lock()
+	conn.ctx = nil
+	conn.ctxLock.Unlock()
+
 	return err
 }
 
 func (conn *Connection) onSetSubState(payload []byte) {
-	var states []string
-	if err := json.Unmarshal(payload, &states); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return
+	}
+
+	items, ok := conn.m.limitSubs(nil, sub.Items)
+	if !ok {
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
 		return
 	}
 
 	ans := make(map[string]struct{})
-	for _, state := range states {
+	for _, state := range items {
 		ans[state] = struct{}{}
 	}
 
 	conn.statesLock.Lock()
+	added := newItems(conn.pubStates, items)
 	conn.pubStates = ans
+	conn.resetLastStates()
 	conn.statesLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendSnapshot(added)
+	}
+	conn.notifySub(SubKindState)
+	conn.ackSubState(sub.UUID)
 }
 
 func (conn *Connection) onAddSubState(payload []byte) {
-	var states []string
-	if err := json.Unmarshal(payload, &states); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
 		return
 	}
 
 	conn.statesLock.Lock()
-	for _, state := range states {
+	items, ok := conn.m.limitSubs(conn.pubStates, sub.Items)
+	if !ok {
+		conn.statesLock.Unlock()
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
+		return
+	}
+	added := newItems(conn.pubStates, items)
+	for _, state := range items {
 		conn.pubStates[state] = struct{}{}
 	}
 	conn.statesLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendSnapshot(added)
+	}
+	conn.notifySub(SubKindState)
+	conn.ackSubState(sub.UUID)
+}
+
+// onUpdateSubState 处理同时新增和取消状态订阅的报文, 先取消 Remove 中的订阅再新增 Items 中的订阅,
+// 在持有锁的情况下一次完成, 因此不会出现既没有订阅旧状态也没有订阅新状态的间隙
+func (conn *Connection) onUpdateSubState(payload []byte) {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return
+	}
+
+	conn.statesLock.Lock()
+	remain := make(map[string]struct{}, len(conn.pubStates))
+	for state := range conn.pubStates {
+		remain[state] = struct{}{}
+	}
+	for _, state := range sub.Remove {
+		delete(remain, state)
+	}
+	items, ok := conn.m.limitSubs(remain, sub.Items)
+	if !ok {
+		conn.statesLock.Unlock()
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
+		return
+	}
+	added := newItems(remain, items)
+	for _, state := range items {
+		remain[state] = struct{}{}
+	}
+	conn.pubStates = remain
+	conn.lastStatesLock.Lock()
+	for _, state := range sub.Remove {
+		delete(conn.lastStates, state)
+	}
+	conn.lastStatesLock.Unlock()
+	conn.statesLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendSnapshot(added)
+	}
+	conn.notifySub(SubKindState)
+	conn.ackSubState(sub.UUID)
+}
+
+// sendSnapshot 向对端推送状态列表states中所有状态的最新值
+func (conn *Connection) sendSnapshot(states []string) {
+	for _, state := range states {
+		if cached, seen := conn.m.lastStateMsg(state); seen {
+			conn.sendState(state, cached.msg, cached.key, conn.m.stateDedup)
+		}
+	}
 }
 
 func (conn *Connection) onRemoveSubState(payload []byte) {
-	var states []string
-	if err := json.Unmarshal(payload, &states); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
 		return
 	}
+	states := sub.Items
 
 	conn.statesLock.Lock()
 	for _, state := range states {
 		delete(conn.pubStates, state)
 	}
+	conn.lastStatesLock.Lock()
+	for _, state := range states {
+		delete(conn.lastStates, state)
+	}
+	conn.lastStatesLock.Unlock()
 	conn.statesLock.Unlock()
+
+	conn.notifySub(SubKindState)
+	conn.ackSubState(sub.UUID)
 }
 
-func (conn *Connection) onClearSubState([]byte) {
+func (conn *Connection) onClearSubState(payload []byte) {
 	conn.statesLock.Lock()
 	conn.pubStates = make(map[string]struct{})
+	conn.resetLastStates()
 	conn.statesLock.Unlock()
+	conn.notifySub(SubKindState)
+
+	// NOTE: 清空订阅报文的报文内容无效时, 仍然清空订阅, 只是不回复订阅确认
+	var sub message.SubPayload
+	if json.Unmarshal(payload, &sub) == nil {
+		conn.ackSubState(sub.UUID)
+	}
+}
+
+// ackSubState 若uuid不为空, 则向对端回复订阅确认, 确认报文中包含当前有效的状态订阅列表
+func (conn *Connection) ackSubState(uuid string) {
+	if uuid == "" {
+		return
+	}
+	_ = conn.sendMsg(message.EncodeSubAckMsg(uuid, existItems(conn.GetSubStates(), conn.m.servedMeta().AllStates())))
+}
+
+// resetLastStates 清空状态去重记录, 保证重新订阅后能立即收到状态.
+// NOTE: 调用前必须持有 statesLock
+func (conn *Connection) resetLastStates() {
+	conn.lastStatesLock.Lock()
+	conn.lastStates = make(map[string][]byte)
+	conn.lastStatesLock.Unlock()
 }
 
 func (conn *Connection) onSetSubEvent(payload []byte) {
-	var events []string
-	if err := json.Unmarshal(payload, &events); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return
+	}
+	events, ok := conn.m.limitSubs(nil, sub.Items)
+	if !ok {
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
 		return
 	}
 
@@ -463,64 +1415,161 @@ func (conn *Connection) onSetSubEvent(payload []byte) {
 	}
 
 	conn.eventsLock.Lock()
+	added := newItems(conn.pubEvents, events)
 	conn.pubEvents = ans
 	conn.eventsLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendEventHistory(added)
+	}
+	conn.notifySub(SubKindEvent)
+	conn.ackSubEvent(sub.UUID)
 }
 
 func (conn *Connection) onAddSubEvent(payload []byte) {
-	var events []string
-	if err := json.Unmarshal(payload, &events); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
 		return
 	}
-
 	conn.eventsLock.Lock()
+	events, ok := conn.m.limitSubs(conn.pubEvents, sub.Items)
+	if !ok {
+		conn.eventsLock.Unlock()
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
+		return
+	}
+	added := newItems(conn.pubEvents, events)
 	for _, event := range events {
 		conn.pubEvents[event] = struct{}{}
 	}
 	conn.eventsLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendEventHistory(added)
+	}
+	conn.notifySub(SubKindEvent)
+	conn.ackSubEvent(sub.UUID)
+}
+
+// onUpdateSubEvent 处理同时新增和取消事件订阅的报文, 先取消 Remove 中的订阅再新增 Items 中的订阅, 见 onUpdateSubState
+func (conn *Connection) onUpdateSubEvent(payload []byte) {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
+		return
+	}
+
+	conn.eventsLock.Lock()
+	remain := make(map[string]struct{}, len(conn.pubEvents))
+	for event := range conn.pubEvents {
+		remain[event] = struct{}{}
+	}
+	for _, event := range sub.Remove {
+		delete(remain, event)
+	}
+	events, ok := conn.m.limitSubs(remain, sub.Items)
+	if !ok {
+		conn.eventsLock.Unlock()
+		_ = conn.close(CloseKindSubLimit, subLimitReason)
+		return
+	}
+	added := newItems(remain, events)
+	for _, event := range events {
+		remain[event] = struct{}{}
+	}
+	conn.pubEvents = remain
+	conn.eventsLock.Unlock()
+
+	if sub.Snapshot {
+		conn.sendEventHistory(added)
+	}
+	conn.notifySub(SubKindEvent)
+	conn.ackSubEvent(sub.UUID)
 }
 
 func (conn *Connection) onRemoveSubEvent(payload []byte) {
-	var events []string
-	if err := json.Unmarshal(payload, &events); err != nil {
+	var sub message.SubPayload
+	if err := json.Unmarshal(payload, &sub); err != nil {
 		return
 	}
+	events := sub.Items
 
 	conn.eventsLock.Lock()
 	for _, event := range events {
 		delete(conn.pubEvents, event)
 	}
 	conn.eventsLock.Unlock()
+
+	conn.notifySub(SubKindEvent)
+	conn.ackSubEvent(sub.UUID)
 }
 
-func (conn *Connection) onClearSubEvent([]byte) {
+func (conn *Connection) onClearSubEvent(payload []byte) {
 	conn.eventsLock.Lock()
 	conn.pubEvents = make(map[string]struct{})
 	conn.eventsLock.Unlock()
+	conn.notifySub(SubKindEvent)
+
+	var sub message.SubPayload
+	if json.Unmarshal(payload, &sub) == nil {
+		conn.ackSubEvent(sub.UUID)
+	}
 }
 
-func (conn *Connection) onState(payload []byte) {
-	state := message.StatePayload{}
-	if json.Unmarshal(payload, &state) != nil {
+// notifySub 若物模型配置了订阅变化回调, 则以连接当前类别为kind的完整订阅列表调用回调
+func (conn *Connection) notifySub(kind SubKind) {
+	if conn.m.subHandler == nil {
 		return
 	}
 
-	// 字段缺失或者为空
-	if strings.TrimSpace(state.Name) == "" || state.Data == nil {
+	items := conn.GetSubStates()
+	if kind == SubKindEvent {
+		items = conn.GetSubEvents()
+	}
+	conn.safeCall(func() {
+		conn.m.subHandler.OnSubscription(conn, kind, items)
+	})
+}
+
+// notifySubOnClose 连接关闭后, 若连接有状态或事件订阅, 则以空的订阅列表调用订阅变化回调
+func (conn *Connection) notifySubOnClose() {
+	if conn.m.subHandler == nil {
 		return
 	}
 
-	conn.statesChan <- state
+	if len(conn.GetSubStates()) > 0 {
+		conn.safeCall(func() {
+			conn.m.subHandler.OnSubscription(conn, SubKindState, []string{})
+		})
+	}
+	if len(conn.GetSubEvents()) > 0 {
+		conn.safeCall(func() {
+			conn.m.subHandler.OnSubscription(conn, SubKindEvent, []string{})
+		})
+	}
 }
 
-func (conn *Connection) onEvent(payload []byte) {
-	event := message.EventPayload{}
-	if json.Unmarshal(payload, &event) != nil {
+// ackSubEvent 若uuid不为空, 则向对端回复订阅确认, 确认报文中包含当前有效的事件订阅列表
+func (conn *Connection) ackSubEvent(uuid string) {
+	if uuid == "" {
+		return
+	}
+	_ = conn.sendMsg(message.EncodeSubAckMsg(uuid, existItems(conn.GetSubEvents(), conn.m.servedMeta().AllEvents())))
+}
+
+func (conn *Connection) onState(payload []byte) {
+	// 报文内容无效或者字段缺失
+	state, err := message.ParseStatePayload(payload)
+	if err != nil {
 		return
 	}
 
-	// 字段缺失或者为空
-	if strings.TrimSpace(event.Name) == "" || event.Args == nil {
+	conn.statesChan <- state
+}
+
+func (conn *Connection) onEvent(payload []byte) {
+	// 报文内容无效或者字段缺失
+	event, err := message.ParseEventPayload(payload)
+	if err != nil {
 		return
 	}
 
@@ -528,28 +1577,28 @@ func (conn *Connection) onEvent(payload []byte) {
 }
 
 func (conn *Connection) onCall(payload []byte) {
-	call := message.CallPayload{}
-	if json.Unmarshal(payload, &call) != nil {
-		return
-	}
-	// 参数缺失或者为空
-	if strings.TrimSpace(call.Name) == "" ||
-		strings.TrimSpace(call.UUID) == "" ||
-		call.Args == nil {
+	// 报文内容无效或者参数缺失
+	call, err := message.ParseCallPayload(payload)
+	if err != nil {
 		return
 	}
 	go conn.dealCallReq(call)
 }
 
-func (conn *Connection) onResp(payload []byte) {
-	resp := message.ResponsePayload{}
-	if json.Unmarshal(payload, &resp) != nil {
+func (conn *Connection) onSetState(payload []byte) {
+	// 报文内容无效或者字段缺失
+	setState, err := message.ParseSetStatePayload(payload)
+	if err != nil {
 		return
 	}
+	go conn.dealSetStateReq(setState)
+}
 
-	// 参数缺失或者为空
+func (conn *Connection) onResp(payload []byte) {
+	// 报文内容无效或者参数缺失
 	// NOTE: 无error字段, 认为无错误, 不视为出错
-	if strings.TrimSpace(resp.UUID) == "" || resp.Response == nil {
+	resp, parseErr := message.ParseResponsePayload(payload)
+	if parseErr != nil {
 		return
 	}
 
@@ -569,46 +1618,267 @@ func (conn *Connection) onResp(payload []byte) {
 }
 
 func (conn *Connection) onQueryMeta([]byte) {
-	msg := message.Must(message.EncodeRawMsg("meta-info", conn.m.meta.ToJSON()))
+	if conn.m.metaDisclosure == MetaDiscloseNever {
+		_ = conn.sendMsg(message.EncodeMetaErrorMsg("meta disclosure disabled"))
+		return
+	}
+	msg := message.Must(message.EncodeRawMsg("meta-info", conn.m.servedMeta().ToJSON()))
 	_ = conn.sendMsg(msg)
 }
 
 func (conn *Connection) onMetaInfo(payload []byte) {
 	conn.onMetaOnce.Do(func() {
+		// 对端拒绝公开元信息
+		metaErr := message.MetaErrorPayload{}
+		if json.Unmarshal(payload, &metaErr) == nil && metaErr.Error != nil {
+			conn.peerMetaErr = errors.New(*metaErr.Error)
+			close(conn.metaGotCh)
+			return
+		}
+
 		conn.peerMeta, conn.peerMetaErr = meta.Parse(payload, nil)
 		close(conn.metaGotCh)
 	})
 }
 
-func (conn *Connection) sendState(fullName string, data interface{}) {
+func (conn *Connection) onPing(payload []byte) {
+	ping, err := message.ParsePingPayload(payload)
+	if err != nil {
+		return
+	}
+	_ = conn.sendMsg(message.EncodePongMsg(ping.UUID))
+}
+
+func (conn *Connection) onPong(payload []byte) {
+	pong, err := message.ParsePingPayload(payload)
+	if err != nil {
+		return
+	}
+
+	if strings.TrimSpace(pong.UUID) == "" {
+		return
+	}
+
+	if waiter := conn.removeRespWaiter(pong.UUID); waiter != nil {
+		waiter.wake(message.RawResp{}, nil)
+	}
+}
+
+// sendState 若对端订阅了全名为fullName的状态, 则发送状态报文msg, 开启去重时与上一次发送的状态报文比较去重比较报文key
+func (conn *Connection) sendState(fullName string, msg []byte, key []byte, dedup bool) {
 	conn.statesLock.RLock()
 	defer conn.statesLock.RUnlock()
-	if _, seen := conn.pubStates[fullName]; seen {
-		if msg, err := message.EncodeStateMsg(fullName, data); err == nil {
-			_ = conn.sendMsg(msg)
-		}
+	if _, seen := conn.pubStates[fullName]; !seen {
+		return
+	}
+
+	if !dedup {
+		_ = conn.sendCoalesced(msg, fullName)
+		return
+	}
+
+	// 与上一次发送的状态报文相同则不发送, 上一次的状态报文因发送队列已满被丢弃时不去重
+	conn.lastStatesLock.Lock()
+	defer conn.lastStatesLock.Unlock()
+	dropped := conn.sendQueue != nil && conn.sendQueue.takeDropped(fullName)
+	if last, seen := conn.lastStates[fullName]; seen && !dropped && bytes.Equal(last, key) {
+		return
+	}
+	if conn.sendCoalesced(msg, fullName) == nil {
+		conn.lastStates[fullName] = key
 	}
 }
 
-func (conn *Connection) sendEvent(fullName string, args message.Args) {
+func (conn *Connection) sendEvent(fullName string, seq uint64, args message.Args) {
 	conn.eventsLock.RLock()
 	defer conn.eventsLock.RUnlock()
 	if _, seen := conn.pubEvents[fullName]; seen {
-		if msg, err := message.EncodeEventMsg(fullName, args); err == nil {
-			_ = conn.sendMsg(msg)
+		if msg, err := message.EncodeEventSeqMsg(fullName, seq, args); err == nil {
+			_ = conn.sendCoalesced(msg, "")
 		}
 	}
 }
 
 func (conn *Connection) sendMsg(msg []byte) error {
+	if conn.sendQueue != nil {
+		return <-conn.sendQueue.push(msg)
+	}
+
+	conn.writeLock.Lock()
+	defer conn.writeLock.Unlock()
+	if err := conn.flushLocked(); err != nil {
+		return err
+	}
+	return conn.writeRaw(msg)
+}
+
+// writeRaw 通过底层连接发送报文msg, 发送前调用抓包回调
+// NOTE: 调用前必须持有 writeLock
+func (conn *Connection) writeRaw(msg []byte) error {
+	conn.tapMsg(Outbound, msg)
+	return conn.raw.WriteMsg(msg)
+}
+
+// tapMsg 以方向dir调用抓包回调, 回调发生panic时不影响报文收发
+func (conn *Connection) tapMsg(dir Direction, data []byte) {
+	if conn.tap != nil {
+		conn.safeCall(func() {
+			conn.tap(dir, data)
+		})
+	}
+}
+
+// sendCoalesced 发送状态全名为key(事件报文为空)的状态或事件报文msg, 开启合并发送时先缓存报文, 缓存的字节数达到阈值时立即发送
+func (conn *Connection) sendCoalesced(msg []byte, key string) error {
+	if conn.coalesceDelay <= 0 {
+		if conn.sendQueue != nil {
+			return conn.pushLow(msg, key)
+		}
+		return conn.sendMsg(msg)
+	}
+
 	conn.writeLock.Lock()
-	ans := conn.raw.WriteMsg(msg)
+	defer conn.writeLock.Unlock()
+	conn.pending = append(conn.pending, msg)
+	conn.pendingBytes += len(msg)
+	if conn.coalesceBytes > 0 && conn.pendingBytes >= conn.coalesceBytes {
+		return conn.flushLocked()
+	}
+	if conn.flushTimer == nil {
+		conn.flushTimer = time.AfterFunc(conn.coalesceDelay, func() {
+			// NOTE: 定时发送没有调用者接收写入错误, 写入失败时关闭连接
+			if err := conn.flush(); err != nil {
+				_ = conn.close(CloseKindWriteError, "write: "+err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// Flush 立即发送通过 WithWriteCoalesce 缓存的所有状态和事件报文, 返回底层连接的写入错误, 没有缓存的报文时返回nil.
+// 没有开启合并发送的连接每个报文都直接写入底层连接, Flush 总是返回nil.
+// 开启了 WithPrioritySend 的连接 Flush 还会等待发送队列中的报文全部写入, 队列因写入失败或者连接关闭而结束时返回其原因.
+// Flush 返回时, 在其之前推送的所有报文都已经按推送顺序写入底层连接, 需要确认报文已经发出的调用者可以等待 Flush 返回.
+// Close 和 CloseWithReason 关闭底层连接前也会发送缓存的报文, 但忽略写入错误, 需要关心写入错误时应在关闭前调用 Flush.
+// 连接关闭后缓存的报文已经全部发送, 之后再推送的报文写入失败时 Flush 返回写入错误.
+func (conn *Connection) Flush() error {
+	return conn.flush()
+}
+
+// flush 发送所有缓存的报文, 开启了优先级发送时还会等待发送队列中的报文全部发送
+func (conn *Connection) flush() error {
+	conn.writeLock.Lock()
+	err := conn.flushLocked()
 	conn.writeLock.Unlock()
-	return ans
+	if err != nil || conn.sendQueue == nil {
+		return err
+	}
+	return conn.sendQueue.sync()
+}
+
+// flushFor 与 flush 相同, 但最多等待timeout, 超时返回错误, 此时缓存的报文由后台继续发送直到连接关闭
+func (conn *Connection) flushFor(timeout time.Duration) error {
+	// NOTE: 管道带缓存, 超时后 flush 返回时不会阻塞
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.flush()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return errors.New("flush timeout")
+	}
+}
+
+// pushLow 将状态全名为key(事件报文为空)的状态或事件报文msg加入低优先级发送队列, 不等待报文写入,
+// 队列已经结束时返回结束的原因
+func (conn *Connection) pushLow(msg []byte, key string) error {
+	select {
+	case err := <-conn.sendQueue.pushLow(msg, key):
+		return err
+	default:
+		return nil
+	}
+}
+
+// flushLocked 发送所有缓存的报文, 只有一个报文时直接发送, 否则合并为批量报文发送.
+// NOTE: 调用前必须持有 writeLock
+func (conn *Connection) flushLocked() error {
+	if conn.flushTimer != nil {
+		conn.flushTimer.Stop()
+		conn.flushTimer = nil
+	}
+	if len(conn.pending) == 0 {
+		return nil
+	}
+
+	msg := conn.pending[0]
+	if len(conn.pending) > 1 {
+		msg = message.EncodeBatchMsg(conn.pending)
+	}
+	conn.pending = nil
+	conn.pendingBytes = 0
+
+	if conn.sendQueue != nil {
+		return conn.pushLow(msg, "")
+	}
+	return conn.writeRaw(msg)
+}
+
+// StatesChannel 注册并返回一个缓存大小为buffer的状态管道, 连接conn收到的所有状态报文都会写入该管道,
+// 连接关闭后管道被关闭, 可以作为状态回调的替代方式, 便于通过range或select接收状态.
+// 状态回调和所有通过 StatesChannel 注册的管道都会收到每一个状态报文.
+// NOTE: 管道已满时会阻塞后续状态报文的处理, 使用者需要及时从管道中读取状态.
+func (conn *Connection) StatesChannel(buffer int) <-chan StateMessage {
+	if buffer < 0 {
+		buffer = 0
+	}
+	ch := make(chan StateMessage, buffer)
+
+	conn.outChansLock.Lock()
+	defer conn.outChansLock.Unlock()
+	if conn.statesDone {
+		close(ch)
+		return ch
+	}
+	conn.stateOutChans = append(conn.stateOutChans, ch)
+	return ch
+}
+
+// EventsChannel 注册并返回一个缓存大小为buffer的事件管道, 连接conn收到的所有事件报文都会写入该管道,
+// 连接关闭后管道被关闭. 事件回调和所有通过 EventsChannel 注册的管道都会收到每一个事件报文.
+// NOTE: 管道已满时会阻塞后续事件报文的处理, 使用者需要及时从管道中读取事件.
+func (conn *Connection) EventsChannel(buffer int) <-chan EventMessage {
+	if buffer < 0 {
+		buffer = 0
+	}
+	ch := make(chan EventMessage, buffer)
+
+	conn.outChansLock.Lock()
+	defer conn.outChansLock.Unlock()
+	if conn.eventsDone {
+		close(ch)
+		return ch
+	}
+	conn.eventOutChans = append(conn.eventOutChans, ch)
+	return ch
 }
 
 func (conn *Connection) dealState() {
 	defer close(conn.statesQuited)
+	defer func() {
+		conn.outChansLock.Lock()
+		defer conn.outChansLock.Unlock()
+		conn.statesDone = true
+		for _, ch := range conn.stateOutChans {
+			close(ch)
+		}
+	}()
+
 	for state := range conn.statesChan {
 		i := strings.LastIndex(state.Name, "/")
 		if i == -1 {
@@ -617,12 +1887,46 @@ func (conn *Connection) dealState() {
 		modelName := state.Name[:i]
 		stateName := state.Name[i+1:]
 
-		conn.stateHandler.OnState(modelName, stateName, state.Data)
+		// 清除状态报文的状态数据为nil
+		data, cleared := []byte(state.Data), state.Cleared()
+		if cleared {
+			data = nil
+		}
+
+		conn.safeCall(func() {
+			if handler, ok := conn.stateHandler.(StateSeqHandler); ok {
+				handler.OnStateSeq(modelName, stateName, data, state.Seq)
+			} else {
+				conn.stateHandler.OnState(modelName, stateName, data)
+			}
+		})
+
+		conn.outChansLock.Lock()
+		outChans := conn.stateOutChans
+		conn.outChansLock.Unlock()
+		for _, ch := range outChans {
+			ch <- StateMessage{
+				ModelName: modelName,
+				StateName: stateName,
+				Data:      data,
+				Seq:       state.Seq,
+				Cleared:   cleared,
+			}
+		}
 	}
 }
 
 func (conn *Connection) dealEvent() {
 	defer close(conn.eventsQuited)
+	defer func() {
+		conn.outChansLock.Lock()
+		defer conn.outChansLock.Unlock()
+		conn.eventsDone = true
+		for _, ch := range conn.eventOutChans {
+			close(ch)
+		}
+	}()
+
 	for event := range conn.eventsChan {
 		i := strings.LastIndex(event.Name, "/")
 		if i == -1 {
@@ -631,102 +1935,303 @@ func (conn *Connection) dealEvent() {
 		modelName := event.Name[:i]
 		eventName := event.Name[i+1:]
 
-		conn.eventHandler.OnEvent(modelName, eventName, event.Args)
+		conn.safeCall(func() {
+			if handler, ok := conn.eventHandler.(EventSeqHandler); ok {
+				handler.OnEventSeq(modelName, eventName, event.Args, event.Seq)
+			} else {
+				conn.eventHandler.OnEvent(modelName, eventName, event.Args)
+			}
+		})
+
+		conn.outChansLock.Lock()
+		outChans := conn.eventOutChans
+		conn.outChansLock.Unlock()
+		for _, ch := range outChans {
+			ch <- EventMessage{
+				ModelName: modelName,
+				EventName: eventName,
+				Args:      event.Args,
+				Seq:       event.Seq,
+			}
+		}
 	}
 }
 
+// safeCall 调用回调处理函数f, 若f发生panic则恢复并交给物模型的panic处理函数, 返回f是否发生了panic
+func (conn *Connection) safeCall(f func()) (panicked bool) {
+	defer conn.m.recoverPanic(&panicked)
+	f()
+	return
+}
+
 func (conn *Connection) dealCallReq(call message.CallPayload) {
 	// 1.获取调用参数信息
 	fullName := call.Name
 	uuidStr := call.UUID
 	args := call.Args
+	begin := time.Now()
+	fail := func(method string, err error) {
+		conn.sendErrorResp(uuidStr, method, err)
+		conn.auditCall(call, begin, nil, err)
+	}
 
 	// 2.分解模型名和方法名
 	i := strings.LastIndex(fullName, "/")
 	if i == -1 {
-		resp := message.Must(message.EncodeRespMsg(uuidStr,
-			"fullName is invalid format",
-			message.Resp{}))
-		_ = conn.sendMsg(resp)
+		fail(fullName, errors.New("fullName is invalid format"))
 		return
 	}
 
 	modelName := fullName[:i]
 	methodName := fullName[i+1:]
 
-	// 3.校验模型名称是否匹配
-	if modelName != conn.m.meta.Name {
-		resp := message.Must(message.EncodeRespMsg(uuidStr,
-			fmt.Sprintf("modelName %q: unmatched", modelName),
-			message.Resp{}))
-		_ = conn.sendMsg(resp)
+	// 3.按模型名称路由到本端物模型或者子物模型
+	target := conn.m.modelOf(modelName)
+	if target == nil {
+		fail(methodName, fmt.Errorf("modelName %q: unmatched", modelName))
 		return
 	}
 
-	// 4. 校验调用请求参数
-	if err := conn.m.meta.VerifyRawMethodArgs(methodName, args); err != nil {
-		resp := message.Must(message.EncodeRespMsg(uuidStr,
-			err.Error(),
-			message.Resp{}))
-		_ = conn.sendMsg(resp)
+	// 4.物模型忙碌时拒绝调用请求
+	if err := target.busyErr(); err != nil {
+		fail(methodName, err)
 		return
 	}
 
-	// 5.没有注册回调，直接返回错误信息
-	if conn.m.callReqHandler == nil {
-		resp := message.Must(message.EncodeRespMsg(uuidStr,
-			"NO callback",
-			message.Resp{}))
-		_ = conn.sendMsg(resp)
+	// 5.校验调用请求参数, 补全对端省略的有默认值的参数
+	if err := target.meta.VerifyRawMethodArgs(methodName, args); err != nil {
+		fail(methodName, err)
+		return
+	}
+	args, err := target.meta.FillRawMethodArgDefaults(methodName, args)
+	if err != nil {
+		fail(methodName, err)
+		return
+	}
+
+	// 6.没有注册回调，直接返回错误信息
+	handler := target.callHandler()
+	if handler == nil {
+		fail(methodName, errors.New("NO callback"))
 		return
 	}
 
-	// 6.调用回调
-	resp := conn.m.callReqHandler.OnCallReq(methodName, args)
+	// 7.调用回调, 回调发生panic时返回错误响应, 回调超时时返回超时错误响应
+	ctx := &CallContext{conn: conn, uuid: uuidStr, headers: call.Headers}
+	resp, panicked, timeout := conn.invokeHandler(handler, ctx, methodName, args)
+	if timeout {
+		fail(methodName, errors.New("handler timeout"))
+		return
+	}
+	if panicked {
+		fail(methodName, errors.New("internal error"))
+		return
+	}
 	if resp == nil {
 		resp = message.Resp{}
 	}
 
-	// 7.校验响应
+	// 8.校验响应
+	var respErr error
 	errStr := ""
-	if conn.m.verifyResp {
-		err := conn.m.meta.VerifyMethodResp(methodName, resp)
-		if err != nil {
-			errStr = err.Error()
+	if target.verifyRespEnabled(methodName) {
+		respErr = target.meta.VerifyMethodResp(methodName, resp)
+		if respErr != nil {
+			errStr = respErr.Error()
 		}
 	}
 
-	// 8.发送响应
+	// 9.时长类型按照元信息中声明的单位编码
+	wireResp, err := target.meta.MethodRespInUnit(methodName, resp)
+	if err != nil {
+		fail(methodName, err)
+		return
+	}
+
+	// 10.发送响应
 	msg := message.Must(message.EncodeRespMsg(uuidStr,
 		errStr,
-		resp))
+		wireResp))
 
 	// TODO: 发送失败是否需要写日志
 	_ = conn.sendMsg(msg)
+
+	// 11.审计调用结果
+	conn.auditCall(call, begin, resp, respErr)
+}
+
+// auditCall 配置了调用审计回调时(见 WithCallAudit), 在后台协程中以调用请求call的审计记录调用审计回调,
+// begin为开始处理调用请求的时间, resp和err分别为调用请求回调的返回值和响应报文的错误信息.
+func (conn *Connection) auditCall(call message.CallPayload, begin time.Time, resp message.Resp, err error) {
+	onCall := conn.m.callAudit
+	if onCall == nil {
+		return
+	}
+
+	remoteAddr := ""
+	if addr := conn.RemoteAddr(); addr != nil {
+		remoteAddr = addr.String()
+	}
+	record := AuditRecord{
+		RemoteAddr: remoteAddr,
+		PeerName:   conn.PeerName(),
+		Method:     call.Name,
+		UUID:       call.UUID,
+		Args:       call.Args,
+		Resp:       resp,
+		Err:        err,
+		Duration:   time.Since(begin),
+	}
+	go conn.safeCall(func() {
+		onCall(record)
+	})
+}
+
+// invokeHandler 以调用上下文ctx、方法名methodName和参数args调用调用请求回调handler, 返回回调的返回值和回调是否发生panic,
+// 配置了回调超时时间(见 WithCallHandlerTimeout)时, 回调超时未返回则取消ctx并返回timeout为true, 回调之后的结果被丢弃.
+func (conn *Connection) invokeHandler(handler CallRequestContextHandler, ctx *CallContext, methodName string,
+	args message.RawArgs) (resp message.Resp, panicked bool, timeout bool) {
+	if conn.m.handlerTimeout <= 0 {
+		panicked = conn.safeCall(func() {
+			resp = handler.OnCallReqContext(ctx, methodName, args)
+		})
+		return resp, panicked, false
+	}
+
+	type result struct {
+		resp     message.Resp
+		panicked bool
+	}
+	// NOTE: 回调返回或者超时后都取消上下文, 回调可以通过 CallContext.Done 感知超时
+	handlerCtx, cancel := context.WithTimeout(context.Background(), conn.m.handlerTimeout)
+	defer cancel()
+	ctx.ctx = handlerCtx
+
+	// NOTE: 管道带缓存, 超时后回调返回时不会阻塞
+	done := make(chan result, 1)
+	go func() {
+		var ans result
+		ans.panicked = conn.safeCall(func() {
+			ans.resp = handler.OnCallReqContext(ctx, methodName, args)
+		})
+		done <- ans
+	}()
+
+	select {
+	case ans := <-done:
+		return ans.resp, ans.panicked, false
+	case <-handlerCtx.Done():
+		return nil, false, true
+	}
 }
 
-func (conn *Connection) addRespWaiter(uuid string) *RespWaiter {
+// dealSetStateReq 处理状态设置请求, 依次校验模型名称、状态的访问权限和状态数据, 再调用状态设置回调,
+// 以响应返回值为空的调用响应报文回复设置结果.
+func (conn *Connection) dealSetStateReq(setState message.SetStatePayload) {
+	reply := func(err error) {
+		errStr := ""
+		if err != nil {
+			errStr = err.Error()
+		}
+		_ = conn.sendMsg(message.Must(message.EncodeRespMsg(setState.UUID, errStr, message.Resp{})))
+	}
+
+	// 1.分解模型名和状态名
+	i := strings.LastIndex(setState.Name, "/")
+	if i == -1 {
+		reply(errors.New("fullName is invalid format"))
+		return
+	}
+
+	modelName := setState.Name[:i]
+	stateName := setState.Name[i+1:]
+
+	// 2.按模型名称路由到本端物模型或者子物模型
+	target := conn.m.modelOf(modelName)
+	if target == nil {
+		reply(fmt.Errorf("modelName %q: unmatched", modelName))
+		return
+	}
+
+	// 3.校验访问权限和状态数据
+	if err := target.meta.VerifyStateWrite(stateName); err != nil {
+		reply(err)
+		return
+	}
+	if err := target.meta.VerifyRawState(stateName, setState.Data); err != nil {
+		reply(err)
+		return
+	}
+
+	// 4.没有注册回调，直接返回错误信息
+	handler := target.stateSetter()
+	if handler == nil {
+		reply(errors.New("NO callback"))
+		return
+	}
+
+	// 5.调用回调, 回调发生panic时返回错误响应
+	var err error
+	if conn.safeCall(func() {
+		err = handler.OnStateSet(stateName, setState.Data)
+	}) {
+		reply(errors.New("internal error"))
+		return
+	}
+
+	reply(err)
+}
+
+// sendErrorResp 发送调用标识为uuid的错误响应报文, 响应返回值由物模型的错误响应返回值生成函数生成
+func (conn *Connection) sendErrorResp(uuid string, method string, err error) {
+	resp, encodeErr := message.EncodeRespMsg(uuid, err.Error(), conn.m.errorResp(method, err))
+	if encodeErr != nil {
+		resp = message.Must(message.EncodeRespMsg(uuid, err.Error(), message.Resp{}))
+	}
+	_ = conn.sendMsg(resp)
+}
+
+// addRespWaiter 添加等待uuid对应响应的等待器, 若uuid已经有未收到响应的等待器, 返回错误信息, 避免覆盖先前的等待器
+func (conn *Connection) addRespWaiter(uuid string) (*RespWaiter, error) {
 	conn.waitersLock.Lock()
 	defer conn.waitersLock.Unlock()
+	if _, seen := conn.respWaiters[uuid]; seen {
+		return nil, fmt.Errorf("uuid %q reused", uuid)
+	}
 	waiter := &RespWaiter{
-		got: make(chan struct{}),
+		conn: conn,
+		uuid: uuid,
+		got:  make(chan struct{}),
 	}
 	conn.respWaiters[uuid] = waiter
-	return waiter
+	return waiter, nil
 }
 
+// removeRespWaiter 移除并返回uuid对应的等待器, 不存在时返回nil
 func (conn *Connection) removeRespWaiter(uuid string) *RespWaiter {
 	conn.waitersLock.Lock()
 	defer conn.waitersLock.Unlock()
 	waiter := conn.respWaiters[uuid]
+	delete(conn.respWaiters, uuid)
 	return waiter
 }
 
+// waitRespFor 等待waiter的调用响应, 最长等待timeout, 超时后移除waiter, 避免 PendingCalls 中残留已经放弃等待的请求,
+// 之后到达的响应被忽略
+func (conn *Connection) waitRespFor(waiter *RespWaiter, timeout time.Duration) (message.RawResp, error) {
+	resp, err := waiter.WaitFor(timeout)
+	if err != nil {
+		waiter.drop()
+	}
+	return resp, err
+}
+
 func (conn *Connection) notifyRespWaiterOnClose(reason string) {
 	conn.waitersLock.Lock()
 	defer conn.waitersLock.Unlock()
 
 	// 唤醒所有等待
+	atomic.AddUint64(&conn.droppedCalls, uint64(len(conn.respWaiters)))
 	for _, waiter := range conn.respWaiters {
 		waiter.wake(message.RawResp{}, fmt.Errorf("connection closed for: %s", reason))
 	}
@@ -741,3 +2246,30 @@ func (conn *Connection) notifyMetaWaiterOnClose(reason string) {
 		close(conn.metaGotCh)
 	})
 }
+
+// newItems 返回items中不在集合set中的所有项
+func newItems(set map[string]struct{}, items []string) []string {
+	ans := make([]string, 0, len(items))
+	for _, item := range items {
+		if _, seen := set[item]; !seen {
+			ans = append(ans, item)
+		}
+	}
+	return ans
+}
+
+// existItems 返回items中同时存在于all中的所有项, 保持items中的顺序
+func existItems(items []string, all []string) []string {
+	set := make(map[string]struct{}, len(all))
+	for _, item := range all {
+		set[item] = struct{}{}
+	}
+
+	ans := make([]string, 0, len(items))
+	for _, item := range items {
+		if _, seen := set[item]; seen {
+			ans = append(ans, item)
+		}
+	}
+	return ans
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"github.com/object-model/goModel/message"
+	"strings"
+)
+
+// eventRing 为固定容量的事件报文环形缓存, 缓存满后覆盖最早的事件报文
+type eventRing struct {
+	msgs [][]byte // 事件报文, 容量固定
+	next int      // 下一个写入位置
+	full bool     // 缓存是否已满
+}
+
+func newEventRing(n int) *eventRing {
+	return &eventRing{msgs: make([][]byte, n)}
+}
+
+// add 缓存事件报文msg, 缓存满时覆盖最早的事件报文
+func (r *eventRing) add(msg []byte) {
+	r.msgs[r.next] = msg
+	r.next = (r.next + 1) % len(r.msgs)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// all 按推送顺序返回缓存的所有事件报文, 最早的在前
+func (r *eventRing) all() [][]byte {
+	if !r.full {
+		return append([][]byte(nil), r.msgs[:r.next]...)
+	}
+	ans := make([][]byte, 0, len(r.msgs))
+	ans = append(ans, r.msgs[r.next:]...)
+	return append(ans, r.msgs[:r.next]...)
+}
+
+// WithEventHistory 配置物模型缓存名称为name的事件最近推送的n个事件报文, 用于调试或者让后订阅的对端补齐最近的事件,
+// 缓存的事件可以通过 Model.EventHistory 获取, 也可以由对端通过 Connection.SubEventWithHistory 在订阅后立即收到.
+// 每个事件的缓存容量固定为n, 超出后覆盖最早的事件, n小于等于0时不缓存该事件. 多次配置同一个事件时以最后一次为准.
+func WithEventHistory(name string, n int) ModelOption {
+	return func(model *Model) {
+		model.historyLock.Lock()
+		defer model.historyLock.Unlock()
+		if n <= 0 {
+			delete(model.eventHistory, name)
+			return
+		}
+		if model.eventHistory == nil {
+			model.eventHistory = make(map[string]*eventRing)
+		}
+		model.eventHistory[name] = newEventRing(n)
+	}
+}
+
+// EventHistory 按推送顺序返回物模型m最近推送的名称为name的事件的参数, 最早的在前, 最多返回 WithEventHistory 配置的数量.
+// 没有为该事件配置缓存或者从未推送过该事件时返回nil. EventHistory 可以在任意协程中与 PushEvent 并发调用.
+func (m *Model) EventHistory(name string) []message.RawArgs {
+	msgs := m.eventHistoryMsgs(name)
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	ans := make([]message.RawArgs, 0, len(msgs))
+	for _, msg := range msgs {
+		event := struct {
+			Payload message.EventPayload `json:"payload"`
+		}{}
+		if json.Unmarshal(msg, &event) == nil {
+			ans = append(ans, event.Payload.Args)
+		}
+	}
+	return ans
+}
+
+// recordEvent 若为名称为name的事件配置了缓存, 则缓存全名为fullName, 推送序号为seq, 参数为args的事件报文
+func (m *Model) recordEvent(name string, fullName string, seq uint64, args message.Args) {
+	m.historyLock.Lock()
+	defer m.historyLock.Unlock()
+	ring, seen := m.eventHistory[name]
+	if !seen {
+		return
+	}
+	if msg, err := message.EncodeEventSeqMsg(fullName, seq, args); err == nil {
+		ring.add(msg)
+	}
+}
+
+// eventHistoryMsgs 按推送顺序返回缓存的名称为name的事件报文
+func (m *Model) eventHistoryMsgs(name string) [][]byte {
+	m.historyLock.Lock()
+	defer m.historyLock.Unlock()
+	if ring, seen := m.eventHistory[name]; seen {
+		return ring.all()
+	}
+	return nil
+}
+
+// sendEventHistory 向对端依次推送事件全名列表events中每个事件缓存的事件报文,
+// 事件按模型名路由到本端物模型或者子物模型
+func (conn *Connection) sendEventHistory(events []string) {
+	for _, fullName := range events {
+		i := strings.LastIndex(fullName, "/")
+		if i == -1 {
+			continue
+		}
+		target := conn.m.modelOf(fullName[:i])
+		if target == nil {
+			continue
+		}
+		for _, msg := range target.eventHistoryMsgs(fullName[i+1:]) {
+			_ = conn.sendMsg(msg)
+		}
+	}
+}
@@ -1,16 +1,23 @@
 package model
 
 import (
+	"compress/flate"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/object-model/goModel/message"
 	"github.com/object-model/goModel/meta"
 	"github.com/object-model/goModel/rawConn"
 	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var upgrader = websocket.Upgrader{
@@ -39,15 +46,172 @@ func (c CallRequestFunc) OnCallReq(name string, args message.RawArgs) message.Re
 	return c(name, args)
 }
 
+// CallRequestConnHandler 为携带连接的调用请求处理接口, 参数conn为收到调用请求的连接,
+// 处理调用请求时可以通过conn与调用方交互, 或者通过物模型推送事件(例如推送执行进度事件).
+type CallRequestConnHandler interface {
+	OnCallReqConn(conn *Connection, name string, args message.RawArgs) message.Resp
+}
+
+// CallRequestConnFunc 为携带连接的调用请求回调函数, 参数conn为收到调用请求的连接,
+// 参数name为调用的方法名, 参数args为调用参数, 函数返回值为调用请求的返回值.
+type CallRequestConnFunc func(conn *Connection, name string, args message.RawArgs) message.Resp
+
+func (c CallRequestConnFunc) OnCallReqConn(conn *Connection, name string, args message.RawArgs) message.Resp {
+	return c(conn, name, args)
+}
+
+// callReqAdapter 将 CallRequestHandler 适配为 CallRequestConnHandler 和 CallRequestContextHandler
+type callReqAdapter struct {
+	CallRequestHandler
+}
+
+func (c callReqAdapter) OnCallReqConn(_ *Connection, name string, args message.RawArgs) message.Resp {
+	return c.OnCallReq(name, args)
+}
+
+func (c callReqAdapter) OnCallReqContext(_ *CallContext, name string, args message.RawArgs) message.Resp {
+	return c.OnCallReq(name, args)
+}
+
+// StateSetHandler 为状态设置请求处理接口, 参数name为设置的状态名, 参数data为设置的状态原始数据,
+// 返回nil表示设置成功, 否则返回的错误信息作为响应报文的错误提示信息.
+type StateSetHandler interface {
+	OnStateSet(name string, data []byte) error
+}
+
+// StateSetFunc 为状态设置请求回调函数, 参数name为设置的状态名, 参数data为设置的状态原始数据,
+// 返回nil表示设置成功, 否则返回设置失败的原因.
+type StateSetFunc func(name string, data []byte) error
+
+func (s StateSetFunc) OnStateSet(name string, data []byte) error {
+	return s(name, data)
+}
+
+// SubKind 为订阅类别
+type SubKind int
+
+const (
+	SubKindState SubKind = iota // 状态订阅
+	SubKindEvent                // 事件订阅
+)
+
+func (k SubKind) String() string {
+	switch k {
+	case SubKindState:
+		return "state"
+	case SubKindEvent:
+		return "event"
+	default:
+		return fmt.Sprintf("SubKind(%d)", int(k))
+	}
+}
+
+// SubscriptionHandler 为订阅变化处理接口, 参数conn为订阅变化的连接, 参数kind为订阅类别,
+// 参数items为订阅变化后该连接的完整订阅列表, 按名称排序, 连接不再订阅任何状态或事件时为空列表.
+type SubscriptionHandler interface {
+	OnSubscription(conn *Connection, kind SubKind, items []string)
+}
+
+// SubscriptionFunc 为订阅变化回调函数, 参数含义与 SubscriptionHandler 相同.
+type SubscriptionFunc func(conn *Connection, kind SubKind, items []string)
+
+func (s SubscriptionFunc) OnSubscription(conn *Connection, kind SubKind, items []string) {
+	s(conn, kind, items)
+}
+
+// PanicHandler 为回调处理函数发生panic时的处理函数, 参数recovered为recover()的返回值, 参数stack为发生panic时的调用栈
+type PanicHandler func(recovered interface{}, stack []byte)
+
+// PushAuditFunc 为推送审计回调函数, 参数name为校验失败的状态名, 参数err为校验错误信息
+type PushAuditFunc func(name string, err error)
+
+// AuditRecord 为一次调用请求的审计记录, 见 WithCallAudit
+type AuditRecord struct {
+	RemoteAddr string          // 调用方的地址
+	PeerName   string          // 调用方的物模型名称, 尚未获取到对端元信息时为空字符串, 见 Connection.PeerName
+	Method     string          // 调用的方法全名
+	UUID       string          // 调用请求的UUID
+	Args       message.RawArgs // 原始调用参数
+	Resp       message.Resp    // 调用请求回调的返回值, 没有调用回调或者回调发生panic、超时时为nil
+	Err        error           // 响应报文的错误提示信息, 调用成功时为nil
+	Duration   time.Duration   // 从开始处理调用请求到发出响应报文的耗时
+}
+
+// CallAuditFunc 为调用审计回调函数, 参数record为调用请求的审计记录
+type CallAuditFunc func(record AuditRecord)
+
+// ErrorResponseBuilder 为调用请求出错时的响应返回值生成函数, 参数method为调用的方法名, 参数err为错误信息,
+// 函数返回值作为错误响应报文的返回值, 可用于向调用方提供结构化的错误诊断信息. 错误响应报文的错误提示信息不受影响.
+type ErrorResponseBuilder func(method string, err error) message.Resp
+
+// MetaDisclosure 为物模型元信息公开策略, 决定物模型是否响应对端的元信息查询报文
+type MetaDisclosure int
+
+const (
+	MetaDiscloseAlways MetaDisclosure = iota // 总是向对端公开元信息, 默认策略
+	MetaDiscloseNever                        // 从不向对端公开元信息, 对端的元信息查询将收到错误提示
+)
+
+// cachedState 为缓存的状态报文
+type cachedState struct {
+	msg  []byte    // 状态报文
+	key  []byte    // 状态去重时比较的报文, 不含推送序号
+	time time.Time // 推送时间
+}
+
+// SubLimitPolicy 为订阅数量超出上限时的处理策略
+type SubLimitPolicy int
+
+const (
+	SubLimitTruncate SubLimitPolicy = iota // 忽略超出上限的订阅项并打印警告, 默认策略
+	SubLimitClose                          // 关闭连接
+)
+
 // Model 表示物模型, 提供了元信息查询、状态和事件发布、与其他物模型建立连接、运行TCP服务和WebSocket服务功能.
-// 若物模型的元信息包含方法, 并通过 WithCallReqHandler 或 WithCallReqFunc 注册了有效的调用请求回调,
+// 若物模型的元信息包含方法, 并通过 WithCallReqHandler 、 WithCallReqFunc 或 WithCallReqConnHandler 等注册了有效的调用请求回调,
 // 在收到有效的调用请求报文时, 物模型将自动触发调用请求回调.
 type Model struct {
-	meta           *meta.Meta               // 元信息
-	connLock       sync.RWMutex             // 保护 allConn
-	allConn        map[*Connection]struct{} // 所有连接
-	verifyResp     bool                     // 是否校验 callReqHandler 返回的响应返回值
-	callReqHandler CallRequestHandler       // 调用请求处理函数
+	// NOTE: seq 以原子操作访问, 必须是第一个字段, 保证在32位平台(386、ARM32、MIPS32)上64位对齐
+	seq             uint64                    // 最近一次推送的序号, 原子操作
+	meta            *meta.Meta                // 元信息
+	connLock        sync.RWMutex              // 保护 allConn
+	allConn         map[*Connection]struct{}  // 所有连接
+	callLock        sync.RWMutex              // 保护 verifyResp methodVerify callReqHandler callConnHandler callCtxHandler stateSetHandler 和 busy
+	verifyResp      bool                      // 是否校验 callReqHandler 返回的响应返回值
+	methodVerify    map[string]bool           // 运行时配置的每个方法是否校验响应返回值, 优先于元信息和 verifyResp
+	callReqHandler  CallRequestHandler        // 调用请求处理函数
+	callConnHandler CallRequestConnHandler    // 携带连接的调用请求处理函数, 与 callReqHandler 只有一个有效
+	callCtxHandler  CallRequestContextHandler // 携带调用上下文的调用请求处理函数, 与 callReqHandler 和 callConnHandler 只有一个有效
+	stateSetHandler StateSetHandler           // 状态设置请求处理函数
+	busy            *string                   // 忙碌原因, 为nil表示不忙碌, 见 SetBusy
+	subHandler      SubscriptionHandler       // 订阅变化处理函数, 为nil表示不通知
+	stateDedup      bool                      // 是否对连接上重复的状态报文去重
+	errRespBuilder  ErrorResponseBuilder      // 出错时的调用响应返回值生成函数
+	cacheLock       sync.RWMutex              // 保护 stateCache
+	stateCache      map[string]cachedState    // 每个状态最近一次推送的状态报文, 状态全名 -> 状态报文
+	tcpOpts         []rawConn.TcpOption       // TCP连接配置选项
+	metaDisclosure  MetaDisclosure            // 元信息公开策略
+	panicHandler    PanicHandler              // 回调处理函数发生panic时的处理函数
+	stateBuffSize   int                       // 连接的默认状态管道大小
+	eventBuffSize   int                       // 连接的默认事件管道大小
+	pushAudit       PushAuditFunc             // 推送审计回调, 为nil表示不审计
+	callAudit       CallAuditFunc             // 调用审计回调, 为nil表示不审计
+	wsSubprotocols  []string                  // WebSocket子协议, 按优先级排列
+	wsCompress      bool                      // 是否协商WebSocket的permessage-deflate压缩扩展
+	wsCompressLevel int                       // WebSocket报文的压缩级别, 仅在 wsCompress 为true时有效
+	maxSubs         int                       // 每个连接的状态或事件订阅数量上限, 为0表示不限制
+	subLimitPolicy  SubLimitPolicy            // 订阅数量超出上限时的处理策略
+	listenLock      sync.Mutex                // 保护 listener
+	listener        *net.TCPListener          // 通过 Listen 开启的TCP监听
+	seqEnabled      bool                      // 是否在状态和事件报文中携带推送序号
+	handlerTimeout  time.Duration             // 被调用方调用请求回调的超时时间, 为0表示不限制, 见 WithCallHandlerTimeout
+	callWaitTimeout time.Duration             // 调用方连接 Call 的默认等待超时时间, 为0表示一直等待, 见 WithDefaultCallTimeout
+	subLock         sync.RWMutex              // 保护 parent subModels 和 mergedMeta
+	parent          *Model                    // 宿主物模型, 为nil表示不是子物模型, 见 AddSubModel
+	subModels       []*Model                  // 挂载的子物模型, 按挂载顺序排列
+	mergedMeta      *meta.Meta                // 合并了所有子物模型元信息的元信息, 没有子物模型时为nil
+	historyLock     sync.Mutex                // 保护 eventHistory
+	eventHistory    map[string]*eventRing     // 事件名称 -> 最近推送的事件报文, 见 WithEventHistory
 }
 
 // ModelOption 为物模型创建选项
@@ -58,6 +222,8 @@ func WithCallReqHandler(onCall CallRequestHandler) ModelOption {
 	return func(model *Model) {
 		if onCall != nil {
 			model.callReqHandler = onCall
+			model.callConnHandler = nil
+			model.callCtxHandler = nil
 		}
 	}
 }
@@ -67,17 +233,307 @@ func WithCallReqFunc(onCall CallRequestFunc) ModelOption {
 	return func(model *Model) {
 		if onCall != nil {
 			model.callReqHandler = onCall
+			model.callConnHandler = nil
+			model.callCtxHandler = nil
+		}
+	}
+}
+
+// WithCallReqConnHandler 配置物模型的携带连接的调用请求回调处理.
+// 与 WithCallReqHandler 和 WithCallReqFunc 只有一个生效, 以最后配置的为准.
+func WithCallReqConnHandler(onCall CallRequestConnHandler) ModelOption {
+	return func(model *Model) {
+		if onCall != nil {
+			model.callConnHandler = onCall
+			model.callReqHandler = nil
+			model.callCtxHandler = nil
+		}
+	}
+}
+
+// WithCallReqConnFunc 配置物模型的携带连接的调用请求回调函数对象.
+// 与 WithCallReqHandler 和 WithCallReqFunc 只有一个生效, 以最后配置的为准.
+func WithCallReqConnFunc(onCall CallRequestConnFunc) ModelOption {
+	return func(model *Model) {
+		if onCall != nil {
+			model.callConnHandler = onCall
+			model.callReqHandler = nil
+			model.callCtxHandler = nil
+		}
+	}
+}
+
+// WithCallReqContextHandler 配置物模型的携带调用上下文的调用请求回调处理,
+// 回调中可以通过 CallContext.Headers 读取调用方通过 Connection.InvokeWithHeaders 附加的头部.
+// 与 WithCallReqHandler 、 WithCallReqConnHandler 等选项只有一个生效, 以最后配置的为准.
+func WithCallReqContextHandler(onCall CallRequestContextHandler) ModelOption {
+	return func(model *Model) {
+		if onCall != nil {
+			model.callCtxHandler = onCall
+			model.callReqHandler = nil
+			model.callConnHandler = nil
+		}
+	}
+}
+
+// WithCallReqContextFunc 配置物模型的携带调用上下文的调用请求回调函数对象.
+// 与 WithCallReqHandler 、 WithCallReqConnHandler 等选项只有一个生效, 以最后配置的为准.
+func WithCallReqContextFunc(onCall CallRequestContextFunc) ModelOption {
+	return func(model *Model) {
+		if onCall != nil {
+			model.callCtxHandler = onCall
+			model.callReqHandler = nil
+			model.callConnHandler = nil
+		}
+	}
+}
+
+// WithStateSetHandler 配置物模型的状态设置请求回调处理.
+// 物模型只接受对元信息中访问权限为可写(w或rw)的状态的设置请求, 并在调用回调前校验设置的状态数据.
+func WithStateSetHandler(onSet StateSetHandler) ModelOption {
+	return func(model *Model) {
+		if onSet != nil {
+			model.stateSetHandler = onSet
+		}
+	}
+}
+
+// WithStateSetFunc 配置物模型的状态设置请求回调函数, 见 WithStateSetHandler.
+func WithStateSetFunc(onSet StateSetFunc) ModelOption {
+	return func(model *Model) {
+		if onSet != nil {
+			model.stateSetHandler = onSet
+		}
+	}
+}
+
+// WithSubscriptionHandler 配置物模型的订阅变化回调处理, 对端通过连接修改状态或事件订阅后,
+// 物模型在更新该连接的订阅列表后调用onSub, 通知订阅变化后的完整订阅列表.
+// 有订阅的连接关闭时也会以空列表调用onSub, 因此可以据此按需开启或关闭资源, 例如没有连接订阅某个状态时停止采集该状态.
+// NOTE: onSub在连接的接收协程中同步调用, 耗时的onSub会阻塞该连接的报文处理
+func WithSubscriptionHandler(onSub SubscriptionHandler) ModelOption {
+	return func(model *Model) {
+		if onSub != nil {
+			model.subHandler = onSub
+		}
+	}
+}
+
+// WithSubscriptionFunc 配置物模型的订阅变化回调函数, 见 WithSubscriptionHandler.
+func WithSubscriptionFunc(onSub SubscriptionFunc) ModelOption {
+	return func(model *Model) {
+		if onSub != nil {
+			model.subHandler = onSub
 		}
 	}
 }
 
-// WithVerifyResp 开启物模型的响应校验选项
+// WithSequence 开启物模型的推送序号选项, 物模型推送的每个状态报文和事件报文都携带一个递增的推送序号,
+// 对端可以通过 StateSeqHandler 或者 StateMessage.Seq 获取序号, 并通过 message.SeqNewer 丢弃乱序到达的旧状态.
+// 序号从1开始, 物模型的所有状态和事件共用一个计数器, 递增到uint64最大值后回绕并跳过0.
+// 不识别推送序号的对端会忽略序号字段, 不受影响.
+func WithSequence() ModelOption {
+	return func(model *Model) {
+		model.seqEnabled = true
+	}
+}
+
+// WithVerifyResp 开启物模型的响应校验选项.
+// 元信息中方法声明了verifyResponse字段或者通过 Model.SetMethodVerifyResp 配置过的方法不受该选项影响.
 func WithVerifyResp() ModelOption {
 	return func(model *Model) {
 		model.verifyResp = true
 	}
 }
 
+// WithErrorResponseBuilder 配置物模型的错误响应返回值生成函数为builder, 默认错误响应的返回值为空.
+func WithErrorResponseBuilder(builder ErrorResponseBuilder) ModelOption {
+	return func(model *Model) {
+		if builder != nil {
+			model.errRespBuilder = builder
+		}
+	}
+}
+
+// WithStateDedup 开启物模型的状态去重选项.
+// 开启后, 对于每个连接, 若推送的状态报文与该连接上一次发送的同名状态报文完全相同, 则不再发送.
+// 需要周期性地发送状态报文时(例如作为心跳), 可以使用 Model.ForcePushState 绕过去重.
+func WithStateDedup() ModelOption {
+	return func(model *Model) {
+		model.stateDedup = true
+	}
+}
+
+// WithTcpOptions 配置物模型建立的所有TCP连接(包括TCP服务接受的连接和主动建立的连接)的配置选项为opts,
+// 例如通过 rawConn.WithMaxMsgSize 限制允许接收的最大报文长度.
+func WithTcpOptions(opts ...rawConn.TcpOption) ModelOption {
+	return func(model *Model) {
+		for _, opt := range opts {
+			if opt != nil {
+				model.tcpOpts = append(model.tcpOpts, opt)
+			}
+		}
+	}
+}
+
+// WithTCPNoDelay 配置物模型建立的所有TCP连接是否禁用Nagle算法, 等同于 WithTcpOptions(rawConn.WithNoDelay(noDelay)).
+// 未配置时保持默认行为. WebSocket连接忽略该选项.
+func WithTCPNoDelay(noDelay bool) ModelOption {
+	return WithTcpOptions(rawConn.WithNoDelay(noDelay))
+}
+
+// WithWebSocketSubprotocols 配置物模型WebSocket连接的子协议为protocols, 按优先级从高到低排列.
+// 主动建立WebSocket连接时通过 Sec-WebSocket-Protocol 请求头向服务端请求这些子协议,
+// WebSocket服务接受连接时从客户端请求的子协议中选择第一个受支持的子协议.
+func WithWebSocketSubprotocols(protocols ...string) ModelOption {
+	return func(model *Model) {
+		model.wsSubprotocols = append(model.wsSubprotocols, protocols...)
+	}
+}
+
+// WithWebSocketCompression 配置物模型的WebSocket连接通过标准的 permessage-deflate 扩展协商压缩报文, 压缩级别为level,
+// level的有效范围与 compress/flate 相同, 为 flate.HuffmanOnly 到 flate.BestCompression, 无效时不开启压缩.
+// 开启后, WebSocket服务接受连接和主动建立WebSocket连接时都会协商压缩扩展, 只有双方都支持时才压缩, 否则仍然发送未压缩的报文,
+// 因此可以与不支持压缩的对端通信. 浏览器都支持该扩展, 可以明显减小推送给网页的较大状态报文.
+// NOTE: 压缩会增加CPU开销, 报文较小时收益不大
+func WithWebSocketCompression(level int) ModelOption {
+	return func(model *Model) {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return
+		}
+		model.wsCompress = true
+		model.wsCompressLevel = level
+	}
+}
+
+// setupWebSocket 根据物模型的配置设置建立的WebSocket连接conn
+func (m *Model) setupWebSocket(conn *websocket.Conn) {
+	if m.wsCompress {
+		_ = conn.SetCompressionLevel(m.wsCompressLevel)
+	}
+}
+
+// WithDefaultStateBuffSize 配置物模型所有连接(包括TCP服务和WebSocket服务接受的连接和主动建立的连接)
+// 的默认状态管道大小为size, 未配置时为256. 建立连接时通过 WithStateBuffSize 配置的大小优先.
+func WithDefaultStateBuffSize(size int) ModelOption {
+	return func(model *Model) {
+		if size > 0 {
+			model.stateBuffSize = size
+		}
+	}
+}
+
+// WithDefaultEventBuffSize 配置物模型所有连接(包括TCP服务和WebSocket服务接受的连接和主动建立的连接)
+// 的默认事件管道大小为size, 未配置时为256. 建立连接时通过 WithEventBuffSize 配置的大小优先.
+func WithDefaultEventBuffSize(size int) ModelOption {
+	return func(model *Model) {
+		if size > 0 {
+			model.eventBuffSize = size
+		}
+	}
+}
+
+// WithPushAudit 开启物模型的推送审计选项, 用于开发调试.
+// 开启后, 通过 Model.PushState 或 Model.ForcePushState 推送状态时即使参数verify为false,
+// 也会在后台异步地根据元信息校验已编码的状态数据, 校验失败时调用onMismatch, 不会阻塞推送.
+// onMismatch为nil时通过标准库log打印校验失败的状态名和错误信息.
+func WithPushAudit(onMismatch PushAuditFunc) ModelOption {
+	return func(model *Model) {
+		if onMismatch == nil {
+			onMismatch = func(name string, err error) {
+				log.Printf("push state %q: %s", name, err)
+			}
+		}
+		model.pushAudit = onMismatch
+	}
+}
+
+// WithCallAudit 配置物模型的调用审计回调为onCall, 用于记录每个调用请求的调用方、方法、参数、结果和耗时, 例如写入审计日志.
+// 每个调用请求发出响应报文后都会调用onCall, 包括方法不存在、参数校验失败、物模型忙碌、回调panic或者超时等错误响应.
+// 与调用请求回调不同, onCall只能观察调用结果, 无法影响响应. onCall在后台协程中异步调用, 不会阻塞响应的发送,
+// 因此多个调用请求的审计记录不保证按响应的顺序到达. 调用挂载的子物模型的方法时使用宿主物模型的审计回调.
+// onCall为nil时该选项无效.
+func WithCallAudit(onCall CallAuditFunc) ModelOption {
+	return func(model *Model) {
+		if onCall != nil {
+			model.callAudit = onCall
+		}
+	}
+}
+
+// WithMetaDisclosure 配置物模型的元信息公开策略为policy, 默认为 MetaDiscloseAlways.
+// 策略为 MetaDiscloseNever 时, 物模型收到元信息查询报文后回复错误提示信息而不是元信息,
+// 对端通过 Connection.GetPeerMeta 获取元信息时将返回该错误.
+// NOTE: 依赖元信息的对端将无法正常工作, 例如代理服务在物模型连接后会查询其元信息,
+// 查询失败时将推送元信息校验错误事件并断开连接; 对端开启 WithValidatedSubscriptions 时也将无法订阅.
+func WithMetaDisclosure(policy MetaDisclosure) ModelOption {
+	return func(model *Model) {
+		if policy == MetaDiscloseAlways || policy == MetaDiscloseNever {
+			model.metaDisclosure = policy
+		}
+	}
+}
+
+// WithMaxSubscriptions 配置物模型每个连接的状态订阅数量和事件订阅数量的上限均为n, n不大于0时该选项无效, 默认不限制.
+// 对端设置或添加订阅后的订阅数量超出上限时, 根据 WithSubLimitPolicy 配置的策略处理, 避免恶意或者异常的对端耗尽资源.
+func WithMaxSubscriptions(n int) ModelOption {
+	return func(model *Model) {
+		if n > 0 {
+			model.maxSubs = n
+		}
+	}
+}
+
+// WithSubLimitPolicy 配置物模型订阅数量超出 WithMaxSubscriptions 配置的上限时的处理策略为policy, 默认为 SubLimitTruncate.
+// 策略为 SubLimitTruncate 时, 按照订阅列表的顺序保留不超过上限的订阅项, 忽略其余订阅项并通过标准库log打印警告;
+// 策略为 SubLimitClose 时, 不修改订阅并关闭连接, 关闭原因为"too many subscriptions".
+func WithSubLimitPolicy(policy SubLimitPolicy) ModelOption {
+	return func(model *Model) {
+		if policy == SubLimitTruncate || policy == SubLimitClose {
+			model.subLimitPolicy = policy
+		}
+	}
+}
+
+// WithPanicHandler 配置物模型的panic处理函数为onPanic.
+// 连接的状态回调、事件回调和物模型的调用请求回调发生panic时, 会恢复panic并调用onPanic, 连接继续正常工作.
+// 调用请求回调发生panic时, 调用方会收到错误提示信息为"internal error"的响应报文.
+// 默认的panic处理函数通过标准库log打印panic信息和调用栈.
+func WithPanicHandler(onPanic PanicHandler) ModelOption {
+	return func(model *Model) {
+		if onPanic != nil {
+			model.panicHandler = onPanic
+		}
+	}
+}
+
+// WithCallHandlerTimeout 配置物模型调用请求回调的超时时间为timeout, timeout不大于0时该选项无效, 默认不限制.
+// 调用请求回调在timeout内没有返回时, 调用方会收到错误提示信息为"handler timeout"的响应报文, 回调之后的返回值被丢弃.
+// 超时时回调的 CallContext.Context 被取消.
+// NOTE: 超时不会中断回调, 回调所在的协程会继续运行直到回调返回,
+// NOTE: 需要真正取消耗时操作的回调应使用 CallRequestContextHandler, 将 CallContext.Context 传递给耗时操作或者检查 CallContext.Done.
+// 该选项限制的是本物模型作为被调用方执行回调的时间, 调用方等待响应的超时时间见 WithDefaultCallTimeout.
+func WithCallHandlerTimeout(timeout time.Duration) ModelOption {
+	return func(model *Model) {
+		if timeout > 0 {
+			model.handlerTimeout = timeout
+		}
+	}
+}
+
+// WithDefaultCallTimeout 配置物模型所有连接(包括TCP服务和WebSocket服务接受的连接和主动建立的连接)
+// 的 Connection.Call 默认等待超时时间为timeout, timeout不大于0时该选项无效, 默认一直等待.
+// 配置后 Call 的行为与以timeout调用 Connection.CallFor 相同, 避免对端不响应也不关闭连接时调用方永久阻塞.
+// 建立连接时通过 WithCallTimeout 配置的超时时间优先, 显式调用 CallFor 时总是使用其参数timeout, 不受两者影响.
+// 该选项限制的是本物模型作为调用方等待响应的时间, 被调用方执行回调的超时时间见 WithCallHandlerTimeout.
+func WithDefaultCallTimeout(timeout time.Duration) ModelOption {
+	return func(model *Model) {
+		if timeout > 0 {
+			model.callWaitTimeout = timeout
+		}
+	}
+}
+
 // NewEmptyModel 创建一个状态、事件、方法都为空的物模型.
 func NewEmptyModel() *Model {
 	return New(meta.NewEmptyMeta())
@@ -95,6 +551,24 @@ func LoadFromFile(file string, tmpl meta.TemplateParam, opts ...ModelOption) (*M
 	return LoadFromBuff(content, tmpl, opts...)
 }
 
+// LoadFromFileEnv 从文件file中加载元信息, 从环境变量中读取元信息模板参数(见 meta.TemplateFromEnv), 并利用加载的元信息和配置参数opts创建物模型
+// 返回创建的物模型和错误信息. 模板参数name对应的环境变量名为 prefix+strings.ToUpper(name), 例如prefix为"MODEL_"时模板参数id对应环境变量MODEL_ID,
+// 同一个元信息文件部署到多个设备时, 可以通过环境变量设置每个设备的模板参数.
+// 如果加载失败或者有模板参数对应的环境变量未设置, LoadFromFileEnv 会返回由 NewEmptyModel() 创建的空物模型和错误信息, LoadFromFileEnv 不会返回值为nil的物模型.
+func LoadFromFileEnv(file string, prefix string, opts ...ModelOption) (*Model, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return NewEmptyModel(), err
+	}
+
+	tmpl, err := meta.TemplateFromEnv(content, prefix)
+	if err != nil {
+		return NewEmptyModel(), err
+	}
+
+	return LoadFromBuff(content, tmpl, opts...)
+}
+
 // LoadFromBuff 从缓存buff中加载元信息, 设置元信息模板参数为tmpl, 并利用加载的元信息和配置参数opts创建物模型
 // 返回创建的物模型和错误信息.
 // 如果加载失败, LoadFromBuff 会返回由 NewEmptyModel() 创建的空物模型和错误信息, LoadFromBuff 不会返回值为nil的物模型.
@@ -107,8 +581,14 @@ func LoadFromBuff(buff []byte, tmpl meta.TemplateParam, opts ...ModelOption) (*M
 // New 根据参数opts创建元信息为meta的物模型并返回这个新创建的物模型.
 func New(meta *meta.Meta, opts ...ModelOption) *Model {
 	ans := &Model{
-		meta:    meta,
-		allConn: make(map[*Connection]struct{}),
+		meta:          meta,
+		allConn:       make(map[*Connection]struct{}),
+		stateCache:    make(map[string]cachedState),
+		stateBuffSize: 256,
+		eventBuffSize: 256,
+		panicHandler: func(recovered interface{}, stack []byte) {
+			log.Printf("recovered from panic: %v\n%s", recovered, stack)
+		},
 	}
 
 	for _, opt := range opts {
@@ -119,31 +599,236 @@ func New(meta *meta.Meta, opts ...ModelOption) *Model {
 }
 
 // Meta 返回物模型m所加载的元信息.
+// NOTE: 返回的元信息不包含通过 AddSubModel 挂载的子物模型, 对端查询到的是合并后的元信息
 func (m *Model) Meta() *meta.Meta {
 	return m.meta
 }
 
+// AddSubModel 将物模型sub作为子物模型挂载到物模型m上, m的每个连接同时为sub提供服务,
+// 用于一个进程通过同一个监听或者连接提供多个物模型, 而不需要为每个物模型单独建立连接.
+// sub的模型名必须以m的模型名加"/"为前缀, 例如m的模型名为 "A/car" 时, sub的模型名可以为 "A/car/tpqs". 挂载后:
+//   - 对端查询到的是合并后的元信息, sub的状态、事件和方法以相对模型名为前缀, 如 "tpqs/gear", 全名仍为 "A/car/tpqs/gear";
+//   - 调用请求和状态设置请求按模型名路由到sub, 由sub的回调处理并按sub的元信息校验;
+//   - sub的 PushState ClearState 和 PushEvent 通过m的连接推送, 状态缓存在m中, LastState 通过m或sub查询结果相同.
+//
+// 去重、推送序号、订阅数量上限和回调超时等与连接相关的选项以m的配置为准.
+// sub为nil、已经挂载到其他物模型、自身挂载了子物模型、模型名不匹配或者合并后的元信息有重名时返回错误信息.
+// NOTE: 代理服务默认按模型名路由, 通过代理访问子物模型需要配置自定义的路由
+func (m *Model) AddSubModel(sub *Model) error {
+	if sub == nil {
+		return errors.New("sub model is nil")
+	}
+	if !strings.HasPrefix(sub.meta.Name, m.meta.Name+"/") {
+		return fmt.Errorf("sub model %q is NOT under %q", sub.meta.Name, m.meta.Name)
+	}
+
+	m.subLock.Lock()
+	defer m.subLock.Unlock()
+	if m.parent != nil {
+		return fmt.Errorf("%q is a sub model", m.meta.Name)
+	}
+
+	sub.subLock.Lock()
+	defer sub.subLock.Unlock()
+	if sub.parent != nil {
+		return fmt.Errorf("sub model %q already added", sub.meta.Name)
+	}
+	if len(sub.subModels) > 0 {
+		return fmt.Errorf("sub model %q has sub models", sub.meta.Name)
+	}
+
+	subModels := append(append([]*Model(nil), m.subModels...), sub)
+	merged, err := mergeMeta(m.meta, subModels)
+	if err != nil {
+		return err
+	}
+
+	m.subModels = subModels
+	m.mergedMeta = merged
+	sub.parent = m
+	return nil
+}
+
+// mergeMeta 将子物模型subs的元信息合并到宿主元信息host中, 子物模型的状态、事件和方法名称加上相对于host的模型名前缀,
+// 返回合并后的元信息和错误信息.
+func mergeMeta(host *meta.Meta, subs []*Model) (*meta.Meta, error) {
+	merged := &meta.Meta{
+		Name:        host.Name,
+		Description: host.Description,
+		Version:     host.Version,
+		LabelMap:    host.Labels(),
+		State:       append([]meta.ParamMeta(nil), host.State...),
+		Event:       append([]meta.EventMeta(nil), host.Event...),
+		Method:      append([]meta.MethodMeta(nil), host.Method...),
+	}
+
+	for _, sub := range subs {
+		prefix := strings.TrimPrefix(sub.meta.Name, host.Name+"/") + "/"
+		for _, state := range sub.meta.State {
+			name := prefix + *state.Name
+			state.Name = &name
+			merged.State = append(merged.State, state)
+		}
+		for _, event := range sub.meta.Event {
+			event.Name = prefix + event.Name
+			merged.Event = append(merged.Event, event)
+		}
+		for _, method := range sub.meta.Method {
+			method.Name = prefix + method.Name
+			merged.Method = append(merged.Method, method)
+		}
+	}
+
+	buff, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Parse(buff, nil)
+}
+
+// host 返回物模型m的宿主物模型, m不是子物模型时返回m本身
+func (m *Model) host() *Model {
+	m.subLock.RLock()
+	defer m.subLock.RUnlock()
+	if m.parent != nil {
+		return m.parent
+	}
+	return m
+}
+
+// modelOf 返回模型名为name的物模型, 即m本身或者m的子物模型, 不存在时返回nil
+func (m *Model) modelOf(name string) *Model {
+	if name == m.meta.Name {
+		return m
+	}
+
+	m.subLock.RLock()
+	defer m.subLock.RUnlock()
+	for _, sub := range m.subModels {
+		if sub.meta.Name == name {
+			return sub
+		}
+	}
+	return nil
+}
+
+// VerifyRawStateFor 根据模型名为modelName的物模型的元信息校验状态名为stateName的原始状态数据data,
+// 模型名可以是物模型m本身或者通过 AddSubModel 挂载的子物模型, 用于在挂载了子物模型的物模型上按模型名校验状态.
+// 模型名不存在时返回形如 NO model "A/car" 的错误信息, 校验规则与 meta.Meta.VerifyRawState 相同.
+func (m *Model) VerifyRawStateFor(modelName string, stateName string, data []byte) error {
+	target := m.modelOf(modelName)
+	if target == nil {
+		return fmt.Errorf("NO model %q", modelName)
+	}
+	return target.meta.VerifyRawState(stateName, data)
+}
+
+// servedMeta 返回物模型m对端可见的元信息, 挂载了子物模型时为合并后的元信息
+func (m *Model) servedMeta() *meta.Meta {
+	m.subLock.RLock()
+	defer m.subLock.RUnlock()
+	if m.mergedMeta != nil {
+		return m.mergedMeta
+	}
+	return m.meta
+}
+
+// CheckResponseShape 检查方法method的响应示例sample是否与元信息声明的响应字段完全一致,
+// 除了 meta.Meta.VerifyMethodResp 的校验外, 还检查sample中是否有元信息未声明的多余字段.
+// 用于在测试中尽早发现调用请求回调返回的响应与元信息不一致的问题, 例如字段名拼写错误.
+func (m *Model) CheckResponseShape(method string, sample message.Resp) error {
+	if err := m.meta.VerifyMethodResp(method, sample); err != nil {
+		return err
+	}
+
+	var declared map[string]struct{}
+	for _, methodMeta := range m.meta.Method {
+		if methodMeta.Name == method {
+			declared = make(map[string]struct{}, len(methodMeta.Response))
+			for _, respMeta := range methodMeta.Response {
+				declared[*respMeta.Name] = struct{}{}
+			}
+			break
+		}
+	}
+
+	var extra []string
+	for name := range sample {
+		if _, seen := declared[name]; !seen {
+			extra = append(extra, name)
+		}
+	}
+	if len(extra) > 0 {
+		sort.Strings(extra)
+		return fmt.Errorf("response %q: NOT declared", strings.Join(extra, ", "))
+	}
+
+	return nil
+}
+
 // ListenServeTCP 开启对地址addr的监听, 并等待其他客户端物模型与m建立TCP连接.
 // 所有建立的TCP连接自动开启 keep-alive 选项. ListenServeTCP 总是返回不为nil的错误信息.
 //
 // 客户端物模型可以同过 Dial("tcp@addr", opts...) 或者 DialTcp(addr, opts...) 与m建立连接.
 func (m *Model) ListenServeTCP(addr string) error {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	l, err := listenTCP(addr)
 	if err != nil {
 		return err
 	}
-	l, err := net.ListenTCP("tcp", tcpAddr)
+
+	return m.serveTCP(l)
+}
+
+// Listen 开启对地址addr的TCP监听, 返回实际监听的地址和错误信息, 需要再调用 Model.Serve 等待客户端物模型建立连接.
+// 监听端口为0时由系统分配端口, 通过返回的地址可以获取实际的端口, 例如在测试中监听"127.0.0.1:0"避免端口冲突.
+// 物模型m只能通过 Listen 开启一个TCP监听, 重复调用返回错误信息.
+func (m *Model) Listen(addr string) (net.Addr, error) {
+	m.listenLock.Lock()
+	defer m.listenLock.Unlock()
+	if m.listener != nil {
+		return nil, fmt.Errorf("already listening on %s", m.listener.Addr())
+	}
+
+	l, err := listenTCP(addr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	m.listener = l
+
+	return l.Addr(), nil
+}
+
+// Serve 在通过 Model.Listen 开启的TCP监听上等待其他客户端物模型与m建立TCP连接, 建立的连接与 ListenServeTCP 相同.
+// 未调用 Listen 时返回错误信息. Serve 总是返回不为nil的错误信息.
+func (m *Model) Serve() error {
+	m.listenLock.Lock()
+	l := m.listener
+	m.listenLock.Unlock()
+	if l == nil {
+		return errors.New("NOT listening")
 	}
 
+	return m.serveTCP(l)
+}
+
+// listenTCP 开启对地址addr的TCP监听
+func listenTCP(addr string) (*net.TCPListener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+// serveTCP 在监听l上等待客户端物模型建立TCP连接
+func (m *Model) serveTCP(l *net.TCPListener) error {
 	for {
 		conn, err := l.AcceptTCP()
 		if err != nil {
 			return err
 		}
 
-		go m.dealConn(newConn(m, rawConn.NewTcpConn(conn, true)))
+		go m.dealConn(newConn(m, rawConn.NewTcpConn(conn, true, m.tcpOpts...)))
 	}
 }
 
@@ -155,10 +840,14 @@ func (m *Model) ListenServeTCP(addr string) error {
 func (m *Model) ListenServeWebSocket(addr string) error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		conn, err := upgrader.Upgrade(writer, request, nil)
+		wsUpgrader := upgrader
+		wsUpgrader.Subprotocols = m.wsSubprotocols
+		wsUpgrader.EnableCompression = m.wsCompress
+		conn, err := wsUpgrader.Upgrade(writer, request, nil)
 		if err != nil {
 			return
 		}
+		m.setupWebSocket(conn)
 
 		m.dealConn(newConn(m, rawConn.NewWebSocketConn(conn, true)))
 	})
@@ -166,8 +855,19 @@ func (m *Model) ListenServeWebSocket(addr string) error {
 }
 
 // PushState 推送名称为name, 数据为data的状态, m的所有连接只要是订阅了该状态, 都会收到该状态报文,
-// 参数verify表示是否根据m的元信息校验状态数据, 若校验不通过返回错误信息, 其他情况都返回nil.
+// 参数verify表示是否根据m的元信息校验状态数据, 若校验不通过或者状态数据编码失败返回错误信息, 其他情况都返回nil.
+// 若开启了 WithStateDedup 选项, 与连接上一次发送的状态报文相同的状态报文不会再次发送.
 func (m *Model) PushState(name string, data interface{}, verify bool) error {
+	return m.pushState(name, data, verify, false)
+}
+
+// ForcePushState 与 PushState 相同, 推送名称为name, 数据为data的状态,
+// 区别是 ForcePushState 总是发送状态报文, 不受 WithStateDedup 选项的影响.
+func (m *Model) ForcePushState(name string, data interface{}, verify bool) error {
+	return m.pushState(name, data, verify, true)
+}
+
+func (m *Model) pushState(name string, data interface{}, verify bool, force bool) error {
 	// 首先验证推送数据是否符合物模型元信息
 	if verify {
 		if err := m.meta.VerifyState(name, data); err != nil {
@@ -181,14 +881,131 @@ func (m *Model) PushState(name string, data interface{}, verify bool) error {
 		name,
 	}, "/")
 
+	// 时长类型按照元信息中声明的单位编码
+	data, err := m.meta.StateInUnit(name, data)
+	if err != nil {
+		return err
+	}
+
+	// 编码状态报文, 所有链路共用, 子物模型通过宿主物模型的连接推送
+	h := m.host()
+	msg, err := message.EncodeStateSeqMsg(fullName, h.nextSeq(), data)
+	if err != nil {
+		return err
+	}
+
+	// 去重时不比较推送序号
+	key := msg
+	if h.seqEnabled && h.stateDedup {
+		key = message.Must(message.EncodeStateMsg(fullName, data))
+	}
+
+	// 未校验的状态在后台审计
+	if !verify && m.pushAudit != nil {
+		go m.auditState(name, msg)
+	}
+
+	h.broadcastState(fullName, msg, key, force)
+	return nil
+}
+
+// ClearState 推送名称为name的清除状态报文(见 message.EncodeClearStateMsg), 告知订阅了该状态的所有连接该状态之前推送的值已经失效,
+// 例如传感器离线后数据不再可用. 对端的 StateHandler 收到的状态数据为nil, 见 StateMessage.Cleared.
+// 只有元信息中声明了 "optional": true 的状态可以被清除, 状态不存在或者不可清除时返回错误信息.
+// 清除后 LastState 返回的状态数据为nil, 之后订阅该状态的连接也会立即收到清除状态报文. 清除状态报文同样受 WithStateDedup 选项的影响.
+func (m *Model) ClearState(name string) error {
+	if err := m.meta.VerifyStateClear(name); err != nil {
+		return err
+	}
+
+	h := m.host()
+	fullName := m.meta.Name + "/" + name
+	msg := message.EncodeClearStateMsg(fullName, h.nextSeq())
+	key := msg
+	if h.seqEnabled && h.stateDedup {
+		key = message.EncodeClearStateMsg(fullName, 0)
+	}
+
+	h.broadcastState(fullName, msg, key, false)
+	return nil
+}
+
+// broadcastState 缓存全名为fullName的状态报文msg, 并向所有订阅了该状态的连接推送, key为去重时比较的报文,
+// force为true时不去重
+func (m *Model) broadcastState(fullName string, msg []byte, key []byte, force bool) {
+	// 缓存最新的状态报文, 用于订阅时立即推送
+	m.cacheLock.Lock()
+	m.stateCache[fullName] = cachedState{
+		msg:  msg,
+		key:  key,
+		time: time.Now(),
+	}
+	m.cacheLock.Unlock()
+
 	// 向所有链路推送
 	m.connLock.RLock()
 	defer m.connLock.RUnlock()
 	for conn := range m.allConn {
-		conn.sendState(fullName, data)
+		conn.sendState(fullName, msg, key, m.stateDedup && !force)
 	}
+}
 
-	return nil
+// auditState 根据元信息校验名称为name的状态报文msg中的状态数据, 校验失败时调用审计回调
+func (m *Model) auditState(name string, msg []byte) {
+	state := struct {
+		Payload message.StatePayload `json:"payload"`
+	}{}
+	if err := json.Unmarshal(msg, &state); err != nil {
+		m.pushAudit(name, err)
+		return
+	}
+
+	if err := m.meta.VerifyRawState(name, state.Payload.Data); err != nil {
+		m.pushAudit(name, err)
+	}
+}
+
+// LastState 返回物模型m最近一次推送的全名为fullName的状态数据, 若该状态从未推送过, 返回的bool值为false.
+func (m *Model) LastState(fullName string) ([]byte, bool) {
+	data, _, seen := m.LastStateWithTime(fullName)
+	return data, seen
+}
+
+// LastStateWithTime 返回物模型m最近一次推送的全名为fullName的状态数据和推送时间, 若该状态从未推送过, 返回的bool值为false.
+// 最近一次推送的是清除状态报文(见 ClearState)时, 返回的状态数据为nil.
+// 通过推送时间可以判断缓存的状态数据是否已经过时.
+func (m *Model) LastStateWithTime(fullName string) ([]byte, time.Time, bool) {
+	cached, seen := m.host().lastStateMsg(fullName)
+	if !seen {
+		return nil, time.Time{}, false
+	}
+
+	state := struct {
+		Payload message.StatePayload `json:"payload"`
+	}{}
+	if json.Unmarshal(cached.msg, &state) != nil {
+		return nil, time.Time{}, false
+	}
+	return state.Payload.Data, cached.time, true
+}
+
+func (m *Model) lastStateMsg(fullName string) (cachedState, bool) {
+	m.cacheLock.RLock()
+	defer m.cacheLock.RUnlock()
+	cached, seen := m.stateCache[fullName]
+	return cached, seen
+}
+
+// nextSeq 返回下一个推送序号, 未开启推送序号选项时返回0
+func (m *Model) nextSeq() uint64 {
+	if !m.seqEnabled {
+		return 0
+	}
+	for {
+		if seq := atomic.AddUint64(&m.seq, 1); seq != 0 {
+			return seq
+		}
+	}
 }
 
 // PushEvent 推送名称为name, 参数为args的事件, m的所有连接只要是订阅了该事件, 都会收到该事件报文,
@@ -201,17 +1018,26 @@ func (m *Model) PushEvent(name string, args message.Args, verify bool) error {
 		}
 	}
 
+	// 时长类型按照元信息中声明的单位编码
+	args, err := m.meta.EventArgsInUnit(name, args)
+	if err != nil {
+		return err
+	}
+
 	// 全事件名 = 模型名/事件名
 	fullName := strings.Join([]string{
 		m.meta.Name,
 		name,
 	}, "/")
 
-	// 向所有链路推送
-	m.connLock.RLock()
-	defer m.connLock.RUnlock()
-	for conn := range m.allConn {
-		conn.sendEvent(fullName, args)
+	// 向所有链路推送, 子物模型通过宿主物模型的连接推送
+	h := m.host()
+	seq := h.nextSeq()
+	m.recordEvent(name, fullName, seq, args)
+	h.connLock.RLock()
+	defer h.connLock.RUnlock()
+	for conn := range h.allConn {
+		conn.sendEvent(fullName, seq, args)
 	}
 
 	return nil
@@ -264,7 +1090,7 @@ func (m *Model) DialTcp(addr string, opts ...ConnOption) (*Connection, error) {
 		return nil, err
 	}
 
-	ans := newConn(m, rawConn.NewTcpConn(raw, false), opts...)
+	ans := newConn(m, rawConn.NewTcpConn(raw, false, m.tcpOpts...), opts...)
 	go m.dealConn(ans)
 
 	return ans, nil
@@ -277,10 +1103,21 @@ func (m *Model) DialTcp(addr string, opts ...ConnOption) (*Connection, error) {
 // 		ws://192.168.1.51:8080
 // 		ws://localhost:8080
 func (m *Model) DialWebSocket(addr string, opts ...ConnOption) (*Connection, error) {
-	raw, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	return m.DialWebSocketWithHeader(addr, nil, opts...)
+}
+
+// DialWebSocketWithHeader 与 DialWebSocket 相同, 但是在WebSocket握手请求中附加请求头header,
+// 例如用于通过需要鉴权的反向代理时携带 Authorization 请求头. header为nil时等同于 DialWebSocket.
+// 通过 WithWebSocketSubprotocols 配置的子协议会自动添加到握手请求中, header中不需要再设置 Sec-WebSocket-Protocol.
+func (m *Model) DialWebSocketWithHeader(addr string, header http.Header, opts ...ConnOption) (*Connection, error) {
+	dialer := *websocket.DefaultDialer
+	dialer.Subprotocols = m.wsSubprotocols
+	dialer.EnableCompression = m.wsCompress
+	raw, _, err := dialer.Dial(addr, header)
 	if err != nil {
 		return nil, err
 	}
+	m.setupWebSocket(raw)
 
 	ans := newConn(m, rawConn.NewWebSocketConn(raw, false), opts...)
 	go m.dealConn(ans)
@@ -288,15 +1125,214 @@ func (m *Model) DialWebSocket(addr string, opts ...ConnOption) (*Connection, err
 	return ans, nil
 }
 
+// Attach 根据连接配置opts使物模型m在原始连接raw上建立连接, 返回所建立的连接, 建立的连接与 Dial 建立的连接相同.
+// 用于自定义传输层, 或者通过 replay.Conn 将抓取的报文日志回放给物模型.
+func (m *Model) Attach(raw rawConn.RawConn, opts ...ConnOption) *Connection {
+	ans := newConn(m, raw, opts...)
+	go m.dealConn(ans)
+
+	return ans
+}
+
+// SetCallHandler 在运行时将物模型m的调用请求回调处理替换为handler, 替换通过 WithCallReqConnHandler 等选项配置的回调.
+// handler为nil时注销回调, 此后收到的调用请求都会收到错误提示信息为"NO callback"的响应, 例如用于设备维护时拒绝调用.
+// SetCallHandler 可以在任意协程中与调用请求的处理并发调用, 替换后新收到的调用请求使用新的回调,
+// 已经开始处理的调用请求仍然使用原来的回调.
+func (m *Model) SetCallHandler(handler CallRequestHandler) {
+	m.callLock.Lock()
+	defer m.callLock.Unlock()
+	m.callReqHandler = handler
+	m.callConnHandler = nil
+	m.callCtxHandler = nil
+}
+
+// SetVerifyResp 在运行时开启(verify为true)或者关闭物模型m的响应校验选项, 作用与 WithVerifyResp 相同.
+// SetVerifyResp 可以在任意协程中与调用请求的处理并发调用, 修改后新收到的调用请求的响应按照新的选项校验.
+func (m *Model) SetVerifyResp(verify bool) {
+	m.callLock.Lock()
+	defer m.callLock.Unlock()
+	m.verifyResp = verify
+}
+
+// SetMethodVerifyResp 在运行时开启(verify为true)或者关闭物模型m对名为method的方法的响应校验, 用于逐个方法地收紧响应校验.
+// 方法是否校验响应的优先级从高到低为: SetMethodVerifyResp 的配置, 元信息中方法的verifyResponse字段,
+// WithVerifyResp 或 SetVerifyResp 配置的全局选项. SetMethodVerifyResp 可以在任意协程中与调用请求的处理并发调用.
+func (m *Model) SetMethodVerifyResp(method string, verify bool) {
+	m.callLock.Lock()
+	defer m.callLock.Unlock()
+	if m.methodVerify == nil {
+		m.methodVerify = make(map[string]bool)
+	}
+	m.methodVerify[method] = verify
+}
+
+// SetBusy 将物模型m切换为忙碌状态, 例如固件升级或者执行耗时的物理操作期间, 此后收到的调用请求不再交给调用请求回调处理,
+// 而是直接回复错误提示信息为 "device busy: reason" 的响应(reason为空时为 "device busy"), 不需要注销调用请求回调.
+// 忙碌状态只影响调用请求, 状态和事件的推送以及状态设置请求不受影响. 重复调用时以最后一次的reason为准.
+// SetBusy 可以在任意协程中与调用请求的处理并发调用, 已经开始处理的调用请求不受影响.
+func (m *Model) SetBusy(reason string) {
+	m.callLock.Lock()
+	defer m.callLock.Unlock()
+	m.busy = &reason
+}
+
+// ClearBusy 解除物模型m的忙碌状态(见 SetBusy), 此后收到的调用请求恢复由调用请求回调处理.
+func (m *Model) ClearBusy() {
+	m.callLock.Lock()
+	defer m.callLock.Unlock()
+	m.busy = nil
+}
+
+// busyErr 返回物模型处于忙碌状态时拒绝调用请求的错误信息, 不忙碌时返回nil
+func (m *Model) busyErr() error {
+	m.callLock.RLock()
+	defer m.callLock.RUnlock()
+	if m.busy == nil {
+		return nil
+	}
+	if *m.busy == "" {
+		return errors.New("device busy")
+	}
+	return fmt.Errorf("device busy: %s", *m.busy)
+}
+
+// verifyRespEnabled 返回物模型是否需要校验名为method的方法的响应返回值
+func (m *Model) verifyRespEnabled(method string) bool {
+	m.callLock.RLock()
+	defer m.callLock.RUnlock()
+	if verify, seen := m.methodVerify[method]; seen {
+		return verify
+	}
+	if verify, seen := m.meta.VerifyResponseOf(method); seen {
+		return verify
+	}
+	return m.verifyResp
+}
+
+// callHandler 返回物模型的调用请求处理对象, 未注册调用请求回调时返回nil
+func (m *Model) callHandler() CallRequestContextHandler {
+	m.callLock.RLock()
+	defer m.callLock.RUnlock()
+	if m.callCtxHandler != nil {
+		return m.callCtxHandler
+	}
+	if m.callConnHandler != nil {
+		return callConnAdapter{m.callConnHandler}
+	}
+	if m.callReqHandler != nil {
+		return callReqAdapter{m.callReqHandler}
+	}
+	return nil
+}
+
+// Validate 检查物模型m的配置是否完整, 用于在创建物模型之后、开始服务之前尽早发现配置错误:
+//   - 元信息声明了方法, 但没有注册调用请求回调, 所有调用请求都会返回 "NO callback" 错误;
+//   - 元信息声明了可写状态, 但没有注册状态设置回调, 所有状态设置请求都会返回 "NO callback" 错误.
+//
+// 配置完整时返回nil, 否则返回第一个错误信息.
+func (m *Model) Validate() error {
+	if len(m.meta.Method) > 0 && m.callHandler() == nil {
+		methods := make([]string, 0, len(m.meta.Method))
+		for i := range m.meta.Method {
+			methods = append(methods, m.meta.Method[i].Name)
+		}
+		return fmt.Errorf("methods [%s] declared but NO call handler", strings.Join(methods, ", "))
+	}
+
+	var writable []string
+	for i := range m.meta.State {
+		if err := m.meta.VerifyStateWrite(*m.meta.State[i].Name); err == nil {
+			writable = append(writable, *m.meta.State[i].Name)
+		}
+	}
+	if len(writable) > 0 && m.stateSetter() == nil {
+		return fmt.Errorf("writable states [%s] declared but NO state set handler", strings.Join(writable, ", "))
+	}
+
+	return nil
+}
+
+// stateSetter 返回物模型的状态设置请求处理对象, 未注册状态设置回调时返回nil
+func (m *Model) stateSetter() StateSetHandler {
+	m.callLock.RLock()
+	defer m.callLock.RUnlock()
+	return m.stateSetHandler
+}
+
+// recoverPanic 恢复回调处理函数发生的panic并交给 panicHandler 处理, 返回是否发生了panic.
+// NOTE: 必须通过defer直接调用
+func (m *Model) recoverPanic(panicked *bool) {
+	if r := recover(); r != nil {
+		*panicked = true
+		m.panicHandler(r, debug.Stack())
+	}
+}
+
+// limitSubs 根据订阅数量上限限制订阅列表items, current为当前订阅集合, 设置订阅时为nil.
+// 返回限制后的订阅列表, 超出上限且策略为 SubLimitClose 时返回false.
+func (m *Model) limitSubs(current map[string]struct{}, items []string) ([]string, bool) {
+	if m.maxSubs <= 0 {
+		return items, true
+	}
+
+	count := len(current)
+	added := make(map[string]struct{})
+	ans := make([]string, 0, len(items))
+	dropped := 0
+	for _, item := range items {
+		_, inCurrent := current[item]
+		_, inAdded := added[item]
+		if inCurrent || inAdded {
+			ans = append(ans, item)
+			continue
+		}
+
+		if count >= m.maxSubs {
+			if m.subLimitPolicy == SubLimitClose {
+				return nil, false
+			}
+			dropped++
+			continue
+		}
+
+		count++
+		added[item] = struct{}{}
+		ans = append(ans, item)
+	}
+
+	if dropped > 0 {
+		log.Printf("subscriptions exceed limit %d: %d items dropped", m.maxSubs, dropped)
+	}
+
+	return ans, true
+}
+
+// errorResp 生成调用名为method的方法出错时的响应返回值
+func (m *Model) errorResp(method string, err error) message.Resp {
+	if m.errRespBuilder == nil {
+		return message.Resp{}
+	}
+	if resp := m.errRespBuilder(method, err); resp != nil {
+		return resp
+	}
+	return message.Resp{}
+}
+
 func (m *Model) dealConn(conn *Connection) {
 	// 添加链接
 	m.addConn(conn)
 
+	// 自动订阅
+	conn.autoSubscribe()
+
 	// 处理接收
 	conn.dealReceive()
 
 	// 删除链接
 	m.removeConn(conn)
+
+	// 连接关闭后不再有订阅
+	conn.notifySubOnClose()
 }
 
 func (m *Model) addConn(conn *Connection) {
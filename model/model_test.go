@@ -1,22 +1,40 @@
 package model
 
 import (
+	"bytes"
+	"compress/flate"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/gorilla/websocket"
 	"github.com/object-model/goModel/message"
 	"github.com/object-model/goModel/meta"
+	"github.com/object-model/goModel/rawConn"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 )
 
+// freeAddr 返回一个本地回环的空闲地址
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.Nil(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
 // 模拟连接
 type mockConn struct {
 	mock.Mock
@@ -174,6 +192,16 @@ func TestWithCallReqFunc(t *testing.T) {
 		"配置调用请求回调处理函数")
 }
 
+// TestWithTcpOptions 测试配置物模型的TCP连接选项
+func TestWithTcpOptions(t *testing.T) {
+	m := &Model{}
+	WithTcpOptions(nil, rawConn.WithMaxMsgSize(1024))(m)
+	assert.Len(t, m.tcpOpts, 1, "忽略nil选项")
+
+	WithTCPNoDelay(false)(m)
+	assert.Len(t, m.tcpOpts, 2, "配置Nagle算法")
+}
+
 // TestWithStateBuffSize 测试配置连接状态缓存区大小
 func TestWithStateBuffSize(t *testing.T) {
 	conn := &Connection{}
@@ -192,6 +220,61 @@ func TestWithEventBuffSize(t *testing.T) {
 	assert.Equal(t, 100, cap(conn.eventsChan), "配置状态缓存大小")
 }
 
+// TestWithDefaultBuffSize 测试配置物模型所有连接的默认缓存区大小
+func TestWithDefaultBuffSize(t *testing.T) {
+	conn := newConn(NewEmptyModel(), new(mockConn))
+	assert.Equal(t, 256, cap(conn.statesChan), "默认状态缓存大小")
+	assert.Equal(t, 256, cap(conn.eventsChan), "默认事件缓存大小")
+
+	m := New(meta.NewEmptyMeta(), WithDefaultStateBuffSize(10), WithDefaultEventBuffSize(20),
+		WithDefaultStateBuffSize(0), WithDefaultEventBuffSize(-1))
+	conn = newConn(m, new(mockConn))
+	assert.Equal(t, 10, cap(conn.statesChan), "配置默认状态缓存大小")
+	assert.Equal(t, 20, cap(conn.eventsChan), "配置默认事件缓存大小")
+
+	conn = newConn(m, new(mockConn), WithStateBuffSize(30), WithEventBuffSize(40))
+	assert.Equal(t, 30, cap(conn.statesChan), "连接选项优先")
+	assert.Equal(t, 40, cap(conn.eventsChan), "连接选项优先")
+}
+
+// TestWithPushAudit 测试推送审计选项
+func TestWithPushAudit(t *testing.T) {
+	type audit struct {
+		name string
+		err  string
+	}
+	audits := make(chan audit, 10)
+
+	m, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithPushAudit(func(name string, err error) {
+		audits <- audit{name, err.Error()}
+	}))
+	require.Nil(t, err)
+
+	require.Nil(t, m.PushState("gear", "1", false), "不阻塞推送")
+	select {
+	case got := <-audits:
+		assert.Equal(t, "gear", got.name, "审计失败的状态名")
+		assert.Equal(t, m.meta.VerifyRawState("gear", []byte(`"1"`)).Error(), got.err, "审计错误信息")
+	case <-time.After(time.Second):
+		t.Fatal("没有审计")
+	}
+
+	require.Nil(t, m.PushState("gear", 1, false))
+	require.NotNil(t, m.PushState("gear", "1", true), "开启校验时不审计")
+	select {
+	case got := <-audits:
+		t.Fatalf("不应该审计: %v", got)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	m = &Model{}
+	WithPushAudit(nil)(m)
+	assert.NotNil(t, m.pushAudit, "默认打印日志")
+}
+
 // TestWithStateFunc 测试配置连接状态回调处理函数
 func TestWithStateFunc(t *testing.T) {
 	conn := &Connection{}
@@ -228,6 +311,24 @@ func TestLoadFromFileFailed(t *testing.T) {
 	assert.NotNil(t, err, "加载不存在的文件")
 }
 
+func TestLoadFromFileEnv(t *testing.T) {
+	require.Nil(t, os.Setenv("MODEL_GROUP", "A"))
+	defer os.Unsetenv("MODEL_GROUP")
+
+	_, err := LoadFromFileEnv("../meta/tpqs.json", "MODEL_")
+	assert.Equal(t, errors.New(`template "id": environment variable "MODEL_ID" NOT set`), err, "缺少环境变量")
+
+	require.Nil(t, os.Setenv("MODEL_ID", "#1"))
+	defer os.Unsetenv("MODEL_ID")
+
+	m, err := LoadFromFileEnv("../meta/tpqs.json", "MODEL_")
+	require.Nil(t, err)
+	assert.Equal(t, "A/car/#1/tpqs", m.Meta().Name)
+
+	_, err = LoadFromFileEnv("unknown.json", "MODEL_")
+	assert.NotNil(t, err, "加载不存在的文件")
+}
+
 // ModelTestSuite
 type StateEventSuite struct {
 	suite.Suite
@@ -297,6 +398,139 @@ func (s *StateEventSuite) TestPushState_Error() {
 	assert.EqualValues(s.T(), errors.New("type unmatched"), err, "不符合元信息的状态")
 }
 
+// TestPushState_Dedup 测试开启状态去重后重复推送相同状态的情况
+func (s *StateEventSuite) TestPushState_Dedup() {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithStateDedup())
+	require.Nil(s.T(), err)
+
+	state := tpqsInfo{
+		QsState:  "erecting",
+		HpSwitch: false,
+		QsAngle:  90,
+		Errors:   []errorInfo{},
+	}
+	msg := message.Must(message.EncodeStateMsg("A/car/#1/tpqs/tpqsInfo", state))
+
+	// 统计写入的字节数
+	written := 0
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", msg).Return(nil).Run(func(args mock.Arguments) {
+		written += len(args.Get(0).([]byte))
+	})
+
+	conn := newConn(server, mockedConn)
+	conn.pubStates["A/car/#1/tpqs/tpqsInfo"] = struct{}{}
+	server.allConn[conn] = struct{}{}
+
+	for i := 0; i < 100; i++ {
+		require.Nil(s.T(), server.PushState("tpqsInfo", state, true))
+	}
+	mockedConn.AssertNumberOfCalls(s.T(), "WriteMsg", 1)
+	assert.Equal(s.T(), len(msg), written, "重复状态只发送一次")
+	s.T().Logf("push %d times, saved %d bytes", 100, 100*len(msg)-written)
+
+	// 强制推送不受去重影响
+	require.Nil(s.T(), server.ForcePushState("tpqsInfo", state, true))
+	mockedConn.AssertNumberOfCalls(s.T(), "WriteMsg", 2)
+
+	// 状态变化后需要重新发送
+	state.QsAngle = 91
+	changed := message.Must(message.EncodeStateMsg("A/car/#1/tpqs/tpqsInfo", state))
+	mockedConn.On("WriteMsg", changed).Return(nil)
+	require.Nil(s.T(), server.PushState("tpqsInfo", state, true))
+	require.Nil(s.T(), server.PushState("tpqsInfo", state, true))
+	mockedConn.AssertNumberOfCalls(s.T(), "WriteMsg", 3)
+
+	// 重新订阅后需要重新发送
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/tpqsInfo"]`))
+	require.Nil(s.T(), server.PushState("tpqsInfo", state, true))
+	mockedConn.AssertNumberOfCalls(s.T(), "WriteMsg", 4)
+}
+
+// TestPushState_Sequence 测试开启推送序号后推送状态和事件的情况
+func (s *StateEventSuite) TestPushState_Sequence() {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithSequence(), WithStateDedup())
+	require.Nil(s.T(), err)
+
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateSeqMsg("A/car/#1/tpqs/gear", 1, uint(1)))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeEventSeqMsg("A/car/#1/tpqs/qsAction", 3, message.Args{
+		"type": "erect",
+		"time": 1,
+	}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateSeqMsg("A/car/#1/tpqs/gear", 4, uint(2)))).Return(nil).Once()
+
+	conn := newConn(server, mockedConn)
+	conn.pubStates["A/car/#1/tpqs/gear"] = struct{}{}
+	conn.pubEvents["A/car/#1/tpqs/qsAction"] = struct{}{}
+	server.allConn[conn] = struct{}{}
+
+	require.Nil(s.T(), server.PushState("gear", uint(1), true))
+	require.Nil(s.T(), server.PushState("gear", uint(1), true), "去重时不比较推送序号")
+	require.Nil(s.T(), server.PushEvent("qsAction", message.Args{
+		"type": "erect",
+		"time": 1,
+	}, false))
+	require.Nil(s.T(), server.PushState("gear", uint(2), true))
+
+	data, seen := server.LastState("A/car/#1/tpqs/gear")
+	assert.True(s.T(), seen)
+	assert.Equal(s.T(), []byte(`2`), data, "缓存的状态数据不含推送序号")
+
+	mockedConn.AssertExpectations(s.T())
+}
+
+// TestDealSubStateMsg_Snapshot 测试订阅状态时请求立即推送状态最新值的情况
+func (s *StateEventSuite) TestDealSubStateMsg_Snapshot() {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(s.T(), err)
+
+	_, seen := server.LastState("A/car/#1/tpqs/gear")
+	assert.False(s.T(), seen, "未推送过的状态")
+
+	_, _, seen = server.LastStateWithTime("A/car/#1/tpqs/gear")
+	assert.False(s.T(), seen, "未推送过的状态")
+
+	before := time.Now()
+	require.Nil(s.T(), server.PushState("gear", uint(1), true))
+	data, seen := server.LastState("A/car/#1/tpqs/gear")
+	assert.True(s.T(), seen, "推送过的状态")
+	assert.Equal(s.T(), []byte(`1`), data, "最近一次推送的状态数据")
+
+	data, pushTime, seen := server.LastStateWithTime("A/car/#1/tpqs/gear")
+	assert.True(s.T(), seen, "推送过的状态")
+	assert.Equal(s.T(), []byte(`1`), data, "最近一次推送的状态数据")
+	assert.False(s.T(), pushTime.Before(before), "推送时间")
+	assert.False(s.T(), pushTime.After(time.Now()), "推送时间")
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	server.allConn[conn] = struct{}{}
+
+	// 未请求最新值时不推送
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	mockedConn.AssertNotCalled(s.T(), "WriteMsg", mock.Anything)
+
+	// 已订阅的状态不重复推送, 新订阅且推送过的状态立即推送
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateMsg("A/car/#1/tpqs/gear", 1))).Return(nil).Once()
+	conn.onSetSubState([]byte(`{"items":["A/car/#1/tpqs/gear","A/car/#1/tpqs/QSCount"],"snapshot":true}`))
+	mockedConn.AssertNumberOfCalls(s.T(), "WriteMsg", 0)
+
+	conn.onClearSubState(nil)
+	conn.onAddSubState([]byte(`{"items":["A/car/#1/tpqs/gear"],"snapshot":true}`))
+	mockedConn.AssertExpectations(s.T())
+	assert.Equal(s.T(), []string{"A/car/#1/tpqs/gear"}, conn.GetSubStates())
+}
+
 // TestPushEvent 测试推送事件报文成功的情况
 func (s *StateEventSuite) TestPushEvent() {
 	action := message.Args{
@@ -780,6 +1014,36 @@ func (s *StateEventSuite) TestDealStateMsg() {
 	mockOnClose.AssertExpectations(s.T())
 }
 
+// TestDealStateMsg_BigInteger 测试状态报文中的大整数在转发过程中不丢失精度
+func (s *StateEventSuite) TestDealStateMsg_BigInteger() {
+	mockedConn := new(mockConn)
+
+	msg := []byte(`{"type":"state","payload":{"name":"model/state","data":{"count": 9007199254740993}}}`)
+	wanted := []byte(`{"count": 9007199254740993}`)
+
+	var got []byte
+	conn := newConn(s.server, mockedConn, WithStateFunc(func(modelName string, stateName string, data []byte) {
+		got = data
+	}))
+
+	mockedConn.On("ReadMsg").Return(msg, nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	s.server.dealConn(conn)
+
+	require.Equal(s.T(), wanted, got, "状态数据原样转发")
+
+	var value map[string]interface{}
+	require.Nil(s.T(), message.UnmarshalUseNumber(got, &value))
+	assert.Equal(s.T(), "9007199254740993", fmt.Sprint(value["count"]), "解码后大整数不丢失精度")
+
+	// 重新编码后保持不变
+	relayed := message.Must(message.EncodeStateMsg("model/state", value))
+	assert.Equal(s.T(), `{"type":"state","payload":{"name":"model/state","data":{"count":9007199254740993}}}`,
+		string(relayed), "重新编码后大整数不变")
+}
+
 // TestDealInvalidStateMsg 测试收到无效的状态报文
 func (s *StateEventSuite) TestDealInvalidStateMsg() {
 	type TestCase struct {
@@ -1004,6 +1268,42 @@ func (s *StateEventSuite) TestDealQueryMetaMsg() {
 	mockOnClose.AssertExpectations(s.T())
 }
 
+// TestWithMetaDisclosure 测试不公开元信息时回复元信息查询报文
+func TestWithMetaDisclosure(t *testing.T) {
+	m := &Model{}
+	WithMetaDisclosure(MetaDisclosure(100))(m)
+	assert.Equal(t, MetaDiscloseAlways, m.metaDisclosure, "无效的策略")
+	WithMetaDisclosure(MetaDiscloseNever)(m)
+	assert.Equal(t, MetaDiscloseNever, m.metaDisclosure, "不公开元信息")
+
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithMetaDisclosure(MetaDiscloseNever))
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", []byte(`{"type":"meta-info","payload":{"error":"meta disclosure disabled"}}`)).Return(nil).Once()
+	conn.onQueryMeta(nil)
+	mockedConn.AssertExpectations(t)
+}
+
+// TestQueryMetaLabels 测试元信息查询报文的响应中携带版本号和附加标签
+func TestQueryMetaLabels(t *testing.T) {
+	server, err := LoadFromBuff([]byte(`{"name": "A/car", "description": "车辆", "version": "1.2.0",
+		"labels": {"manufacturer": "ACME", "serial": "SN-0001"}, "state": [], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"manufacturer": "ACME", "serial": "SN-0001"}, server.Meta().Labels())
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", []byte(`{"type":"meta-info","payload":{"name":"A/car","description":"车辆","version":"1.2.0",`+
+		`"labels":{"manufacturer":"ACME","serial":"SN-0001"},"state":[],"event":[],"method":[]}}`)).Return(nil).Once()
+	conn.onQueryMeta(nil)
+	mockedConn.AssertExpectations(t)
+}
+
 // TestAboutStateEvent 测试推送状态、事件、状态和事件报文、状态和事件订阅报文的处理逻辑
 func TestAboutStateEvent(t *testing.T) {
 	suite.Run(t, new(StateEventSuite))
@@ -1042,15 +1342,15 @@ func TestDealCallMsg(t *testing.T) {
 		},
 
 		{
-			msg:     []byte(`{"type":"call","payload":{"name":"A/car/#1/tpqs/QS","uuid":"123456","args":{}}}`),
-			wantMsg: []byte(`{"type":"response","payload":{"uuid":"123456","error":"arg \"angle\": missing","response":{}}}`),
-			desc:    "调用的参数不符合元信息---参数缺失",
+			msg:     []byte(`{"type":"call","payload":{"name":"A/car/#1/tpqs/QS","uuid":"123456","args":{"angle":"90"}}}`),
+			wantMsg: []byte(`{"type":"response","payload":{"uuid":"123456","error":"arg \"angle\": NOT number","response":{}}}`),
+			desc:    "调用的参数不符合元信息---参数类型错误",
 		},
 
 		{
 			msg:     []byte(`{"type":"call","payload":{"name":"A/car/#1/tpqs/QS","uuid":"123456","args":{}}}`),
-			wantMsg: []byte(`{"type":"response","payload":{"uuid":"123456","error":"arg \"angle\": missing","response":{}}}`),
-			desc:    "调用的参数不符合元信息---参数缺失",
+			wantMsg: []byte(`{"type":"response","payload":{"uuid":"123456","error":"NO callback","response":{}}}`),
+			desc:    "调用的参数缺失---有默认值的参数可以省略",
 		},
 
 		{
@@ -1203,135 +1503,1214 @@ func TestDealCallMsg(t *testing.T) {
 	}
 }
 
-// TestDealInvalidCallMsg 测试无效调用请求报文
-func TestDealInvalidCallMsg(t *testing.T) {
-	type TestCase struct {
-		msg  []byte // 测试报文数据
-		desc string // 用例描述
-	}
+// TestWithErrorResponseBuilder 测试配置错误响应返回值生成函数后的错误响应报文
+func TestWithErrorResponseBuilder(t *testing.T) {
+	mockedConn := new(mockConn)
 
-	testCases := []TestCase{
-		{
-			msg:  []byte(`{"type":"call"}`),
-			desc: "缺少payload字段",
-		},
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithErrorResponseBuilder(func(method string, err error) message.Resp {
+		return message.Resp{
+			"method": method,
+			"detail": err.Error(),
+		}
+	}))
+	require.Nil(t, err)
 
-		{
-			msg:  []byte(`{"type":"call","payload":null}`),
-			desc: "payload为null",
-		},
+	conn := newConn(server, mockedConn)
 
-		{
-			msg:  []byte(`{"type":"call","payload":[]}`),
-			desc: "payload不是对象",
-		},
+	wantMsg := []byte(`{"type":"response","payload":{"uuid":"123456","error":"arg \"angle\": NOT number",` +
+		`"response":{"detail":"arg \"angle\": NOT number","method":"QS"}}}`)
+	mockedConn.On("WriteMsg", wantMsg).Return(nil).Once()
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":123}}`),
-			desc: "调用请求的name字段不是字符串",
-		},
+	conn.dealCallReq(message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{"angle": []byte(`"90"`)},
+	})
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"uuid":"123","args":{}}}`),
-			desc: "调用请求缺少name字段",
-		},
+	mockedConn.AssertExpectations(t)
+}
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"\r\n  \t","uuid":"123","args":{}}}`),
-			desc: "name字段去除空格后为空",
-		},
+// TestWithCallReqConnFunc 测试携带连接的调用请求回调
+func TestWithCallReqConnFunc(t *testing.T) {
+	mockedConn := new(mockConn)
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","args":{}}}`),
-			desc: "调用请求缺少uuid字段",
-		},
+	var gotConn *Connection
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": false}
+	}), WithCallReqConnFunc(func(conn *Connection, name string, args message.RawArgs) message.Resp {
+		gotConn = conn
+		return message.Resp{"res": true}
+	}))
+	require.Nil(t, err)
+	assert.Nil(t, server.callReqHandler, "以最后配置的回调为准")
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"","args":{}}}`),
-			desc: "uuid字段为空",
-		},
+	conn := newConn(server, mockedConn)
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"  \t","args":{}}}`),
-			desc: "uuid字段去除空格后为空",
-		},
+	wantMsg := []byte(`{"type":"response","payload":{"uuid":"123456","error":"","response":{"res":true}}}`)
+	mockedConn.On("WriteMsg", wantMsg).Return(nil).Once()
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"123"}}`),
-			desc: "调用请求缺少args字段",
+	conn.dealCallReq(message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"fast"`),
 		},
+	})
 
-		{
-			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"123","args":null}}`),
-			desc: "args字段为null",
-		},
-	}
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, conn, gotConn, "回调中获取收到调用请求的连接")
 
-	for _, test := range testCases {
-		fmt.Println(test.desc)
+	// 旧的回调通过适配器调用
+	m := &Model{}
+	WithCallReqConnFunc(func(*Connection, string, message.RawArgs) message.Resp {
+		return nil
+	})(m)
+	WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": false}
+	})(m)
+	assert.Nil(t, m.callConnHandler, "以最后配置的回调为准")
+	assert.Equal(t, message.Resp{"res": false}, m.callHandler().OnCallReqContext(&CallContext{}, "QS", nil), "适配旧的回调")
+}
 
-		mockOnCall := new(mockCallReqHandler)
-		mockOnClose := new(mockCloseHandler)
-		mockedConn := new(mockConn)
+// TestWithCallReqContextFunc 测试调用方附加头部, 回调中通过调用上下文读取
+func TestWithCallReqContextFunc(t *testing.T) {
+	var gotCtx *CallContext
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqContextFunc(func(ctx *CallContext, name string, args message.RawArgs) message.Resp {
+		gotCtx = ctx
+		return message.Resp{"res": ctx.Header("trace-id") == "abc"}
+	}))
+	require.Nil(t, err)
 
-		server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
-			"group": "A",
-			"id":    "#1",
-		})
+	// 调用方发送携带头部的调用请求报文
+	headers := map[string]string{"trace-id": "abc", "deadline": "2024-01-01T00:00:00Z"}
+	args := message.Args{"angle": 10, "speed": "fast"}
+	mockedCaller := new(mockConn)
+	caller := newConn(NewEmptyModel(), mockedCaller)
+	caller.uidCreator = func() string { return "123456" }
+	mockedCaller.On("WriteMsg", message.Must(message.EncodeCallMsgWithHeaders("A/car/#1/tpqs/QS", "123456", args, headers))).Return(nil).Once()
+	_, err = caller.InvokeWithHeaders("A/car/#1/tpqs/QS", args, headers)
+	require.Nil(t, err)
+	mockedCaller.AssertExpectations(t)
 
-		require.Nil(t, err)
+	// 被调用方在回调中读取头部
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("123456", "", message.Resp{"res": true}))).Return(nil).Once()
+	call, err := message.ParseCallPayload([]byte(`{"name":"A/car/#1/tpqs/QS","uuid":"123456",` +
+		`"args":{"angle":10,"speed":"fast"},"headers":{"trace-id":"abc","deadline":"2024-01-01T00:00:00Z"}}`))
+	require.Nil(t, err)
+	conn.dealCallReq(call)
+	mockedConn.AssertExpectations(t)
+
+	require.NotNil(t, gotCtx)
+	assert.Equal(t, conn, gotCtx.Conn())
+	assert.Equal(t, "123456", gotCtx.UUID())
+	assert.Equal(t, headers, gotCtx.Headers())
+	gotCtx.Headers()["trace-id"] = "changed"
+	assert.Equal(t, "abc", gotCtx.Header("trace-id"), "修改返回的头部不影响调用上下文")
+	assert.Equal(t, "", gotCtx.Header("none"))
+
+	// 没有附加头部的调用请求
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("123456", "", message.Resp{"res": false}))).Return(nil).Once()
+	call.Headers = nil
+	conn.dealCallReq(call)
+	mockedConn.AssertExpectations(t)
+	assert.Nil(t, gotCtx.Headers())
+
+	// 以最后配置的回调为准
+	m := &Model{}
+	WithCallReqContextFunc(func(*CallContext, string, message.RawArgs) message.Resp {
+		return nil
+	})(m)
+	WithCallReqConnFunc(func(conn *Connection, name string, args message.RawArgs) message.Resp {
+		return message.Resp{"res": conn == nil}
+	})(m)
+	assert.Nil(t, m.callCtxHandler, "以最后配置的回调为准")
+	assert.Equal(t, message.Resp{"res": true}, m.callHandler().OnCallReqContext(&CallContext{}, "QS", nil), "适配携带连接的回调")
+}
 
-		conn := newConn(server, mockedConn, WithClosedHandler(mockOnClose))
+// TestModel_SetCallHandler 测试运行时替换调用请求回调
+func TestModel_SetCallHandler(t *testing.T) {
+	mockedConn := new(mockConn)
 
-		mockOnClose.On("OnClosed", io.EOF.Error()).Once()
-		mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
-		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
-		mockedConn.On("Close").Return(errors.New("already closed")).Once()
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqConnFunc(func(*Connection, string, message.RawArgs) message.Resp {
+		return message.Resp{"res": false}
+	}))
+	require.Nil(t, err)
 
-		server.dealConn(conn)
+	conn := newConn(server, mockedConn)
+	call := message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"fast"`),
+		},
+	}
 
-		mockedConn.AssertExpectations(t)
-		mockOnCall.AssertExpectations(t)
-		mockOnClose.AssertExpectations(t)
+	server.SetCallHandler(CallRequestFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": true}
+	}))
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"","response":{"res":true}}}`)).Return(nil).Once()
+	conn.dealCallReq(call)
+
+	server.SetCallHandler(nil)
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"NO callback","response":{}}}`)).Return(nil).Once()
+	conn.dealCallReq(call)
+
+	mockedConn.AssertExpectations(t)
+
+	// 与调用请求的处理并发替换回调
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn.dealCallReq(call)
+		}()
+		go func() {
+			defer wg.Done()
+			server.SetCallHandler(nil)
+		}()
 	}
+	wg.Wait()
 }
 
-// TestDealResponseMsg 测试响应报文的处理逻辑
-func TestDealResponseMsg(t *testing.T) {
-
-	closedErr := errors.New("connection closed for: EOF")
-	const uuidStr = `12345`
+// TestModel_SetBusy 测试物模型忙碌时拒绝调用请求
+func TestModel_SetBusy(t *testing.T) {
+	mockedConn := new(mockConn)
 
-	type TestCase struct {
-		msg      []byte          // 测试报文数据
-		wantErr  error           // 期望的响应错误
-		wantResp message.RawResp // 期望的响应数据
-		desc     string          // 用例描述
-	}
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": true}
+	}))
+	require.Nil(t, err)
 
-	testCases := []TestCase{
-		{
-			msg:      []byte(`{"type":"response","payload":[]}`),
-			wantErr:  closedErr,
-			wantResp: message.RawResp{},
-			desc:     "payload不是对象",
+	conn := newConn(server, mockedConn)
+	conn.pubStates["A/car/#1/tpqs/tpqsInfo"] = struct{}{}
+	server.addConn(conn)
+	call := message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"fast"`),
 		},
+	}
+	okResp := message.Must(message.EncodeRespMsg("123456", "", message.Resp{"res": true}))
+	busyResp := message.Must(message.EncodeRespMsg("123456", "device busy: firmware update", message.Resp{}))
+
+	server.SetBusy("firmware update")
+	mockedConn.On("WriteMsg", busyResp).Return(nil).Once()
+	conn.dealCallReq(call)
+
+	// 忙碌时状态推送不受影响
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateMsg("A/car/#1/tpqs/tpqsInfo", 1))).Return(nil).Once()
+	require.Nil(t, server.PushState("tpqsInfo", 1, false))
+
+	server.SetBusy("")
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("123456", "device busy", message.Resp{}))).Return(nil).Once()
+	conn.dealCallReq(call)
+
+	server.ClearBusy()
+	mockedConn.On("WriteMsg", okResp).Return(nil).Once()
+	conn.dealCallReq(call)
+	mockedConn.AssertExpectations(t)
+
+	// 与调用请求的处理并发切换忙碌状态, 每个调用请求要么成功要么收到忙碌错误
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn)
+	var lock sync.Mutex
+	var responses [][]byte
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		lock.Lock()
+		defer lock.Unlock()
+		responses = append(responses, args.Get(0).([]byte))
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			conn.dealCallReq(call)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				server.SetBusy("firmware update")
+			} else {
+				server.ClearBusy()
+			}
+		}(i)
+	}
+	wg.Wait()
 
-		{
-			msg:      []byte(`{"type":"response","payload":{}}`),
-			wantErr:  closedErr,
-			wantResp: message.RawResp{},
-			desc:     "payload缺失字段",
-		},
+	require.Len(t, responses, 20)
+	for _, resp := range responses {
+		assert.Contains(t, [][]byte{okResp, busyResp}, resp)
+	}
 
-		{
-			msg:      []byte(`{"type":"response","payload":{"uuid":"   ","response":{}}}`),
-			wantErr:  closedErr,
-			wantResp: message.RawResp{},
-			desc:     "uuid字段为空",
-		},
+	server.ClearBusy()
+	assert.Nil(t, server.busyErr())
+}
 
-		{
+// TestWithCallHandlerTimeout 测试调用请求回调超时
+func TestWithCallHandlerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallHandlerTimeout(50*time.Millisecond), WithCallReqFunc(func(_ string, args message.RawArgs) message.Resp {
+		if string(args["speed"]) == `"slow"` {
+			<-release
+		}
+		if string(args["speed"]) == `"fast"` && string(args["angle"]) == "0" {
+			panic("boom")
+		}
+		return message.Resp{"res": true}
+	}), WithPanicHandler(func(interface{}, []byte) {}))
+	require.Nil(t, err)
+
+	call := func(speed string, angle string) message.CallPayload {
+		return message.CallPayload{
+			Name: "A/car/#1/tpqs/QS",
+			UUID: "123456",
+			Args: message.RawArgs{
+				"angle": []byte(angle),
+				"speed": []byte(`"` + speed + `"`),
+			},
+		}
+	}
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+
+	// 回调及时返回
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"","response":{"res":true}}}`)).Return(nil).Once()
+	conn.dealCallReq(call("fast", "10"))
+
+	// 回调发生panic
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"internal error","response":{}}}`)).Return(nil).Once()
+	conn.dealCallReq(call("fast", "0"))
+
+	// 回调超时
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"handler timeout","response":{}}}`)).Return(nil).Once()
+	start := time.Now()
+	conn.dealCallReq(call("slow", "10"))
+	assert.Less(t, time.Since(start), time.Second, "超时后立即回复")
+	mockedConn.AssertExpectations(t)
+
+	// 超时后回调返回的结果被丢弃
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	mockedConn.AssertNumberOfCalls(t, "WriteMsg", 3)
+}
+
+// TestWithCallHandlerTimeout_Context 测试调用请求回调超时后取消回调的上下文
+func TestWithCallHandlerTimeout_Context(t *testing.T) {
+	canceled := make(chan error, 1)
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallHandlerTimeout(50*time.Millisecond), WithCallReqContextFunc(func(ctx *CallContext, _ string, args message.RawArgs) message.Resp {
+		if string(args["speed"]) == `"slow"` {
+			<-ctx.Done()
+			canceled <- ctx.Context().Err()
+		}
+		return message.Resp{"res": true}
+	}))
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"handler timeout","response":{}}}`)).Return(nil).Once()
+	conn.dealCallReq(message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"slow"`),
+		},
+	})
+	mockedConn.AssertExpectations(t)
+
+	select {
+	case err := <-canceled:
+		assert.Equal(t, context.DeadlineExceeded, err, "超时后取消回调的上下文")
+	case <-time.After(time.Second):
+		require.Fail(t, "超时后没有取消回调的上下文")
+	}
+
+	// 没有配置超时时间时上下文不会被取消
+	ctx := &CallContext{}
+	assert.Nil(t, ctx.Done())
+	assert.Equal(t, context.Background(), ctx.Context())
+}
+
+// TestWithCallAudit 测试调用审计回调
+func TestWithCallAudit(t *testing.T) {
+	records := make(chan AuditRecord, 4)
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallAudit(func(record AuditRecord) {
+		records <- record
+	}), WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": true}
+	}))
+	require.Nil(t, err)
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6000}
+	mockedConn := new(mockConn)
+	mockedConn.On("RemoteAddr").Return(addr)
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil)
+	conn := newConn(server, mockedConn)
+
+	recv := func() AuditRecord {
+		select {
+		case record := <-records:
+			return record
+		case <-time.After(time.Second):
+			t.Fatal("audit record NOT received")
+		}
+		return AuditRecord{}
+	}
+
+	// 调用成功
+	args := message.RawArgs{
+		"angle": []byte("10"),
+		"speed": []byte(`"fast"`),
+	}
+	conn.dealCallReq(message.CallPayload{Name: "A/car/#1/tpqs/QS", UUID: "1", Args: args})
+	record := recv()
+	assert.Equal(t, "127.0.0.1:6000", record.RemoteAddr)
+	assert.Equal(t, "", record.PeerName)
+	assert.Equal(t, "A/car/#1/tpqs/QS", record.Method)
+	assert.Equal(t, "1", record.UUID)
+	assert.Equal(t, args, record.Args)
+	assert.Equal(t, message.Resp{"res": true}, record.Resp)
+	assert.Nil(t, record.Err)
+	assert.GreaterOrEqual(t, record.Duration, time.Duration(0))
+
+	// 参数校验失败的错误响应同样审计
+	conn.dealCallReq(message.CallPayload{Name: "A/car/#1/tpqs/QS", UUID: "2", Args: message.RawArgs{"angle": []byte(`"10"`)}})
+	record = recv()
+	assert.Equal(t, "2", record.UUID)
+	assert.Nil(t, record.Resp)
+	assert.NotNil(t, record.Err)
+
+	// 模型名称不匹配
+	conn.dealCallReq(message.CallPayload{Name: "A/car/#2/tpqs/QS", UUID: "3", Args: args})
+	record = recv()
+	assert.Equal(t, "3", record.UUID)
+	assert.EqualError(t, record.Err, `modelName "A/car/#2/tpqs": unmatched`)
+
+	// 物模型忙碌
+	server.SetBusy("")
+	conn.dealCallReq(message.CallPayload{Name: "A/car/#1/tpqs/QS", UUID: "4", Args: args})
+	record = recv()
+	assert.Equal(t, "4", record.UUID)
+	assert.EqualError(t, record.Err, "device busy")
+
+	mockedConn.AssertNumberOfCalls(t, "WriteMsg", 4)
+}
+
+// TestCallArgDefaults 测试调用方省略有默认值的参数时, 被调用方通过校验并在回调中收到补全的默认值
+func TestCallArgDefaults(t *testing.T) {
+	gotArgs := make(chan message.RawArgs, 1)
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqFunc(func(name string, args message.RawArgs) message.Resp {
+		gotArgs <- args
+		return message.Resp{"res": true, "msg": "ok", "time": uint(0), "code": 0}
+	}))
+	require.Nil(t, err)
+
+	addr, err := server.Listen("127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		_ = server.Serve()
+	}()
+
+	conn, err := NewEmptyModel().Dial("tcp@" + addr.String())
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// 省略全部参数
+	_, err = conn.CallFor("A/car/#1/tpqs/QS", message.Args{}, time.Second)
+	require.Nil(t, err)
+	args := <-gotArgs
+	assert.Equal(t, []byte(`90`), []byte(args["angle"]), "补全默认值")
+	assert.Contains(t, args, "speed", "补全默认值")
+	assert.Nil(t, server.Meta().VerifyRawMethodArgs("QS", args))
+
+	// 已经传入的参数保持不变
+	_, err = conn.CallFor("A/car/#1/tpqs/QS", message.Args{"angle": 10, "speed": "slow"}, time.Second)
+	require.Nil(t, err)
+	args = <-gotArgs
+	assert.Equal(t, message.RawArgs{"angle": []byte(`10`), "speed": []byte(`"slow"`)}, args)
+}
+
+// TestModel_SetVerifyResp 测试运行时修改响应校验选项
+func TestModel_SetVerifyResp(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": "true"}
+	}))
+	require.Nil(t, err)
+
+	call := message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"fast"`),
+		},
+	}
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+
+	mockedConn.On("WriteMsg",
+		[]byte(`{"type":"response","payload":{"uuid":"123456","error":"","response":{"res":"true"}}}`)).Return(nil).Once()
+	conn.dealCallReq(call)
+
+	server.SetVerifyResp(true)
+	mockedConn.On("WriteMsg", mock.MatchedBy(func(msg []byte) bool {
+		return !bytes.Contains(msg, []byte(`"error":""`))
+	})).Return(nil).Once()
+	conn.dealCallReq(call)
+	mockedConn.AssertExpectations(t)
+
+	// 多个连接并发处理调用请求时修改选项
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		raw := new(mockConn)
+		raw.On("WriteMsg", mock.Anything).Return(nil)
+		c := newConn(server, raw)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c.dealCallReq(call)
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		server.SetVerifyResp(i%2 == 0)
+		server.SetCallHandler(CallRequestFunc(func(string, message.RawArgs) message.Resp {
+			return message.Resp{}
+		}))
+	}
+	wg.Wait()
+}
+
+// TestModel_CheckResponseShape 测试检查方法响应与元信息是否一致
+func TestModel_CheckResponseShape(t *testing.T) {
+	m, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	type TestCase struct {
+		method  string       // 方法名
+		sample  message.Resp // 响应示例
+		wantErr error        // 期望的错误信息
+		desc    string       // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			method: "QS",
+			sample: message.Resp{
+				"res":  true,
+				"msg":  "ok",
+				"time": uint(10),
+				"code": 0,
+			},
+			desc: "字段完全一致",
+		},
+
+		{
+			method:  "NotExist",
+			sample:  message.Resp{},
+			wantErr: fmt.Errorf("NO method %q", "NotExist"),
+			desc:    "方法不存在",
+		},
+
+		{
+			method: "QS",
+			sample: message.Resp{
+				"res":  true,
+				"mgs":  "ok",
+				"time": uint(10),
+				"code": 0,
+			},
+			wantErr: fmt.Errorf("response %q: missing", "msg"),
+			desc:    "缺少字段",
+		},
+
+		{
+			method: "QS",
+			sample: message.Resp{
+				"res":   true,
+				"msg":   "ok",
+				"time":  uint(10),
+				"code":  0,
+				"extra": 1,
+				"debug": "",
+			},
+			wantErr: fmt.Errorf("response %q: NOT declared", "debug, extra"),
+			desc:    "多余字段",
+		},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.wantErr, m.CheckResponseShape(test.method, test.sample), test.desc)
+	}
+}
+
+// TestModel_DialWebSocketWithHeader 测试建立WebSocket连接时携带请求头和子协议
+func TestModel_DialWebSocketWithHeader(t *testing.T) {
+	type handshake struct {
+		auth      string   // 请求头中的鉴权信息
+		protocols []string // 请求的子协议
+		selected  string   // 协商的子协议
+	}
+	handshakes := make(chan handshake, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		wsUpgrader := websocket.Upgrader{Subprotocols: []string{"model.v2", "model.v1"}}
+		conn, err := wsUpgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handshakes <- handshake{
+			auth:      request.Header.Get("Authorization"),
+			protocols: websocket.Subprotocols(request),
+			selected:  conn.Subprotocol(),
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer ts.Close()
+
+	addr := "ws" + strings.TrimPrefix(ts.URL, "http")
+	client := New(meta.NewEmptyMeta(), WithWebSocketSubprotocols("model.v1", "model.v2"))
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer token")
+	conn, err := client.DialWebSocketWithHeader(addr, header)
+	require.Nil(t, err)
+	assert.Equal(t, handshake{
+		auth:      "Bearer token",
+		protocols: []string{"model.v1", "model.v2"},
+		selected:  "model.v2",
+	}, <-handshakes)
+	require.Nil(t, conn.Close())
+
+	// 不携带请求头
+	conn, err = NewEmptyModel().DialWebSocket(addr)
+	require.Nil(t, err)
+	assert.Equal(t, handshake{}, <-handshakes)
+	require.Nil(t, conn.Close())
+}
+
+// TestWithWebSocketCompression 测试WebSocket连接协商压缩扩展
+func TestWithWebSocketCompression(t *testing.T) {
+	assert.False(t, New(meta.NewEmptyMeta(), WithWebSocketCompression(flate.BestCompression+1)).wsCompress, "无效的压缩级别")
+	m := New(meta.NewEmptyMeta(), WithWebSocketCompression(flate.BestSpeed))
+	assert.True(t, m.wsCompress)
+	assert.Equal(t, flate.BestSpeed, m.wsCompressLevel)
+
+	server, err := LoadFromBuff([]byte(`{"name": "A/dashboard", "description": "看板", "state": [
+		{"name": "log", "description": "日志", "type": "string"}
+	], "event": [], "method": []}`), nil, WithWebSocketCompression(flate.BestCompression))
+	require.Nil(t, err)
+	addr := freeAddr(t)
+	go func() {
+		_ = server.ListenServeWebSocket(addr)
+	}()
+
+	// 服务端协商压缩扩展
+	var raw *websocket.Conn
+	var resp *http.Response
+	require.Eventually(t, func() bool {
+		dialer := websocket.Dialer{EnableCompression: true}
+		raw, resp, err = dialer.Dial("ws://"+addr, nil)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	require.Nil(t, raw.Close())
+
+	raw, resp, err = websocket.DefaultDialer.Dial("ws://"+addr, nil)
+	require.Nil(t, err)
+	assert.Empty(t, resp.Header.Get("Sec-WebSocket-Extensions"), "客户端不支持压缩时不压缩")
+	require.Nil(t, raw.Close())
+
+	// 压缩后较大的状态报文仍然能正确解码
+	states := make(chan []byte, 1)
+	client := New(meta.NewEmptyMeta(), WithWebSocketCompression(flate.DefaultCompression))
+	conn, err := client.DialWebSocket("ws://"+addr, WithStateFunc(func(modelName string, stateName string, data []byte) {
+		states <- data
+	}))
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.SubStateAck([]string{"A/dashboard/log"})
+	require.Nil(t, err)
+
+	content := strings.Repeat(`{"level":"info","msg":"state changed"}`, 1000)
+	require.Nil(t, server.PushState("log", content, true))
+	select {
+	case data := <-states:
+		var got string
+		require.Nil(t, json.Unmarshal(data, &got))
+		assert.Equal(t, content, got)
+	case <-time.After(time.Second):
+		t.Fatal("state NOT received")
+	}
+}
+
+// TestModel_ListenServe 测试分开监听和服务
+func TestModel_ListenServe(t *testing.T) {
+	server := NewEmptyModel()
+	assert.Equal(t, errors.New("NOT listening"), server.Serve(), "未监听")
+
+	addr, err := server.Listen("127.0.0.1:0")
+	require.Nil(t, err)
+	assert.NotEqual(t, 0, addr.(*net.TCPAddr).Port, "系统分配端口")
+
+	_, err = server.Listen("127.0.0.1:0")
+	assert.Equal(t, fmt.Errorf("already listening on %s", addr), err, "重复监听")
+
+	go func() {
+		_ = server.Serve()
+	}()
+
+	conn, err := NewEmptyModel().Dial("tcp@" + addr.String())
+	require.Nil(t, err)
+	peer, err := conn.GetPeerMeta()
+	require.Nil(t, err)
+	assert.Equal(t, server.Meta().Name, peer.Name)
+	require.Nil(t, conn.Close())
+}
+
+// TestModel_Validate 测试检查物模型配置是否完整
+func TestModel_Validate(t *testing.T) {
+	assert.Nil(t, NewEmptyModel().Validate(), "没有方法和可写状态")
+
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithVerifyResp())
+	require.Nil(t, err)
+	assert.Equal(t, errors.New("methods [QS] declared but NO call handler"), server.Validate())
+
+	server.SetCallHandler(CallRequestFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{}
+	}))
+	assert.Nil(t, server.Validate(), "注册调用请求回调")
+
+	writable := []byte(`{"name": "test", "description": "测试物模型", "state": [
+		{"name": "speed", "description": "速度", "type": "float"},
+		{"name": "target", "description": "目标速度", "type": "float", "access": "rw"},
+		{"name": "mode", "description": "模式", "type": "string", "access": "w"}
+	], "event": [], "method": []}`)
+	server, err = LoadFromBuff(writable, nil)
+	require.Nil(t, err)
+	assert.Equal(t, errors.New("writable states [target, mode] declared but NO state set handler"), server.Validate())
+
+	server, err = LoadFromBuff(writable, nil, WithStateSetFunc(func(string, []byte) error {
+		return nil
+	}))
+	require.Nil(t, err)
+	assert.Nil(t, server.Validate(), "注册状态设置回调")
+}
+
+// TestConnection_SetState 测试通过状态设置报文设置对端的可写状态
+func TestConnection_SetState(t *testing.T) {
+	var target float64
+	server, err := LoadFromBuff([]byte(`{"name": "test", "description": "测试物模型", "state": [
+		{"name": "speed", "description": "速度", "type": "float"},
+		{"name": "target", "description": "目标速度", "type": "float", "access": "rw", "range": {"min": 0, "max": 100}}
+	], "event": [], "method": []}`), nil, WithStateSetFunc(func(name string, data []byte) error {
+		if err := json.Unmarshal(data, &target); err != nil {
+			return err
+		}
+		if target == 50 {
+			return errors.New("busy")
+		}
+		return nil
+	}))
+	require.Nil(t, err)
+
+	addr, err := server.Listen("127.0.0.1:0")
+	require.Nil(t, err)
+	go func() {
+		_ = server.Serve()
+	}()
+	defer server.listener.Close()
+
+	conn, err := NewEmptyModel().Dial("tcp@" + addr.String())
+	require.Nil(t, err)
+	defer conn.Close()
+
+	require.Nil(t, conn.SetState("test/target", 10.5))
+	assert.Equal(t, 10.5, target)
+
+	testCases := []struct {
+		name    string      // 状态全名
+		data    interface{} // 状态数据
+		wantErr error       // 期望的错误信息
+		desc    string      // 用例描述
+	}{
+		{"test/speed", 1.0, errors.New(`state "speed" is read-only`), "只读状态"},
+		{"test/unknown", 1.0, errors.New(`NO state "unknown"`), "状态不存在"},
+		{"other/target", 1.0, errors.New(`modelName "other": unmatched`), "模型名称不匹配"},
+		{"target", 1.0, errors.New(`fullName "target" missing '/'`), "状态全名格式错误, 编码时即返回错误"},
+		{"test/target", 200.0, errors.New("greater than max"), "状态数据超出范围"},
+		{"test/target", 50.0, errors.New("busy"), "设置回调返回错误"},
+	}
+
+	for _, test := range testCases {
+		assert.Equal(t, test.wantErr, conn.SetState(test.name, test.data), test.desc)
+	}
+
+	// 未注册状态设置回调
+	noCallback := New(server.Meta())
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("1", "NO callback", message.Resp{}))).Return(nil).Once()
+	newConn(noCallback, mockedConn).dealSetStateReq(message.SetStatePayload{
+		Name: "test/target",
+		UUID: "1",
+		Data: []byte(`1`),
+	})
+	mockedConn.AssertExpectations(t)
+}
+
+// TestModel_SetMethodVerifyResp 测试按方法配置响应校验
+func TestModel_SetMethodVerifyResp(t *testing.T) {
+	server, err := LoadFromBuff([]byte(`{"name": "test", "description": "测试物模型", "state": [], "event": [], "method": [
+		{"name": "a", "description": "方法a", "args": [], "response": [{"name": "res", "description": "结果", "type": "bool"}]},
+		{"name": "legacy", "description": "旧方法", "args": [], "response": [{"name": "res", "description": "结果", "type": "bool"}], "verifyResponse": false}
+	]}`), nil, WithVerifyResp(), WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		return message.Resp{"res": "true"}
+	}))
+	require.Nil(t, err)
+
+	// 调用方法method, 返回响应是否校验失败
+	verifyFailed := func(method string) bool {
+		mockedConn := new(mockConn)
+		var failed bool
+		mockedConn.On("WriteMsg", mock.Anything).Run(func(args mock.Arguments) {
+			failed = !bytes.Contains(args.Get(0).([]byte), []byte(`"error":""`))
+		}).Return(nil).Once()
+		newConn(server, mockedConn).dealCallReq(message.CallPayload{
+			Name: "test/" + method,
+			UUID: "123",
+			Args: message.RawArgs{},
+		})
+		mockedConn.AssertExpectations(t)
+		return failed
+	}
+
+	assert.True(t, verifyFailed("a"), "全局开启校验")
+	assert.False(t, verifyFailed("legacy"), "元信息关闭校验")
+
+	server.SetMethodVerifyResp("a", false)
+	server.SetMethodVerifyResp("legacy", true)
+	assert.False(t, verifyFailed("a"), "运行时关闭校验")
+	assert.True(t, verifyFailed("legacy"), "运行时开启校验优先于元信息")
+
+	server.SetVerifyResp(true)
+	assert.False(t, verifyFailed("a"), "运行时配置优先于全局选项")
+}
+
+// TestWithPanicHandler 测试回调发生panic时连接继续工作
+func TestWithPanicHandler(t *testing.T) {
+	var lock sync.Mutex
+	var recovered []interface{}
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithCallReqFunc(func(string, message.RawArgs) message.Resp {
+		panic("call panic")
+	}), WithPanicHandler(func(r interface{}, stack []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+		recovered = append(recovered, r)
+		assert.NotEmpty(t, stack, "提供调用栈")
+	}))
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+
+	var states []string
+	var events []string
+	conn := newConn(server, mockedConn, WithStateFunc(func(modelName string, stateName string, data []byte) {
+		if stateName == "panic" {
+			panic("state panic")
+		}
+		states = append(states, stateName)
+	}), WithEventFunc(func(modelName string, eventName string, args message.RawArgs) {
+		if eventName == "panic" {
+			panic("event panic")
+		}
+		events = append(events, eventName)
+	}))
+
+	// 调用请求回调发生panic时返回错误响应
+	wantResp := []byte(`{"type":"response","payload":{"uuid":"123456","error":"internal error","response":{}}}`)
+	mockedConn.On("WriteMsg", wantResp).Return(nil).Once()
+	conn.dealCallReq(message.CallPayload{
+		Name: "A/car/#1/tpqs/QS",
+		UUID: "123456",
+		Args: message.RawArgs{
+			"angle": []byte("10"),
+			"speed": []byte(`"fast"`),
+		},
+	})
+
+	// 状态和事件回调发生panic后继续处理后续报文
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"model/panic","data":1}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"model/ok","data":1}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"event","payload":{"name":"model/panic","args":{}}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"event","payload":{"name":"model/ok","args":{}}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	server.dealConn(conn)
+
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []string{"ok"}, states, "panic后继续处理状态")
+	assert.Equal(t, []string{"ok"}, events, "panic后继续处理事件")
+	assert.ElementsMatch(t, []interface{}{"call panic", "state panic", "event panic"}, recovered, "panic交给处理函数")
+
+	// 忽略nil处理函数
+	m := &Model{}
+	WithPanicHandler(nil)(m)
+	assert.Nil(t, m.panicHandler, "忽略nil处理函数")
+}
+
+// TestWaitForAll 测试同时等待多个调用响应
+func TestWaitForAll(t *testing.T) {
+	newWaiter := func() *RespWaiter {
+		return &RespWaiter{got: make(chan struct{})}
+	}
+
+	w1, w2, w3 := newWaiter(), newWaiter(), newWaiter()
+	w1.wake(message.RawResp{"res": []byte("true")}, nil)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		w2.wake(message.RawResp{}, errors.New("connection closed"))
+	}()
+
+	start := time.Now()
+	resps, errs := WaitForAll([]*RespWaiter{w1, w2, w3, nil}, time.Millisecond*200)
+	elapsed := time.Since(start)
+
+	assert.Less(t, elapsed, time.Millisecond*400, "共用同一个截止时间")
+	assert.GreaterOrEqual(t, elapsed, time.Millisecond*200, "等待到截止时间")
+	require.Len(t, resps, 4)
+	require.Len(t, errs, 4)
+
+	assert.Equal(t, message.RawResp{"res": []byte("true")}, resps[0], "已收到的响应保持不变")
+	assert.Nil(t, errs[0], "已收到的响应保持不变")
+	assert.Equal(t, errors.New("connection closed"), errs[1], "截止时间前收到的错误")
+	assert.Equal(t, message.RawResp{}, resps[2], "超时")
+	assert.Equal(t, errors.New("timeout"), errs[2], "超时")
+	assert.Equal(t, errors.New("nil waiter"), errs[3], "空等待器")
+
+	// 全部收到响应时立即返回
+	w4 := newWaiter()
+	w4.wake(message.RawResp{}, nil)
+	start = time.Now()
+	_, errs = WaitForAll([]*RespWaiter{w4}, time.Second)
+	assert.Less(t, time.Since(start), time.Millisecond*100, "全部收到响应时立即返回")
+	assert.Equal(t, []error{nil}, errs)
+}
+
+// TestWithUnknownMessageHandler 测试收到未知类型的报文
+func TestWithUnknownMessageHandler(t *testing.T) {
+	server := NewEmptyModel()
+
+	type unknown struct {
+		msgType string
+		payload string
+	}
+
+	for _, withHandler := range []bool{false, true} {
+		mockedConn := new(mockConn)
+
+		var got []unknown
+		var opts []ConnOption
+		if withHandler {
+			opts = append(opts, WithUnknownMessageHandler(func(msgType string, payload []byte) {
+				got = append(got, unknown{msgType, string(payload)})
+			}))
+		}
+		conn := newConn(server, mockedConn, opts...)
+
+		mockedConn.On("ReadMsg").Return([]byte(`{"type":"future","payload":{"a":1}}`), nil).Once()
+		mockedConn.On("ReadMsg").Return([]byte(`{"type":"pong","payload":{"uuid":"1"}}`), nil).Once()
+		mockedConn.On("ReadMsg").Return([]byte(`{"type":"other"}`), nil).Once()
+		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+		mockedConn.On("Close").Return(nil).Once()
+
+		server.dealConn(conn)
+
+		mockedConn.AssertExpectations(t)
+		assert.Equal(t, uint64(2), conn.UnknownMsgCount(), "统计未知类型报文数量")
+		if withHandler {
+			assert.Equal(t, []unknown{
+				{"future", `{"a":1}`},
+				{"other", ""},
+			}, got, "回调未知类型报文")
+		}
+	}
+}
+
+// TestConnection_StatesEventsChannel 测试通过管道接收状态和事件
+func TestConnection_StatesEventsChannel(t *testing.T) {
+	mockedConn := new(mockConn)
+
+	var states []string
+	conn := newConn(NewEmptyModel(), mockedConn, WithStateFunc(func(modelName string, stateName string, data []byte) {
+		states = append(states, stateName)
+	}))
+
+	statesCh := conn.StatesChannel(10)
+	eventsCh := conn.EventsChannel(-1)
+
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"A/car/speed","data":1}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"event","payload":{"name":"A/car/alarm","args":{"level":2}}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	var events []EventMessage
+	eventsDone := make(chan struct{})
+	go func() {
+		defer close(eventsDone)
+		for event := range eventsCh {
+			events = append(events, event)
+		}
+	}()
+
+	NewEmptyModel().dealConn(conn)
+	<-eventsDone
+
+	var got []StateMessage
+	for state := range statesCh {
+		got = append(got, state)
+	}
+
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []StateMessage{{"A/car", "speed", []byte("1"), 0, false}}, got, "管道收到状态")
+	assert.Equal(t, []string{"speed"}, states, "回调也收到状态")
+	assert.Equal(t, []EventMessage{{"A/car", "alarm", message.RawArgs{"level": []byte("2")}, 0}}, events, "管道收到事件")
+
+	_, ok := <-conn.StatesChannel(1)
+	assert.False(t, ok, "连接关闭后注册的管道已关闭")
+	_, ok = <-conn.EventsChannel(1)
+	assert.False(t, ok, "连接关闭后注册的管道已关闭")
+}
+
+// TestConnection_SeqHandler 测试通过扩展回调接收推送序号
+func TestConnection_SeqHandler(t *testing.T) {
+	mockedConn := new(mockConn)
+
+	var stateSeqs, eventSeqs []uint64
+	conn := newConn(NewEmptyModel(), mockedConn,
+		WithStateHandler(StateSeqFunc(func(modelName string, stateName string, data []byte, seq uint64) {
+			stateSeqs = append(stateSeqs, seq)
+		})),
+		WithEventHandler(EventSeqFunc(func(modelName string, eventName string, args message.RawArgs, seq uint64) {
+			eventSeqs = append(eventSeqs, seq)
+		})),
+	)
+
+	statesCh := conn.StatesChannel(10)
+	eventsCh := conn.EventsChannel(10)
+
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"A/car/speed","data":1,"seq":7}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"A/car/speed","data":2}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"event","payload":{"name":"A/car/alarm","args":{},"seq":8}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	NewEmptyModel().dealConn(conn)
+
+	var gotStates []uint64
+	for state := range statesCh {
+		gotStates = append(gotStates, state.Seq)
+	}
+	var gotEvents []uint64
+	for event := range eventsCh {
+		gotEvents = append(gotEvents, event.Seq)
+	}
+
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []uint64{7, 0}, stateSeqs, "回调收到状态推送序号, 没有序号时为0")
+	assert.Equal(t, []uint64{8}, eventSeqs, "回调收到事件推送序号")
+	assert.Equal(t, []uint64{7, 0}, gotStates, "管道收到状态推送序号")
+	assert.Equal(t, []uint64{8}, gotEvents, "管道收到事件推送序号")
+}
+
+// TestDealInvalidCallMsg 测试无效调用请求报文
+func TestDealInvalidCallMsg(t *testing.T) {
+	type TestCase struct {
+		msg  []byte // 测试报文数据
+		desc string // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			msg:  []byte(`{"type":"call"}`),
+			desc: "缺少payload字段",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":null}`),
+			desc: "payload为null",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":[]}`),
+			desc: "payload不是对象",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":123}}`),
+			desc: "调用请求的name字段不是字符串",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"uuid":"123","args":{}}}`),
+			desc: "调用请求缺少name字段",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"\r\n  \t","uuid":"123","args":{}}}`),
+			desc: "name字段去除空格后为空",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","args":{}}}`),
+			desc: "调用请求缺少uuid字段",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"","args":{}}}`),
+			desc: "uuid字段为空",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"  \t","args":{}}}`),
+			desc: "uuid字段去除空格后为空",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"123"}}`),
+			desc: "调用请求缺少args字段",
+		},
+
+		{
+			msg:  []byte(`{"type":"call","payload":{"name":"cat/qs","uuid":"123","args":null}}`),
+			desc: "args字段为null",
+		},
+	}
+
+	for _, test := range testCases {
+		fmt.Println(test.desc)
+
+		mockOnCall := new(mockCallReqHandler)
+		mockOnClose := new(mockCloseHandler)
+		mockedConn := new(mockConn)
+
+		server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+			"group": "A",
+			"id":    "#1",
+		})
+
+		require.Nil(t, err)
+
+		conn := newConn(server, mockedConn, WithClosedHandler(mockOnClose))
+
+		mockOnClose.On("OnClosed", io.EOF.Error()).Once()
+		mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
+		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+		mockedConn.On("Close").Return(errors.New("already closed")).Once()
+
+		server.dealConn(conn)
+
+		mockedConn.AssertExpectations(t)
+		mockOnCall.AssertExpectations(t)
+		mockOnClose.AssertExpectations(t)
+	}
+}
+
+// TestDealResponseMsg 测试响应报文的处理逻辑
+func TestDealResponseMsg(t *testing.T) {
+
+	closedErr := errors.New("connection closed for: EOF")
+	const uuidStr = `12345`
+
+	type TestCase struct {
+		msg      []byte          // 测试报文数据
+		wantErr  error           // 期望的响应错误
+		wantResp message.RawResp // 期望的响应数据
+		desc     string          // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			msg:      []byte(`{"type":"response","payload":[]}`),
+			wantErr:  closedErr,
+			wantResp: message.RawResp{},
+			desc:     "payload不是对象",
+		},
+
+		{
+			msg:      []byte(`{"type":"response","payload":{}}`),
+			wantErr:  closedErr,
+			wantResp: message.RawResp{},
+			desc:     "payload缺失字段",
+		},
+
+		{
+			msg:      []byte(`{"type":"response","payload":{"uuid":"   ","response":{}}}`),
+			wantErr:  closedErr,
+			wantResp: message.RawResp{},
+			desc:     "uuid字段为空",
+		},
+
+		{
 			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","response":null}}`),
 			wantErr:  closedErr,
 			wantResp: message.RawResp{},
@@ -1339,41 +2718,129 @@ func TestDealResponseMsg(t *testing.T) {
 		},
 
 		{
-			msg:      []byte(`{"type":"response","payload":{"uuid":"not existed","response":{}}}`),
-			wantErr:  closedErr,
-			wantResp: message.RawResp{},
-			desc:     "响应报文无对应的等待器",
+			msg:      []byte(`{"type":"response","payload":{"uuid":"not existed","response":{}}}`),
+			wantErr:  closedErr,
+			wantResp: message.RawResp{},
+			desc:     "响应报文无对应的等待器",
+		},
+
+		{
+			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","response":{}}}`),
+			wantErr:  nil,
+			wantResp: message.RawResp{},
+			desc:     "error字段缺失,响应为空",
+		},
+
+		{
+			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","error":"   ","response":{}}}`),
+			wantErr:  nil,
+			wantResp: message.RawResp{},
+			desc:     "error字段为空字符串,响应为空",
+		},
+
+		{
+			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","error":"  arg \"a\": missing ","response":{}}}`),
+			wantErr:  errors.New(`arg "a": missing`),
+			wantResp: message.RawResp{},
+			desc:     "error字段为有效错误,响应为空",
+		},
+
+		{
+			msg:     []byte(`{"type":"response","payload":{"uuid":"12345","error":" ","response":{"res":true,"time":100}}}`),
+			wantErr: nil,
+			wantResp: message.RawResp{
+				"res":  []byte(`true`),
+				"time": []byte(`100`),
+			},
+			desc: "无错误信息的响应",
+		},
+	}
+
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	for _, test := range testCases {
+		fmt.Println(test.desc)
+
+		mockOnClose := new(mockCloseHandler)
+		mockedConn := new(mockConn)
+
+		waiter := &RespWaiter{
+			got: make(chan struct{}),
+		}
+
+		conn := newConn(server, mockedConn, WithClosedHandler(mockOnClose))
+		conn.respWaiters = map[string]*RespWaiter{
+			uuidStr: waiter,
+		}
+
+		// NOTE: 接收完响应报文就关闭连接,
+		// NOTE: 如果没有收到正确的响应报文,等待器则会由于连接关闭而唤醒
+		mockOnClose.On("OnClosed", io.EOF.Error()).Once()
+		mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
+		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+		mockedConn.On("Close").Return(errors.New("already closed")).Once()
+
+		server.dealConn(conn)
+
+		select {
+		case <-waiter.got:
+			assert.EqualValues(t, test.wantErr, waiter.err, test.desc)
+			assert.EqualValues(t, test.wantResp, waiter.resp, test.desc)
+		default:
+			assert.Fail(t, "等待器未唤醒", test.desc)
+		}
+
+		assert.Len(t, conn.respWaiters, 0, "响应等待器已清空")
+
+		mockedConn.AssertExpectations(t)
+		mockOnClose.AssertExpectations(t)
+	}
+
+	assert.Len(t, server.allConn, 0, "管理的连接必须为空")
+}
+
+// TestDealMetaInfoMsg 测试元信息报文处理逻辑
+func TestDealMetaInfoMsg(t *testing.T) {
+
+	type TestCase struct {
+		msg     []byte // 测试报文数据
+		wantErr error  // 期望的元信息错误
+		desc    string // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			msg:     nil,
+			wantErr: errors.New("connection closed for: EOF"),
+			desc:    "未收到元信息连接就关闭",
 		},
 
 		{
-			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","response":{}}}`),
-			wantErr:  nil,
-			wantResp: message.RawResp{},
-			desc:     "error字段缺失,响应为空",
+			msg:     []byte(`{"type":"meta-info","payload":123}`),
+			wantErr: errors.New("root: NOT an object"),
+			desc:    "元信息不为对象",
 		},
 
 		{
-			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","error":"   ","response":{}}}`),
-			wantErr:  nil,
-			wantResp: message.RawResp{},
-			desc:     "error字段为空字符串,响应为空",
+			msg:     []byte(`{"type":"meta-info","payload":{}}`),
+			wantErr: errors.New("root: name NOT exist"),
+			desc:    "name字段不存在",
 		},
 
 		{
-			msg:      []byte(`{"type":"response","payload":{"uuid":"12345","error":"  arg \"a\": missing ","response":{}}}`),
-			wantErr:  errors.New(`arg "a": missing`),
-			wantResp: message.RawResp{},
-			desc:     "error字段为有效错误,响应为空",
+			msg:     []byte(`{"type":"meta-info","payload":{"name":"tpqs"}}`),
+			wantErr: errors.New("root: description NOT exist"),
+			desc:    "description字段不存在",
 		},
 
 		{
-			msg:     []byte(`{"type":"response","payload":{"uuid":"12345","error":" ","response":{"res":true,"time":100}}}`),
-			wantErr: nil,
-			wantResp: message.RawResp{
-				"res":  []byte(`true`),
-				"time": []byte(`100`),
-			},
-			desc: "无错误信息的响应",
+			msg:     []byte(`{"type":"meta-info","payload":{"error":"meta disclosure disabled"}}`),
+			wantErr: errors.New("meta disclosure disabled"),
+			desc:    "对端拒绝公开元信息",
 		},
 	}
 
@@ -1389,111 +2856,1318 @@ func TestDealResponseMsg(t *testing.T) {
 		mockOnClose := new(mockCloseHandler)
 		mockedConn := new(mockConn)
 
-		waiter := &RespWaiter{
-			got: make(chan struct{}),
-		}
-
 		conn := newConn(server, mockedConn, WithClosedHandler(mockOnClose))
-		conn.respWaiters = map[string]*RespWaiter{
-			uuidStr: waiter,
-		}
 
-		// NOTE: 接收完响应报文就关闭连接,
-		// NOTE: 如果没有收到正确的响应报文,等待器则会由于连接关闭而唤醒
 		mockOnClose.On("OnClosed", io.EOF.Error()).Once()
-		mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
+		if test.msg != nil {
+			mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
+		}
 		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
 		mockedConn.On("Close").Return(errors.New("already closed")).Once()
 
 		server.dealConn(conn)
 
 		select {
-		case <-waiter.got:
-			assert.EqualValues(t, test.wantErr, waiter.err, test.desc)
-			assert.EqualValues(t, test.wantResp, waiter.resp, test.desc)
+		case <-conn.metaGotCh:
 		default:
-			assert.Fail(t, "等待器未唤醒", test.desc)
+			assert.Fail(t, "未唤醒元信息等待", test.desc)
+		}
+
+		assert.EqualValues(t, test.wantErr, conn.peerMetaErr, "期望的对端元信息错误")
+
+		mockedConn.AssertExpectations(t)
+		mockOnClose.AssertExpectations(t)
+	}
+
+}
+
+// TestConnection_PeerMeta 测试非阻塞地获取对端元信息
+func TestConnection_PeerMeta(t *testing.T) {
+	peer, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+
+	got, ok := conn.PeerMeta()
+	assert.False(t, ok, "尚未收到对端元信息")
+	assert.Equal(t, meta.NewEmptyMeta().Description, got.Description, "尚未收到对端元信息")
+	assert.Equal(t, "", conn.PeerName(), "尚未收到对端元信息")
+
+	conn.onMetaInfo(peer.Meta().ToJSON())
+	got, ok = conn.PeerMeta()
+	assert.True(t, ok, "已经收到对端元信息")
+	assert.Equal(t, peer.Meta().Name, got.Name, "已经收到对端元信息")
+	assert.Equal(t, "A/car/#1/tpqs", conn.PeerName(), "已经收到对端元信息")
+	mockedConn.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	conn = newConn(NewEmptyModel(), mockedConn)
+	conn.onMetaInfo([]byte(`{}`))
+	_, ok = conn.PeerMeta()
+	assert.False(t, ok, "对端元信息无效")
+	assert.Equal(t, "", conn.PeerName(), "对端元信息无效")
+}
+
+func TestConnection_RemoteAddr(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	mockedConn := new(mockConn)
+	mockedConn.On("RemoteAddr").Return(addr).Once()
+	conn := newConn(NewEmptyModel(), mockedConn)
+
+	assert.Equal(t, addr, conn.RemoteAddr(), "对端地址")
+	mockedConn.AssertExpectations(t)
+}
+
+// TestWithMaxSubscriptions 测试订阅数量上限
+func TestWithMaxSubscriptions(t *testing.T) {
+	// 超大的订阅列表
+	huge := make([]string, 0, 100000)
+	for i := 0; i < 100000; i++ {
+		huge = append(huge, fmt.Sprintf("A/car/#1/tpqs/state%d", i))
+	}
+
+	// 截断策略
+	server := New(meta.NewEmptyMeta(), WithMaxSubscriptions(3))
+	mockedConn := new(mockConn)
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubStateMsg(message.SetSub, huge)), nil).Once()
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubStateMsg(message.AddSub, []string{"a", huge[0]})), nil).Once()
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubEventMsg(message.SetSub, []string{"e1", "e1", "e2"})), nil).Once()
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubEventMsg(message.AddSub, huge)), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	conn := newConn(server, mockedConn)
+	server.dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	assert.ElementsMatch(t, huge[:3], conn.GetSubStates(), "截断状态订阅")
+	assert.ElementsMatch(t, []string{"e1", "e2", huge[0]}, conn.GetSubEvents(), "截断事件订阅")
+
+	// 关闭连接策略
+	server = New(meta.NewEmptyMeta(), WithMaxSubscriptions(3), WithSubLimitPolicy(SubLimitClose))
+	mockedConn = new(mockConn)
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubStateMsg(message.SetSub, huge[:3])), nil).Once()
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeSubStateMsg(message.AddSub, huge[3:4])), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil)
+
+	var reason string
+	conn = newConn(server, mockedConn, WithClosedFunc(func(r string) {
+		reason = r
+	}))
+	server.dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, "too many subscriptions", reason, "关闭原因")
+	assert.ElementsMatch(t, huge[:3], conn.GetSubStates(), "超出上限时不修改订阅")
+}
+
+// TestConnection_CloseWithReason 测试通过关闭报文告知对端关闭原因
+func TestConnection_CloseWithReason(t *testing.T) {
+	// 主动关闭的一端先发送关闭报文
+	var reason string
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(r string) {
+		reason = r
+	}))
+	first := mockedConn.On("WriteMsg", message.EncodeCloseMsg("maintenance")).Return(nil).Once()
+	mockedConn.On("Close").Return(nil).Once().NotBefore(first)
+	require.Nil(t, conn.CloseWithReason("maintenance"))
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, "active close: maintenance", reason)
+
+	// 对端收到关闭报文后以关闭原因作为连接关闭原因
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(r string) {
+		reason = r
+	}))
+	mockedConn.On("ReadMsg").Return(message.EncodeCloseMsg("maintenance"), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, "peer closed: maintenance", reason)
+	assert.Equal(t, uint64(0), conn.UnknownMsgCount())
+
+	// 没有收到关闭报文
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(r string) {
+		reason = r
+	}))
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, "EOF", reason)
+}
+
+// TestConnection_Done 测试通过管道等待连接关闭
+func TestConnection_Done(t *testing.T) {
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+
+	select {
+	case <-conn.Done():
+		assert.Fail(t, "连接关闭前管道不应关闭")
+	default:
+	}
+	assert.Nil(t, conn.Err(), "连接关闭前没有错误")
+
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil)
+	go NewEmptyModel().dealConn(conn)
+
+	select {
+	case <-conn.Done():
+	case <-time.After(time.Second):
+		require.Fail(t, "连接关闭后管道应关闭")
+	}
+	assert.Equal(t, errors.New("connection closed for: EOF"), conn.Err(), "连接关闭原因")
+
+	// 再次关闭不影响关闭原因
+	require.Nil(t, conn.Close())
+	<-conn.Done()
+	assert.Equal(t, errors.New("connection closed for: EOF"), conn.Err(), "关闭原因不变")
+}
+
+// timeoutErr 为模拟的读取超时错误
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+// TestConnection_CloseReason 测试结构化的连接关闭原因
+func TestConnection_CloseReason(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs [][]byte
+		err  error
+		want CloseReason
+	}{
+		{"EOF", nil, io.EOF, CloseReason{CloseKindEOF, "EOF"}},
+		{"websocket关闭", nil, &websocket.CloseError{Code: websocket.CloseNormalClosure},
+			CloseReason{CloseKindEOF, "websocket: close 1000 (normal)"}},
+		{"对端关闭报文", [][]byte{message.EncodeCloseMsg("bye")}, io.EOF, CloseReason{CloseKindPeer, "peer closed: bye"}},
+		{"解析错误", [][]byte{[]byte("{")}, nil, CloseReason{CloseKindDecodeError, "decode json: " + func() string {
+			_, err := message.Decode([]byte("{"))
+			return err.Error()
+		}()}},
+		{"读取超时", nil, timeoutErr{}, CloseReason{CloseKindTimeout, "i/o timeout"}},
+		{"心跳超时", nil, fmt.Errorf("%w: %s", rawConn.ErrHeartbeatTimeout, timeoutErr{}),
+			CloseReason{CloseKindHeartbeatTimeout, "heartbeat timeout: i/o timeout"}},
+		{"其他错误", nil, errors.New("reset"), CloseReason{CloseKindUnknown, "reset"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got CloseReason
+			mockedConn := new(mockConn)
+			conn := newConn(NewEmptyModel(), mockedConn, WithCloseReasonFunc(func(r CloseReason) {
+				got = r
+			}))
+			_, closed := conn.CloseReason()
+			assert.False(t, closed, "连接关闭前没有关闭原因")
+
+			for _, msg := range test.msgs {
+				mockedConn.On("ReadMsg").Return(msg, nil).Once()
+			}
+			if test.err != nil {
+				mockedConn.On("ReadMsg").Return([]byte(nil), test.err).Once()
+			}
+			mockedConn.On("Close").Return(nil).Once()
+			NewEmptyModel().dealConn(conn)
+			mockedConn.AssertExpectations(t)
+
+			assert.Equal(t, test.want, got)
+			reason, closed := conn.CloseReason()
+			assert.True(t, closed)
+			assert.Equal(t, test.want, reason)
+			assert.Equal(t, fmt.Errorf("connection closed for: %s", test.want.Detail), conn.Err())
+		})
+	}
+
+	// 主动关闭
+	var got CloseReason
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn, WithClosedHandler(CloseReasonFunc(func(r CloseReason) {
+		got = r
+	})))
+	mockedConn.On("Close").Return(nil).Once()
+	require.Nil(t, conn.Close())
+	assert.Equal(t, CloseReason{CloseKindActive, "active close"}, got)
+	assert.True(t, got.Expected())
+	assert.Equal(t, "active close: active close", got.String())
+
+	// 字符串关闭回调仍然收到原来的关闭原因
+	var reason string
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(r string) {
+		reason = r
+	}))
+	mockedConn.On("ReadMsg").Return([]byte("{"), nil).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	reason2, _ := conn.CloseReason()
+	assert.Equal(t, CloseKindDecodeError, reason2.Kind)
+	assert.Equal(t, reason2.Detail, reason)
+	assert.False(t, reason2.Expected())
+
+	// CloseReasonFunc 作为 ClosedHandler 使用
+	CloseReasonFunc(func(r CloseReason) {
+		got = r
+	}).OnClosed("EOF")
+	assert.Equal(t, CloseReason{CloseKindUnknown, "EOF"}, got)
+}
+
+// TestModel_ClearState 测试清除可选状态
+func TestModel_ClearState(t *testing.T) {
+	server, err := LoadFromBuff([]byte(`{"name": "A/car", "description": "车辆", "state": [
+		{"name": "speed", "description": "速度", "type": "float", "range": {"max": 100}, "optional": true},
+		{"name": "gear", "description": "档位", "type": "uint", "range": {"max": 5}}
+	], "event": [], "method": []}`), nil, WithSequence())
+	require.Nil(t, err)
+
+	assert.Equal(t, errors.New(`state "gear" is NOT optional`), server.ClearState("gear"))
+	assert.Equal(t, errors.New(`NO state "none"`), server.ClearState("none"))
+
+	// 推送清除状态报文
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	conn.pubStates["A/car/speed"] = struct{}{}
+	server.allConn[conn] = struct{}{}
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateSeqMsg("A/car/speed", 1, 10))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.EncodeClearStateMsg("A/car/speed", 2)).Return(nil).Once()
+	require.Nil(t, server.PushState("speed", 10, true))
+	require.Nil(t, server.ClearState("speed"))
+	mockedConn.AssertExpectations(t)
+
+	data, seen := server.LastState("A/car/speed")
+	assert.True(t, seen, "推送过的状态")
+	assert.Nil(t, data, "最近一次推送的是清除状态报文")
+
+	// 接收清除状态报文
+	type state struct {
+		name string
+		data []byte
+	}
+	var states []state
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithStateFunc(func(modelName string, stateName string, data []byte) {
+		states = append(states, state{stateName, data})
+	}))
+	statesCh := conn.StatesChannel(10)
+	mockedConn.On("ReadMsg").Return(message.Must(message.EncodeStateMsg("A/car/speed", 10)), nil).Once()
+	mockedConn.On("ReadMsg").Return(message.EncodeClearStateMsg("A/car/speed", 0), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"state","payload":{"name":"A/car/speed"}}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	mockedConn.AssertExpectations(t)
+
+	assert.Equal(t, []state{{"speed", []byte("10")}, {"speed", nil}}, states, "缺少状态数据的报文被忽略")
+	var got []StateMessage
+	for msg := range statesCh {
+		got = append(got, msg)
+	}
+	assert.Equal(t, []StateMessage{
+		{ModelName: "A/car", StateName: "speed", Data: []byte("10")},
+		{ModelName: "A/car", StateName: "speed", Cleared: true},
+	}, got)
+}
+
+// TestModel_AddSubModel 测试在一个物模型上挂载子物模型
+func TestModel_AddSubModel(t *testing.T) {
+	server, err := LoadFromBuff([]byte(`{"name": "A/car", "description": "车辆", "state": [
+		{"name": "speed", "description": "速度", "type": "float", "range": {"max": 100}}
+	], "event": [], "method": [
+		{"name": "stop", "description": "停车", "args": [], "response": []}
+	]}`), nil, WithSequence(), WithCallReqFunc(func(name string, args message.RawArgs) message.Resp {
+		return message.Resp{"host": name}
+	}))
+	require.Nil(t, err)
+
+	subJSON := `{"name": "A/car/tpqs", "description": "调平起竖", "state": [
+		{"name": "gear", "description": "档位", "type": "uint", "range": {"max": 5}, "access": "rw"}
+	], "event": [
+		{"name": "done", "description": "完成", "args": []}
+	], "method": [
+		{"name": "lift", "description": "起竖", "args": [
+			{"name": "angle", "description": "角度", "type": "uint", "range": {"max": 90}}
+		], "response": [
+			{"name": "ok", "description": "是否成功", "type": "bool"}
+		]}
+	]}`
+	var setStates []string
+	sub, err := LoadFromBuff([]byte(subJSON), nil, WithCallReqFunc(func(name string, args message.RawArgs) message.Resp {
+		return message.Resp{"ok": name == "lift"}
+	}), WithStateSetFunc(func(name string, data []byte) error {
+		setStates = append(setStates, name+"="+string(data))
+		return nil
+	}))
+	require.Nil(t, err)
+
+	// 挂载失败
+	other, err := LoadFromBuff([]byte(`{"name": "B/car/tpqs", "description": "调平起竖", "state": [], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+	assert.Equal(t, errors.New("sub model is nil"), server.AddSubModel(nil))
+	assert.Equal(t, errors.New(`sub model "B/car/tpqs" is NOT under "A/car"`), server.AddSubModel(other))
+	assert.Equal(t, errors.New(`sub model "A/car" is NOT under "A/car"`), server.AddSubModel(server))
+
+	require.Nil(t, server.AddSubModel(sub))
+	assert.Equal(t, errors.New(`sub model "A/car/tpqs" already added`), server.AddSubModel(sub))
+	nested, err := LoadFromBuff([]byte(`{"name": "A/car/tpqs/leg", "description": "支腿", "state": [], "event": [], "method": []}`), nil)
+	require.Nil(t, err)
+	assert.Equal(t, errors.New(`"A/car/tpqs" is a sub model`), sub.AddSubModel(nested))
+
+	// 查询到合并后的元信息
+	merged := server.servedMeta()
+	assert.Equal(t, []string{"A/car/speed", "A/car/tpqs/gear"}, merged.AllStates())
+	assert.Equal(t, []string{"A/car/tpqs/done"}, merged.AllEvents())
+	assert.Equal(t, []string{"A/car/stop", "A/car/tpqs/lift"}, merged.AllMethods())
+	assert.Equal(t, "A/car/speed", server.Meta().AllStates()[0], "Meta 返回物模型自身的元信息")
+	assert.Len(t, server.Meta().AllStates(), 1)
+
+	// 按模型名校验状态
+	assert.Nil(t, server.VerifyRawStateFor("A/car", "speed", []byte("10.5")))
+	assert.Nil(t, server.VerifyRawStateFor("A/car/tpqs", "gear", []byte("3")))
+	assert.NotNil(t, server.VerifyRawStateFor("A/car/tpqs", "gear", []byte("6")), "超出范围")
+	assert.NotNil(t, server.VerifyRawStateFor("A/car", "gear", []byte("3")), "宿主物模型没有该状态")
+	assert.Equal(t, errors.New(`NO model "A/car/leg"`), server.VerifyRawStateFor("A/car/leg", "gear", []byte("3")))
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRawMsg("meta-info", merged.ToJSON()))).Return(nil).Once()
+	conn.onQueryMeta(nil)
+	mockedConn.AssertExpectations(t)
+
+	// 调用请求和状态设置请求按模型名路由
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("1", "", message.Resp{"host": "stop"}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("2", "", message.Resp{"ok": true}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("3", `arg "angle": missing`, message.Resp{}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("4", `modelName "A/car/leg": unmatched`, message.Resp{}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("5", "", message.Resp{}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeRespMsg("6", `NO state "speed"`, message.Resp{}))).Return(nil).Once()
+	conn.dealCallReq(message.CallPayload{Name: "A/car/stop", UUID: "1", Args: message.RawArgs{}})
+	conn.dealCallReq(message.CallPayload{Name: "A/car/tpqs/lift", UUID: "2", Args: message.RawArgs{"angle": []byte("10")}})
+	conn.dealCallReq(message.CallPayload{Name: "A/car/tpqs/lift", UUID: "3", Args: message.RawArgs{}})
+	conn.dealCallReq(message.CallPayload{Name: "A/car/leg/lift", UUID: "4", Args: message.RawArgs{}})
+	conn.dealSetStateReq(message.SetStatePayload{Name: "A/car/tpqs/gear", UUID: "5", Data: []byte("2")})
+	conn.dealSetStateReq(message.SetStatePayload{Name: "A/car/tpqs/speed", UUID: "6", Data: []byte("2")})
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []string{"gear=2"}, setStates)
+
+	// 子物模型通过宿主物模型的连接推送, 共用推送序号
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn)
+	conn.pubStates["A/car/tpqs/gear"] = struct{}{}
+	conn.pubEvents["A/car/tpqs/done"] = struct{}{}
+	server.addConn(conn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeStateSeqMsg("A/car/tpqs/gear", 1, uint(3)))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeEventSeqMsg("A/car/tpqs/done", 3, message.Args{}))).Return(nil).Once()
+	require.Nil(t, sub.PushState("gear", uint(3), true))
+	require.Nil(t, server.PushState("speed", 10, true), "未订阅的状态不推送")
+	require.Nil(t, sub.PushEvent("done", message.Args{}, true))
+	mockedConn.AssertExpectations(t)
+
+	for _, m := range []*Model{server, sub} {
+		data, seen := m.LastState("A/car/tpqs/gear")
+		assert.True(t, seen)
+		assert.Equal(t, []byte("3"), data)
+	}
+}
+
+// TestConnection_Context 测试连接附加数据
+func TestConnection_Context(t *testing.T) {
+	type userKey struct{}
+
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+
+	_, ok := conn.Context(userKey{})
+	assert.False(t, ok, "没有附加数据")
+
+	conn.SetContext(userKey{}, "alice")
+	conn.SetContext("role", "admin")
+	conn.SetContext(userKey{}, "bob")
+	user, ok := conn.Context(userKey{})
+	assert.True(t, ok)
+	assert.Equal(t, "bob", user, "覆盖原来的数据")
+	role, ok := conn.Context("role")
+	assert.True(t, ok)
+	assert.Equal(t, "admin", role)
+
+	// 并发读写
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn.SetContext(i, i)
+			_, _ = conn.Context(i)
+		}(i)
+	}
+	wg.Wait()
+
+	// 连接关闭后清空
+	mockedConn.On("Close").Return(nil)
+	require.Nil(t, conn.Close())
+	_, ok = conn.Context(userKey{})
+	assert.False(t, ok, "连接关闭后清空")
+	conn.SetContext(userKey{}, "alice")
+	_, ok = conn.Context(userKey{})
+	assert.False(t, ok, "连接关闭后设置无效")
+}
+
+// TestConnection_ReusedUUID 测试uuid生成器生成重复uuid的情况
+func TestConnection_ReusedUUID(t *testing.T) {
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+	conn.uidCreator = func() string {
+		return "123"
+	}
+
+	callMsg := message.Must(message.EncodeCallMsg("A/car/QS", "123", message.Args{}))
+	mockedConn.On("WriteMsg", callMsg).Return(nil).Twice()
+
+	// 同时发起两次调用, 只有一次能成功
+	var wg sync.WaitGroup
+	waiters := make([]*RespWaiter, 2)
+	errs := make([]error, 2)
+	for i := range waiters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			waiters[i], errs[i] = conn.Invoke("A/car/QS", nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var waiter *RespWaiter
+	var failed []error
+	for i := range waiters {
+		if errs[i] == nil {
+			waiter = waiters[i]
+		} else {
+			assert.Nil(t, waiters[i], "调用失败时等待器为nil")
+			failed = append(failed, errs[i])
+		}
+	}
+	require.NotNil(t, waiter, "先发起的调用成功")
+	assert.Equal(t, []error{errors.New(`uuid "123" reused`)}, failed, "uuid重复的调用失败")
+
+	// 先前的等待器不受影响
+	conn.onResp([]byte(`{"uuid":"123","error":"","response":{"res":true}}`))
+	resp, err := waiter.WaitFor(time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, message.RawResp{"res": []byte(`true`)}, resp, "先前的等待器收到响应")
+
+	// 收到响应后uuid可以再次使用
+	_, err = conn.Invoke("A/car/QS", nil)
+	assert.Nil(t, err, "收到响应后uuid可以再次使用")
+
+	mockedConn.AssertExpectations(t)
+}
+
+// TestConnection_PendingCalls 测试获取未收到响应的请求以及关闭时丢弃的请求数量
+func TestConnection_PendingCalls(t *testing.T) {
+	var dropped uint64
+	mockedConn := new(mockConn)
+	var conn *Connection
+	conn = newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(reason string) {
+		dropped = conn.DroppedCalls()
+	}))
+	uid := 0
+	conn.uidCreator = func() string {
+		uid++
+		return strconv.Itoa(uid)
+	}
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil)
+	mockedConn.On("Close").Return(nil)
+
+	assert.Empty(t, conn.PendingCalls(), "没有请求")
+
+	waiters := make([]*RespWaiter, 0, 3)
+	for i := 0; i < 3; i++ {
+		waiter, err := conn.Invoke("A/car/QS", nil)
+		require.Nil(t, err)
+		waiters = append(waiters, waiter)
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, conn.PendingCalls())
+
+	// 与收到响应并发调用
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn.onResp([]byte(`{"uuid":"2","error":"","response":{}}`))
+	}()
+	go func() {
+		defer wg.Done()
+		_ = conn.PendingCalls()
+	}()
+	wg.Wait()
+	assert.Equal(t, []string{"1", "3"}, conn.PendingCalls(), "收到响应的请求被移除")
+	assert.Equal(t, uint64(0), conn.DroppedCalls(), "连接未关闭")
+
+	require.Nil(t, conn.Close())
+	assert.Equal(t, uint64(2), dropped, "关闭回调中可以获取丢弃的请求数量")
+	assert.Equal(t, uint64(2), conn.DroppedCalls())
+	assert.Empty(t, conn.PendingCalls(), "连接关闭后没有未收到响应的请求")
+	_, err := waiters[0].WaitFor(time.Second)
+	assert.NotNil(t, err, "丢弃的请求收到关闭错误")
+}
+
+// TestConnection_PendingCalls_Timeout 测试等待超时的请求从未收到响应的请求中移除
+func TestConnection_PendingCalls_Timeout(t *testing.T) {
+	var dropped uint64
+	mockedConn := new(mockConn)
+	var conn *Connection
+	conn = newConn(NewEmptyModel(), mockedConn, WithClosedFunc(func(reason string) {
+		dropped = conn.DroppedCalls()
+	}))
+	uid := 0
+	conn.uidCreator = func() string {
+		uid++
+		return strconv.Itoa(uid)
+	}
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil)
+	mockedConn.On("Close").Return(nil)
+
+	// CallFor 超时
+	_, err := conn.CallFor("A/car/QS", nil, 10*time.Millisecond)
+	assert.Equal(t, errors.New("timeout"), err)
+	assert.Empty(t, conn.PendingCalls(), "CallFor 超时后移除")
+
+	// InvokeFor 超时
+	done := make(chan error, 1)
+	require.Nil(t, conn.InvokeFor("A/car/QS", nil, func(resp message.RawResp, err error) {
+		done <- err
+	}, 10*time.Millisecond))
+	assert.Equal(t, []string{"2"}, conn.PendingCalls(), "等待中")
+	assert.Equal(t, errors.New("timeout"), <-done)
+	assert.Empty(t, conn.PendingCalls(), "InvokeFor 超时后移除")
+
+	// 没有回调时同样在超时后移除
+	require.Nil(t, conn.InvokeFor("A/car/QS", nil, nil, 10*time.Millisecond))
+	assert.Eventually(t, func() bool {
+		return len(conn.PendingCalls()) == 0
+	}, time.Second, 5*time.Millisecond, "InvokeFor 没有回调时超时后移除")
+
+	// 超时后到达的响应被忽略
+	conn.onResp([]byte(`{"uuid":"1","error":"","response":{}}`))
+
+	// WaitForAll 部分超时
+	w4, err := conn.Invoke("A/car/QS", nil)
+	require.Nil(t, err)
+	w5, err := conn.Invoke("A/car/QS", nil)
+	require.Nil(t, err)
+	conn.onResp([]byte(`{"uuid":"4","error":"","response":{}}`))
+	_, errs := WaitForAll([]*RespWaiter{w4, w5}, 10*time.Millisecond)
+	assert.Equal(t, []error{nil, errors.New("timeout")}, errs)
+	assert.Empty(t, conn.PendingCalls(), "WaitForAll 超时后移除")
+	reused, err := conn.addRespWaiter("5")
+	assert.Nil(t, err, "超时的UUID可以重新使用")
+	conn.removeRespWaiter(reused.uuid)
+
+	// 未超时的请求不受影响
+	waiter, err := conn.Invoke("A/car/QS", nil)
+	require.Nil(t, err)
+	require.Nil(t, conn.Close())
+	assert.Equal(t, uint64(1), dropped, "只统计连接关闭时仍在等待的请求")
+	_, err = waiter.Wait()
+	assert.NotNil(t, err)
+}
+
+// TestModel_SubscriptionHandler 测试订阅变化回调
+func TestModel_SubscriptionHandler(t *testing.T) {
+	type change struct {
+		kind  SubKind  // 订阅类别
+		items []string // 完整订阅列表
+	}
+
+	var conn *Connection
+	var got []change
+	server := New(meta.NewEmptyMeta(), WithSubscriptionFunc(func(c *Connection, kind SubKind, items []string) {
+		assert.Equal(t, conn, c, "回调的连接")
+		got = append(got, change{kind, items})
+	}))
+
+	mockedConn := new(mockConn)
+	conn = newConn(server, mockedConn)
+	msgs := []string{
+		`{"type":"set-subscribe-state","payload":["A/b","A/a"]}`,
+		`{"type":"add-subscribe-state","payload":["A/c"]}`,
+		`{"type":"remove-subscribe-state","payload":["A/a"]}`,
+		`{"type":"clear-subscribe-state","payload":null}`,
+		`{"type":"set-subscribe-event","payload":["A/e"]}`,
+		`{"type":"add-subscribe-event","payload":["A/f"]}`,
+		`{"type":"remove-subscribe-event","payload":["A/f"]}`,
+	}
+	for _, msg := range msgs {
+		mockedConn.On("ReadMsg").Return([]byte(msg), nil).Once()
+	}
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+
+	server.dealConn(conn)
+
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []change{
+		{SubKindState, []string{"A/a", "A/b"}},
+		{SubKindState, []string{"A/a", "A/b", "A/c"}},
+		{SubKindState, []string{"A/b", "A/c"}},
+		{SubKindState, []string{}},
+		{SubKindEvent, []string{"A/e"}},
+		{SubKindEvent, []string{"A/e", "A/f"}},
+		{SubKindEvent, []string{"A/e"}},
+		{SubKindEvent, []string{}},
+	}, got, "订阅变化后回调完整订阅列表, 连接关闭时只通知仍有订阅的类别")
+	assert.Equal(t, "state", SubKindState.String())
+	assert.Equal(t, "event", SubKindEvent.String())
+}
+
+// TestWithEventHistory 测试事件历史缓存
+func TestWithEventHistory(t *testing.T) {
+	server, err := LoadFromBuff([]byte(`{"name": "A/car", "description": "车辆", "state": [], "event": [
+		{"name": "alarm", "description": "告警", "args": [
+			{"name": "code", "description": "告警码", "type": "int", "range": {"max": 100}}
+		]},
+		{"name": "done", "description": "完成", "args": []}
+	], "method": []}`), nil, WithSequence(), WithEventHistory("alarm", 2), WithEventHistory("done", 0))
+	require.Nil(t, err)
+
+	assert.Nil(t, server.EventHistory("alarm"), "从未推送过")
+	for i := 1; i <= 3; i++ {
+		require.Nil(t, server.PushEvent("alarm", message.Args{"code": i}, true))
+	}
+	require.Nil(t, server.PushEvent("done", message.Args{}, true))
+
+	assert.Equal(t, []message.RawArgs{
+		{"code": []byte("2")},
+		{"code": []byte("3")},
+	}, server.EventHistory("alarm"), "只缓存最近的2个事件")
+	assert.Nil(t, server.EventHistory("done"), "没有配置缓存")
+
+	// 订阅时推送新订阅事件的历史事件, 已经订阅的事件不再推送
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	sub, err := json.Marshal(message.SubPayload{Items: []string{"A/car/done", "A/car/alarm"}, Snapshot: true})
+	require.Nil(t, err)
+	for _, onSub := range []func([]byte){conn.onSetSubEvent, conn.onAddSubEvent} {
+		conn.pubEvents = map[string]struct{}{}
+		mockedConn.On("WriteMsg", message.Must(message.EncodeEventSeqMsg("A/car/alarm", 2, message.Args{"code": 2}))).Return(nil).Once()
+		mockedConn.On("WriteMsg", message.Must(message.EncodeEventSeqMsg("A/car/alarm", 3, message.Args{"code": 3}))).Return(nil).Once()
+		onSub(sub)
+		mockedConn.AssertExpectations(t)
+		assert.Equal(t, []string{"A/car/alarm", "A/car/done"}, conn.GetSubEvents())
+
+		onSub(sub)
+		mockedConn.AssertExpectations(t)
+	}
+
+	// 请求推送历史事件的订阅报文
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn)
+	mockedConn.On("WriteMsg", message.Must(message.EncodeSubEventPayloadMsg(message.SetSub, message.SubPayload{
+		Items:    []string{"A/car/alarm"},
+		Snapshot: true,
+	}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeSubEventPayloadMsg(message.AddSub, message.SubPayload{
+		Items:    []string{"A/car/alarm"},
+		Snapshot: true,
+	}))).Return(nil).Once()
+	require.Nil(t, conn.SubEventWithHistory([]string{"A/car/alarm"}))
+	require.Nil(t, conn.AddSubEventWithHistory([]string{"A/car/alarm"}))
+	mockedConn.AssertExpectations(t)
+
+	// 与推送并发获取历史事件
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_ = server.PushEvent("alarm", message.Args{"code": i}, true)
+		}(i)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, server.EventHistory("alarm"), 2)
+		}()
+	}
+	wg.Wait()
+}
+
+// TestConnection_PushEventTo 测试向单个连接推送事件
+func TestConnection_PushEventTo(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	other := new(mockConn)
+	server.addConn(conn)
+	server.addConn(newConn(server, other))
+	defer server.removeConn(conn)
+
+	// 未订阅事件也能收到
+	mockedConn.On("WriteMsg", message.Must(message.EncodeEventMsg("A/car/#1/tpqs/qsMotorOverCur", message.Args{}))).
+		Return(nil).Once()
+	require.Nil(t, conn.PushEventTo("qsMotorOverCur", message.Args{}, true))
+	mockedConn.AssertExpectations(t)
+	other.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	// 校验事件参数
+	assert.Equal(t, errors.New(`NO event "unknown"`), conn.PushEventTo("unknown", message.Args{}, true))
+	assert.Equal(t, errors.New(`arg "motors": missing`), conn.PushEventTo("qsAction", message.Args{}, true))
+
+	mockedConn.On("WriteMsg", message.Must(message.EncodeEventMsg("A/car/#1/tpqs/unknown", message.Args{}))).
+		Return(errors.New("closed")).Once()
+	assert.Equal(t, errors.New("closed"), conn.PushEventTo("unknown", message.Args{}, false), "不校验, 发送失败")
+	mockedConn.AssertExpectations(t)
+}
+
+// TestWithTap 测试抓包回调
+func TestWithTap(t *testing.T) {
+	type frame struct {
+		dir  Direction
+		data string
+	}
+	var frames []frame
+	tap := WithTap(func(dir Direction, data []byte) {
+		frames = append(frames, frame{dir, string(data)})
+		if dir == Outbound {
+			panic("tap panic")
+		}
+	})
+
+	ping := message.EncodePingMsg("1")
+	pong := message.EncodePongMsg("1")
+
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn, tap, WithTap(nil))
+	mockedConn.On("ReadMsg").Return(ping, nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("WriteMsg", pong).Return(nil).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	mockedConn.AssertExpectations(t)
+
+	assert.Equal(t, []frame{
+		{Inbound, string(ping)},
+		{Outbound, string(pong)},
+	}, frames, "回调发生panic不影响报文发送")
+
+	assert.Equal(t, "inbound", Inbound.String())
+	assert.Equal(t, "outbound", Outbound.String())
+	assert.Equal(t, "Direction(2)", Direction(2).String())
+}
+
+// TestWithWriteCoalesce 测试合并发送状态和事件报文
+func TestWithWriteCoalesce(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	gear0 := message.Must(message.EncodeStateMsg("A/car/#1/tpqs/gear", uint(0)))
+	gear1 := message.Must(message.EncodeStateMsg("A/car/#1/tpqs/gear", uint(1)))
+	ping := message.EncodePingMsg("1")
+
+	// 发送其他报文前先发送缓存的报文
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn, WithWriteCoalesce(time.Hour, 0))
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	require.Nil(t, server.PushState("gear", uint(1), true))
+	mockedConn.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	first := mockedConn.On("WriteMsg", message.EncodeBatchMsg([][]byte{gear0, gear1})).Return(nil).Once()
+	mockedConn.On("WriteMsg", ping).Return(nil).Once().NotBefore(first)
+	require.Nil(t, conn.sendMsg(ping))
+	mockedConn.AssertExpectations(t)
+	server.removeConn(conn)
+
+	// 主动发送缓存的报文, 返回写入错误
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn, WithWriteCoalesce(time.Hour, 0))
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	require.Nil(t, conn.Flush(), "没有缓存的报文")
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	require.Nil(t, server.PushState("gear", uint(1), true))
+	mockedConn.On("WriteMsg", message.EncodeBatchMsg([][]byte{gear0, gear1})).Return(io.ErrClosedPipe).Once()
+	assert.Equal(t, io.ErrClosedPipe, conn.Flush(), "返回写入错误")
+	assert.Nil(t, conn.Flush(), "缓存的报文已发送")
+	mockedConn.AssertExpectations(t)
+	server.removeConn(conn)
+
+	// 达到字节数阈值时立即发送
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn, WithWriteCoalesce(time.Hour, len(gear0)+1))
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	mockedConn.On("WriteMsg", message.EncodeBatchMsg([][]byte{gear0, gear1})).Return(nil).Once()
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	require.Nil(t, server.PushState("gear", uint(1), true))
+	mockedConn.AssertExpectations(t)
+	server.removeConn(conn)
+
+	// 达到延时后发送, 只有一个报文时不合并
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn, WithWriteCoalesce(10*time.Millisecond, 0))
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	written := make(chan struct{})
+	mockedConn.On("WriteMsg", gear0).Return(nil).Once().Run(func(mock.Arguments) {
+		close(written)
+	})
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("超时未发送")
+	}
+	mockedConn.AssertExpectations(t)
+	server.removeConn(conn)
+
+	// 定时发送失败时关闭连接
+	reasons := make(chan CloseReason, 1)
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn, WithWriteCoalesce(10*time.Millisecond, 0),
+		WithCloseReasonFunc(func(r CloseReason) {
+			reasons <- r
+		}))
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	mockedConn.On("WriteMsg", gear0).Return(io.ErrClosedPipe).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	select {
+	case r := <-reasons:
+		assert.Equal(t, CloseReason{CloseKindWriteError, "write: " + io.ErrClosedPipe.Error()}, r)
+	case <-time.After(time.Second):
+		t.Fatal("写入失败后未关闭连接")
+	}
+	<-conn.Done()
+	mockedConn.AssertExpectations(t)
+	server.removeConn(conn)
+
+	// 接收批量报文
+	var got []string
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn,
+		WithStateFunc(func(modelName string, stateName string, data []byte) {
+			got = append(got, stateName+"="+string(data))
+		}))
+	mockedConn.On("ReadMsg").Return(message.EncodeBatchMsg([][]byte{
+		gear0,
+		[]byte(`123`),
+		message.EncodeBatchMsg([][]byte{gear0}),
+		gear1,
+	}), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	NewEmptyModel().dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []string{"gear=0", "gear=1"}, got, "忽略无效报文和嵌套的批量报文")
+}
+
+// BenchmarkWriteCoalesce 测试合并发送对推送事件吞吐量的影响
+func BenchmarkWriteCoalesce(b *testing.B) {
+	benchmarks := []struct {
+		name string       // 测试名称
+		opts []ConnOption // 连接选项
+	}{
+		{"NoCoalesce", nil},
+		{"Coalesce", []ConnOption{WithWriteCoalesce(time.Millisecond, 64*1024)}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			require.Nil(b, err)
+			defer l.Close()
+
+			// 接收端持续读取报文
+			go func() {
+				raw, err := l.AcceptTCP()
+				if err != nil {
+					return
+				}
+				reader := rawConn.NewTcpConn(raw, false)
+				defer reader.Close()
+				for {
+					if _, err := reader.ReadMsg(); err != nil {
+						return
+					}
+				}
+			}()
+
+			raw, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+			require.Nil(b, err)
+			server := NewEmptyModel()
+			conn := newConn(server, rawConn.NewTcpConn(raw, false), bm.opts...)
+			conn.onSetSubEvent([]byte(`["model/tick"]`))
+			defer conn.Close()
+
+			args := message.Args{"seq": 1}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				conn.sendEvent("model/tick", 0, args)
+			}
+			_ = conn.flush()
+		})
+	}
+}
+
+// TestWithPrioritySend 测试优先级发送, 积压大量状态报文时调用请求等控制报文仍然及时发送
+func TestWithPrioritySend(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	ping := message.EncodePingMsg("1")
+	var lock sync.Mutex
+	var written [][]byte
+	// 第一次写入阻塞直到gate关闭, 模拟积压报文时缓慢的底层连接
+	gate := make(chan struct{})
+	var gateOnce sync.Once
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		lock.Lock()
+		written = append(written, args.Get(0).([]byte))
+		first := len(written) == 1
+		lock.Unlock()
+		if first {
+			<-gate
 		}
+	})
+	conn := newConn(server, mockedConn, WithPrioritySend())
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	server.addConn(conn)
+	defer server.removeConn(conn)
+
+	const n = 300
+	var want [][]byte
+	for i := 0; i < n; i++ {
+		gear := uint(i % 2)
+		require.Nil(t, server.PushState("gear", gear, true))
+		want = append(want, message.Must(message.EncodeStateMsg("A/car/#1/tpqs/gear", gear)))
+	}
 
-		assert.Len(t, conn.respWaiters, 0, "响应等待器已清空")
+	// 发送协程阻塞在第一个状态报文时发送调用请求
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(written) == 1
+	}, time.Second, time.Millisecond)
+	pingDone := make(chan error, 1)
+	go func() {
+		pingDone <- conn.sendMsg(ping)
+	}()
+	require.Eventually(t, func() bool {
+		conn.sendQueue.lock.Lock()
+		defer conn.sendQueue.lock.Unlock()
+		return len(conn.sendQueue.high) == 1
+	}, time.Second, time.Millisecond)
+	gateOnce.Do(func() { close(gate) })
+	require.Nil(t, <-pingDone)
+
+	require.Nil(t, conn.Flush())
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, written, n+1)
+	assert.Equal(t, ping, written[1], "调用请求不排在积压的状态报文之后")
+	states := append([][]byte{written[0]}, written[2:]...)
+	assert.Equal(t, want, states, "同一优先级的报文顺序不变")
+
+	// 关闭连接后不再写入
+	mockedConn.On("Close").Return(nil).Once()
+	require.Nil(t, conn.Close())
+	assert.EqualError(t, conn.sendMsg(ping), "connection closed for: active close")
+	assert.Len(t, written, n+1)
+}
 
-		mockedConn.AssertExpectations(t)
-		mockOnClose.AssertExpectations(t)
-	}
+// TestPushState_Decimal 测试推送要求带小数点的浮点数状态时, 对端以原始数据校验能够通过
+func TestPushState_Decimal(t *testing.T) {
+	metaData := []byte(`{"name": "car", "description": "车辆", "event": [], "method": [], "state": [
+		{"name": "angle", "description": "角度", "type": "float", "decimal": true}
+	]}`)
+	server, err := LoadFromBuff(metaData, nil)
+	require.Nil(t, err)
+	peer, err := meta.Parse(metaData, nil)
+	require.Nil(t, err)
 
-	assert.Len(t, server.allConn, 0, "管理的连接必须为空")
+	written := make(chan []byte, 4)
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		written <- args.Get(0).([]byte)
+	})
+	conn := newConn(server, mockedConn)
+	conn.onSetSubState([]byte(`["car/angle"]`))
+	server.addConn(conn)
+	defer server.removeConn(conn)
+
+	require.Nil(t, server.PushState("angle", 90.0, true))
+	msg, err := message.Decode(<-written)
+	require.Nil(t, err)
+	state, err := message.ParseStatePayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, "90.0", string(state.Data))
+	assert.Nil(t, peer.VerifyRawState("angle", state.Data), "对端校验通过")
 }
 
-// TestDealMetaInfoMsg 测试元信息报文处理逻辑
-func TestDealMetaInfoMsg(t *testing.T) {
+// TestWithPrioritySend_CloseStuck 测试发送协程阻塞在写入时关闭连接不会永久阻塞
+func TestWithPrioritySend_CloseStuck(t *testing.T) {
+	// 对端不再读取, 写入阻塞直到关闭底层连接
+	stuck := make(chan struct{})
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(io.ErrClosedPipe).Run(func(mock.Arguments) {
+		<-stuck
+	})
+	var stuckOnce sync.Once
+	mockedConn.On("Close").Return(nil).Run(func(mock.Arguments) {
+		stuckOnce.Do(func() { close(stuck) })
+	})
+	conn := newConn(NewEmptyModel(), mockedConn, WithPrioritySend())
+	conn.flushTimeout = 20 * time.Millisecond
+	require.Nil(t, conn.pushLow([]byte("1"), ""))
+	require.Nil(t, conn.pushLow([]byte("2"), ""))
 
-	type TestCase struct {
-		msg     []byte // 测试报文数据
-		wantErr error  // 期望的元信息错误
-		desc    string // 用例描述
+	closed := make(chan error, 1)
+	go func() {
+		closed <- conn.Close()
+	}()
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "关闭连接阻塞")
 	}
+	<-conn.Done()
+	mockedConn.AssertExpectations(t)
+}
 
-	testCases := []TestCase{
-		{
-			msg:     nil,
-			wantErr: errors.New("connection closed for: EOF"),
-			desc:    "未收到元信息连接就关闭",
-		},
+// TestWithPrioritySend_WriteError 测试优先级发送时写入失败关闭连接
+func TestWithPrioritySend_WriteError(t *testing.T) {
+	reasons := make(chan CloseReason, 1)
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(io.ErrClosedPipe).Once()
+	mockedConn.On("Close").Return(nil)
+	conn := newConn(NewEmptyModel(), mockedConn, WithPrioritySend(), WithCloseReasonFunc(func(r CloseReason) {
+		reasons <- r
+	}))
 
-		{
-			msg:     []byte(`{"type":"meta-info","payload":123}`),
-			wantErr: errors.New("root: NOT an object"),
-			desc:    "元信息不为对象",
-		},
+	ping := message.EncodePingMsg("1")
+	assert.Equal(t, io.ErrClosedPipe, conn.sendMsg(ping), "返回写入错误")
+	select {
+	case r := <-reasons:
+		assert.Equal(t, CloseReason{CloseKindWriteError, "write: " + io.ErrClosedPipe.Error()}, r)
+	case <-time.After(time.Second):
+		t.Fatal("写入失败后未关闭连接")
+	}
+	<-conn.Done()
 
-		{
-			msg:     []byte(`{"type":"meta-info","payload":{}}`),
-			wantErr: errors.New("root: name NOT exist"),
-			desc:    "name字段不存在",
-		},
+	// 连接关闭后不再写入, 返回导致发送队列结束的写入错误
+	assert.Equal(t, io.ErrClosedPipe, conn.sendMsg(ping))
+	assert.Equal(t, io.ErrClosedPipe, conn.Flush())
+	mockedConn.AssertNumberOfCalls(t, "WriteMsg", 1)
+}
 
-		{
-			msg:     []byte(`{"type":"meta-info","payload":{"name":"tpqs"}}`),
-			wantErr: errors.New("root: description NOT exist"),
-			desc:    "description字段不存在",
-		},
+// TestWithSendQueueLimit 测试低优先级发送队列已满时的处理策略
+func TestWithSendQueueLimit(t *testing.T) {
+	// popAll 依次取出队列中的所有报文
+	popAll := func(q *sendQueue) []string {
+		var ans []string
+		for len(q.high) > 0 || len(q.low) > 0 {
+			item, ok := q.pop()
+			require.True(t, ok)
+			ans = append(ans, string(item.msg))
+		}
+		return ans
+	}
+
+	// 合并同名状态, 没有同名状态时丢弃最早的报文
+	q := newSendQueue()
+	q.limit = 2
+	q.pushLow([]byte("s1-a"), "s1")
+	q.pushLow([]byte("s2-a"), "s2")
+	q.pushLow([]byte("s1-b"), "s1")
+	assert.False(t, q.takeDropped("s1"), "同名状态被替换而非丢弃")
+	q.pushLow([]byte("e1"), "")
+	assert.True(t, q.takeDropped("s1"), "最早的状态报文被丢弃")
+	assert.False(t, q.takeDropped("s1"), "记录已清除")
+	assert.Equal(t, uint64(2), q.drops)
+	assert.Equal(t, []string{"s2-a", "e1"}, popAll(q))
+
+	// 丢弃最早的报文, 同步标记不占用容量也不会被丢弃
+	q = newSendQueue()
+	q.limit = 2
+	q.pushLow([]byte("s1-a"), "s1")
+	go func() {
+		_ = q.sync()
+	}()
+	require.Eventually(t, func() bool {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		return len(q.low) == 2
+	}, time.Second, time.Millisecond)
+	q.policy = SendQueueDropOldest
+	q.pushLow([]byte("s2-a"), "s2")
+	q.pushLow([]byte("s1-b"), "s1")
+	assert.Equal(t, []string{"", "s2-a", "s1-b"}, popAll(q))
+	assert.True(t, q.takeDropped("s1"))
+
+	// 阻塞直到队列有空闲位置
+	q = newSendQueue()
+	q.limit = 1
+	q.policy = SendQueueBlock
+	q.pushLow([]byte("s1-a"), "s1")
+	pushed := make(chan struct{})
+	go func() {
+		q.pushLow([]byte("s1-b"), "s1")
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("队列已满时没有阻塞")
+	case <-time.After(20 * time.Millisecond):
 	}
+	item, ok := q.pop()
+	require.True(t, ok)
+	assert.Equal(t, "s1-a", string(item.msg))
+	<-pushed
+	assert.Equal(t, []string{"s1-b"}, popAll(q))
+	assert.Equal(t, uint64(0), q.drops, "阻塞策略不丢弃报文")
+
+	// 队列结束时唤醒阻塞的推送
+	q.pushLow([]byte("s1-c"), "s1")
+	done := make(chan error, 1)
+	go func() {
+		done <- <-q.pushLow([]byte("s1-d"), "s1")
+	}()
+	q.fail(io.EOF)
+	assert.Equal(t, io.EOF, <-done)
+
+	// 连接选项
+	conn := newConn(NewEmptyModel(), new(mockConn), WithSendQueueLimit(8, SendQueueBlock))
+	require.NotNil(t, conn.sendQueue, "同时开启优先级发送")
+	assert.Equal(t, 8, conn.sendQueue.limit)
+	assert.Equal(t, SendQueueBlock, conn.sendQueue.policy)
+	conn = newConn(NewEmptyModel(), new(mockConn), WithPrioritySend(), WithSendQueueLimit(0, SendQueueBlock))
+	assert.Equal(t, DefaultSendQueueLimit, conn.sendQueue.limit, "无效配置")
+	assert.Equal(t, SendQueueCoalesce, conn.sendQueue.policy, "无效配置")
+	assert.Equal(t, uint64(0), conn.DroppedPushes())
+	assert.Equal(t, uint64(0), newConn(NewEmptyModel(), new(mockConn)).DroppedPushes(), "没有开启优先级发送")
+}
 
+// TestWithSendQueueLimit_Dedup 测试状态报文被丢弃后不再去重
+func TestWithSendQueueLimit_Dedup(t *testing.T) {
 	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
 		"group": "A",
 		"id":    "#1",
-	})
+	}, WithStateDedup())
 	require.Nil(t, err)
 
-	for _, test := range testCases {
-		fmt.Println(test.desc)
-
-		mockOnClose := new(mockCloseHandler)
-		mockedConn := new(mockConn)
+	block := make(chan struct{})
+	var lock sync.Mutex
+	var written []string
+	mockedConn := new(mockConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		<-block
+		lock.Lock()
+		defer lock.Unlock()
+		written = append(written, string(args.Get(0).([]byte)))
+	})
+	conn := newConn(server, mockedConn, WithSendQueueLimit(1, SendQueueDropOldest))
+	conn.pubStates["A/car/#1/tpqs/gear"] = struct{}{}
+	conn.pubEvents["A/car/#1/tpqs/qsAction"] = struct{}{}
+	server.addConn(conn)
+	defer server.removeConn(conn)
+
+	gear := func(v uint) string {
+		return string(message.Must(message.EncodeStateMsg("A/car/#1/tpqs/gear", v)))
+	}
 
-		conn := newConn(server, mockedConn, WithClosedHandler(mockOnClose))
+	// 第一个报文被发送协程取出后阻塞在写入, 之后队列中只能容纳一个报文
+	require.Nil(t, server.PushState("gear", uint(0), true))
+	require.Eventually(t, func() bool {
+		conn.sendQueue.lock.Lock()
+		defer conn.sendQueue.lock.Unlock()
+		return len(conn.sendQueue.low) == 0
+	}, time.Second, time.Millisecond)
+	require.Nil(t, server.PushState("gear", uint(1), true))
+	require.Nil(t, server.PushEvent("qsAction", message.Args{"angle": 1}, false))
+	assert.Equal(t, uint64(1), conn.DroppedPushes(), "状态报文被丢弃")
+
+	close(block)
+	require.Nil(t, conn.Flush())
+
+	// 相同的状态再次推送时不去重
+	require.Nil(t, server.PushState("gear", uint(1), true))
+	require.Nil(t, conn.Flush())
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Len(t, written, 3)
+	assert.Equal(t, gear(0), written[0])
+	assert.Contains(t, written[1], "qsAction")
+	assert.Equal(t, gear(1), written[2], "被丢弃的状态重新发送")
+}
 
-		mockOnClose.On("OnClosed", io.EOF.Error()).Once()
-		if test.msg != nil {
-			mockedConn.On("ReadMsg").Return(test.msg, nil).Once()
-		}
-		mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
-		mockedConn.On("Close").Return(errors.New("already closed")).Once()
+// TestConnection_GetSubStates 测试在连接关闭的同时查询订阅列表不会阻塞
+func TestConnection_GetSubStates(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
 
+	mockedConn := new(mockConn)
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"set-subscribe-state","payload":["b","a"]}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(`{"type":"set-subscribe-event","payload":["e"]}`), nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil)
+
+	conn := newConn(server, mockedConn)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = conn.GetSubStates()
+				_ = conn.GetSubEvents()
+			}
+		}()
+	}
+	go func() {
 		server.dealConn(conn)
+		_ = conn.Close()
+		wg.Wait()
+		close(done)
+	}()
 
-		select {
-		case <-conn.metaGotCh:
-		default:
-			assert.Fail(t, "未唤醒元信息等待", test.desc)
-		}
-
-		assert.EqualValues(t, test.wantErr, conn.peerMetaErr, "期望的对端元信息错误")
-
-		mockedConn.AssertExpectations(t)
-		mockOnClose.AssertExpectations(t)
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		require.Fail(t, "查询订阅列表阻塞")
 	}
 
+	assert.Equal(t, []string{"a", "b"}, conn.GetSubStates(), "关闭后查询状态订阅列表")
+	assert.Equal(t, []string{"e"}, conn.GetSubEvents(), "关闭后查询事件订阅列表")
 }
 
 // TestConnection_SubState 测试发送状态订阅报文
@@ -1631,6 +4305,46 @@ func TestConnection_AddSubState(t *testing.T) {
 	}
 }
 
+// TestConnection_UpdateSubState 测试同时新增和取消订阅
+func TestConnection_UpdateSubState(t *testing.T) {
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+	mockedConn.On("WriteMsg", []byte(`{"type":"set-subscribe-state","payload":["A/a"]}`)).Return(nil).Once()
+	mockedConn.On("WriteMsg", []byte(`{"type":"update-subscribe-state","payload":{"items":["A/a"],"remove":["B/b"]}}`)).Return(nil).Once()
+	mockedConn.On("WriteMsg", []byte(`{"type":"update-subscribe-event","payload":{"items":[],"remove":["B/b"]}}`)).Return(io.EOF).Once()
+	assert.Nil(t, conn.ReplaceSubState([]string{"A/a"}))
+	assert.Nil(t, conn.UpdateSubState([]string{"A/a"}, []string{"B/b"}))
+	assert.Equal(t, io.EOF, conn.UpdateSubEvent(nil, []string{"B/b"}))
+	mockedConn.AssertExpectations(t)
+
+	// 对端在一次处理中先取消再新增, 订阅数量达到上限时也可以替换订阅项
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	}, WithMaxSubscriptions(2), WithSubLimitPolicy(SubLimitClose))
+	require.Nil(t, err)
+
+	mockedConn = new(mockConn)
+	conn = newConn(server, mockedConn)
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear","A/car/#1/tpqs/QSCount"]`))
+	conn.onSetSubEvent([]byte(`["A/car/#1/tpqs/qsAction"]`))
+
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("1", []string{"A/car/#1/tpqs/QSCount", "A/car/#1/tpqs/tpqsInfo"})).Return(nil).Once()
+	conn.onUpdateSubState([]byte(`{"items":["A/car/#1/tpqs/tpqsInfo","A/car/#1/tpqs/QSCount"],` +
+		`"remove":["A/car/#1/tpqs/gear","A/car/#1/tpqs/QSCount"],"uuid":"1"}`))
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("2", nil)).Return(nil).Once()
+	conn.onUpdateSubEvent([]byte(`{"items":[],"remove":["A/car/#1/tpqs/qsAction"],"uuid":"2"}`))
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []string{"A/car/#1/tpqs/QSCount", "A/car/#1/tpqs/tpqsInfo"}, conn.GetSubStates())
+	assert.Empty(t, conn.GetSubEvents())
+
+	// 超出上限时不修改订阅并关闭连接
+	mockedConn.On("Close").Return(nil).Once()
+	conn.onUpdateSubState([]byte(`{"items":["A/car/#1/tpqs/gear"]}`))
+	mockedConn.AssertExpectations(t)
+	assert.Equal(t, []string{"A/car/#1/tpqs/QSCount", "A/car/#1/tpqs/tpqsInfo"}, conn.GetSubStates())
+}
+
 // TestConnection_CancelSubState 测试发送取消状态订阅报文
 func TestConnection_CancelSubState(t *testing.T) {
 	type TestCase struct {
@@ -2089,8 +4803,7 @@ type CallSuite struct {
 }
 
 func (c *CallSuite) SetupSuite() {
-	c.tcpAddr = "localhost:65432"
-	c.wsAddr = "localhost:61888"
+	c.wsAddr = freeAddr(c.T())
 
 	c.mockOnCall = new(mockCallReqHandler)
 	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
@@ -2204,9 +4917,13 @@ func (c *CallSuite) SetupSuite() {
 			Times(2)
 	}
 
+	listenAddr, err := server.Listen("localhost:0")
+	require.Nil(c.T(), err)
+	c.tcpAddr = listenAddr.String()
+
 	go func() {
 		fmt.Printf("listen tcp@%s\n", c.tcpAddr)
-		err := server.ListenServeTCP(c.tcpAddr)
+		err := server.Serve()
 		require.NotNil(c.T(), err)
 	}()
 
@@ -2358,8 +5075,7 @@ type CallForSuite struct {
 }
 
 func (c *CallForSuite) SetupSuite() {
-	c.tcpAddr = "localhost:60000"
-	c.wsAddr = "localhost:60001"
+	c.wsAddr = freeAddr(c.T())
 
 	c.mockOnCall = new(mockCallReqHandler)
 	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
@@ -2373,13 +5089,13 @@ func (c *CallForSuite) SetupSuite() {
 	c.calls = []callForSample{
 		{
 			args: message.Args{
-				"angle": 90,
+				"angle": "90",
 			},
 			callIsOn: false,
 			rawResp:  message.RawResp{},
 			waitTime: time.Second,
-			err:      errors.New("arg \"speed\": missing"),
-			desc:     "调用参数不符合元信息---调用参数缺失",
+			err:      errors.New("arg \"angle\": NOT number"),
+			desc:     "调用参数不符合元信息---调用参数类型错误",
 		},
 
 		{
@@ -2505,9 +5221,13 @@ func (c *CallForSuite) SetupSuite() {
 		}
 	}
 
+	listenAddr, err := server.Listen("localhost:0")
+	require.Nil(c.T(), err)
+	c.tcpAddr = listenAddr.String()
+
 	go func() {
 		fmt.Printf("listen tcp@%s\n", c.tcpAddr)
-		err := server.ListenServeTCP(c.tcpAddr)
+		err := server.Serve()
 		require.NotNil(c.T(), err)
 	}()
 
@@ -2615,6 +5335,41 @@ func TestCallFor(t *testing.T) {
 	suite.Run(t, new(CallForSuite))
 }
 
+// TestWithDefaultCallTimeout 测试 Call 的默认等待超时时间
+func TestWithDefaultCallTimeout(t *testing.T) {
+	server := NewEmptyModel()
+	assert.Equal(t, time.Duration(0), newConn(server, new(mockConn)).callWaitTimeout, "默认一直等待")
+
+	server = New(meta.NewEmptyMeta(), WithDefaultCallTimeout(20*time.Millisecond))
+	assert.Equal(t, 20*time.Millisecond, newConn(server, new(mockConn)).callWaitTimeout, "物模型的默认值")
+	assert.Equal(t, 10*time.Millisecond, newConn(server, new(mockConn), WithCallTimeout(10*time.Millisecond)).callWaitTimeout,
+		"连接配置优先")
+	assert.Equal(t, 20*time.Millisecond, newConn(server, new(mockConn), WithCallTimeout(0)).callWaitTimeout, "无效配置")
+
+	// 对端不响应时 Call 超时返回
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil)
+	start := time.Now()
+	resp, err := conn.Call("A/car/QS", message.Args{})
+	assert.Equal(t, message.RawResp{}, resp)
+	assert.Equal(t, errors.New("timeout"), err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(20*time.Millisecond), "按默认超时时间等待")
+	assert.Empty(t, conn.PendingCalls(), "超时后移除")
+
+	// 连接配置的超时时间同样在超时后移除
+	other := newConn(NewEmptyModel(), mockedConn, WithCallTimeout(10*time.Millisecond))
+	_, err = other.Call("A/car/QS", message.Args{})
+	assert.Equal(t, errors.New("timeout"), err)
+	assert.Empty(t, other.PendingCalls(), "超时后移除")
+
+	// 显式调用 CallFor 时使用其超时时间
+	start = time.Now()
+	_, err = conn.CallFor("A/car/QS", message.Args{}, 60*time.Millisecond)
+	assert.Equal(t, errors.New("timeout"), err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(60*time.Millisecond), "CallFor 不受默认值影响")
+}
+
 // 异步+回调调用示例
 type invokeCallbackSample struct {
 	args     message.Args    // 客户端的调用参数
@@ -2639,8 +5394,7 @@ type InvokeCallbackSuite struct {
 }
 
 func (callbackSuite *InvokeCallbackSuite) SetupSuite() {
-	callbackSuite.tcpAddr = "localhost:50000"
-	callbackSuite.wsAddr = "localhost:50001"
+	callbackSuite.wsAddr = freeAddr(callbackSuite.T())
 
 	callbackSuite.mockOnCall = new(mockCallReqHandler)
 	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
@@ -2654,12 +5408,12 @@ func (callbackSuite *InvokeCallbackSuite) SetupSuite() {
 	callbackSuite.calls = []invokeCallbackSample{
 		{
 			args: message.Args{
-				"angle": 90,
+				"angle": "90",
 			},
 			callIsOn: false,
 			rawResp:  message.RawResp{},
-			err:      errors.New("arg \"speed\": missing"),
-			desc:     "调用参数不符合元信息---调用参数缺失",
+			err:      errors.New("arg \"angle\": NOT number"),
+			desc:     "调用参数不符合元信息---调用参数类型错误",
 		},
 
 		{
@@ -2733,9 +5487,13 @@ func (callbackSuite *InvokeCallbackSuite) SetupSuite() {
 		}
 	}
 
+	listenAddr, err := server.Listen("localhost:0")
+	require.Nil(callbackSuite.T(), err)
+	callbackSuite.tcpAddr = listenAddr.String()
+
 	go func() {
 		fmt.Printf("listen tcp@%s\n", callbackSuite.tcpAddr)
-		err := server.ListenServeTCP(callbackSuite.tcpAddr)
+		err := server.Serve()
 		require.NotNil(callbackSuite.T(), err)
 	}()
 
@@ -2867,8 +5625,7 @@ type InvokeForSuite struct {
 }
 
 func (invokeForSuite *InvokeForSuite) SetupSuite() {
-	invokeForSuite.tcpAddr = "localhost:58888"
-	invokeForSuite.wsAddr = "localhost:59999"
+	invokeForSuite.wsAddr = freeAddr(invokeForSuite.T())
 
 	invokeForSuite.mockOnCall = new(mockCallReqHandler)
 	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
@@ -2882,13 +5639,13 @@ func (invokeForSuite *InvokeForSuite) SetupSuite() {
 	invokeForSuite.calls = []invokeForSample{
 		{
 			args: message.Args{
-				"angle": 90,
+				"angle": "90",
 			},
 			callIsOn: false,
 			rawResp:  message.RawResp{},
 			waitTime: time.Second,
-			err:      errors.New("arg \"speed\": missing"),
-			desc:     "调用参数不符合元信息---调用参数缺失",
+			err:      errors.New("arg \"angle\": NOT number"),
+			desc:     "调用参数不符合元信息---调用参数类型错误",
 		},
 
 		{
@@ -3013,9 +5770,13 @@ func (invokeForSuite *InvokeForSuite) SetupSuite() {
 		}
 	}
 
+	listenAddr, err := server.Listen("localhost:0")
+	require.Nil(invokeForSuite.T(), err)
+	invokeForSuite.tcpAddr = listenAddr.String()
+
 	go func() {
 		fmt.Printf("listen tcp@%s\n", invokeForSuite.tcpAddr)
-		err := server.ListenServeTCP(invokeForSuite.tcpAddr)
+		err := server.Serve()
 		require.NotNil(invokeForSuite.T(), err)
 	}()
 
@@ -3139,8 +5900,7 @@ type CallCloseSuite struct {
 }
 
 func (closeSuite *CallCloseSuite) SetupSuite() {
-	closeSuite.tcpAddr = "localhost:51888"
-	closeSuite.wsAddr = "localhost:51999"
+	closeSuite.wsAddr = freeAddr(closeSuite.T())
 	closeSuite.exeTime = time.Millisecond * 200
 	closeSuite.args = message.Args{
 		"angle": 90,
@@ -3174,9 +5934,13 @@ func (closeSuite *CallCloseSuite) SetupSuite() {
 		Return(resp).
 		Times(2)
 
+	listenAddr, err := server.Listen("localhost:0")
+	require.Nil(closeSuite.T(), err)
+	closeSuite.tcpAddr = listenAddr.String()
+
 	go func() {
 		fmt.Printf("listen tcp@%s\n", closeSuite.tcpAddr)
-		err := server.ListenServeTCP(closeSuite.tcpAddr)
+		err := server.Serve()
 		require.NotNil(closeSuite.T(), err)
 	}()
 
@@ -3270,6 +6034,341 @@ func TestCallClose(t *testing.T) {
 	suite.Run(t, new(CallCloseSuite))
 }
 
+// TestWithValidatedSubscriptions 测试根据对端元信息校验订阅列表
+func TestWithValidatedSubscriptions(t *testing.T) {
+	peer, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	// 获取对端元信息失败
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn, WithValidatedSubscriptions())
+	mockedConn.On("WriteMsg", message.EncodeQueryMetaMsg()).Return(io.EOF).Once()
+	require.Equal(t, io.EOF, conn.SubState([]string{"A/car/#1/tpqs/gear"}), "获取对端元信息失败")
+	mockedConn.AssertExpectations(t)
+
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithValidatedSubscriptions())
+	conn.onMetaInfo(peer.Meta().ToJSON())
+
+	require.Equal(t, errors.New(`NO such state "A/car/#1/tpqs/typo" on peer`),
+		conn.SubState([]string{"A/car/#1/tpqs/gear", "A/car/#1/tpqs/typo"}), "状态不存在")
+	require.Equal(t, errors.New(`NO such state "A/car/#1/tpqs/qsAction" on peer`),
+		conn.AddSubState([]string{"A/car/#1/tpqs/qsAction"}), "事件名称不是状态")
+	require.Equal(t, errors.New(`NO such event "A/car/#1/tpqs/gear" on peer`),
+		conn.SubEvent([]string{"A/car/#1/tpqs/gear"}), "事件不存在")
+	mockedConn.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	mockedConn.On("WriteMsg", message.Must(message.EncodeSubStateMsg(message.SetSub,
+		[]string{"A/car/#1/tpqs/gear"}))).Return(nil).Once()
+	mockedConn.On("WriteMsg", message.Must(message.EncodeSubEventMsg(message.AddSub,
+		[]string{"A/car/#1/tpqs/qsAction"}))).Return(nil).Once()
+	require.Nil(t, conn.SubState([]string{"A/car/#1/tpqs/gear"}), "状态存在")
+	require.Nil(t, conn.AddSubEvent([]string{"A/car/#1/tpqs/qsAction"}), "事件存在")
+	mockedConn.AssertExpectations(t)
+}
+
+// TestWithAutoSub 测试连接建立后自动订阅状态和事件
+func TestWithAutoSub(t *testing.T) {
+	stateSub := message.Must(message.EncodeSubStateMsg(message.AddSub, []string{"A/car/#1/tpqs/gear", "A/car/#1/tpqs/tpqsInfo"}))
+	eventSub := message.Must(message.EncodeSubEventMsg(message.AddSub, []string{"A/car/#1/tpqs/qsAction"}))
+
+	// 在接收报文之前发送自动订阅
+	mockedConn := new(mockConn)
+	m := NewEmptyModel()
+	conn := newConn(m, mockedConn,
+		WithAutoSubState([]string{"A/car/#1/tpqs/gear"}),
+		WithAutoSubState([]string{"A/car/#1/tpqs/tpqsInfo"}),
+		WithAutoSubEvent([]string{"A/car/#1/tpqs/qsAction"}))
+	mockedConn.On("WriteMsg", eventSub).Return(nil).Once()
+	mockedConn.On("WriteMsg", stateSub).Return(nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once()
+	mockedConn.On("Close").Return(nil).Once()
+	m.dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	require.Len(t, mockedConn.Calls, 4)
+	assert.Equal(t, "WriteMsg", mockedConn.Calls[0].Method, "先发送事件订阅")
+	assert.Equal(t, eventSub, mockedConn.Calls[0].Arguments.Get(0))
+	assert.Equal(t, stateSub, mockedConn.Calls[1].Arguments.Get(0), "再发送状态订阅")
+	assert.Equal(t, "ReadMsg", mockedConn.Calls[2].Method, "订阅之后才接收报文")
+
+	// 开启订阅校验时收到对端元信息后再订阅, 不存在的订阅不发送
+	peer, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+	metaMsg := message.Must(message.EncodeRawMsg("meta-info", peer.Meta().ToJSON()))
+
+	mockedConn = new(mockConn)
+	conn = newConn(NewEmptyModel(), mockedConn, WithValidatedSubscriptions(),
+		WithAutoSubState([]string{"A/car/#1/tpqs/gear", "A/car/#1/tpqs/tpqsInfo"}),
+		WithAutoSubEvent([]string{"A/car/#1/tpqs/typo"}))
+	sent := make(chan struct{})
+	// NOTE: 先收到元信息报文时不会再发送查询元信息报文
+	mockedConn.On("WriteMsg", message.EncodeQueryMetaMsg()).Return(nil).Maybe()
+	mockedConn.On("WriteMsg", stateSub).Return(nil).Once().Run(func(mock.Arguments) {
+		close(sent)
+	})
+	mockedConn.On("ReadMsg").Return(metaMsg, nil).Once()
+	mockedConn.On("ReadMsg").Return([]byte(nil), io.EOF).Once().Run(func(mock.Arguments) {
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+		}
+	})
+	mockedConn.On("Close").Return(nil).Once()
+	conn.m.dealConn(conn)
+	mockedConn.AssertExpectations(t)
+	mockedConn.AssertNotCalled(t, "WriteMsg", eventSub)
+}
+
+// TestConnection_SubStateAck 测试订阅状态并等待订阅确认
+func TestConnection_SubStateAck(t *testing.T) {
+	type TestCase struct {
+		isState bool     // 是否为状态订阅
+		items   []string // 订阅列表
+		wantMsg []byte   // 期望发送的订阅报文
+		sendErr error    // 发送订阅报文的错误信息
+		ack     []byte   // 对端回复的订阅确认报文内容, 为nil表示不回复
+		want    []string // 期望的有效订阅列表
+		wantErr error    // 期望的错误信息
+		desc    string   // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			isState: true,
+			items:   []string{"A/s1"},
+			wantMsg: []byte(`{"type":"set-subscribe-state","payload":{"items":["A/s1"],"uuid":"123"}}`),
+			sendErr: io.EOF,
+			wantErr: io.EOF,
+			desc:    "发送失败",
+		},
+
+		{
+			isState: true,
+			items:   []string{"A/s1", "A/s2"},
+			wantMsg: []byte(`{"type":"set-subscribe-state","payload":{"items":["A/s1","A/s2"],"uuid":"123"}}`),
+			ack:     []byte(`{"uuid":"123","error":"","response":{"items":["A/s1"]}}`),
+			want:    []string{"A/s1"},
+			desc:    "状态订阅确认",
+		},
+
+		{
+			isState: false,
+			items:   nil,
+			wantMsg: []byte(`{"type":"set-subscribe-event","payload":{"items":[],"uuid":"123"}}`),
+			ack:     []byte(`{"uuid":"123","error":"","response":{"items":[]}}`),
+			want:    []string{},
+			desc:    "事件订阅确认--空列表",
+		},
+
+		{
+			isState: false,
+			items:   []string{"A/e1"},
+			wantMsg: []byte(`{"type":"set-subscribe-event","payload":{"items":["A/e1"],"uuid":"123"}}`),
+			ack:     []byte(`{"uuid":"123","error":"","response":{"res":true}}`),
+			wantErr: errors.New("invalid subscription ack"),
+			desc:    "订阅确认无效",
+		},
+	}
+
+	for _, test := range testCases {
+		mockedConn := new(mockConn)
+		conn := newConn(NewEmptyModel(), mockedConn)
+		conn.uidCreator = func() string {
+			return "123"
+		}
+
+		ack := test.ack
+		mockedConn.On("WriteMsg", test.wantMsg).Return(test.sendErr).Run(func(mock.Arguments) {
+			if ack != nil {
+				go conn.onResp(ack)
+			}
+		}).Once()
+
+		var got []string
+		var err error
+		if test.isState {
+			got, err = conn.SubStateAck(test.items)
+		} else {
+			got, err = conn.SubEventAck(test.items)
+		}
+		require.Equal(t, test.wantErr, err, test.desc)
+		require.Equal(t, test.want, got, test.desc)
+		mockedConn.AssertExpectations(t)
+	}
+
+	// 等待订阅确认超时
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn, WithSubAckTimeout(20*time.Millisecond))
+	conn.uidCreator = func() string {
+		return "123"
+	}
+	mockedConn.On("WriteMsg", mock.Anything).Return(nil).Twice()
+
+	got, err := conn.SubStateAck([]string{"A/s1"})
+	assert.Equal(t, errors.New("timeout"), err, "超时")
+	assert.Nil(t, got, "超时")
+	assert.Empty(t, conn.PendingCalls(), "超时后移除等待器")
+
+	// 移除等待器后可以复用uuid, 迟到的确认被忽略
+	conn.onResp([]byte(`{"uuid":"123","error":"","response":{"items":["A/s1"]}}`))
+	_, err = conn.SubEventAck([]string{"A/e1"})
+	assert.Equal(t, errors.New("timeout"), err, "超时")
+	mockedConn.AssertExpectations(t)
+
+	assert.Equal(t, DefaultSubAckTimeout, newConn(NewEmptyModel(), new(mockConn)).subAckTimeout, "默认超时时间")
+}
+
+// TestConnection_BindSession 测试绑定代理会话并等待会话确认
+func TestConnection_BindSession(t *testing.T) {
+	type TestCase struct {
+		sendErr error      // 发送会话报文的错误信息
+		ack     []byte     // 代理回复的会话确认报文内容, 为nil表示不回复
+		want    SessionAck // 期望的会话确认
+		wantErr error      // 期望的错误信息
+		desc    string     // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			sendErr: io.EOF,
+			wantErr: io.EOF,
+			desc:    "发送失败",
+		},
+
+		{
+			ack:  []byte(`{"uuid":"123","error":"","response":{"restored":true,"states":["A/s1"],"events":[]}}`),
+			want: SessionAck{Restored: true, States: []string{"A/s1"}, Events: []string{}},
+			desc: "恢复会话",
+		},
+
+		{
+			ack:     []byte(`{"uuid":"123","error":"session NOT enabled","response":{"restored":false,"states":[],"events":[]}}`),
+			wantErr: errors.New("session NOT enabled"),
+			desc:    "代理没有开启会话",
+		},
+
+		{
+			ack:     []byte(`{"uuid":"123","error":"","response":{"res":true}}`),
+			wantErr: errors.New("invalid session ack"),
+			desc:    "会话确认无效",
+		},
+
+		{
+			wantErr: errors.New("timeout"),
+			desc:    "等待会话确认超时",
+		},
+	}
+
+	for _, test := range testCases {
+		mockedConn := new(mockConn)
+		conn := newConn(NewEmptyModel(), mockedConn, WithSubAckTimeout(20*time.Millisecond))
+		conn.uidCreator = func() string {
+			return "123"
+		}
+
+		ack := test.ack
+		mockedConn.On("WriteMsg", message.EncodeSessionMsg("phone-1", "123")).Return(test.sendErr).Run(func(mock.Arguments) {
+			if ack != nil {
+				go conn.onResp(ack)
+			}
+		}).Once()
+
+		got, err := conn.BindSession("phone-1")
+		require.Equal(t, test.wantErr, err, test.desc)
+		require.Equal(t, test.want, got, test.desc)
+		require.Empty(t, conn.PendingCalls(), test.desc)
+		mockedConn.AssertExpectations(t)
+	}
+
+	_, err := newConn(NewEmptyModel(), new(mockConn)).BindSession(" ")
+	assert.Equal(t, errors.New("session id is empty"), err, "会话ID为空")
+}
+
+// TestDealSubMsg_Ack 测试收到需要确认的订阅报文时回复订阅确认
+func TestDealSubMsg_Ack(t *testing.T) {
+	server, err := LoadFromFile("../meta/tpqs.json", meta.TemplateParam{
+		"group": "A",
+		"id":    "#1",
+	})
+	require.Nil(t, err)
+
+	mockedConn := new(mockConn)
+	conn := newConn(server, mockedConn)
+
+	// 不需要确认
+	conn.onSetSubState([]byte(`["A/car/#1/tpqs/gear"]`))
+	mockedConn.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	// 有效订阅只包含存在的状态和事件
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("1", []string{"A/car/#1/tpqs/QSCount", "A/car/#1/tpqs/gear"})).Return(nil).Once()
+	conn.onAddSubState([]byte(`{"items":["A/car/#1/tpqs/QSCount","A/car/#1/tpqs/none"],"uuid":"1"}`))
+
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("2", []string{"A/car/#1/tpqs/QSCount"})).Return(nil).Once()
+	conn.onRemoveSubState([]byte(`{"items":["A/car/#1/tpqs/gear"],"uuid":"2"}`))
+
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("3", nil)).Return(nil).Once()
+	conn.onClearSubState([]byte(`{"items":[],"uuid":"3"}`))
+
+	mockedConn.On("WriteMsg", message.EncodeSubAckMsg("4", []string{"A/car/#1/tpqs/qsAction"})).Return(nil).Once()
+	conn.onSetSubEvent([]byte(`{"items":["A/car/#1/tpqs/qsAction","A/car/#1/tpqs/gear"],"uuid":"4"}`))
+
+	mockedConn.AssertExpectations(t)
+}
+
+// TestConnection_Ping 测试通过ping报文测量往返时间
+func TestConnection_Ping(t *testing.T) {
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+	conn.uidCreator = func() string {
+		return "123"
+	}
+
+	// 发送失败
+	mockedConn.On("WriteMsg", message.EncodePingMsg("123")).Return(io.EOF).Once()
+	rtt, err := conn.Ping(time.Second)
+	require.Equal(t, io.EOF, err, "发送失败")
+	require.Zero(t, rtt, "发送失败")
+
+	// 超时
+	mockedConn.On("WriteMsg", message.EncodePingMsg("123")).Return(nil).Once()
+	rtt, err = conn.Ping(time.Millisecond * 10)
+	require.Equal(t, errors.New("timeout"), err, "超时")
+	require.Zero(t, rtt, "超时")
+
+	// 收到pong报文
+	mockedConn.On("WriteMsg", message.EncodePingMsg("123")).Return(nil).Run(func(mock.Arguments) {
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			conn.onPong([]byte(`{"uuid":"456"}`))
+			conn.onPong([]byte(`{"uuid":"123"}`))
+		}()
+	}).Once()
+	rtt, err = conn.Ping(time.Second)
+	require.Nil(t, err, "收到pong报文")
+	require.GreaterOrEqual(t, rtt, time.Millisecond*20, "收到pong报文")
+
+	mockedConn.AssertExpectations(t)
+}
+
+// TestDealPingMsg 测试自动回复ping报文
+func TestDealPingMsg(t *testing.T) {
+	mockedConn := new(mockConn)
+	conn := newConn(NewEmptyModel(), mockedConn)
+
+	conn.onPing([]byte(`123`))
+	mockedConn.AssertNotCalled(t, "WriteMsg", mock.Anything)
+
+	mockedConn.On("WriteMsg", []byte(`{"type":"pong","payload":{"uuid":"abc"}}`)).Return(nil).Once()
+	conn.onPing([]byte(`{"uuid":"abc"}`))
+	mockedConn.AssertExpectations(t)
+}
+
 // TestModel_DialFailed 测试建立连接失败的情况
 func TestModel_DialFailed(t *testing.T) {
 	m := NewEmptyModel()
@@ -3338,3 +6437,18 @@ func TestModel_DialFailed(t *testing.T) {
 		}
 	}
 }
+
+// TestSetJSONConfig 测试物模型与报文共用同一个JSON API实例
+func TestSetJSONConfig(t *testing.T) {
+	defer SetJSONConfig(message.DefaultJSONConfig)
+	assert.True(t, json == message.JSON(), "默认与报文共用")
+
+	config := message.DefaultJSONConfig
+	config.EscapeHTML = false
+	SetJSONConfig(config)
+	assert.True(t, json == message.JSON(), "替换后仍然与报文共用")
+
+	data, err := json.Marshal("<a&b>")
+	require.Nil(t, err)
+	assert.Equal(t, `"<a&b>"`, string(data), "关闭HTML转义")
+}
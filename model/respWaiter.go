@@ -9,6 +9,8 @@ import (
 
 // RespWaiter 为调用响应等待器, 用于等待调用请求报文的响应报文.
 type RespWaiter struct {
+	conn    *Connection     // 发送调用请求的连接, 为nil表示不属于任何连接
+	uuid    string          // 等待的调用请求的UUID
 	gotOnce sync.Once       // 保证 got 只关闭一次
 	got     chan struct{}   // 收到响应信号
 	resp    message.RawResp // 响应原始报文
@@ -23,6 +25,19 @@ func (w *RespWaiter) wake(resp message.RawResp, err error) {
 	})
 }
 
+// drop 将已经放弃等待的等待器从所属连接中移除, 避免 Connection.PendingCalls 中残留已经放弃等待的请求,
+// 之后到达的响应被忽略. 连接中uuid对应的等待器已经不是w时不做任何操作.
+func (w *RespWaiter) drop() {
+	if w.conn == nil {
+		return
+	}
+	w.conn.waitersLock.Lock()
+	defer w.conn.waitersLock.Unlock()
+	if w.conn.respWaiters[w.uuid] == w {
+		delete(w.conn.respWaiters, w.uuid)
+	}
+}
+
 // Wait 阻塞式地等待调用响应报文,直到收到调用响应报文或者连接关闭,返回响应报文的返回值和错误信息.
 func (w *RespWaiter) Wait() (message.RawResp, error) {
 	<-w.got
@@ -39,3 +54,44 @@ func (w *RespWaiter) WaitFor(timeout time.Duration) (message.RawResp, error) {
 		return w.resp, w.err
 	}
 }
+
+// WaitForAll 同时等待多个调用响应等待器waiters, 直到全部收到调用响应报文或者等待时间超过timeout,
+// 返回与waiters一一对应的响应返回值和错误信息. 所有等待器共用同一个截止时间,
+// 超时前已经收到的响应保持不变, 超时仍未收到响应的等待器对应的错误信息为"timeout", 并从所属连接中移除,
+// 之后到达的响应被忽略, 与 Connection.CallFor 超时的行为相同.
+func WaitForAll(waiters []*RespWaiter, timeout time.Duration) ([]message.RawResp, []error) {
+	resps := make([]message.RawResp, len(waiters))
+	errs := make([]error, len(waiters))
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	expired := false
+	for i, w := range waiters {
+		if w == nil {
+			resps[i] = message.RawResp{}
+			errs[i] = errors.New("nil waiter")
+			continue
+		}
+
+		// 截止时间前等待响应
+		if !expired {
+			select {
+			case <-w.got:
+			case <-timer.C:
+				expired = true
+			}
+		}
+
+		// 截止时间后只收集已经收到的响应
+		select {
+		case <-w.got:
+			resps[i], errs[i] = w.resp, w.err
+		default:
+			resps[i], errs[i] = message.RawResp{}, errors.New("timeout")
+			w.drop()
+		}
+	}
+
+	return resps, errs
+}
@@ -0,0 +1,229 @@
+package model
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SendQueuePolicy 为开启了 WithPrioritySend 的连接的低优先级发送队列已满时的处理策略, 见 WithSendQueueLimit
+type SendQueuePolicy int
+
+const (
+	SendQueueCoalesce   SendQueuePolicy = iota // 以新的状态报文替换队列中同名状态的报文, 没有同名状态的报文时丢弃最早的报文, 默认策略
+	SendQueueDropOldest                        // 丢弃队列中最早的报文
+	SendQueueBlock                             // 阻塞推送直到队列有空闲位置
+)
+
+// DefaultSendQueueLimit 为低优先级发送队列的默认容量, 见 WithSendQueueLimit
+const DefaultSendQueueLimit = 1024
+
+// sendItem 为发送队列中等待发送的报文
+type sendItem struct {
+	msg  []byte     // 报文数据, 为nil表示同步标记, 不发送任何数据, 见 sendQueue.sync
+	key  string     // 状态报文的状态全名, 用于合并同名状态, 其他报文为空
+	done chan error // 报文的写入结果, 带缓存, 写入后不会阻塞发送协程
+}
+
+// sendQueue 为连接的两级优先级发送队列, 见 WithPrioritySend.
+// 发送协程总是先发送高优先级队列中的报文, 高优先级队列为空时才发送低优先级队列中的报文,
+// 同一优先级的报文按加入队列的顺序发送.
+type sendQueue struct {
+	// NOTE: drops 以原子操作访问, 必须是第一个字段, 保证在32位平台上64位对齐
+	drops   uint64 // 被丢弃或者被替换的低优先级报文数量, 原子操作
+	lock    sync.Mutex
+	cond    *sync.Cond
+	high    []sendItem          // 高优先级报文, 如调用请求、调用响应和订阅报文
+	low     []sendItem          // 低优先级报文, 即推送的状态报文和事件报文
+	lowSize int                 // 低优先级队列中的报文数量, 不包括同步标记
+	limit   int                 // 低优先级队列的容量
+	policy  SendQueuePolicy     // 低优先级队列已满时的处理策略
+	dropped map[string]struct{} // 被丢弃或者被替换的状态报文的状态全名, 见 takeDropped
+	err     error               // 队列结束的原因, 不为nil时不再发送任何报文, 见 fail
+}
+
+func newSendQueue() *sendQueue {
+	q := &sendQueue{
+		limit:   DefaultSendQueueLimit,
+		policy:  SendQueueCoalesce,
+		dropped: make(map[string]struct{}),
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// push 将报文msg加入高优先级队列, 返回报文写入结果的管道, 队列已经结束时管道中立即写入结束的原因.
+func (q *sendQueue) push(msg []byte) <-chan error {
+	done := make(chan error, 1)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.err != nil {
+		done <- q.err
+		return done
+	}
+
+	q.high = append(q.high, sendItem{msg: msg, done: done})
+	q.cond.Broadcast()
+	return done
+}
+
+// pushLow 将状态全名为key(事件报文和批量报文为空)的报文msg加入低优先级队列, 返回报文写入结果的管道,
+// 队列已满时按照 policy 处理, 队列已经结束时管道中立即写入结束的原因.
+func (q *sendQueue) pushLow(msg []byte, key string) <-chan error {
+	done := make(chan error, 1)
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.err == nil && q.lowSize >= q.limit && q.policy == SendQueueBlock {
+		q.cond.Wait()
+	}
+	if q.err != nil {
+		done <- q.err
+		return done
+	}
+
+	item := sendItem{msg: msg, key: key, done: done}
+	if q.lowSize >= q.limit {
+		if q.policy == SendQueueCoalesce && q.replace(item) {
+			return done
+		}
+		q.dropOldest()
+	}
+
+	q.low = append(q.low, item)
+	q.lowSize++
+	q.cond.Broadcast()
+	return done
+}
+
+// replace 以item替换低优先级队列中同名状态的报文, 没有同名状态的报文时返回false
+// NOTE: 调用前必须持有 lock
+func (q *sendQueue) replace(item sendItem) bool {
+	if item.key == "" {
+		return false
+	}
+	for i := range q.low {
+		if q.low[i].msg != nil && q.low[i].key == item.key {
+			q.low[i] = item
+			atomic.AddUint64(&q.drops, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// dropOldest 丢弃低优先级队列中最早的报文, 同步标记不会被丢弃
+// NOTE: 调用前必须持有 lock
+func (q *sendQueue) dropOldest() {
+	for i, item := range q.low {
+		if item.msg == nil {
+			continue
+		}
+		if item.key != "" {
+			q.dropped[item.key] = struct{}{}
+		}
+		q.low = append(q.low[:i], q.low[i+1:]...)
+		q.lowSize--
+		atomic.AddUint64(&q.drops, 1)
+		return
+	}
+}
+
+// takeDropped 返回状态全名为key的状态报文在上一次调用之后是否被丢弃过, 并清除该记录.
+// 被丢弃的状态报文没有发送, 因此下一次推送该状态时不能去重.
+func (q *sendQueue) takeDropped(key string) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	_, seen := q.dropped[key]
+	delete(q.dropped, key)
+	return seen
+}
+
+// sync 等待在其之前加入队列的所有报文发送完成, 返回队列结束的原因, 队列没有结束时返回nil.
+// 同步标记不占用低优先级队列的容量, 也不会被丢弃.
+func (q *sendQueue) sync() error {
+	done := make(chan error, 1)
+
+	q.lock.Lock()
+	if q.err != nil {
+		q.lock.Unlock()
+		return q.err
+	}
+	q.low = append(q.low, sendItem{done: done})
+	q.cond.Broadcast()
+	q.lock.Unlock()
+
+	return <-done
+}
+
+// pop 阻塞直到队列中有待发送的报文, 优先返回高优先级的报文, 队列已经结束时返回false
+func (q *sendQueue) pop() (sendItem, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.high) == 0 && len(q.low) == 0 && q.err == nil {
+		q.cond.Wait()
+	}
+	if q.err != nil {
+		return sendItem{}, false
+	}
+
+	var item sendItem
+	if len(q.high) > 0 {
+		item, q.high = q.high[0], q.high[1:]
+	} else {
+		item, q.low = q.low[0], q.low[1:]
+		if item.msg != nil {
+			q.lowSize--
+		}
+		// NOTE: 唤醒因队列已满而阻塞的推送
+		q.cond.Broadcast()
+	}
+	return item, true
+}
+
+// fail 以原因err结束队列, 队列中所有未发送的报文的写入结果都为err, 之后加入队列的报文也不再发送.
+// 只有第一次调用有效.
+func (q *sendQueue) fail(err error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.err != nil {
+		return
+	}
+
+	q.err = err
+	for _, item := range q.high {
+		item.done <- err
+	}
+	for _, item := range q.low {
+		item.done <- err
+	}
+	q.high = nil
+	q.low = nil
+	q.lowSize = 0
+	q.cond.Broadcast()
+}
+
+// runSendQueue 为开启了 WithPrioritySend 的连接的发送协程, 按优先级依次写入队列中的报文直到队列结束.
+// 写入失败时结束队列并关闭连接.
+// NOTE: 开启优先级发送后所有报文都由发送协程写入底层连接, 因此写入时不需要持有 writeLock
+func (conn *Connection) runSendQueue() {
+	for {
+		item, ok := conn.sendQueue.pop()
+		if !ok {
+			return
+		}
+
+		var err error
+		if item.msg != nil {
+			err = conn.writeRaw(item.msg)
+		}
+		item.done <- err
+
+		if err != nil {
+			conn.sendQueue.fail(err)
+			// NOTE: 关闭连接时会等待发送队列, 不能在发送协程中同步关闭
+			go conn.close(CloseKindWriteError, "write: "+err.Error())
+			return
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package modeltest
+
+import (
+	"fmt"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
+	"github.com/object-model/goModel/model"
+	"github.com/object-model/goModel/rawConn"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Responder 为模拟对端的调用请求处理函数, 参数method为方法名(不含物模型名称), 参数args为调用参数,
+// 返回调用响应的返回值和错误信息, 错误信息不为nil时调用方收到错误提示信息为err.Error()的响应报文.
+type Responder func(method string, args message.RawArgs) (message.Resp, error)
+
+// FakePeer 为模拟的物模型对端, 通过内存管道(见 Pipe)与被测的物模型连接, 不需要监听端口.
+// FakePeer 以给定的元信息回复元信息查询, 以预设的返回值或者 Responder 回复调用请求, 回复ping报文和订阅确认,
+// 并记录被测连接的订阅关系. 测试代码可以通过 FakePeer.PushState 和 FakePeer.PushEvent 向被测连接注入状态和事件,
+// 驱动被测连接的状态和事件回调. 例如:
+//
+//	peer := modeltest.NewFakePeer(peerMeta, modeltest.WithResponses(map[string]message.Resp{
+//		"QS": {"res": true},
+//	}))
+//	conn := peer.Connect(client, model.WithStateFunc(onState))
+//	defer peer.Close()
+//	resp, err := conn.Call("A/car/#1/tpqs/QS", message.Args{"angle": 10})
+type FakePeer struct {
+	meta      *meta.Meta              // 模拟对端的元信息
+	responses map[string]message.Resp // 方法名 -> 预设的返回值
+	responder Responder               // 调用请求处理函数, 优先于 responses
+	raw       rawConn.RawConn         // 模拟对端一侧的原始连接
+	lock      sync.Mutex              // 保护 subStates subEvents received
+	subStates map[string]struct{}     // 被测连接订阅的状态
+	subEvents map[string]struct{}     // 被测连接订阅的事件
+	received  [][]byte                // 收到的所有报文
+	quit      chan struct{}           // serve 退出信号
+}
+
+// PeerOption 为模拟对端配置选项
+type PeerOption func(*FakePeer)
+
+// WithResponses 配置模拟对端每个方法(不含物模型名称)的预设返回值为responses, 没有预设返回值的方法回复错误响应.
+func WithResponses(responses map[string]message.Resp) PeerOption {
+	return func(peer *FakePeer) {
+		for method, resp := range responses {
+			peer.responses[method] = resp
+		}
+	}
+}
+
+// WithResponder 配置模拟对端的调用请求处理函数为responder, 配置后忽略 WithResponses 配置的预设返回值.
+func WithResponder(responder Responder) PeerOption {
+	return func(peer *FakePeer) {
+		if responder != nil {
+			peer.responder = responder
+		}
+	}
+}
+
+// NewFakePeer 根据配置选项opts创建元信息为m的模拟对端, m为nil时使用空元信息.
+func NewFakePeer(m *meta.Meta, opts ...PeerOption) *FakePeer {
+	if m == nil {
+		m = meta.NewEmptyMeta()
+	}
+
+	ans := &FakePeer{
+		meta:      m,
+		responses: make(map[string]message.Resp),
+		subStates: make(map[string]struct{}),
+		subEvents: make(map[string]struct{}),
+		quit:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ans)
+	}
+
+	return ans
+}
+
+// Connect 通过内存管道将被测物模型client与模拟对端p连接, 返回被测物模型一侧的连接, opts为被测连接的配置选项.
+// 每个模拟对端只能调用一次 Connect.
+func (p *FakePeer) Connect(client *model.Model, opts ...model.ConnOption) *model.Connection {
+	clientSide, peerSide := Pipe()
+	p.raw = peerSide
+	go p.serve()
+	return client.Attach(clientSide, opts...)
+}
+
+// PushState 向被测连接推送名为name(不含物模型名称)数据为data的状态报文, 不论被测连接是否订阅了该状态.
+// 状态数据不做元信息校验, 可以用来测试被测连接对无效数据的处理.
+func (p *FakePeer) PushState(name string, data interface{}) error {
+	msg, err := message.EncodeStateMsg(p.meta.Name+"/"+name, data)
+	if err != nil {
+		return err
+	}
+	return p.write(msg)
+}
+
+// PushEvent 向被测连接推送名为name(不含物模型名称)参数为args的事件报文, 不论被测连接是否订阅了该事件.
+// 事件参数不做元信息校验, 可以用来测试被测连接对无效数据的处理.
+func (p *FakePeer) PushEvent(name string, args message.Args) error {
+	msg, err := message.EncodeEventMsg(p.meta.Name+"/"+name, args)
+	if err != nil {
+		return err
+	}
+	return p.write(msg)
+}
+
+// SubStates 返回被测连接当前订阅的所有状态全名, 按名称排序.
+func (p *FakePeer) SubStates() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return sortedItems(p.subStates)
+}
+
+// SubEvents 返回被测连接当前订阅的所有事件全名, 按名称排序.
+func (p *FakePeer) SubEvents() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return sortedItems(p.subEvents)
+}
+
+// Received 返回模拟对端从被测连接收到的所有报文, 按接收顺序排列.
+func (p *FakePeer) Received() [][]byte {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return append([][]byte(nil), p.received...)
+}
+
+// Close 关闭模拟对端与被测连接之间的内存管道, 被测连接随之关闭.
+func (p *FakePeer) Close() error {
+	if p.raw == nil {
+		return nil
+	}
+	err := p.raw.Close()
+	<-p.quit
+	return err
+}
+
+// write 向被测连接发送报文msg
+func (p *FakePeer) write(msg []byte) error {
+	if p.raw == nil {
+		return fmt.Errorf("NOT connected")
+	}
+	return p.raw.WriteMsg(msg)
+}
+
+// serve 处理被测连接发送的报文, 直到管道关闭
+func (p *FakePeer) serve() {
+	defer close(p.quit)
+
+	for {
+		data, err := p.raw.ReadMsg()
+		if err != nil {
+			return
+		}
+
+		p.lock.Lock()
+		p.received = append(p.received, data)
+		p.lock.Unlock()
+
+		msg, err := message.Decode(data)
+		if err != nil {
+			continue
+		}
+		p.dispatch(msg)
+	}
+}
+
+// dispatch 根据报文类型处理被测连接发送的报文msg, 忽略不支持的报文
+func (p *FakePeer) dispatch(msg message.RawMessage) {
+	switch msg.Type {
+	case "query-meta":
+		_ = p.write(message.Must(message.EncodeRawMsg("meta-info", p.meta.ToJSON())))
+	case "call":
+		p.onCall(msg.Payload)
+	case "set-state":
+		setState, err := message.ParseSetStatePayload(msg.Payload)
+		if err == nil {
+			_ = p.write(message.Must(message.EncodeRespMsg(setState.UUID, "NO callback", message.Resp{})))
+		}
+	case "ping":
+		if ping, err := message.ParsePingPayload(msg.Payload); err == nil {
+			_ = p.write(message.EncodePongMsg(ping.UUID))
+		}
+	case "batch":
+		msgs, err := message.ParseBatchPayload(msg.Payload)
+		if err != nil {
+			return
+		}
+		for _, data := range msgs {
+			if sub, err := message.Decode(data); err == nil && sub.Type != "batch" {
+				p.dispatch(sub)
+			}
+		}
+	default:
+		if strings.HasSuffix(msg.Type, "-subscribe-state") {
+			p.onSub(msg, p.subStates)
+		} else if strings.HasSuffix(msg.Type, "-subscribe-event") {
+			p.onSub(msg, p.subEvents)
+		}
+	}
+}
+
+// onCall 以 Responder 或者预设的返回值回复调用请求
+func (p *FakePeer) onCall(payload []byte) {
+	call, err := message.ParseCallPayload(payload)
+	if err != nil {
+		return
+	}
+
+	method := call.Name
+	if i := strings.LastIndex(call.Name, "/"); i != -1 {
+		if call.Name[:i] != p.meta.Name {
+			_ = p.write(message.Must(message.EncodeRespMsg(call.UUID,
+				fmt.Sprintf("modelName %q: unmatched", call.Name[:i]), message.Resp{})))
+			return
+		}
+		method = call.Name[i+1:]
+	}
+
+	resp, errStr := p.respond(method, call.Args)
+	_ = p.write(message.Must(message.EncodeRespMsg(call.UUID, errStr, resp)))
+}
+
+// respond 返回方法method以参数args调用的返回值和错误信息
+func (p *FakePeer) respond(method string, args message.RawArgs) (message.Resp, string) {
+	if p.responder != nil {
+		resp, err := p.responder(method, args)
+		if resp == nil {
+			resp = message.Resp{}
+		}
+		if err != nil {
+			return resp, err.Error()
+		}
+		return resp, ""
+	}
+
+	resp, seen := p.responses[method]
+	if !seen {
+		return message.Resp{}, fmt.Sprintf("NO response for method %q", method)
+	}
+	return resp, ""
+}
+
+// onSub 根据订阅报文msg更新订阅集合set, 订阅报文要求确认时回复订阅确认
+func (p *FakePeer) onSub(msg message.RawMessage, set map[string]struct{}) {
+	sub, err := message.ParseSubPayload(msg.Payload)
+	if err != nil {
+		return
+	}
+
+	p.lock.Lock()
+	switch {
+	case strings.HasPrefix(msg.Type, "set-"):
+		for item := range set {
+			delete(set, item)
+		}
+		fallthrough
+	case strings.HasPrefix(msg.Type, "add-"):
+		for _, item := range sub.Items {
+			set[item] = struct{}{}
+		}
+	case strings.HasPrefix(msg.Type, "remove-"):
+		for _, item := range sub.Items {
+			delete(set, item)
+		}
+	case strings.HasPrefix(msg.Type, "clear-"):
+		for item := range set {
+			delete(set, item)
+		}
+	}
+	items := sortedItems(set)
+	p.lock.Unlock()
+
+	if sub.UUID != "" {
+		_ = p.write(message.EncodeSubAckMsg(sub.UUID, items))
+	}
+}
+
+// sortedItems 返回集合set中的所有项, 按名称排序
+func sortedItems(set map[string]struct{}) []string {
+	ans := make([]string, 0, len(set))
+	for item := range set {
+		ans = append(ans, item)
+	}
+	sort.Strings(ans)
+	return ans
+}
@@ -0,0 +1,181 @@
+package modeltest
+
+import (
+	"errors"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
+	"github.com/object-model/goModel/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+	"time"
+)
+
+const peerMetaJSON = `{
+	"name": "test/peer",
+	"description": "模拟对端",
+	"state": [
+		{"name": "speed", "description": "速度", "type": "float", "unit": "m/s", "range": {"max": 100}}
+	],
+	"event": [
+		{"name": "alarm", "description": "告警", "args": [
+			{"name": "code", "description": "告警码", "type": "int", "range": {"max": 100}}
+		]}
+	],
+	"method": [
+		{"name": "echo", "description": "回显", "args": [
+			{"name": "x", "description": "输入", "type": "int", "range": {"max": 100}}
+		], "response": [
+			{"name": "y", "description": "输出", "type": "int", "range": {"max": 100}}
+		]}
+	]
+}`
+
+func newPeerMeta(t *testing.T) *meta.Meta {
+	m, err := meta.Parse([]byte(peerMetaJSON), nil)
+	require.Nil(t, err)
+	return m
+}
+
+func TestPipe(t *testing.T) {
+	a, b := Pipe()
+	assert.Equal(t, "pipe-a", b.RemoteAddr().String())
+	assert.Equal(t, "pipe-b", a.RemoteAddr().String())
+
+	msg := []byte("hello")
+	require.Nil(t, a.WriteMsg(msg))
+	msg[0] = 'H'
+	got, err := b.ReadMsg()
+	require.Nil(t, err)
+	assert.Equal(t, []byte("hello"), got)
+
+	require.Nil(t, b.Close())
+	_, err = a.ReadMsg()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, io.ErrClosedPipe, a.WriteMsg(msg))
+}
+
+func TestFakePeer_Meta(t *testing.T) {
+	peerMeta := newPeerMeta(t)
+	peer := NewFakePeer(peerMeta)
+	conn := peer.Connect(model.NewEmptyModel())
+	defer peer.Close()
+
+	got, err := conn.GetPeerMeta()
+	require.Nil(t, err)
+	assert.Equal(t, peerMeta.ToJSON(), got.ToJSON())
+}
+
+func TestFakePeer_Call(t *testing.T) {
+	peer := NewFakePeer(newPeerMeta(t), WithResponses(map[string]message.Resp{
+		"echo": {"y": 1},
+	}))
+	conn := peer.Connect(model.NewEmptyModel())
+	defer peer.Close()
+
+	resp, err := conn.Call("test/peer/echo", message.Args{"x": 1})
+	require.Nil(t, err)
+	assert.Equal(t, message.RawResp{"y": []byte("1")}, resp)
+
+	_, err = conn.Call("test/peer/unknown", message.Args{})
+	assert.EqualError(t, err, `NO response for method "unknown"`)
+
+	_, err = conn.Call("test/other/echo", message.Args{"x": 1})
+	assert.EqualError(t, err, `modelName "test/other": unmatched`)
+
+	require.Len(t, peer.Received(), 3)
+}
+
+func TestFakePeer_Responder(t *testing.T) {
+	peer := NewFakePeer(newPeerMeta(t), WithResponses(map[string]message.Resp{
+		"echo": {"y": 0},
+	}), WithResponder(func(method string, args message.RawArgs) (message.Resp, error) {
+		if method != "echo" {
+			return nil, errors.New("unknown method")
+		}
+		return message.Resp{"y": args["x"]}, nil
+	}))
+	conn := peer.Connect(model.NewEmptyModel())
+	defer peer.Close()
+
+	resp, err := conn.Call("test/peer/echo", message.Args{"x": 7})
+	require.Nil(t, err)
+	assert.Equal(t, message.RawResp{"y": []byte("7")}, resp)
+
+	_, err = conn.Call("test/peer/other", message.Args{})
+	assert.EqualError(t, err, "unknown method")
+}
+
+func TestFakePeer_Push(t *testing.T) {
+	type state struct {
+		modelName string
+		stateName string
+		data      []byte
+	}
+	states := make(chan state, 1)
+	events := make(chan string, 1)
+
+	peer := NewFakePeer(newPeerMeta(t))
+	conn := peer.Connect(model.NewEmptyModel(),
+		model.WithStateFunc(func(modelName string, stateName string, data []byte) {
+			states <- state{modelName, stateName, data}
+		}),
+		model.WithEventFunc(func(modelName string, eventName string, args message.RawArgs) {
+			events <- modelName + "/" + eventName + " " + string(args["code"])
+		}))
+	defer peer.Close()
+
+	require.Nil(t, peer.PushState("speed", 12.5))
+	select {
+	case got := <-states:
+		assert.Equal(t, state{"test/peer", "speed", []byte("12.5")}, got)
+	case <-time.After(time.Second):
+		t.Fatal("state NOT received")
+	}
+
+	require.Nil(t, peer.PushEvent("alarm", message.Args{"code": 3}))
+	select {
+	case got := <-events:
+		assert.Equal(t, "test/peer/alarm 3", got)
+	case <-time.After(time.Second):
+		t.Fatal("event NOT received")
+	}
+
+	assert.NotNil(t, conn)
+}
+
+func TestFakePeer_Sub(t *testing.T) {
+	peer := NewFakePeer(newPeerMeta(t))
+	conn := peer.Connect(model.NewEmptyModel())
+	defer peer.Close()
+
+	items, err := conn.SubStateAck([]string{"test/peer/speed"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"test/peer/speed"}, items)
+	assert.Equal(t, []string{"test/peer/speed"}, peer.SubStates())
+
+	items, err = conn.SubEventAck([]string{"test/peer/alarm"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"test/peer/alarm"}, items)
+	assert.Equal(t, []string{"test/peer/alarm"}, peer.SubEvents())
+
+	_, err = conn.Ping(time.Second)
+	assert.Nil(t, err)
+}
+
+func TestFakePeer_Close(t *testing.T) {
+	closed := make(chan struct{})
+	peer := NewFakePeer(nil)
+	peer.Connect(model.NewEmptyModel(), model.WithClosedFunc(func(reason string) {
+		close(closed)
+	}))
+
+	require.Nil(t, peer.Close())
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection NOT closed")
+	}
+	assert.Equal(t, io.ErrClosedPipe, peer.PushState("speed", 1))
+}
@@ -0,0 +1,85 @@
+package modeltest
+
+import (
+	"github.com/object-model/goModel/rawConn"
+	"io"
+	"net"
+	"sync"
+)
+
+// pipeBuffSize 为内存管道每个方向缓存的报文数量, 避免两端同时写入时互相阻塞
+const pipeBuffSize = 256
+
+// pipeConn 为内存管道的一端, 实现了 rawConn.RawConn 接口
+type pipeConn struct {
+	in        <-chan []byte // 接收报文通道
+	out       chan<- []byte // 发送报文通道
+	closeOnce *sync.Once    // 确保 closed 只关闭一次, 两端共用
+	closed    chan struct{} // 管道关闭信号, 两端共用
+	addr      pipeAddr      // 对端地址
+}
+
+// Pipe 创建一对在内存中直接相连的原始连接, 一端写入的报文从另一端按顺序读出, 不需要监听端口.
+// 任意一端关闭后两端都关闭, 之后 ReadMsg 返回 io.EOF, WriteMsg 返回 io.ErrClosedPipe.
+// 可以通过 model.Model.Attach 将任意一端交给物模型处理.
+func Pipe() (rawConn.RawConn, rawConn.RawConn) {
+	a2b := make(chan []byte, pipeBuffSize)
+	b2a := make(chan []byte, pipeBuffSize)
+	closeOnce := &sync.Once{}
+	closed := make(chan struct{})
+
+	a := &pipeConn{in: b2a, out: a2b, closeOnce: closeOnce, closed: closed, addr: "pipe-b"}
+	b := &pipeConn{in: a2b, out: b2a, closeOnce: closeOnce, closed: closed, addr: "pipe-a"}
+	return a, b
+}
+
+// ReadMsg 读取对端写入的下一包报文, 管道关闭后返回 io.EOF.
+func (conn *pipeConn) ReadMsg() ([]byte, error) {
+	select {
+	case msg := <-conn.in:
+		return msg, nil
+	case <-conn.closed:
+		return nil, io.EOF
+	}
+}
+
+// WriteMsg 将报文msg写入管道, 管道关闭后返回 io.ErrClosedPipe.
+func (conn *pipeConn) WriteMsg(msg []byte) error {
+	// NOTE: 先检查是否已经关闭, 避免关闭后仍然写入缓存
+	select {
+	case <-conn.closed:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	select {
+	case conn.out <- append([]byte(nil), msg...):
+		return nil
+	case <-conn.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// Close 关闭管道的两端.
+func (conn *pipeConn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+	return nil
+}
+
+// RemoteAddr 返回对端的地址.
+func (conn *pipeConn) RemoteAddr() net.Addr {
+	return conn.addr
+}
+
+// pipeAddr 为内存管道的地址
+type pipeAddr string
+
+func (addr pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (addr pipeAddr) String() string {
+	return string(addr)
+}
@@ -0,0 +1,390 @@
+package rawConn
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// tcpPair 建立一对本地回环TCP连接, 返回客户端连接和服务端连接
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.Nil(t, err)
+	defer l.Close()
+
+	accepted := make(chan *net.TCPConn, 1)
+	go func() {
+		conn, _ := l.AcceptTCP()
+		accepted <- conn
+	}()
+
+	client, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	require.Nil(t, err)
+
+	server := <-accepted
+	require.NotNil(t, server)
+
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+
+	return client, server
+}
+
+func TestTcpConn_ReadWriteMsg(t *testing.T) {
+	client, server := tcpPair(t)
+	writer := NewTcpConn(client, false)
+	reader := NewTcpConn(server, false)
+
+	require.Nil(t, writer.WriteMsg(nil), "空报文不发送")
+	require.Nil(t, writer.WriteMsg([]byte(`{"type":"query-meta","payload":null}`)))
+
+	data, err := reader.ReadMsg()
+	require.Nil(t, err)
+	require.Equal(t, []byte(`{"type":"query-meta","payload":null}`), data)
+}
+
+func TestTcpConn_ReadMsg_InvalidLength(t *testing.T) {
+	type TestCase struct {
+		maxSize uint32 // 配置的最大报文长度, 为0表示使用默认值
+		length  uint32 // 报文声明的长度
+		wantErr error  // 期望的错误信息
+		desc    string // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			length:  0,
+			wantErr: errors.New("empty message"),
+			desc:    "长度为0",
+		},
+
+		{
+			length:  0xFFFFFFFF,
+			wantErr: errors.New("message size 4294967295 exceeds limit 67108864"),
+			desc:    "长度超过默认最大值",
+		},
+
+		{
+			maxSize: 1024,
+			length:  1025,
+			wantErr: errors.New("message size 1025 exceeds limit 1024"),
+			desc:    "长度超过配置的最大值",
+		},
+	}
+
+	for _, test := range testCases {
+		client, server := tcpPair(t)
+		reader := NewTcpConn(server, false, WithMaxMsgSize(test.maxSize))
+
+		header := make([]byte, 4)
+		binary.LittleEndian.PutUint32(header, test.length)
+		_, err := client.Write(header)
+		require.Nil(t, err, test.desc)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		data, err := reader.ReadMsg()
+		runtime.ReadMemStats(&after)
+
+		require.Nil(t, data, test.desc)
+		require.Equal(t, test.wantErr, err, test.desc)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1024*1024), test.desc)
+
+		// 关闭后对端能够及时感知
+		require.Nil(t, reader.Close(), test.desc)
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = client.Read(make([]byte, 1))
+		require.Equal(t, io.EOF, err, test.desc)
+	}
+}
+
+func TestTcpConn_ReadMsg_MaxSize(t *testing.T) {
+	client, server := tcpPair(t)
+	writer := NewTcpConn(client, false)
+	reader := NewTcpConn(server, false, WithMaxMsgSize(8))
+
+	require.Nil(t, writer.WriteMsg([]byte(`12345678`)))
+	data, err := reader.ReadMsg()
+	require.Nil(t, err, "长度等于最大值")
+	require.Equal(t, []byte(`12345678`), data, "长度等于最大值")
+}
+
+func TestTcpConn_FrameHeader(t *testing.T) {
+	client, server := tcpPair(t)
+	writer := NewTcpConn(client, false, WithFrameHeader())
+
+	msg := []byte(`{"type":"query-meta","payload":null}`)
+	require.Nil(t, writer.WriteMsg(msg))
+	require.Nil(t, writer.WriteMsg(msg))
+
+	// 帧头格式
+	head := make([]byte, 6)
+	_, err := io.ReadFull(server, head)
+	require.Nil(t, err)
+	require.Equal(t, []byte{FrameMagic, FrameVersion, byte(len(msg)), 0, 0, 0}, head, "帧头")
+	data := make([]byte, len(msg))
+	_, err = io.ReadFull(server, data)
+	require.Nil(t, err)
+	require.Equal(t, msg, data)
+
+	// 接收方自动识别带帧头的报文
+	reader := NewTcpConn(server, false)
+	data, err = reader.ReadMsg()
+	require.Nil(t, err)
+	require.Equal(t, msg, data)
+}
+
+func TestTcpConn_FrameByteOrder(t *testing.T) {
+	type TestCase struct {
+		order binary.ByteOrder // 配置的字节序, 为nil表示使用默认值
+		opts  []TcpOption      // 其他配置选项
+		head  []byte           // 期望的长度前缀
+		desc  string           // 用例描述
+	}
+
+	msg := []byte(`{"type":"query-meta","payload":null}`)
+	size := byte(len(msg))
+	testCases := []TestCase{
+		{nil, nil, []byte{size, 0, 0, 0}, "默认小端序"},
+		{binary.LittleEndian, nil, []byte{size, 0, 0, 0}, "小端序"},
+		{binary.BigEndian, nil, []byte{0, 0, 0, size}, "大端序"},
+		{binary.BigEndian, []TcpOption{WithFrameHeader()}, []byte{FrameMagic, FrameVersion, 0, 0, 0, size}, "大端序帧头"},
+	}
+
+	for _, test := range testCases {
+		client, server := tcpPair(t)
+		opts := append([]TcpOption{WithFrameByteOrder(test.order)}, test.opts...)
+		writer := NewTcpConn(client, false, opts...)
+		require.Nil(t, writer.WriteMsg(msg), test.desc)
+		require.Nil(t, writer.WriteMsg(msg), test.desc)
+
+		// 长度前缀的字节序
+		head := make([]byte, len(test.head))
+		_, err := io.ReadFull(server, head)
+		require.Nil(t, err, test.desc)
+		require.Equal(t, test.head, head, test.desc)
+		data := make([]byte, len(msg))
+		_, err = io.ReadFull(server, data)
+		require.Nil(t, err, test.desc)
+		require.Equal(t, msg, data, test.desc)
+
+		// 相同字节序的接收方能正确读取
+		reader := NewTcpConn(server, false, WithFrameByteOrder(test.order))
+		data, err = reader.ReadMsg()
+		require.Nil(t, err, test.desc)
+		require.Equal(t, msg, data, test.desc)
+	}
+}
+
+func TestTcpConn_ReadMsg_Framing(t *testing.T) {
+	// 构造长度为length的旧格式报文数据
+	legacyMsg := func(length int) []byte {
+		msg := []byte(`{"type":"query-meta","payload":"`)
+		for len(msg) < length-2 {
+			msg = append(msg, 'a')
+		}
+		return append(msg, '"', '}')
+	}
+
+	// 构造帧
+	frame := func(magic byte, version byte, msg []byte) []byte {
+		head := []byte{magic, version, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(head[2:], uint32(len(msg)))
+		return append(head, msg...)
+	}
+
+	// 构造大端序的帧
+	bigEndianFrame := func(msg []byte) []byte {
+		head := []byte{FrameMagic, FrameVersion, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(head[2:], uint32(len(msg)))
+		return append(head, msg...)
+	}
+
+	// 构造旧格式的帧
+	legacy := func(msg []byte) []byte {
+		head := make([]byte, 4)
+		binary.LittleEndian.PutUint32(head, uint32(len(msg)))
+		return append(head, msg...)
+	}
+
+	msg := []byte(`{"type":"query-meta","payload":null}`)
+
+	type TestCase struct {
+		frames   [][]byte    // 依次发送的帧
+		wantMsgs [][]byte    // 期望依次收到的报文
+		wantErr  error       // 收完报文后期望的错误信息
+		opts     []TcpOption // 接收方的配置选项
+		desc     string      // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			frames:   [][]byte{legacy(msg), legacy(msg)},
+			wantMsgs: [][]byte{msg, msg},
+			desc:     "旧格式",
+		},
+
+		{
+			frames:   [][]byte{legacy(legacyMsg(0x01FE)), legacy(msg)},
+			wantMsgs: [][]byte{legacyMsg(0x01FE), msg},
+			desc:     "旧格式长度低字节等于魔数",
+		},
+
+		{
+			frames:   [][]byte{frame(FrameMagic, FrameVersion, msg), legacy(msg)},
+			wantMsgs: [][]byte{msg},
+			wantErr:  errors.New("invalid frame magic 0x24"),
+			desc:     "识别为新格式后收到旧格式的报文",
+		},
+
+		{
+			frames:  [][]byte{frame(FrameMagic, 0x02, msg)},
+			wantErr: errors.New("unsupported frame version 2"),
+			desc:    "不支持的版本",
+		},
+
+		{
+			frames:  [][]byte{frame(FrameMagic, 0x11, msg)},
+			wantErr: errors.New("unsupported frame flags 0x1"),
+			desc:    "不支持的标志",
+		},
+
+		{
+			frames:  [][]byte{frame(FrameMagic, FrameVersion, nil)},
+			wantErr: errors.New("empty message"),
+			desc:    "带帧头的空报文",
+		},
+	}
+
+	// 配置了较大的最大报文长度时仍能识别旧格式
+	huge := WithMaxMsgSize(1 << 31)
+	testCases = append(testCases,
+		TestCase{
+			frames:   [][]byte{legacy(legacyMsg(0x01FE)), legacy(msg)},
+			wantMsgs: [][]byte{legacyMsg(0x01FE), msg},
+			opts:     []TcpOption{huge},
+			desc:     "最大报文长度较大时旧格式长度低字节等于魔数",
+		},
+
+		TestCase{
+			frames:   [][]byte{legacy(msg), legacy(legacyMsg(0x01FE))},
+			wantMsgs: [][]byte{msg, legacyMsg(0x01FE)},
+			opts:     []TcpOption{huge},
+			desc:     "最大报文长度较大时的旧格式",
+		},
+
+		TestCase{
+			frames:   [][]byte{frame(FrameMagic, FrameVersion, msg), frame(FrameMagic, FrameVersion, msg)},
+			wantMsgs: [][]byte{msg, msg},
+			opts:     []TcpOption{huge},
+			desc:     "最大报文长度较大时的新格式",
+		},
+
+		TestCase{
+			frames:   [][]byte{bigEndianFrame(legacyMsg(0x7B22)), bigEndianFrame(msg)},
+			wantMsgs: [][]byte{legacyMsg(0x7B22), msg},
+			opts:     []TcpOption{huge, WithFrameByteOrder(binary.BigEndian)},
+			desc:     "新格式的长度低字节恰好为JSON对象开头",
+		},
+	)
+
+	for _, test := range testCases {
+		client, server := tcpPair(t)
+		reader := NewTcpConn(server, false, test.opts...)
+		// NOTE: 帧格式识别错误时会等待永远不会到达的数据, 设置截止时间避免测试阻塞
+		require.Nil(t, server.SetReadDeadline(time.Now().Add(2*time.Second)), test.desc)
+
+		for _, f := range test.frames {
+			_, err := client.Write(f)
+			require.Nil(t, err, test.desc)
+		}
+
+		for _, want := range test.wantMsgs {
+			data, err := reader.ReadMsg()
+			require.Nil(t, err, test.desc)
+			require.Equal(t, want, data, test.desc)
+		}
+
+		if test.wantErr != nil {
+			data, err := reader.ReadMsg()
+			require.Nil(t, data, test.desc)
+			require.Equal(t, test.wantErr, err, test.desc)
+		}
+	}
+}
+
+// socketRecorder 记录socket选项的设置
+type socketRecorder struct {
+	noDelay     []bool
+	writeBuffer []int
+}
+
+func (r *socketRecorder) SetNoDelay(noDelay bool) error {
+	r.noDelay = append(r.noDelay, noDelay)
+	return nil
+}
+
+func (r *socketRecorder) SetWriteBuffer(bytes int) error {
+	r.writeBuffer = append(r.writeBuffer, bytes)
+	return nil
+}
+
+func TestTcpConn_SocketOptions(t *testing.T) {
+	type TestCase struct {
+		opts            []TcpOption // 配置选项
+		wantNoDelay     []bool      // 期望的Nagle设置
+		wantWriteBuffer []int       // 期望的写缓冲区设置
+		desc            string      // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			desc: "默认不设置",
+		},
+
+		{
+			opts:        []TcpOption{WithNoDelay(false)},
+			wantNoDelay: []bool{false},
+			desc:        "开启Nagle算法",
+		},
+
+		{
+			opts:            []TcpOption{WithNoDelay(true), WithWriteBuffer(4096)},
+			wantNoDelay:     []bool{true},
+			wantWriteBuffer: []int{4096},
+			desc:            "禁用Nagle算法并设置写缓冲区",
+		},
+
+		{
+			opts: []TcpOption{WithWriteBuffer(0), WithWriteBuffer(-1)},
+			desc: "无效的写缓冲区大小",
+		},
+	}
+
+	for _, test := range testCases {
+		conn := &tcpConn{}
+		for _, opt := range test.opts {
+			opt(conn)
+		}
+		recorder := &socketRecorder{}
+		conn.applySocketOptions(recorder)
+		require.Equal(t, test.wantNoDelay, recorder.noDelay, test.desc)
+		require.Equal(t, test.wantWriteBuffer, recorder.writeBuffer, test.desc)
+	}
+
+	// 应用到真实的TCP连接
+	client, server := tcpPair(t)
+	writer := NewTcpConn(client, false, WithNoDelay(false), WithWriteBuffer(4096))
+	reader := NewTcpConn(server, false)
+	require.Nil(t, writer.WriteMsg([]byte(`{}`)))
+	data, err := reader.ReadMsg()
+	require.Nil(t, err)
+	require.Equal(t, []byte(`{}`), data)
+}
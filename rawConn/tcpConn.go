@@ -2,25 +2,195 @@ package rawConn
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"time"
 )
 
+// DefaultMaxMsgSize 为TCP连接默认允许接收的最大报文长度(64MB)
+const DefaultMaxMsgSize uint32 = 64 * 1024 * 1024
+
+// 帧头格式: 魔数(1字节) + 版本/标志(1字节, 低4位为版本号, 高4位为标志位) + 报文长度(4字节).
+// 旧格式的帧只有报文长度(4字节). 报文长度默认为小端序, 见 WithFrameByteOrder.
+const (
+	FrameMagic   byte = 0xFE // 帧头魔数
+	FrameVersion byte = 0x01 // 帧头版本号
+)
+
+// 帧格式
+const (
+	framingUnknown = iota // 还未收到报文, 帧格式未知
+	framingLegacy         // 旧格式, 没有帧头
+	framingHeader         // 新格式, 有帧头
+)
+
 type tcpConn struct {
 	*net.TCPConn
+	maxMsgSize  uint32           // 允许接收的最大报文长度
+	frameHeader bool             // 发送报文时是否带帧头
+	framing     int              // 接收报文的帧格式, 由收到的第一帧确定
+	noDelay     *bool            // 是否禁用Nagle算法, 为nil表示不设置
+	writeBuffer int              // 系统写缓冲区大小, 为0表示不设置
+	byteOrder   binary.ByteOrder // 报文长度的字节序
+}
+
+// socketSetter 为可以设置socket选项的连接, *net.TCPConn 实现了该接口
+type socketSetter interface {
+	SetNoDelay(noDelay bool) error
+	SetWriteBuffer(bytes int) error
+}
+
+// TcpOption 为TCP连接配置选项
+type TcpOption func(*tcpConn)
+
+// WithMaxMsgSize 配置TCP连接允许接收的最大报文长度为size, 默认为 DefaultMaxMsgSize.
+// 若收到的报文声明的长度超过size, ReadMsg 在分配内存前直接返回错误, 由调用者关闭连接.
+// size为0时该选项无效.
+func WithMaxMsgSize(size uint32) TcpOption {
+	return func(conn *tcpConn) {
+		if size > 0 {
+			conn.maxMsgSize = size
+		}
+	}
+}
+
+// WithFrameHeader 配置TCP连接发送报文时带帧头, 帧头由魔数 FrameMagic 、版本/标志字节和报文长度组成,
+// 为后续协商压缩、校验等功能预留. 接收报文时总是根据收到的第一帧自动识别对端是否带帧头,
+// 因此开启该选项前需要确保对端能够识别帧头, 默认不带帧头, 与旧版本兼容.
+func WithFrameHeader() TcpOption {
+	return func(conn *tcpConn) {
+		conn.frameHeader = true
+	}
+}
+
+// WithFrameByteOrder 配置TCP连接收发报文时报文长度的字节序为order, 默认为小端序 binary.LittleEndian, 与旧版本兼容.
+// 与使用网络字节序的对端(例如大端序的单片机)互通时可以配置为 binary.BigEndian, 收发双方必须使用相同的字节序.
+// order为nil时该选项无效.
+func WithFrameByteOrder(order binary.ByteOrder) TcpOption {
+	return func(conn *tcpConn) {
+		if order != nil {
+			conn.byteOrder = order
+		}
+	}
+}
+
+// WithNoDelay 配置TCP连接是否禁用Nagle算法, noDelay为true时报文立即发送, 适用于对延时敏感的控制指令,
+// 为false时操作系统可以合并小报文再发送, 适用于大量的遥测数据. 未配置时保持系统默认行为(Go默认禁用Nagle算法).
+func WithNoDelay(noDelay bool) TcpOption {
+	return func(conn *tcpConn) {
+		conn.noDelay = &noDelay
+	}
+}
+
+// WithWriteBuffer 配置TCP连接的系统写缓冲区大小为size字节, size不大于0时该选项无效, 未配置时保持系统默认大小.
+func WithWriteBuffer(size int) TcpOption {
+	return func(conn *tcpConn) {
+		if size > 0 {
+			conn.writeBuffer = size
+		}
+	}
+}
+
+// applySocketOptions 将配置的socket选项应用到连接socket上
+func (conn *tcpConn) applySocketOptions(socket socketSetter) {
+	if conn.noDelay != nil {
+		_ = socket.SetNoDelay(*conn.noDelay)
+	}
+	if conn.writeBuffer > 0 {
+		_ = socket.SetWriteBuffer(conn.writeBuffer)
+	}
 }
 
 func (conn *tcpConn) ReadMsg() ([]byte, error) {
-	// 读取长度
-	var length uint32
-	err := binary.Read(conn, binary.LittleEndian, &length)
-	if err != nil {
+	// 读取首字节, 判断帧格式
+	var head [6]byte
+	if _, err := io.ReadFull(conn, head[:1]); err != nil {
 		return nil, err
 	}
 
+	switch conn.framing {
+	case framingLegacy:
+		return conn.readLegacy(head)
+	case framingHeader:
+		if head[0] != FrameMagic {
+			return nil, fmt.Errorf("invalid frame magic 0x%02X", head[0])
+		}
+	default:
+		// 魔数不匹配为旧格式
+		if head[0] != FrameMagic {
+			conn.framing = framingLegacy
+			return conn.readLegacy(head)
+		}
+	}
+
+	// 读取版本/标志和长度
+	if _, err := io.ReadFull(conn, head[1:]); err != nil {
+		return nil, err
+	}
+	length := conn.byteOrder.Uint32(head[2:])
+
+	// 旧格式报文长度的首字节可能恰好等于魔数, 此时按照旧格式解析的长度之后为报文数据, 即JSON对象的开头,
+	// 因此已读取的最后2字节是JSON对象的开头并且旧格式的长度有效时按照旧格式解析, 已读取的最后2字节为报文数据.
+	// NOTE: 不能以新格式的长度是否超出 maxMsgSize 判断, 否则配置了较大的 maxMsgSize 时旧格式的报文会被误判为新格式
+	if conn.framing == framingUnknown {
+		legacyLength := conn.byteOrder.Uint32(head[:4])
+		if isObjectStart(head[4:]) && legacyLength <= conn.maxMsgSize {
+			conn.framing = framingLegacy
+			return conn.readBody(legacyLength, head[4:])
+		}
+		conn.framing = framingHeader
+	}
+
+	// 校验版本和标志
+	if version := head[1] & 0x0F; version != FrameVersion {
+		return nil, fmt.Errorf("unsupported frame version %d", version)
+	}
+	if flags := head[1] >> 4; flags != 0 {
+		return nil, fmt.Errorf("unsupported frame flags 0x%X", flags)
+	}
+
+	return conn.readBody(length, nil)
+}
+
+// isObjectStart 返回2字节数据prefix是否可能为JSON对象的开头, 即'{'之后为'"'、'}'或者空白字符
+func isObjectStart(prefix []byte) bool {
+	if prefix[0] != '{' {
+		return false
+	}
+	switch prefix[1] {
+	case '"', '}', ' ', '\t', '\r', '\n':
+		return true
+	}
+	return false
+}
+
+// readLegacy 读取旧格式的报文, head[0]为已读取的长度首字节
+func (conn *tcpConn) readLegacy(head [6]byte) ([]byte, error) {
+	if _, err := io.ReadFull(conn, head[1:4]); err != nil {
+		return nil, err
+	}
+	return conn.readBody(conn.byteOrder.Uint32(head[:4]), nil)
+}
+
+// readBody 读取长度为length的报文数据, prefix为已读取的报文数据
+func (conn *tcpConn) readBody(length uint32, prefix []byte) ([]byte, error) {
+	// 校验长度, 避免非法长度导致分配过大的内存
+	if length == 0 {
+		return nil, errors.New("empty message")
+	}
+	if length > conn.maxMsgSize {
+		return nil, fmt.Errorf("message size %d exceeds limit %d", length, conn.maxMsgSize)
+	}
+	if length < uint32(len(prefix)) {
+		return nil, fmt.Errorf("invalid message size %d", length)
+	}
+
 	// 读取数据
 	data := make([]byte, length)
-	if err = binary.Read(conn, binary.LittleEndian, &data); err != nil {
+	n := copy(data, prefix)
+	if _, err := io.ReadFull(conn, data[n:]); err != nil {
 		return nil, err
 	}
 	return data, nil
@@ -31,22 +201,41 @@ func (conn *tcpConn) WriteMsg(msg []byte) error {
 		return nil
 	}
 
-	length := uint32(len(msg))
-	err := binary.Write(conn, binary.LittleEndian, &length)
-	if err != nil {
-		return err
+	if conn.frameHeader {
+		var head [6]byte
+		head[0] = FrameMagic
+		head[1] = FrameVersion
+		conn.byteOrder.PutUint32(head[2:], uint32(len(msg)))
+		if _, err := conn.Write(head[:]); err != nil {
+			return err
+		}
+	} else {
+		length := uint32(len(msg))
+		if err := binary.Write(conn, conn.byteOrder, &length); err != nil {
+			return err
+		}
 	}
 
-	_, err = conn.Write(msg)
+	_, err := conn.Write(msg)
 	return err
 }
 
-func NewTcpConn(rawConn *net.TCPConn, keepAlive bool) RawConn {
+// NewTcpConn 利用TCP连接rawConn创建物模型原始连接, keepAlive表示是否开启TCP保活, opts为TCP连接配置选项.
+func NewTcpConn(rawConn *net.TCPConn, keepAlive bool, opts ...TcpOption) RawConn {
 	if keepAlive {
 		_ = rawConn.SetKeepAlive(true)
 		_ = rawConn.SetKeepAlivePeriod(time.Second * 5)
 	}
-	return &tcpConn{
-		rawConn,
+	ans := &tcpConn{
+		TCPConn:    rawConn,
+		maxMsgSize: DefaultMaxMsgSize,
+		byteOrder:  binary.LittleEndian,
 	}
+
+	for _, opt := range opts {
+		opt(ans)
+	}
+	ans.applySocketOptions(rawConn)
+
+	return ans
 }
@@ -1,7 +1,10 @@
 package rawConn
 
 import (
+	"errors"
+	"fmt"
 	"github.com/gorilla/websocket"
+	"net"
 	"sync"
 	"time"
 )
@@ -14,14 +17,24 @@ const (
 	pingPeriod = (pongWait * 9) / 10
 )
 
+// ErrHeartbeatTimeout 为心跳超时错误, 开启ping的WebSocket连接在规定时间内没有收到pong报文时,
+// ReadMsg 返回的错误包装了该错误, 可以通过 errors.Is 判断.
+var ErrHeartbeatTimeout = errors.New("heartbeat timeout")
+
 type webSocketConn struct {
 	writeMu sync.Mutex
+	ping    bool // 是否开启ping, 开启后读取超时即为心跳超时
 	*websocket.Conn
 }
 
 func (conn *webSocketConn) ReadMsg() ([]byte, error) {
 	messageType, p, err := conn.ReadMessage()
 	if err != nil {
+		// NOTE: 只有开启ping时才设置读取超时, 因此读取超时表示没有按时收到pong报文
+		var netErr net.Error
+		if conn.ping && errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, fmt.Errorf("%w: %s", ErrHeartbeatTimeout, err)
+		}
 		return nil, err
 	}
 
@@ -47,6 +60,7 @@ func (conn *webSocketConn) writePing() error {
 func NewWebSocketConn(conn *websocket.Conn, ping bool) RawConn {
 	ans := &webSocketConn{
 		writeMu: sync.Mutex{},
+		ping:    ping,
 		Conn:    conn,
 	}
 
@@ -0,0 +1,143 @@
+package replay
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn 为回放连接, 实现了 rawConn.RawConn 接口. ReadMsg 按照记录的时间间隔依次返回记录的报文数据,
+// 所有记录返回后返回 io.EOF(开启 WithKeepOpen 时阻塞到连接关闭); WriteMsg 不发送数据, 只保存写入的报文, 可以通过 Conn.Written 获取.
+// 通过 model.Model.Attach 将回放连接交给物模型处理, 可以把抓取的报文日志变为可复现的测试数据或者压力测试数据.
+type Conn struct {
+	records   []Record      // 回放的记录
+	speed     float64       // 回放速度倍数, 为0表示不等待
+	keepOpen  bool          // 回放结束后是否保持连接
+	next      int           // 下一条回放的记录索引
+	closeOnce sync.Once     // 确保 closed 只关闭一次
+	closed    chan struct{} // 连接关闭信号
+	lock      sync.Mutex    // 保护 written
+	written   [][]byte      // 写入的报文
+}
+
+// ConnOption 为回放连接配置选项
+type ConnOption func(*Conn)
+
+// WithSpeed 配置回放速度为原始速度的multiplier倍, 例如2表示记录之间的时间间隔缩短为原来的一半,
+// multiplier为0表示不等待, 尽快回放所有记录, multiplier小于0时该选项无效. 默认按照原始时间间隔回放.
+func WithSpeed(multiplier float64) ConnOption {
+	return func(conn *Conn) {
+		if multiplier >= 0 {
+			conn.speed = multiplier
+		}
+	}
+}
+
+// WithKeepOpen 配置回放所有记录后保持连接, ReadMsg 阻塞到连接关闭, 默认回放结束后 ReadMsg 返回 io.EOF.
+// 被测物模型异步处理调用请求, 开启该选项可以在关闭连接前通过 Conn.Written 收集所有的调用响应.
+func WithKeepOpen() ConnOption {
+	return func(conn *Conn) {
+		conn.keepOpen = true
+	}
+}
+
+// NewConn 根据配置选项opts创建依次回放记录records中报文数据的回放连接.
+// 通常先筛选出某个物模型发给代理的记录(方向为 Received, 地址为该物模型的地址), 再回放给被测物模型.
+func NewConn(records []Record, opts ...ConnOption) *Conn {
+	ans := &Conn{
+		records: records,
+		speed:   1,
+		closed:  make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(ans)
+	}
+
+	return ans
+}
+
+// ReadMsg 等待与上一条记录的时间间隔后返回下一条记录的报文数据, 所有记录返回后返回 io.EOF.
+func (conn *Conn) ReadMsg() ([]byte, error) {
+	if conn.next >= len(conn.records) {
+		if conn.keepOpen {
+			<-conn.closed
+			return nil, errors.New("replay connection closed")
+		}
+		return nil, io.EOF
+	}
+
+	record := conn.records[conn.next]
+	if conn.next > 0 && conn.speed > 0 {
+		interval := record.Time.Sub(conn.records[conn.next-1].Time)
+		if interval > 0 {
+			timer := time.NewTimer(time.Duration(float64(interval) / conn.speed))
+			select {
+			case <-timer.C:
+			case <-conn.closed:
+				timer.Stop()
+				return nil, errors.New("replay connection closed")
+			}
+		}
+	}
+
+	select {
+	case <-conn.closed:
+		return nil, errors.New("replay connection closed")
+	default:
+	}
+
+	conn.next++
+	return record.Data, nil
+}
+
+// WriteMsg 保存写入的报文msg, 连接关闭后返回错误信息.
+func (conn *Conn) WriteMsg(msg []byte) error {
+	select {
+	case <-conn.closed:
+		return errors.New("replay connection closed")
+	default:
+	}
+
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	conn.written = append(conn.written, append([]byte(nil), msg...))
+	return nil
+}
+
+// Written 返回所有写入回放连接的报文, 例如被测物模型回复的调用响应.
+func (conn *Conn) Written() [][]byte {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	return append([][]byte(nil), conn.written...)
+}
+
+// Close 关闭回放连接, 正在等待的 ReadMsg 立即返回错误信息.
+func (conn *Conn) Close() error {
+	conn.closeOnce.Do(func() {
+		close(conn.closed)
+	})
+	return nil
+}
+
+// RemoteAddr 返回回放连接的地址, 地址为第一条记录的物模型地址.
+func (conn *Conn) RemoteAddr() net.Addr {
+	addr := replayAddr("replay")
+	if len(conn.records) > 0 {
+		addr = replayAddr(conn.records[0].Addr)
+	}
+	return addr
+}
+
+// replayAddr 为回放连接的地址
+type replayAddr string
+
+func (addr replayAddr) Network() string {
+	return "replay"
+}
+
+func (addr replayAddr) String() string {
+	return string(addr)
+}
@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/rawConn"
+	"io"
+	"strings"
+	"time"
+)
+
+// TimeLayout 为代理报文日志中每条记录的时间格式, 与 log.LstdFlags|log.Lmicroseconds 的输出一致
+const TimeLayout = "2006/01/02 15:04:05.000000"
+
+// Direction 为报文方向, 以代理为参照
+type Direction string
+
+const (
+	Received Direction = "<--" // 代理从物模型接收的报文
+	Sent     Direction = "-->" // 代理向物模型发送的报文
+)
+
+// Record 为报文日志中的一条记录
+type Record struct {
+	Time      time.Time // 记录时间
+	Direction Direction // 报文方向
+	Addr      string    // 物模型的地址
+	Data      []byte    // 报文数据
+}
+
+// Decode 将记录的报文数据解码为 message.RawMessage, 等同于 message.Decode(r.Data).
+func (r Record) Decode() (message.RawMessage, error) {
+	return message.Decode(r.Data)
+}
+
+// Reader 为代理报文日志的读取器, 日志中每行为一条记录, 格式为:
+//
+//	2024/01/07 15:04:05.123456 <-- 127.0.0.1:50000 {"type":"state","payload":{...}}
+//
+// 依次为记录时间、报文方向、物模型地址和报文数据, 以空格分割.
+// 也支持代理以 json 日志格式记录的多行记录, 即报文数据缩进格式化为多行JSON, 写在记录头(时间、方向和地址)之后:
+//
+//	2024/01/07 15:04:05.123456 <-- 127.0.0.1:50000
+//	{
+//	  "type": "state",
+//	  "payload": {...}
+//	}
+//
+// 多行记录的报文数据会被压缩为一行JSON.
+type Reader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewReader 创建从r中读取代理报文日志的读取器
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), int(rawConn.DefaultMaxMsgSize)+1024)
+	return &Reader{
+		scanner: scanner,
+	}
+}
+
+// Next 读取下一条记录, 忽略空行. 读取完所有记录后返回 io.EOF, 记录格式错误时返回的错误信息包含行号.
+func (r *Reader) Next() (Record, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		record, err := parseRecord(line)
+		if err != nil {
+			return Record{}, fmt.Errorf("line %d: %s", r.line, err)
+		}
+
+		// 记录头之后没有报文数据, 为多行JSON记录
+		if record.Data == nil {
+			start := r.line
+			if record.Data, err = r.readJSON(); err != nil {
+				return Record{}, fmt.Errorf("line %d: %s", start, err)
+			}
+		}
+		return record, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+// readJSON 读取多行记录中缩进格式化的报文数据, 返回压缩为一行的报文数据.
+// 顶层JSON的最后一行没有缩进, 因此只在读到没有缩进的行时检查数据是否完整.
+func (r *Reader) readJSON() ([]byte, error) {
+	var data bytes.Buffer
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Bytes()
+		data.Write(line)
+		data.WriteByte('\n')
+		if len(line) == 0 || line[0] == ' ' || line[0] == '\t' || !json.Valid(data.Bytes()) {
+			continue
+		}
+
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, data.Bytes()); err != nil {
+			return nil, err
+		}
+		return compact.Bytes(), nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data.Bytes())) == 0 {
+		return nil, errors.New("missing fields")
+	}
+	return nil, errors.New("incomplete JSON data")
+}
+
+// ReadAll 读取r中的所有记录, 返回按照日志顺序排列的记录和错误信息.
+func ReadAll(r io.Reader) ([]Record, error) {
+	reader := NewReader(r)
+	var ans []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return ans, nil
+		}
+		if err != nil {
+			return ans, err
+		}
+		ans = append(ans, record)
+	}
+}
+
+// parseRecord 解析一行记录, 没有报文数据时(多行记录的记录头)返回的记录的 Data 为nil
+func parseRecord(line string) (Record, error) {
+	if len(line) <= len(TimeLayout) {
+		return Record{}, errors.New("record too short")
+	}
+
+	recordTime, err := time.ParseInLocation(TimeLayout, line[:len(TimeLayout)], time.Local)
+	if err != nil {
+		return Record{}, fmt.Errorf("invalid time: %s", err)
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(line[len(TimeLayout):]), " ", 3)
+	if len(fields) < 2 {
+		return Record{}, errors.New("missing fields")
+	}
+
+	direction := Direction(fields[0])
+	if direction != Received && direction != Sent {
+		return Record{}, fmt.Errorf("invalid direction %q", fields[0])
+	}
+
+	record := Record{
+		Time:      recordTime,
+		Direction: direction,
+		Addr:      fields[1],
+	}
+	if len(fields) == 3 {
+		record.Data = []byte(fields[2])
+	}
+	return record, nil
+}
@@ -0,0 +1,196 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/object-model/goModel/message"
+	"github.com/object-model/goModel/meta"
+	"github.com/object-model/goModel/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sessionLog = `2024/01/07 15:04:05.000000 <-- 127.0.0.1:50000 {"type":"meta-info","payload":{}}
+2024/01/07 15:04:05.100000 --> 127.0.0.1:50000 {"type":"query-meta","payload":null}
+
+2024/01/07 15:04:05.300000 <-- 127.0.0.1:50000 {"type":"call","payload":{"name":"test/echo","uuid":"1","args":{"x":1}}}
+`
+
+func TestReadAll(t *testing.T) {
+	records, err := ReadAll(strings.NewReader(sessionLog))
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+
+	start := time.Date(2024, 1, 7, 15, 4, 5, 0, time.Local)
+	assert.Equal(t, Record{
+		Time:      start.Add(100 * time.Millisecond),
+		Direction: Sent,
+		Addr:      "127.0.0.1:50000",
+		Data:      []byte(`{"type":"query-meta","payload":null}`),
+	}, records[1])
+	assert.Equal(t, start.Add(300*time.Millisecond), records[2].Time)
+
+	msg, err := records[2].Decode()
+	require.Nil(t, err)
+	assert.Equal(t, "call", msg.Type)
+	call, err := message.ParseCallPayload(msg.Payload)
+	require.Nil(t, err)
+	assert.Equal(t, "test/echo", call.Name)
+}
+
+func TestReadAll_JSON(t *testing.T) {
+	const jsonLog = `2024/01/07 15:04:05.000000 <-- 127.0.0.1:50000
+{
+  "type": "call",
+  "payload": {
+    "name": "test/echo",
+    "args": {"x": [1, 2]}
+  }
+}
+2024/01/07 15:04:05.100000 --> 127.0.0.1:50000 not json
+2024/01/07 15:04:05.200000 --> 127.0.0.1:50000
+null
+`
+	records, err := ReadAll(strings.NewReader(jsonLog))
+	require.Nil(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, []byte(`{"type":"call","payload":{"name":"test/echo","args":{"x":[1,2]}}}`), records[0].Data, "压缩多行记录")
+	assert.Equal(t, Received, records[0].Direction)
+	assert.Equal(t, []byte("not json"), records[1].Data, "非JSON报文原样记录")
+	assert.Equal(t, []byte("null"), records[2].Data)
+}
+
+func TestReader_Error(t *testing.T) {
+	type TestCase struct {
+		log     string // 日志内容
+		wantErr error  // 期望的错误信息
+		desc    string // 用例描述
+	}
+
+	testCases := []TestCase{
+		{
+			log:     "\n2024/01/07 15:04:05",
+			wantErr: errors.New("line 2: record too short"),
+			desc:    "记录过短",
+		},
+
+		{
+			log:     "2024/01/07 15:04:05.000000 <== 127.0.0.1:50000 {}",
+			wantErr: errors.New(`line 1: invalid direction "<=="`),
+			desc:    "无效的报文方向",
+		},
+
+		{
+			log:     "2024/01/07 15:04:05.000000 <-- 127.0.0.1:50000",
+			wantErr: errors.New("line 1: missing fields"),
+			desc:    "缺少报文数据",
+		},
+
+		{
+			log:     "2024/01/07 15:04:05.000000 <-- 127.0.0.1:50000\n{\n  \"type\": \"state\",\n",
+			wantErr: errors.New("line 1: incomplete JSON data"),
+			desc:    "多行报文数据不完整",
+		},
+	}
+
+	for _, test := range testCases {
+		_, err := NewReader(strings.NewReader(test.log)).Next()
+		assert.Equal(t, test.wantErr, err, test.desc)
+	}
+
+	_, err := NewReader(strings.NewReader("2024-01-07 15:04:05.000000 <-- 127.0.0.1:50000 {}")).Next()
+	assert.NotNil(t, err, "无效的时间")
+
+	_, err = NewReader(strings.NewReader("\n\n")).Next()
+	assert.Equal(t, io.EOF, err, "空日志")
+}
+
+func TestConn_Timing(t *testing.T) {
+	start := time.Now()
+	records := []Record{
+		{Time: start, Data: []byte("1")},
+		{Time: start.Add(200 * time.Millisecond), Data: []byte("2")},
+	}
+
+	// 按照2倍速回放
+	conn := NewConn(records, WithSpeed(2))
+	begin := time.Now()
+	for _, want := range []string{"1", "2"} {
+		data, err := conn.ReadMsg()
+		require.Nil(t, err)
+		assert.Equal(t, []byte(want), data)
+	}
+	elapsed := time.Since(begin)
+	assert.True(t, elapsed >= 100*time.Millisecond && elapsed < 200*time.Millisecond, "回放间隔: %s", elapsed)
+
+	_, err := conn.ReadMsg()
+	assert.Equal(t, io.EOF, err, "回放结束")
+
+	// 关闭连接立即结束等待
+	conn = NewConn(records)
+	_, err = conn.ReadMsg()
+	require.Nil(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = conn.Close()
+	}()
+	begin = time.Now()
+	_, err = conn.ReadMsg()
+	assert.Equal(t, errors.New("replay connection closed"), err)
+	assert.Less(t, int64(time.Since(begin)), int64(150*time.Millisecond), "关闭后立即返回")
+	assert.Equal(t, errors.New("replay connection closed"), conn.WriteMsg([]byte("{}")))
+}
+
+func TestConn_Attach(t *testing.T) {
+	records, err := ReadAll(strings.NewReader(sessionLog))
+	require.Nil(t, err)
+
+	// 只回放物模型发给代理的报文
+	var received []Record
+	for _, record := range records {
+		if record.Direction == Received {
+			received = append(received, record)
+		}
+	}
+
+	m, err := model.LoadFromBuff([]byte(`{"name": "test", "description": "测试", "state": [], "event": [], "method": [
+		{"name": "echo", "description": "回显", "args": [{"name": "x", "description": "x", "type": "int"}],
+		"response": [{"name": "x", "description": "x", "type": "int"}]}
+	]}`), meta.TemplateParam{}, model.WithCallReqFunc(func(name string, args message.RawArgs) message.Resp {
+		return message.Resp{"x": args["x"]}
+	}))
+	require.Nil(t, err)
+
+	conn := NewConn(received, WithSpeed(0), WithKeepOpen())
+	assert.Equal(t, "127.0.0.1:50000", conn.RemoteAddr().String())
+
+	closed := make(chan struct{})
+	m.Attach(conn, model.WithClosedFunc(func(string) {
+		close(closed)
+	}))
+
+	// 等待异步的调用请求处理完成
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && len(conn.Written()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_ = conn.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("连接未关闭")
+	}
+
+	var hasResp bool
+	for _, msg := range conn.Written() {
+		if bytes.Contains(msg, []byte(`"uuid":"1"`)) {
+			hasResp = true
+		}
+	}
+	assert.True(t, hasResp, "回放的调用请求收到响应")
+}